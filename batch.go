@@ -0,0 +1,257 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/majiddarvishan/config_manager/history"
+)
+
+// Operation is a single modification as accepted by the batch APIs
+type Operation struct {
+	Op    string      `json:"op"` // "insert", "remove" or "replace"
+	Path  string      `json:"path"`
+	Index int         `json:"index,omitempty"` // insert and remove only
+	Value interface{} `json:"value,omitempty"` // insert and replace only
+}
+
+// OperationResult reports the outcome of one operation of a batch
+type OperationResult struct {
+	Index   int    `json:"index"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BatchIndependent applies ops one after the other, each validated and
+// persisted on its own, and carries on past failures. It returns one result
+// per operation and the version after the last one. Other writers may
+// interleave between operations.
+func (m *Manager) BatchIndependent(ops []Operation) ([]OperationResult, int64) {
+	results := make([]OperationResult, 0, len(ops))
+	for i, op := range ops {
+		result := OperationResult{Index: i, Success: true}
+		if err := m.apply(op); err != nil {
+			result.Success = false
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	return results, m.Version()
+}
+
+// apply performs a single operation through the regular mutation path
+func (m *Manager) apply(op Operation) error {
+	switch op.Op {
+	case "insert":
+		return m.insert(op.Path, op.Index, op.Value, mutationOptions{})
+	case "remove":
+		return m.remove(op.Path, op.Index, mutationOptions{})
+	case "replace":
+		return m.replace(op.Path, op.Value, mutationOptions{})
+	default:
+		return fmt.Errorf("unsupported operation: %s", op.Op)
+	}
+}
+
+// BatchPreview is the outcome of trying a batch without applying it
+type BatchPreview struct {
+	Diff    []DiffEntry `json:"diff"`    // turns the current config into the one the batch produces
+	Version int64       `json:"version"` // version the batch would produce
+	Valid   bool        `json:"valid"`   // whether every operation would succeed
+	Error   string      `json:"error,omitempty"`
+}
+
+// BatchOperation is the Operation of the history events recorded by Batch
+const BatchOperation = "batch"
+
+// Batch applies ops atomically. They are applied in order to a copy of the
+// config, each addressing the config as left by the ones before it; the
+// result is validated against the schema once, so intermediate states need
+// not conform, and persisted in a single write. If any operation fails or
+// the result does not conform, nothing changes.
+//
+// The version goes up by one, and one "batch" event at "/" listing the
+// changed paths is recorded in the history; a batch changing nothing leaves
+// the version alone. As with Import, registered nodes stay attached where
+// their paths still exist. Handlers and path subscribers see each operation
+// as if it had been applied on its own, with the value it wrote: critical
+// handlers run before anything is persisted, and any of them can veto the
+// batch, while best-effort handlers and subscribers run after the commit.
+func (m *Manager) Batch(ops []Operation) error {
+	m.lockForWrite()
+	defer m.unlockForWrite()
+
+	scratch, err := m.stageLocked(ops)
+	if err != nil {
+		return err
+	}
+	_, err = m.commitStagedLocked(scratch, BatchOperation)
+	return err
+}
+
+// commitStagedLocked makes the config staged on scratch current, recording
+// one op event at "/", and returns the version it produced. The handler
+// calls and path events of the staged operations, deferred by scratch, are
+// made as for the operations on their own: critical handlers before anything
+// is persisted, best-effort handlers and subscribers after. Nothing happens
+// when scratch changed nothing.
+func (m *Manager) commitStagedLocked(scratch *Manager, op string) (int64, error) {
+	if scratch.version == m.version {
+		return m.version, nil
+	}
+
+	config := scratch.source.getConfigObject()
+	if _, err := m.runValidationServiceLocked(nil, config); err != nil {
+		return 0, err
+	}
+
+	// The critical handlers of every operation may veto the whole batch
+	for _, effect := range scratch.staged {
+		if effect.mod != nil && !effect.mod.BestEffort {
+			if err := m.vetoLocked(effect.mod, effect.node); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if err := m.source.setConfig(config); err != nil {
+		m.log().Error("failed to persist staged config", "op", op, "error", err)
+		return 0, fmt.Errorf("failed to persist config: %w", err)
+	}
+
+	root := parseNode(config)
+	changed := diffPointers(m.config, root)
+
+	m.rebindModifiablesLocked(root)
+	m.version++
+	for _, d := range changed {
+		m.touchPathLocked(d.Path)
+	}
+	m.recordRootChangeLocked(op, changed)
+	version := m.version
+
+	for _, effect := range scratch.staged {
+		if effect.mod != nil {
+			m.callHandlerLocked(effect.mod, effect.node)
+		} else {
+			m.publishLocked(effect.ev)
+		}
+	}
+
+	return version, nil
+}
+
+// stagedEffect is a handler call, or a path event when mod is nil, that a
+// manager staging a batch defers until the batch commits
+type stagedEffect struct {
+	mod  *modifiable
+	node *Node
+	ev   pathEvent
+}
+
+// deferHandlerLocked records a call of mod's handler with node. Both are
+// copied, as later operations of the batch may change them.
+func (m *Manager) deferHandlerLocked(mod *modifiable, node *Node) {
+	handler := *mod
+	m.staged = append(m.staged, stagedEffect{mod: &handler, node: node.DeepCopy()})
+}
+
+// PreviewBatch reports the diff and version Batch would produce for ops,
+// without changing anything. Operations are checked as for real but
+// handlers do not run, so a critical handler may still veto a change. When
+// the batch would fail, Diff covers the operations before the failing one.
+// The version assumes no other writer gets in first. An error is only
+// returned when the config cannot be copied.
+func (m *Manager) PreviewBatch(ops []Operation) (*BatchPreview, error) {
+	m.mu.RLock()
+	current, version := m.config.DeepCopy(), m.version
+	scratch, err := m.stageLocked(ops)
+	m.mu.RUnlock()
+	if scratch == nil {
+		return nil, err
+	}
+
+	preview := &BatchPreview{
+		Diff:    DiffNodes(current, scratch.config),
+		Version: version,
+		Valid:   err == nil,
+	}
+	if err != nil {
+		preview.Error = err.Error()
+	} else if scratch.version != preview.Version {
+		preview.Version++
+	}
+	return preview, nil
+}
+
+// stageLocked applies ops to a scratch manager and checks the result against
+// the schema. On failure the scratch manager, if it could be made, holds the
+// operations before the failing one.
+func (m *Manager) stageLocked(ops []Operation) (*Manager, error) {
+	return m.stageWithLocked(func(scratch *Manager) error {
+		for i, op := range ops {
+			if err := scratch.apply(op); err != nil {
+				return fmt.Errorf("operation %d (%s '%s'): %w", i, op.Op, op.Path, err)
+			}
+		}
+		return nil
+	})
+}
+
+// stageWithLocked is stageLocked for changes made by apply on the scratch
+// manager
+func (m *Manager) stageWithLocked(apply func(scratch *Manager) error) (*Manager, error) {
+	scratch, err := m.scratchLocked()
+	if err != nil {
+		return nil, err
+	}
+	scratch.staging = true
+
+	if err := apply(scratch); err != nil {
+		return scratch, err
+	}
+
+	if err := m.validateSchema(scratch.source.getConfigObject(), scratch.source.getSchema()); err != nil {
+		return scratch, fmt.Errorf("validation failed: %w", err)
+	}
+	return scratch, nil
+}
+
+// scratchLocked returns a detached manager to try changes on. It holds a
+// copy of the config and the registrations, transformers and validators, but
+// no subscribers or history; the handler calls and events of its changes are
+// deferred, see commitStagedLocked.
+func (m *Manager) scratchLocked() (*Manager, error) {
+	config, err := cloneJSON(m.source.getConfigObject())
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone config: %w", err)
+	}
+
+	source := &CodecSource{store: NewMemoryStore(nil), codec: JSONCodec{}, schema: *m.source.getSchema()}
+	if err := source.adopt(config); err != nil {
+		return nil, err
+	}
+
+	s := &Manager{
+		source:              source,
+		config:              parseNode(config),
+		version:             m.version,
+		transformers:        m.transformers,
+		validators:          m.validators,
+		caseInsensitiveKeys: m.caseInsensitiveKeys,
+		pointerPaths:        m.pointerPaths,
+		unvalidated:         m.unvalidated,
+		logger:              m.logger,
+		history:             history.NewChangeHistory(1),
+	}
+
+	for _, mod := range m.modifiables {
+		if node, err := findNodeByPath(s.config, mod.Path); err == nil {
+			mod.Node = node
+			s.modifiables = append(s.modifiables, mod)
+		}
+	}
+	s.patterns = append(s.patterns, m.patterns...)
+
+	return s, nil
+}
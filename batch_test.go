@@ -0,0 +1,357 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBatchIndependentReportsEachOperation(t *testing.T) {
+	m := newTestManager(t, `{"list":["a"],"name":"x"}`)
+	list, err := m.ConfigRef().At("list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(list, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnRemove(list, nil); err != nil {
+		t.Fatal(err)
+	}
+	replaceable(t, m, "name")
+	before := m.Version()
+
+	code, body := serve(t, m, "POST", "/config/batch?mode=independent", `{"operations":[
+		{"op":"insert","path":"/list","index":1,"value":"b"},
+		{"op":"remove","path":"/list","index":9},
+		{"op":"replace","path":"/name","value":"y"},
+		{"op":"replace","path":"/missing","value":1},
+		{"op":"rename","path":"/name"},
+		"not an operation"
+	]}`)
+	if code != 200 {
+		t.Fatalf("status = %d, want 200: %v", code, body)
+	}
+
+	data, _ := body["data"].(map[string]interface{})
+	results, _ := data["results"].([]interface{})
+	if len(results) != 6 {
+		t.Fatalf("%d results, want 6: %v", len(results), data)
+	}
+	var succeeded []bool
+	for i, raw := range results {
+		result, _ := raw.(map[string]interface{})
+		if result["index"] != float64(i) {
+			t.Errorf("result %d has index %v", i, result["index"])
+		}
+		ok, _ := result["success"].(bool)
+		if msg, _ := result["error"].(string); !ok && msg == "" {
+			t.Errorf("result %d failed without an error message", i)
+		}
+		succeeded = append(succeeded, ok)
+	}
+	if want := []bool{true, false, true, false, false, false}; !reflect.DeepEqual(succeeded, want) {
+		t.Errorf("success = %v, want %v", succeeded, want)
+	}
+
+	if data["version"] != float64(before+2) || m.Version() != before+2 {
+		t.Errorf("version = %v (manager %d), want %d", data["version"], m.Version(), before+2)
+	}
+	if got := m.Config().toInterface(); !reflect.DeepEqual(got, map[string]interface{}{
+		"list": []interface{}{"a", "b"}, "name": "y"}) {
+		t.Errorf("config = %v", got)
+	}
+}
+
+func TestPreviewBatch(t *testing.T) {
+	source, err := NewStrSource(`{"list":["a"],"port":1}`, `{"type":"object","properties":{"port":{"type":"integer","maximum":100}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := m.ConfigRef().At("list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handled := 0
+	if err := m.OnInsert(list, func(*Node) { handled++ }); err != nil {
+		t.Fatal(err)
+	}
+	port, err := m.ConfigRef().At("port")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnReplace(port, func(*Node) { handled++ }); err != nil {
+		t.Fatal(err)
+	}
+	before := m.Version()
+	fingerprint := m.Fingerprint()
+
+	preview, err := m.PreviewBatch([]Operation{
+		{Op: "insert", Path: "/list", Index: 1, Value: "b"},
+		{Op: "replace", Path: "/port", Value: 2},
+		{Op: "insert", Path: "/list", Index: 0, Value: "z"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !preview.Valid || preview.Error != "" || preview.Version != before+1 {
+		t.Errorf("preview = %+v, want valid at version %d", preview, before+1)
+	}
+	want := []DiffEntry{
+		{Path: "/list/0", Op: DiffChange, Old: "a", New: "z"},
+		{Path: "/list/1", Op: DiffAdd, New: "a"},
+		{Path: "/list/2", Op: DiffAdd, New: "b"},
+		{Path: "/port", Op: DiffChange, Old: 1, New: 2},
+	}
+	if !equalJSON(preview.Diff, want) {
+		t.Errorf("diff = %+v, want %+v", preview.Diff, want)
+	}
+
+	// Nothing was applied
+	if m.Version() != before || m.Fingerprint() != fingerprint || handled != 0 || len(m.History().GetAll()) != 0 {
+		t.Errorf("preview changed state: version %d -> %d, %d handler calls", before, m.Version(), handled)
+	}
+
+	// A failing operation is reported, with the diff of those before it
+	preview, err = m.PreviewBatch([]Operation{
+		{Op: "replace", Path: "/port", Value: 5},
+		{Op: "replace", Path: "/missing", Value: 1},
+		{Op: "insert", Path: "/list", Index: 1, Value: "b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.Valid || !strings.Contains(preview.Error, "operation 1") {
+		t.Errorf("preview = %+v, want invalid at operation 1", preview)
+	}
+	if !equalJSON(preview.Diff, []DiffEntry{{Path: "/port", Op: DiffChange, Old: 1, New: 5}}) {
+		t.Errorf("diff = %+v, want only the first replace", preview.Diff)
+	}
+
+	// So is a result failing the schema
+	preview, err = m.PreviewBatch([]Operation{{Op: "replace", Path: "/port", Value: 500}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.Valid || !strings.Contains(preview.Error, "validation failed") {
+		t.Errorf("preview = %+v, want a validation failure", preview)
+	}
+	if m.Version() != before {
+		t.Errorf("version = %d, want %d", m.Version(), before)
+	}
+}
+
+func TestBatchIsAtomic(t *testing.T) {
+	source, err := NewStrSource(`{"list":["a"],"min":1,"max":5}`,
+		`{"type":"object","properties":{"min":{"type":"integer","maximum":10},"max":{"type":"integer","minimum":5}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := m.ConfigRef().At("list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handled := 0
+	if err := m.OnInsert(list, func(*Node) { handled++ }); err != nil {
+		t.Fatal(err)
+	}
+	replaceable(t, m, "min")
+	replaceable(t, m, "max")
+	before := m.Version()
+
+	// A failing operation leaves everything as it was
+	err = m.Batch([]Operation{
+		{Op: "insert", Path: "/list", Index: 1, Value: "b"},
+		{Op: "replace", Path: "/min", Value: 2},
+		{Op: "insert", Path: "/list", Index: 9, Value: "c"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "operation 2") {
+		t.Errorf("batch with an out-of-bounds insert: %v, want operation 2 named", err)
+	}
+	if got := m.ConfigRef().toInterface(); m.Version() != before || !equalJSON(got, map[string]interface{}{"list": []interface{}{"a"}, "max": 5, "min": 1}) {
+		t.Errorf("after failed batch: version %d, config %v, want unchanged", m.Version(), got)
+	}
+
+	// Only the result has to conform: max passes through 4 on the way
+	err = m.Batch([]Operation{
+		{Op: "replace", Path: "/max", Value: 4},
+		{Op: "replace", Path: "/max", Value: 8},
+		{Op: "replace", Path: "/min", Value: 7},
+		{Op: "insert", Path: "/list", Index: 1, Value: "b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"list": []interface{}{"a", "b"}, "max": 8, "min": 7}
+	if got := m.ConfigRef().toInterface(); !equalJSON(got, want) {
+		t.Errorf("config = %v, want %v", got, want)
+	}
+	if m.Version() != before+1 {
+		t.Errorf("version = %d, want %d", m.Version(), before+1)
+	}
+
+	events := m.History().GetAll()
+	if len(events) != 1 || events[0].Operation != BatchOperation || events[0].Path != "/" ||
+		!reflect.DeepEqual(events[0].ChangedFields, []string{"/list/1", "/max", "/min"}) {
+		t.Errorf("history = %+v, want one batch event listing the changed paths", events)
+	}
+	if handled != 1 {
+		t.Errorf("handler called %d times, want once for the insert", handled)
+	}
+
+	// The registered nodes follow the new tree
+	if err := m.Replace("/min", 3); err != nil {
+		t.Errorf("replace after batch: %v", err)
+	}
+
+	// A result failing the schema is rejected
+	if err := m.Batch([]Operation{{Op: "replace", Path: "/max", Value: 1}}); err == nil {
+		t.Error("batch producing an invalid config accepted")
+	}
+}
+
+func TestBatchCriticalHandlerVetoes(t *testing.T) {
+	m := newTestManager(t, `{"a":1,"b":1}`)
+	node, err := m.liveNode("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got interface{}
+	err = m.OnReplaceWithContext(node, func(_ context.Context, n *Node) error {
+		got = n.toInterface()
+		return errors.New("no")
+	}, HandlerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnReplacePath("/b", nil); err != nil {
+		t.Fatal(err)
+	}
+	version := m.Version()
+
+	err = m.Batch([]Operation{
+		{Op: "replace", Path: "/b", Value: 2},
+		{Op: "replace", Path: "/a", Value: 2},
+	})
+	if err == nil {
+		t.Fatal("Batch succeeded, want the critical handler's veto")
+	}
+	if !sameJSON(got, 2) {
+		t.Errorf("handler got %v, want 2", got)
+	}
+	if m.Version() != version {
+		t.Errorf("version = %d after a vetoed batch, want %d", m.Version(), version)
+	}
+	if node, _ := m.LookupPath("/b"); !sameJSON(node.toInterface(), 1) {
+		t.Errorf("/b = %v after a vetoed batch, want 1", node.toInterface())
+	}
+}
+
+func TestStagedChangesCallHandlersAfterCommit(t *testing.T) {
+	m := newTestManager(t, `{"a":{"x":1},"list":[]}`)
+
+	var calls []string
+	record := func(name string) func(*Node) {
+		return func(n *Node) {
+			// Best-effort handlers run after the commit
+			if _, version, err := m.lookup("/a"); err == nil {
+				calls = append(calls, name+"@"+jsonString(version)+"="+jsonString(n.toInterface()))
+			}
+		}
+	}
+	if err := m.OnReplacePath("/a", record("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsertPath("/list", record("list")); err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	err := m.OnPathReplace("/a", func(path string, _, new *Node) {
+		events = append(events, path+"="+jsonString(new.toInterface()))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Batch([]Operation{
+		{Op: "insert", Path: "/list", Index: 0, Value: "x"},
+		{Op: "replace", Path: "/a", Value: map[string]interface{}{"x": 2}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Patch([]JSONPatchOperation{{Op: "replace", Path: "/a", Value: map[string]interface{}{"x": 3}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.MergePatch(map[string]interface{}{"a": map[string]interface{}{"x": 4}}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{`list@2="x"`, `a@2={"x":2}`, `a@3={"x":3}`, `a@4={"x":4}`}
+	if jsonString(calls) != jsonString(want) {
+		t.Errorf("handler calls = %v, want %v", calls, want)
+	}
+	wantEvents := []string{`/a={"x":2}`, `/a={"x":3}`, `/a={"x":4}`}
+	if jsonString(events) != jsonString(wantEvents) {
+		t.Errorf("path events = %v, want %v", events, wantEvents)
+	}
+}
+
+func TestBatchDefaultsToAtomic(t *testing.T) {
+	m := newTestManager(t, `{"list":["a"],"name":"x"}`)
+	list, err := m.ConfigRef().At("list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(list, nil); err != nil {
+		t.Fatal(err)
+	}
+	replaceable(t, m, "name")
+	before := m.Version()
+
+	code, body := serve(t, m, "POST", "/config/batch", `{"operations":[
+		{"op":"insert","path":"/list","index":1,"value":"b"},
+		{"op":"replace","path":"/missing","value":1}
+	]}`)
+	if code == 200 {
+		t.Errorf("status = 200 for a failing atomic batch: %v", body)
+	}
+	if m.Version() != before {
+		t.Errorf("version = %d after a failed batch, want %d", m.Version(), before)
+	}
+
+	code, body = serve(t, m, "POST", "/config/batch", `{"operations":[
+		{"op":"insert","path":"/list","index":1,"value":"b"},
+		{"op":"replace","path":"/name","value":"y"}
+	]}`)
+	data, _ := body["data"].(map[string]interface{})
+	if code != 200 || !equalJSON(data["version"], before+1) {
+		t.Errorf("status = %d, body = %v, want 200 at version %d", code, body, before+1)
+	}
+
+	// mode=independent carries on past failures
+	code, body = serve(t, m, "POST", "/config/batch?mode=independent", `{"operations":[
+		{"op":"replace","path":"/name","value":"z"},
+		{"op":"replace","path":"/missing","value":1}
+	]}`)
+	data, _ = body["data"].(map[string]interface{})
+	if results, _ := data["results"].([]interface{}); code != 200 || len(results) != 2 {
+		t.Errorf("independent: status = %d, body = %v, want a result per operation", code, body)
+	}
+	if name, _ := m.ConfigRef().GetString("name"); name != "z" {
+		t.Errorf("name = %q, want z despite the failing operation", name)
+	}
+
+	if code, _ := serve(t, m, "POST", "/config/batch?mode=eventual", `{"operations":[]}`); code != 400 {
+		t.Errorf("unknown mode: status = %d, want 400", code)
+	}
+}
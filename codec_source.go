@@ -0,0 +1,213 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Store reads and writes the raw bytes of a config document. Implementations
+// only deal with where the document lives; the format is up to the Codec.
+type Store interface {
+	Load() ([]byte, error)
+	Save(data []byte) error
+}
+
+// Codec converts a config document between its stored bytes and the parsed
+// form used by the manager: an *orderedmap.OrderedMap for object roots or a
+// []interface{} for array roots.
+type Codec interface {
+	Decode(data []byte) (interface{}, error)
+	Encode(config interface{}) ([]byte, error)
+}
+
+// JSONCodec stores configs as indented JSON
+type JSONCodec struct{}
+
+func (JSONCodec) Decode(data []byte) (interface{}, error) {
+	return parseConfig(data)
+}
+
+func (JSONCodec) Encode(config interface{}) ([]byte, error) {
+	return json.MarshalIndent(config, "", "  ")
+}
+
+// MemoryStore keeps the document in memory
+type MemoryStore struct {
+	mu   sync.RWMutex
+	data []byte
+}
+
+func NewMemoryStore(data []byte) *MemoryStore {
+	return &MemoryStore{data: append([]byte(nil), data...)}
+}
+
+func (s *MemoryStore) Load() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]byte(nil), s.data...), nil
+}
+
+func (s *MemoryStore) Save(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = append([]byte(nil), data...)
+	return nil
+}
+
+// FileStore keeps the document in a file, replacing it atomically on Save
+type FileStore struct {
+	Path string
+}
+
+func (s *FileStore) Load() ([]byte, error) {
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	return data, nil
+}
+
+func (s *FileStore) Save(data []byte) error {
+	// Write to temp file first, then rename (atomic operation)
+	tempPath := s.Path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+
+	if err := os.Rename(tempPath, s.Path); err != nil {
+		os.Remove(tempPath) // Clean up temp file
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	return nil
+}
+
+// CodecSource is an ISource that keeps its document in a Store, encoded with
+// a Codec
+type CodecSource struct {
+	mu           sync.RWMutex
+	store        Store
+	codec        Codec
+	configObject interface{}
+	config       string // JSON, whatever the codec
+	schema       string
+	meter        sourceMeter
+}
+
+func NewCodecSource(store Store, codec Codec, schema string) (*CodecSource, error) {
+	if store == nil {
+		return nil, fmt.Errorf("store cannot be nil")
+	}
+	if codec == nil {
+		return nil, fmt.Errorf("codec cannot be nil")
+	}
+
+	s := &CodecSource{store: store, codec: codec, schema: schema}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads and decodes the document from the store
+func (s *CodecSource) load() error {
+	config, err := s.fetch()
+	if err != nil {
+		return err
+	}
+	return s.adopt(config)
+}
+
+// fetch reads and decodes the document from the store without making it
+// current
+func (s *CodecSource) fetch() (interface{}, error) {
+	start := time.Now()
+	data, err := s.store.Load()
+	s.meter.observe(loadStats, start, err)
+	if err != nil {
+		return nil, err
+	}
+	return s.codec.Decode(data)
+}
+
+// adopt makes an already stored config current without writing it back
+func (s *CodecSource) adopt(config interface{}) error {
+	return s.adoptWithSchema(config, nil)
+}
+
+// adoptWithSchema is adopt, also replacing the schema in the same step
+// unless schema is nil
+func (s *CodecSource) adoptWithSchema(config interface{}, schema *string) error {
+	configJSON, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	s.mu.Lock()
+	s.configObject = config
+	s.config = string(configJSON)
+	if schema != nil {
+		s.schema = *schema
+	}
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Stats returns the latency and failure counts of the store's loads and
+// saves
+func (s *CodecSource) Stats() SourceStats {
+	return s.meter.snapshot()
+}
+
+func (s *CodecSource) getConfigObject() interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.configObject
+}
+
+func (s *CodecSource) getConfig() *string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	config := s.config
+	return &config
+}
+
+func (s *CodecSource) getSchema() *string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	schema := s.schema
+	return &schema
+}
+
+func (s *CodecSource) setConfig(conf interface{}) error {
+	if conf == nil {
+		return fmt.Errorf("config cannot be nil")
+	}
+
+	data, err := s.codec.Encode(conf)
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	configJSON, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	start := time.Now()
+	err = s.store.Save(data)
+	s.meter.observe(persistStats, start, err)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.configObject = conf
+	s.config = string(configJSON)
+	s.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCodecSourceWithMemoryStore(t *testing.T) {
+	store := NewMemoryStore([]byte(`{"db":{"host":"a"}}`))
+	source, err := NewCodecSource(store, JSONCodec{}, `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaceable(t, m, "db")
+
+	if err := m.Replace("/db", map[string]interface{}{"host": "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("stored %q: %v", data, err)
+	}
+	want := map[string]interface{}{"db": map[string]interface{}{"host": "b"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("stored %v, want %v", got, want)
+	}
+
+	// A second source over the same store sees the saved document
+	reloaded, err := NewCodecSource(store, JSONCodec{}, `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalJSON(reloaded.getConfigObject(), want) {
+		t.Errorf("reloaded %s, want %v", *reloaded.getConfig(), want)
+	}
+}
+
+func TestCodecSourceRejectsMissingParts(t *testing.T) {
+	if _, err := NewCodecSource(nil, JSONCodec{}, `{}`); err == nil {
+		t.Error("nil store accepted")
+	}
+	if _, err := NewCodecSource(NewMemoryStore([]byte(`{}`)), nil, `{}`); err == nil {
+		t.Error("nil codec accepted")
+	}
+}
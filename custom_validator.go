@@ -0,0 +1,213 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ValidatorFunc checks a modification before it is applied. For an insert
+// old is nil and new is the element being added; for a remove old is the
+// element being removed and new the array as it would be without it, so
+// rules such as a minimum count can be enforced; a replace gets the current
+// and the new value. Returning an error rejects the modification.
+type ValidatorFunc func(path string, old, new *Node) error
+
+type customValidator struct {
+	expr    string
+	pattern []querySegment
+	exact   string // the only path pattern matches when it has no wildcards
+	ops     []modifiableType
+	fn      ValidatorFunc
+}
+
+// AddValidator registers fn to check modifications at paths matching
+// pathPattern, a query expression such as "/users/0/age", "/users/*/age" or
+// "/users/[*]/email", so one validator covers every element whatever its
+// index. For inserts and removes the pattern may match either the element or
+// the array. ops selects the operations fn applies to (Insertable, Removable,
+// Replaceable) and defaults to inserts and replaces, so a remove-time
+// validator, e.g. one refusing to remove the last admin, has to ask for
+// Removable explicitly.
+// Validators run after schema validation and transformers, before critical
+// handlers. fn runs while the manager is locked and must not call back into
+// the manager; it gets copies of the nodes.
+func (m *Manager) AddValidator(pathPattern string, fn ValidatorFunc, ops ...modifiableType) error {
+	if fn == nil {
+		return errors.New("validator cannot be nil")
+	}
+
+	pattern, err := parseQuery(pathPattern)
+	if err != nil {
+		return fmt.Errorf("invalid validator pattern: %w", err)
+	}
+
+	if len(ops) == 0 {
+		ops = []modifiableType{Insertable, Replaceable}
+	}
+	for _, op := range ops {
+		if op.String() == "unknown" {
+			return fmt.Errorf("unknown operation type %d", op)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.validators = append(m.validators, customValidator{
+		expr:    pathPattern,
+		pattern: pattern,
+		exact:   literalPath(pattern),
+		ops:     ops,
+		fn:      fn,
+	})
+	return nil
+}
+
+// runValidatorsLocked checks the rate limits (see SetChangeRateLimit), then
+// runs the validators registered for ev.op whose pattern matches the element
+// or its container, stopping at the first rejection
+func (m *Manager) runValidatorsLocked(ev pathEvent) error {
+	if err := m.checkRateLimitLocked(ev.path); err != nil {
+		return err
+	}
+
+	for _, v := range m.validators {
+		if !v.appliesTo(ev.op) {
+			continue
+		}
+		if !v.matches(ev.path) && (ev.container == "" || !v.matches(ev.container)) {
+			continue
+		}
+
+		var old, new *Node
+		if ev.old != nil {
+			old = ev.old.DeepCopy()
+		}
+		if ev.new != nil {
+			new = ev.new.DeepCopy()
+		}
+
+		if err := v.fn(ev.path, old, new); err != nil {
+			return fmt.Errorf("validator '%s' rejected %s at '%s': %w", v.expr, ev.op, ev.path, err)
+		}
+	}
+	return nil
+}
+
+// matches reports whether the validator's pattern matches the concrete path,
+// comparing the path as is when the pattern has no wildcards
+func (v customValidator) matches(path string) bool {
+	if v.exact != "" {
+		return path == v.exact
+	}
+	return matchesPath(v.pattern, path)
+}
+
+// literalPath returns the path a pattern without wildcards or recursive
+// segments matches, or "" when it matches more than one
+func literalPath(pattern []querySegment) string {
+	parts := make([]string, 0, len(pattern))
+	for _, seg := range pattern {
+		if seg.recursive {
+			return ""
+		}
+		switch seg.kind {
+		case segmentName:
+			parts = append(parts, seg.name)
+		case segmentIndex:
+			parts = append(parts, strconv.Itoa(seg.index))
+		default:
+			return ""
+		}
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+func (v customValidator) appliesTo(op modifiableType) bool {
+	for _, o := range v.ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateSchemaEnum returns a validator enforcing the "enum" constraints the
+// schema declares for the new value and everything below it, so the allowed
+// values are only maintained in the schema:
+//
+//	m.AddValidator("/log/level", m.ValidateSchemaEnum())
+//
+// The schema is read when the validator runs, so schema changes apply
+// immediately. A rejection lists the allowed values. Removes and values the
+// schema declares no enum for pass. The validator can also be called on its
+// own, e.g. to check a value before submitting it.
+func (m *Manager) ValidateSchemaEnum() ValidatorFunc {
+	return func(path string, _, new *Node) error {
+		if new == nil {
+			return nil
+		}
+
+		root, err := parseSchema(m.source.getSchema())
+		if err != nil || root == nil {
+			return nil
+		}
+
+		internal, err := m.internalPath(path)
+		if err != nil {
+			return err
+		}
+		return checkSchemaEnums(root, internal, new, m.externalPath)
+	}
+}
+
+// checkSchemaEnums checks node, found at path, and its descendants against
+// the enums of their sub-schemas
+func checkSchemaEnums(root map[string]interface{}, path string, node *Node, external func(string) string) error {
+	if schema := schemaForPath(root, path); schema != nil {
+		if allowed, ok := schema["enum"].([]interface{}); ok && !inEnum(node.toInterface(), allowed) {
+			value, _ := json.Marshal(node.toInterface())
+			return fmt.Errorf("value %s at '%s' is not one of the allowed values: %s", value, external(path), describeEnum(allowed))
+		}
+	}
+
+	switch node.Type() {
+	case Object:
+		obj, _ := node.GetObject()
+		for _, key := range sortedKeys(obj) {
+			if err := checkSchemaEnums(root, joinEscaped(strings.TrimSuffix(path, "/"), key), obj[key], external); err != nil {
+				return err
+			}
+		}
+	case Array:
+		arr, _ := node.GetArray()
+		for i, item := range arr {
+			if err := checkSchemaEnums(root, strings.TrimSuffix(path, "/")+"/"+strconv.Itoa(i), item, external); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// inEnum compares as JSON, so that e.g. 1 matches an enum's 1.0
+func inEnum(value interface{}, allowed []interface{}) bool {
+	for _, candidate := range allowed {
+		if sameJSON(value, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+func describeEnum(allowed []interface{}) string {
+	parts := make([]string, 0, len(allowed))
+	for _, v := range allowed {
+		data, _ := json.Marshal(v)
+		parts = append(parts, string(data))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,268 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// lastAdminGuard refuses to remove the only admin left in users
+func lastAdminGuard(users *Node) ValidatorFunc {
+	return func(path string, old, _ *Node) error {
+		if role, _ := old.GetString("role"); role != "admin" {
+			return nil
+		}
+		admins := 0
+		items, _ := users.GetArray()
+		for _, item := range items {
+			if role, _ := item.GetString("role"); role == "admin" {
+				admins++
+			}
+		}
+		if admins <= 1 {
+			return errors.New("cannot remove the last admin")
+		}
+		return nil
+	}
+}
+
+func TestRemoveValidatorVetoesRemoval(t *testing.T) {
+	m := newTestManager(t, `{"users":[{"name":"ann","role":"admin"},{"name":"bob","role":"user"}]}`)
+	users, err := m.ConfigRef().At("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnRemove(users, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddValidator("/users/*", lastAdminGuard(users), Removable); err != nil {
+		t.Fatal(err)
+	}
+	before := m.Version()
+
+	err = m.Remove("/users", 0)
+	if err == nil || !strings.Contains(err.Error(), "last admin") {
+		t.Fatalf("err = %v, want the validator's veto", err)
+	}
+	if items, _ := users.GetArray(); len(items) != 2 || m.Version() != before {
+		t.Errorf("users = %v, version = %d, want both users at version %d", users.toInterface(), m.Version(), before)
+	}
+
+	if err := m.Remove("/users", 1); err != nil {
+		t.Errorf("removing a non-admin: %v", err)
+	}
+}
+
+func TestValidatorOperations(t *testing.T) {
+	m := newTestManager(t, `{"list":["a"]}`)
+	list, err := m.ConfigRef().At("list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(list, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnRemove(list, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls []string
+	record := func(path string, old, new *Node) error {
+		calls = append(calls, path)
+		return nil
+	}
+	// Without operations a validator covers inserts and replaces only
+	if err := m.AddValidator("/list", record); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Insert("/list", 1, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Remove("/list", 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 || calls[0] != "/list/1" {
+		t.Errorf("validator calls = %v, want only the insert at /list/1", calls)
+	}
+
+	if err := m.AddValidator("/list", record, modifiableType(99)); err == nil {
+		t.Error("unknown operation accepted")
+	}
+}
+
+func TestRemoveValidatorSeesRemainingArray(t *testing.T) {
+	m := newTestManager(t, `{"servers":[{"host":"a","enabled":true},{"host":"b","enabled":false},{"host":"c","enabled":true}]}`)
+	servers, err := m.ConfigRef().At("servers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnRemove(servers, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Never remove the last enabled server
+	err = m.AddValidator("/servers", func(_ string, _, remaining *Node) error {
+		items, err := remaining.GetArray()
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if enabled, _ := item.GetBool("enabled"); enabled {
+				return nil
+			}
+		}
+		return errors.New("at least one server must stay enabled")
+	}, Removable)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Remove("/servers", 0); err != nil {
+		t.Fatalf("removing a: %v", err)
+	}
+	if err := m.Remove("/servers", 1); err == nil {
+		t.Fatal("removed the last enabled server")
+	}
+	if err := m.Remove("/servers", 0); err != nil {
+		t.Errorf("removing disabled b: %v", err)
+	}
+
+	items, _ := servers.GetArray()
+	if len(items) != 1 {
+		t.Fatalf("servers = %v, want only c", servers.toInterface())
+	}
+	if host, _ := items[0].GetString("host"); host != "c" {
+		t.Errorf("remaining server = %q, want c", host)
+	}
+	if err := m.VerifyConsistency(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateSchemaEnum(t *testing.T) {
+	schema := `{"type":"object","properties":{
+		"log":{"type":"object","properties":{"level":{"enum":["debug","info","warn"]}}},
+		"servers":{"type":"array","items":{"type":"object","properties":{"mode":{"enum":["a","b"]},"weight":{"enum":[1,2]}}}}}}`
+	source, err := NewStrSource(`{"log":{"level":"info"},"servers":[{"mode":"a","weight":1}]}`, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaceable(t, m, "log")
+	servers, err := m.ConfigRef().At("servers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(servers, nil); err != nil {
+		t.Fatal(err)
+	}
+	validate := m.ValidateSchemaEnum()
+	if err := m.AddValidator("/log", validate); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddValidator("/servers", validate); err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Replace("/log", map[string]interface{}{"level": "trace"})
+	if err == nil || !strings.Contains(err.Error(), `"debug", "info", "warn"`) {
+		t.Errorf("level outside the enum: %v, want the allowed values listed", err)
+	}
+	if err := m.Replace("/log", map[string]interface{}{"level": "warn"}); err != nil {
+		t.Errorf("level in the enum: %v", err)
+	}
+
+	// Elements of arrays follow the items schema
+	err = m.Insert("/servers", 1, map[string]interface{}{"mode": "c", "weight": 1})
+	if err == nil || !strings.Contains(err.Error(), `"a", "b"`) {
+		t.Errorf("mode outside the enum: %v, want the allowed values listed", err)
+	}
+	if err := m.Insert("/servers", 1, map[string]interface{}{"mode": "b", "weight": 2}); err != nil {
+		t.Errorf("server in the enum: %v", err)
+	}
+
+	// Called on its own, e.g. to check a value first
+	err = validate("/log", nil, parseNode(map[string]interface{}{"level": "trace"}))
+	if err == nil || !strings.Contains(err.Error(), `"trace" at '/log/level'`) {
+		t.Errorf("level outside the enum: %v, want the value and its path named", err)
+	}
+	candidate := parseNode(map[string]interface{}{"mode": "a", "weight": 3})
+	if err := validate("/servers/0", nil, candidate); err == nil || !strings.Contains(err.Error(), "1, 2") {
+		t.Errorf("weight outside the enum: %v", err)
+	}
+	if err := validate("/servers/0", nil, nil); err != nil {
+		t.Errorf("remove rejected: %v", err)
+	}
+}
+
+func TestValidatorPatterns(t *testing.T) {
+	m := newTestManager(t, `{"users":[{"age":1,"email":"a@x"},{"age":2,"email":"b@x"}]}`)
+	for _, path := range []string{"/users/0/age", "/users/1/age", "/users/0/email", "/users/1/email"} {
+		if err := m.OnReplacePath(path, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var calls []string
+	record := func(name string) ValidatorFunc {
+		return func(path string, old, new *Node) error {
+			calls = append(calls, name+" "+path)
+			return nil
+		}
+	}
+	for pattern, name := range map[string]string{
+		"/users/*/age":     "ages",
+		"/users/[*]/email": "emails",
+		"/users/0/age":     "first age",
+		"/users/[1]/age":   "second age",
+	} {
+		if err := m.AddValidator(pattern, record(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, path := range []string{"/users/0/age", "/users/1/age", "/users/1/email"} {
+		calls = nil
+		if err := m.Replace(path, 3); err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(calls)
+		var want []string
+		switch path {
+		case "/users/0/age":
+			want = []string{"ages /users/0/age", "first age /users/0/age"}
+		case "/users/1/age":
+			want = []string{"ages /users/1/age", "second age /users/1/age"}
+		case "/users/1/email":
+			want = []string{"emails /users/1/email"}
+		}
+		if !reflect.DeepEqual(calls, want) {
+			t.Errorf("replace of %s: validator calls %v, want %v", path, calls, want)
+		}
+	}
+}
+
+func TestLiteralPath(t *testing.T) {
+	for pattern, want := range map[string]string{
+		"/users/0/age":     "/users/0/age",
+		"/users/[0]/age":   "/users/0/age",
+		"/":                "/",
+		"/users/*/age":     "",
+		"/users/[*]/email": "",
+		"//age":            "",
+	} {
+		segments, err := parseQuery(pattern)
+		if err != nil {
+			t.Fatalf("%s: %v", pattern, err)
+		}
+		if got := literalPath(segments); got != want {
+			t.Errorf("literalPath(%s) = %q, want %q", pattern, got, want)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type DiffOp string
+
+const (
+	DiffAdd    DiffOp = "add"
+	DiffRemove DiffOp = "remove"
+	DiffChange DiffOp = "change"
+)
+
+// DiffEntry describes a single difference between two node trees
+type DiffEntry struct {
+	Path string      `json:"path"`
+	Op   DiffOp      `json:"op"`
+	Old  interface{} `json:"old"`
+	New  interface{} `json:"new"`
+}
+
+// DiffNodes returns the minimal set of differences needed to turn a into b.
+// Unchanged subtrees are omitted and object keys are visited in sorted order.
+func DiffNodes(a, b *Node) []DiffEntry {
+	out := make([]DiffEntry, 0)
+	diffNodesRecursive("", a, b, joinPlain, &out)
+	return out
+}
+
+// diffPointers is DiffNodes with paths as JSON Pointers, "/" for the root
+func diffPointers(a, b *Node) []DiffEntry {
+	out := make([]DiffEntry, 0)
+	diffNodesRecursive("", a, b, joinEscaped, &out)
+	for i := range out {
+		if out[i].Path == "" {
+			out[i].Path = "/"
+		}
+	}
+	return out
+}
+
+func joinPlain(path, key string) string   { return path + "/" + key }
+func joinEscaped(path, key string) string { return path + "/" + escapePointerSegment(key) }
+
+func diffNodesRecursive(path string, a, b *Node, join func(path, key string) string, out *[]DiffEntry) {
+	if a.Type() == Object && b.Type() == Object {
+		objA, _ := a.GetObject()
+		objB, _ := b.GetObject()
+
+		for _, key := range sortedKeys(objA) {
+			childPath := join(path, key)
+			if childB, ok := objB[key]; ok {
+				diffNodesRecursive(childPath, objA[key], childB, join, out)
+			} else {
+				*out = append(*out, DiffEntry{Path: childPath, Op: DiffRemove, Old: objA[key].toInterface()})
+			}
+		}
+		for _, key := range sortedKeys(objB) {
+			if _, ok := objA[key]; !ok {
+				*out = append(*out, DiffEntry{Path: join(path, key), Op: DiffAdd, New: objB[key].toInterface()})
+			}
+		}
+		return
+	}
+
+	if a.Type() == Array && b.Type() == Array {
+		arrA, _ := a.GetArray()
+		arrB, _ := b.GetArray()
+
+		for i := 0; i < len(arrA) || i < len(arrB); i++ {
+			childPath := path + "/" + strconv.Itoa(i)
+			switch {
+			case i >= len(arrA):
+				*out = append(*out, DiffEntry{Path: childPath, Op: DiffAdd, New: arrB[i].toInterface()})
+			case i >= len(arrB):
+				*out = append(*out, DiffEntry{Path: childPath, Op: DiffRemove, Old: arrA[i].toInterface()})
+			default:
+				diffNodesRecursive(childPath, arrA[i], arrB[i], join, out)
+			}
+		}
+		return
+	}
+
+	oldValue := a.toInterface()
+	newValue := b.toInterface()
+	if isNumeric(a.Type()) && isNumeric(b.Type()) {
+		if !numbersEqual(a, b) {
+			*out = append(*out, DiffEntry{Path: path, Op: DiffChange, Old: oldValue, New: newValue})
+		}
+		return
+	}
+	if a.Type() != b.Type() || !reflect.DeepEqual(oldValue, newValue) {
+		*out = append(*out, DiffEntry{Path: path, Op: DiffChange, Old: oldValue, New: newValue})
+	}
+}
+
+// numbersEqual compares numeric nodes by value, so 1, int64(1) and 1.0 are
+// equal; integers are compared exactly
+func numbersEqual(a, b *Node) bool {
+	if a.Type() == Integral && b.Type() == Integral {
+		return integerValue(a) == integerValue(b)
+	}
+	x, _ := a.getFloat()
+	y, _ := b.getFloat()
+	return x == y
+}
+
+func integerValue(n *Node) int64 {
+	switch v := n.value.(type) {
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	default:
+		return 0
+	}
+}
+
+func sortedKeys(obj map[string]*Node) []string {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// VerifyConsistency checks that the in-memory node tree matches the config
+// held by the source and describes every difference when it does not. Both
+// sides go through JSON first so that numeric types compare equal.
+func (m *Manager) VerifyConsistency() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	configStr := m.source.getConfig()
+	if configStr == nil {
+		return fmt.Errorf("source config is nil")
+	}
+	stored, err := parseConfig([]byte(*configStr))
+	if err != nil {
+		return fmt.Errorf("failed to parse source config: %w", err)
+	}
+
+	treeJSON, err := json.Marshal(m.config.toInterface())
+	if err != nil {
+		return fmt.Errorf("failed to marshal config tree: %w", err)
+	}
+	tree, err := parseValue(treeJSON)
+	if err != nil {
+		return fmt.Errorf("failed to parse config tree: %w", err)
+	}
+
+	diff := DiffNodes(parseNode(stored), parseNode(tree))
+	if len(diff) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("config tree differs from source:")
+	for _, d := range diff {
+		switch d.Op {
+		case DiffAdd:
+			sb.WriteString(fmt.Sprintf("\n  %s: only in tree: %v", d.Path, d.New))
+		case DiffRemove:
+			sb.WriteString(fmt.Sprintf("\n  %s: only in source: %v", d.Path, d.Old))
+		default:
+			sb.WriteString(fmt.Sprintf("\n  %s: source %v, tree %v", d.Path, d.Old, d.New))
+		}
+	}
+	return fmt.Errorf("%s", sb.String())
+}
+
+// Fingerprint returns the hex sha256 of the config in a canonical form:
+// compact JSON with object keys sorted. It does not depend on key order or
+// on the version, so equal content always gives the same fingerprint, also
+// across restarts.
+func (m *Manager) Fingerprint() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// Maps marshal with sorted keys
+	data, err := json.Marshal(m.config.toInterface())
+	if err != nil {
+		return ""
+	}
+	return HashSHA256(string(data))
+}
+
+// Comparison is the outcome of checking a proposed config against the
+// current one
+type Comparison struct {
+	Diff    []DiffEntry `json:"diff"`  // turns the current config into the proposed one
+	Valid   bool        `json:"valid"` // whether the proposed config passes the schema
+	Error   string      `json:"error,omitempty"`
+	Version int64       `json:"version"` // version of the current config compared against
+}
+
+// Compare diffs a complete proposed config document against the current
+// config and validates it against the schema, without applying anything.
+// An error is only returned when proposed is not a config document.
+func (m *Manager) Compare(proposed []byte) (*Comparison, error) {
+	doc, err := parseConfig(proposed)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	current, err := cloneJSON(m.source.getConfigObject())
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone config: %w", err)
+	}
+
+	result := &Comparison{
+		Diff:    DiffNodes(parseNode(current), parseNode(doc)),
+		Valid:   true,
+		Version: m.version,
+	}
+	if err := m.validateSchema(doc, m.source.getSchema()); err != nil {
+		result.Valid = false
+		result.Error = err.Error()
+	}
+	return result, nil
+}
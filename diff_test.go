@@ -0,0 +1,200 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyConsistency(t *testing.T) {
+	m := newTestManager(t, `{"port":1,"items":[{"name":"a"}]}`)
+	replaceable(t, m, "port")
+	items, err := m.ConfigRef().At("items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(items, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Replace("/port", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Insert("/items", 1, map[string]interface{}{"name": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.VerifyConsistency(); err != nil {
+		t.Fatalf("consistent manager: %v", err)
+	}
+
+	// Change the source behind the tree's back
+	stored, err := parseConfig([]byte(`{"port":3,"items":[{"name":"a"},{"name":"b"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.source.setConfig(stored); err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.VerifyConsistency()
+	if err == nil {
+		t.Fatal("desynced manager passed the check")
+	}
+	if !strings.Contains(err.Error(), "/port: source 3, tree 2") {
+		t.Errorf("err = %v, want it to name /port", err)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	a := newTestManager(t, `{"db":{"host":"a","port":1},"tags":["x","y"]}`)
+	b := newTestManager(t, `{"tags":["x","y"],"db":{"port":1,"host":"a"}}`)
+
+	fp := a.Fingerprint()
+	if len(fp) != 64 {
+		t.Fatalf("fingerprint = %q, want 64 hex digits", fp)
+	}
+	if b.Fingerprint() != fp {
+		t.Errorf("reordered keys: fingerprint %s, want %s", b.Fingerprint(), fp)
+	}
+
+	// Array order is content
+	if c := newTestManager(t, `{"db":{"host":"a","port":1},"tags":["y","x"]}`); c.Fingerprint() == fp {
+		t.Error("reordered array has the same fingerprint")
+	}
+
+	replaceable(t, a, "db")
+	if err := a.Replace("/db", map[string]interface{}{"host": "b", "port": 1}); err != nil {
+		t.Fatal(err)
+	}
+	changed := a.Fingerprint()
+	if changed == fp {
+		t.Error("fingerprint unchanged after a change")
+	}
+	if err := a.Replace("/db", map[string]interface{}{"port": 1, "host": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if a.Fingerprint() != fp {
+		t.Error("fingerprint differs after restoring the original content")
+	}
+
+	code, body := serve(t, a, "GET", "/config/stats", "")
+	if data, _ := body["data"].(map[string]interface{}); code != 200 || data["fingerprint"] != fp {
+		t.Errorf("GET /config/stats: status = %d, data = %v, want fingerprint %s", code, data, fp)
+	}
+
+	if code, body := serve(t, a, "GET", "/health", ""); code != 200 || body["fingerprint"] != fp {
+		t.Errorf("GET /health: status = %d, body = %v, want fingerprint %s", code, body, fp)
+	}
+}
+
+func TestDiffNodes(t *testing.T) {
+	a := parseNode(map[string]interface{}{
+		"same":    map[string]interface{}{"deep": []interface{}{1, 2}},
+		"changed": "a",
+		"gone":    true,
+		"list":    []interface{}{1, 2, 3},
+		"nested":  map[string]interface{}{"k": map[string]interface{}{"v": 1, "w": 2}},
+		"kind":    []interface{}{1},
+	})
+	b := parseNode(map[string]interface{}{
+		"same":    map[string]interface{}{"deep": []interface{}{1, 2}},
+		"changed": "b",
+		"added":   nil,
+		"list":    []interface{}{1, 5},
+		"nested":  map[string]interface{}{"k": map[string]interface{}{"v": 1, "w": 3}},
+		"kind":    map[string]interface{}{"0": 1},
+	})
+
+	want := []DiffEntry{
+		{Path: "/changed", Op: DiffChange, Old: "a", New: "b"},
+		{Path: "/gone", Op: DiffRemove, Old: true},
+		{Path: "/kind", Op: DiffChange, Old: []interface{}{1}, New: map[string]interface{}{"0": 1}},
+		{Path: "/list/1", Op: DiffChange, Old: 2, New: 5},
+		{Path: "/list/2", Op: DiffRemove, Old: 3},
+		{Path: "/nested/k/w", Op: DiffChange, Old: 2, New: 3},
+		{Path: "/added", Op: DiffAdd},
+	}
+	if got := DiffNodes(a, b); !equalJSON(got, want) {
+		t.Errorf("DiffNodes = %+v, want %+v", got, want)
+	}
+	if got := DiffNodes(a, a.DeepCopy()); len(got) != 0 {
+		t.Errorf("DiffNodes of equal trees = %+v, want none", got)
+	}
+}
+
+func TestDiffBetweenVersions(t *testing.T) {
+	m := newTestManager(t, `{"port":1,"opt":null,"list":["a"]}`)
+	replaceable(t, m, "port")
+	replaceable(t, m, "opt")
+	list, err := m.ConfigRef().At("list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(list, nil); err != nil {
+		t.Fatal(err)
+	}
+	v0 := m.Version()
+
+	if err := m.Replace("/port", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Replace("/opt", "set"); err != nil {
+		t.Fatal(err)
+	}
+	v2 := m.Version()
+	if err := m.Insert("/list", 1, "b"); err != nil {
+		t.Fatal(err)
+	}
+	v3 := m.Version()
+
+	got, err := m.Diff(v0, v3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DiffEntry{
+		{Path: "/list/1", Op: DiffAdd, New: "b"},
+		{Path: "/opt", Op: DiffChange, Old: nil, New: "set"},
+		{Path: "/port", Op: DiffChange, Old: 1, New: 2},
+	}
+	if !equalJSON(got, want) {
+		t.Errorf("Diff(%d, %d) = %+v, want %+v", v0, v3, got, want)
+	}
+
+	// Backwards, and between two past versions
+	got, err = m.Diff(v2, v0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []DiffEntry{
+		{Path: "/opt", Op: DiffChange, Old: "set", New: nil},
+		{Path: "/port", Op: DiffChange, Old: 2, New: 1},
+	}
+	if !equalJSON(got, want) {
+		t.Errorf("Diff(%d, %d) = %+v, want %+v", v2, v0, got, want)
+	}
+	if got, err := m.Diff(v3, v3); err != nil || len(got) != 0 {
+		t.Errorf("Diff of a version with itself = %+v, %v", got, err)
+	}
+
+	for _, tt := range [][2]int64{{0, v3}, {v0, v3 + 1}} {
+		if _, err := m.Diff(tt[0], tt[1]); err == nil {
+			t.Errorf("Diff(%d, %d) accepted", tt[0], tt[1])
+		}
+	}
+}
+
+func TestDiffNeedsHistory(t *testing.T) {
+	m := newTestManager(t, `{"port":1}`, WithHistorySize(1))
+	replaceable(t, m, "port")
+	v0 := m.Version()
+	for i := 2; i <= 3; i++ {
+		if err := m.Replace("/port", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.Diff(v0, m.Version()); err == nil || !strings.Contains(err.Error(), "no longer covers") {
+		t.Errorf("Diff past the history: %v", err)
+	}
+	if got, err := m.Diff(m.Version()-1, m.Version()); err != nil || len(got) != 1 {
+		t.Errorf("Diff within the history = %+v, %v", got, err)
+	}
+}
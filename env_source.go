@@ -0,0 +1,269 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+// ErrReadOnlySource is returned when a change is persisted to a source that
+// cannot store it
+var ErrReadOnlySource = errors.New("source is read-only")
+
+// EnvSource is a CodecSource reading the config from the environment
+// variables starting with a prefix. The rest of a variable's name is the
+// path of its value, with '_' separating keys and "__" standing for an
+// underscore within a key; keys are lowercased:
+//
+//	APP_SERVER_PORT=8080        -> /server/port = 8080
+//	APP_DB_MAX__CONNS=10        -> /db/max_conns = 10
+//	APP_USERS_0_NAME=alice      -> /users/0/name = "alice"
+//
+// An object whose keys are exactly 0, 1, ... n-1 becomes an array. Values
+// that are valid JSON (numbers, true, false, null, quoted strings, arrays,
+// objects) are used as such; anything else is a string. Without any
+// variables the config is an empty object.
+type EnvSource struct {
+	CodecSource
+}
+
+// NewEnvSource reads the variables starting with prefix ("APP" and "APP_"
+// are the same). Changes are rejected with ErrReadOnlySource unless
+// writeBack is set, in which case they are written back with os.Setenv
+// (and variables no longer in the config are unset), which only affects the
+// current process.
+func NewEnvSource(prefix string, schema string, writeBack bool) (*EnvSource, error) {
+	prefix = strings.TrimSuffix(prefix, "_")
+	if prefix == "" {
+		return nil, fmt.Errorf("prefix cannot be empty")
+	}
+
+	s := &EnvSource{}
+	s.store = &envStore{prefix: prefix + "_", writeBack: writeBack}
+	s.codec = JSONCodec{}
+	s.schema = schema
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// envStore presents the prefixed environment variables as a JSON document
+type envStore struct {
+	prefix    string
+	writeBack bool
+}
+
+func (s *envStore) Load() ([]byte, error) {
+	vars := s.variables()
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	root := orderedmap.New()
+	for _, name := range names {
+		segments, err := envSegments(strings.TrimPrefix(name, s.prefix))
+		if err != nil {
+			return nil, fmt.Errorf("variable %s: %w", name, err)
+		}
+		if err := envStoreValue(root, segments, envValue(vars[name])); err != nil {
+			return nil, fmt.Errorf("variable %s: %w", name, err)
+		}
+	}
+
+	return json.Marshal(envArrays(root))
+}
+
+func (s *envStore) Save(data []byte) error {
+	if !s.writeBack {
+		return ErrReadOnlySource
+	}
+
+	config, err := parseConfig(data)
+	if err != nil {
+		return err
+	}
+	if _, ok := config.(*orderedmap.OrderedMap); !ok {
+		return fmt.Errorf("environment config must be an object")
+	}
+
+	vars := make(map[string]string)
+	if err := envFlatten(config, strings.TrimSuffix(s.prefix, "_"), vars); err != nil {
+		return err
+	}
+
+	for name := range s.variables() {
+		if _, keep := vars[name]; !keep {
+			os.Unsetenv(name)
+		}
+	}
+	for name, value := range vars {
+		if err := os.Setenv(name, value); err != nil {
+			return fmt.Errorf("failed to set %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// variables returns the environment variables starting with the prefix
+func (s *envStore) variables() map[string]string {
+	vars := make(map[string]string)
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, s.prefix) {
+			vars[name] = value
+		}
+	}
+	return vars
+}
+
+// envSegments splits the path part of a variable name into lowercased keys
+func envSegments(name string) ([]string, error) {
+	var segments []string
+	var key strings.Builder
+	for i := 0; i < len(name); i++ {
+		switch {
+		case name[i] == '_' && i+1 < len(name) && name[i+1] == '_':
+			key.WriteByte('_')
+			i++
+		case name[i] == '_':
+			segments = append(segments, key.String())
+			key.Reset()
+		default:
+			key.WriteByte(name[i])
+		}
+	}
+	segments = append(segments, key.String())
+
+	for i, segment := range segments {
+		if segment == "" {
+			return nil, fmt.Errorf("empty key")
+		}
+		segments[i] = strings.ToLower(segment)
+	}
+	return segments, nil
+}
+
+// envValue reads a variable's value as JSON when it is valid JSON, and as a
+// string otherwise
+func envValue(raw string) interface{} {
+	if value, err := parseValue([]byte(raw)); err == nil {
+		return value
+	}
+	return raw
+}
+
+func envStoreValue(root *orderedmap.OrderedMap, segments []string, value interface{}) error {
+	current := root
+	for i, key := range segments[:len(segments)-1] {
+		existing, ok := current.Get(key)
+		if !ok {
+			child := orderedmap.New()
+			current.Set(key, child)
+			current = child
+			continue
+		}
+		child, isObject := existing.(*orderedmap.OrderedMap)
+		if !isObject {
+			return fmt.Errorf("'%s' is both a value and an object", joinPointer(segments[:i+1]))
+		}
+		current = child
+	}
+
+	last := segments[len(segments)-1]
+	if _, exists := current.Get(last); exists {
+		return fmt.Errorf("'%s' is both a value and an object", joinPointer(segments))
+	}
+	current.Set(last, value)
+	return nil
+}
+
+// envArrays turns the objects whose keys are exactly 0..n-1 into arrays
+func envArrays(value interface{}) interface{} {
+	om, ok := value.(*orderedmap.OrderedMap)
+	if !ok {
+		return value
+	}
+
+	keys := om.Keys()
+	items := make([]interface{}, len(keys))
+	isArray := len(keys) > 0
+	for _, key := range keys {
+		child, _ := om.Get(key)
+		child = envArrays(child)
+		om.Set(key, child)
+
+		index, err := strconv.Atoi(key)
+		if err != nil || index < 0 || index >= len(keys) || strconv.Itoa(index) != key {
+			isArray = false
+			continue
+		}
+		items[index] = child
+	}
+
+	if isArray {
+		return items
+	}
+	return om
+}
+
+// envFlatten writes config as variables named after prefix and the path of
+// each value, the inverse of Load
+func envFlatten(value interface{}, name string, vars map[string]string) error {
+	switch v := value.(type) {
+	case *orderedmap.OrderedMap:
+		if len(v.Keys()) == 0 {
+			vars[name] = "{}"
+			return nil
+		}
+		for _, key := range v.Keys() {
+			if key == "" || strings.ToLower(key) != key {
+				return fmt.Errorf("key '%s' cannot be stored in an environment variable", key)
+			}
+			child, _ := v.Get(key)
+			if err := envFlatten(child, name+"_"+strings.ToUpper(strings.ReplaceAll(key, "_", "__")), vars); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case []interface{}:
+		if len(v) == 0 {
+			vars[name] = "[]"
+			return nil
+		}
+		for i, item := range v {
+			if err := envFlatten(item, fmt.Sprintf("%s_%d", name, i), vars); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case string:
+		// Quote strings that would otherwise read back as something else
+		if _, err := parseValue([]byte(v)); err == nil {
+			quoted, _ := json.Marshal(v)
+			vars[name] = string(quoted)
+		} else {
+			vars[name] = v
+		}
+		return nil
+
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		vars[name] = string(data)
+		return nil
+	}
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestEnvSourceMapsVariables(t *testing.T) {
+	t.Setenv("ENVTEST_SERVER_PORT", "8080")
+	t.Setenv("ENVTEST_DB_MAX__CONNS", "10")
+	t.Setenv("ENVTEST_USERS_0_NAME", "alice")
+	t.Setenv("ENVTEST_USERS_1_NAME", "bob")
+	t.Setenv("ENVTEST_DEBUG", "true")
+	t.Setenv("ENVTEST_GREETING", "hello world")
+
+	source, err := NewEnvSource("ENVTEST_", `{}`, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"db":       map[string]interface{}{"max_conns": 10},
+		"debug":    true,
+		"greeting": "hello world",
+		"server":   map[string]interface{}{"port": 8080},
+		"users":    []interface{}{map[string]interface{}{"name": "alice"}, map[string]interface{}{"name": "bob"}},
+	}
+	if got := m.ConfigRef().toInterface(); !equalJSON(got, want) {
+		t.Errorf("config = %v, want %v", got, want)
+	}
+
+	replaceable(t, m, "debug")
+	if err := m.Replace("/debug", false); !errors.Is(err, ErrReadOnlySource) {
+		t.Errorf("replace on read-only source: %v, want ErrReadOnlySource", err)
+	}
+	if v, _ := m.ConfigRef().GetBool("debug"); !v {
+		t.Error("rejected write changed the config")
+	}
+}
+
+func TestEnvSourceWritesBack(t *testing.T) {
+	t.Setenv("ENVTEST_SERVER_PORT", "8080")
+	t.Setenv("ENVTEST_NAME", "a")
+
+	source, err := NewEnvSource("ENVTEST", `{}`, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaceable(t, m, "server")
+	replaceable(t, m, "name")
+
+	if err := m.Replace("/server", map[string]interface{}{"port": 9090, "max_conns": 5}); err != nil {
+		t.Fatal(err)
+	}
+	// A string that reads as JSON is quoted so it stays a string
+	if err := m.Replace("/name", "42"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"ENVTEST_SERVER_PORT":       "9090",
+		"ENVTEST_SERVER_MAX__CONNS": "5",
+		"ENVTEST_NAME":              `"42"`,
+	} {
+		if got := os.Getenv(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	t.Cleanup(func() { os.Unsetenv("ENVTEST_SERVER_MAX__CONNS") })
+
+	reloaded, err := NewEnvSource("ENVTEST", `{}`, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalJSON(reloaded.getConfigObject(), m.ConfigRef().toInterface()) {
+		t.Errorf("reloaded %v, want %v", reloaded.getConfigObject(), m.ConfigRef().toInterface())
+	}
+}
+
+func TestEnvSourceRejectsConflictingVariables(t *testing.T) {
+	t.Setenv("ENVTEST_DB", "x")
+	t.Setenv("ENVTEST_DB_HOST", "y")
+
+	if _, err := NewEnvSource("ENVTEST", `{}`, false); err == nil {
+		t.Error("a value and an object at /db accepted")
+	}
+	if _, err := NewEnvSource("", `{}`, false); err == nil {
+		t.Error("empty prefix accepted")
+	}
+}
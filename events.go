@@ -0,0 +1,326 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/majiddarvishan/config_manager/history"
+)
+
+// pathEvent describes a committed modification for the event bus
+type pathEvent struct {
+	op        modifiableType
+	path      string // element path for insert/remove, node path for replace
+	container string // array path for insert/remove, empty for replace
+	old       *Node
+	new       *Node
+	meta      map[string]string
+}
+
+type pathSubscriber struct {
+	op      modifiableType
+	expr    string
+	pattern []querySegment
+	fn      func(path string, old, new *Node)
+}
+
+// OnPathInsert calls fn after every committed insert whose array or new
+// element path matches pattern (query syntax, e.g. "/users" or "//servers/*").
+// fn receives the path of the new element and a copy of its value.
+func (m *Manager) OnPathInsert(pattern string, fn func(path string, value *Node)) error {
+	if fn == nil {
+		return fmt.Errorf("nil insert consumer for '%s'", pattern)
+	}
+	return m.subscribePath(Insertable, pattern, func(path string, _, value *Node) { fn(path, value) })
+}
+
+// OnPathRemove calls fn after every committed remove whose array or removed
+// element path matches pattern. fn receives the path the element had and a
+// copy of its value.
+func (m *Manager) OnPathRemove(pattern string, fn func(path string, value *Node)) error {
+	if fn == nil {
+		return fmt.Errorf("nil remove consumer for '%s'", pattern)
+	}
+	return m.subscribePath(Removable, pattern, func(path string, value, _ *Node) { fn(path, value) })
+}
+
+// OnPathReplace calls fn after every committed replace whose path matches
+// pattern, with copies of the old and new values.
+func (m *Manager) OnPathReplace(pattern string, fn func(path string, old, new *Node)) error {
+	if fn == nil {
+		return fmt.Errorf("nil replace consumer for '%s'", pattern)
+	}
+	return m.subscribePath(Replaceable, pattern, fn)
+}
+
+func (m *Manager) subscribePath(op modifiableType, expr string, fn func(string, *Node, *Node)) error {
+	pattern, err := parseQuery(expr)
+	if err != nil {
+		return fmt.Errorf("invalid pattern '%s': %w", expr, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pathSubscribers = append(m.pathSubscribers, pathSubscriber{
+		op:      op,
+		expr:    expr,
+		pattern: pattern,
+		fn:      fn,
+	})
+	return nil
+}
+
+// pathDelivery is a committed event waiting to be handed to its consumers
+type pathDelivery struct {
+	ev        pathEvent
+	consumers []pathSubscriber
+}
+
+// publishLocked queues ev for every matching consumer and delivers the queue
+// with the manager lock released. Events are queued under the lock, so every
+// consumer sees them in commit order: while one writer is delivering, events
+// committed by other writers (or by the consumers themselves) are queued
+// behind and delivered by that same writer, so such a write can return
+// before its event has been delivered. Each consumer gets its own copies of
+// the values and a panic in one consumer does not prevent the others from
+// running. A manager staging a batch defers ev to the commit.
+func (m *Manager) publishLocked(ev pathEvent) {
+	if m.staging {
+		ev.old, ev.new = ev.old.DeepCopy(), ev.new.DeepCopy()
+		m.staged = append(m.staged, stagedEffect{ev: ev})
+		return
+	}
+
+	matched := make([]pathSubscriber, 0)
+	for _, s := range m.pathSubscribers {
+		if s.op != ev.op {
+			continue
+		}
+		if matchesPath(s.pattern, ev.path) || (ev.container != "" && matchesPath(s.pattern, ev.container)) {
+			matched = append(matched, s)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+
+	// Later changes may modify the nodes before the event is delivered
+	ev.old, ev.new = ev.old.DeepCopy(), ev.new.DeepCopy()
+	m.pendingEvents = append(m.pendingEvents, pathDelivery{ev: ev, consumers: matched})
+	if m.dispatching {
+		return
+	}
+
+	m.dispatching = true
+	for len(m.pendingEvents) > 0 {
+		next := m.pendingEvents[0]
+		m.pendingEvents = m.pendingEvents[1:]
+
+		m.mu.Unlock()
+		for _, s := range next.consumers {
+			m.dispatchPathEvent(s, next.ev)
+		}
+		m.mu.Lock()
+	}
+	m.pendingEvents = nil
+	m.dispatching = false
+}
+
+func (m *Manager) dispatchPathEvent(s pathSubscriber, ev pathEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			m.log().Error("event consumer panicked", "op", ev.op.String(), "pattern", s.expr, "path", ev.path, "panic", r)
+		}
+	}()
+
+	var old, new *Node
+	if ev.old != nil {
+		old = ev.old.DeepCopy()
+	}
+	if ev.new != nil {
+		new = ev.new.DeepCopy()
+	}
+	s.fn(ev.path, old, new)
+}
+
+// subscriberBuffer is the number of events a Subscribe channel holds before
+// further events are dropped for it
+const subscriberBuffer = 64
+
+type changeSubscriber struct {
+	ch      chan history.ChangeEvent
+	dropped int64
+}
+
+// Subscribe returns a channel receiving every change recorded in the history
+// (insert, remove, replace, move, swap, reorder, batch and reload), sent once
+// the change is persisted and the version bumped, and a function ending the
+// subscription and closing the channel. Any number of subscribers may exist.
+// Events are sent while the change still holds the manager lock, so each
+// subscriber receives them in commit order: a subscriber never sees version
+// N after N+1.
+//
+// Sending never blocks the manager: the channel buffers up to 64 events and
+// while it is full further events are dropped for that subscriber (and a
+// warning logged). Subscribers that must not miss changes can detect a gap
+// from the events' versions and catch up from History.
+func (m *Manager) Subscribe() (<-chan history.ChangeEvent, func()) {
+	s := &changeSubscriber{ch: make(chan history.ChangeEvent, subscriberBuffer)}
+
+	m.mu.Lock()
+	m.changeSubscribers = append(m.changeSubscribers, s)
+	m.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			for i, other := range m.changeSubscribers {
+				if other == s {
+					m.changeSubscribers = append(m.changeSubscribers[:i:i], m.changeSubscribers[i+1:]...)
+					break
+				}
+			}
+			close(s.ch)
+		})
+	}
+	return s.ch, unsubscribe
+}
+
+// recordLocked adds ev to the history at the current version
+func (m *Manager) recordLocked(ev pathEvent) {
+	m.addHistoryLocked(m.changeEventLocked(ev))
+}
+
+// addHistoryLocked adds ev to the history and hands it to the subscribers.
+// The channels are only sent to and closed under the lock, so a send never
+// races an unsubscribe.
+func (m *Manager) addHistoryLocked(ev history.ChangeEvent) {
+	m.history.Add(ev)
+
+	m.noteChangeLocked(ev.Path)
+	if ev.From != "" {
+		m.noteChangeLocked(ev.From)
+	}
+	for _, field := range ev.ChangedFields {
+		m.noteChangeLocked(field)
+	}
+
+	for _, s := range m.changeSubscribers {
+		select {
+		case s.ch <- ev:
+		default:
+			s.dropped++
+			m.log().Warn("change subscriber is not keeping up, event dropped", "version", ev.Version, "path", ev.Path, "dropped", s.dropped)
+		}
+	}
+}
+
+// recordRootChangeLocked records a change of the whole config, such as a
+// reload, as one event at "/" listing the changed paths but not the values
+func (m *Manager) recordRootChangeLocked(op string, diff []DiffEntry) {
+	changed := make([]string, 0, len(diff))
+	for _, d := range diff {
+		changed = append(changed, m.externalPath(d.Path))
+	}
+
+	m.addHistoryLocked(history.ChangeEvent{
+		Version:       m.version,
+		Timestamp:     time.Now(),
+		Operation:     op,
+		Path:          "/",
+		ChangedFields: changed,
+		Count:         1,
+		FirstVersion:  m.version,
+	})
+}
+
+// changeEventLocked describes ev as a history event at the current version
+func (m *Manager) changeEventLocked(ev pathEvent) history.ChangeEvent {
+	return history.ChangeEvent{
+		Version:   m.version,
+		Timestamp: time.Now(),
+		Operation: ev.op.String(),
+		Path:      ev.path,
+		OldValue:  ev.old.toInterface(),
+		NewValue:  ev.new.toInterface(),
+
+		ChangedFields: changedFields(ev),
+		Meta:          copyMeta(ev.meta),
+		Count:         1,
+		FirstVersion:  m.version,
+	}
+}
+
+func copyMeta(meta map[string]string) map[string]string {
+	if len(meta) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(meta))
+	for k, v := range meta {
+		out[k] = v
+	}
+	return out
+}
+
+// changedFields returns the paths that differ between the old and new value
+// of a replace, nil for other operations
+func changedFields(ev pathEvent) []string {
+	if ev.op != Replaceable || ev.old == nil || ev.new == nil {
+		return nil
+	}
+
+	// Compare as JSON so that e.g. an int replacing an equal float64 is no
+	// change
+	old, errOld := cloneJSON(ev.old.toInterface())
+	new, errNew := cloneJSON(ev.new.toInterface())
+	if errOld != nil || errNew != nil {
+		return []string{ev.path}
+	}
+
+	prefix := ev.path
+	if prefix == "/" {
+		prefix = ""
+	}
+
+	fields := make([]string, 0)
+	for _, d := range DiffNodes(parseNode(old), parseNode(new)) {
+		if d.Path == "" {
+			fields = append(fields, ev.path)
+			continue
+		}
+		fields = append(fields, prefix+d.Path)
+	}
+	return fields
+}
+
+// changesSince returns the history events after version together with the
+// current version. complete is false when the history cannot account for
+// every version in between, because events were evicted or a change (such as
+// an import) was not recorded as an event.
+func (m *Manager) changesSince(version int64) (events []history.ChangeEvent, current int64, complete bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	events = m.history.Since(version)
+	expected := version + 1
+	for _, ev := range events {
+		if ev.FirstVersion != expected {
+			return events, m.version, false
+		}
+		expected = ev.Version + 1
+	}
+	return events, m.version, version >= m.version || expected == m.version+1
+}
+
+func elementPath(arrayPath string, index int) string {
+	if arrayPath == "/" {
+		return "/" + strconv.Itoa(index)
+	}
+	return arrayPath + "/" + strconv.Itoa(index)
+}
@@ -0,0 +1,274 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestPathConsumersMatchPattern(t *testing.T) {
+	m := newTestManager(t, `{"users":[{"name":"a"}],"groups":[],"port":1}`)
+	for _, key := range []string{"users", "groups"} {
+		node, err := m.ConfigRef().At(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.OnInsert(node, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.OnRemove(node, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	port, err := m.ConfigRef().At("port")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnReplace(port, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	record := func(event string) { got = append(got, event) }
+	if err := m.OnPathInsert("/users", func(path string, value *Node) {
+		name, _ := value.GetString("name")
+		record("insert " + path + " " + name)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnPathRemove("/users/*", func(path string, value *Node) {
+		name, _ := value.GetString("name")
+		record("remove " + path + " " + name)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnPathReplace("/port", func(path string, old, new *Node) {
+		o, _ := old.GetFloat()
+		n, _ := new.GetFloat()
+		if o == 1 && n == 2 {
+			record("replace " + path)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnPathInsert("/users", func(string, *Node) { panic("consumer failure") }); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Insert("/groups", 0, "g"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Insert("/users", 1, map[string]interface{}{"name": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Remove("/users", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Remove("/groups", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Replace("/port", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"insert /users/1 b", "remove /users/0 a", "replace /port"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestPathConsumersSeeCommitOrder(t *testing.T) {
+	const writers, writes, consumers = 8, 50, 4
+
+	config := "{"
+	for i := 0; i < writers; i++ {
+		if i > 0 {
+			config += ","
+		}
+		config += fmt.Sprintf(`"k%d":0`, i)
+	}
+	m := newTestManager(t, config+"}", WithHistorySize(writers*writes))
+	for i := 0; i < writers; i++ {
+		replaceable(t, m, fmt.Sprintf("k%d", i))
+	}
+
+	received := make([][]float64, consumers)
+	for i := range received {
+		i := i
+		if err := m.OnPathReplace("/*", func(_ string, _, value *Node) {
+			v, _ := value.GetFloat()
+			received[i] = append(received[i], v)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 1; i <= writes; i++ {
+				if err := m.Replace(fmt.Sprintf("/k%d", w), w*1000+i); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// Every written value is unique, so the history tells its version
+	versions := make(map[float64]int64)
+	for _, ev := range m.History().GetAll() {
+		v, _ := parseNode(ev.NewValue).GetFloat()
+		versions[v] = ev.Version
+	}
+
+	for i, values := range received {
+		if len(values) != writers*writes {
+			t.Errorf("consumer %d got %d events, want %d", i, len(values), writers*writes)
+		}
+		for j := 1; j < len(values); j++ {
+			if versions[values[j]] <= versions[values[j-1]] {
+				t.Errorf("consumer %d got version %d after %d", i, versions[values[j]], versions[values[j-1]])
+				break
+			}
+		}
+	}
+}
+
+func TestPathConsumerMayWrite(t *testing.T) {
+	m := newTestManager(t, `{"a":0,"b":0}`)
+	replaceable(t, m, "a")
+	replaceable(t, m, "b")
+
+	var got []string
+	if err := m.OnPathReplace("/*", func(path string, _, _ *Node) {
+		got = append(got, path)
+		if path == "/a" {
+			if err := m.Replace("/b", 1); err != nil {
+				t.Error(err)
+			}
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Replace("/a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/a", "/b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestSubscribersSeeCommitOrder(t *testing.T) {
+	const writers, writes, subscribers = 8, 50, 4
+
+	config := "{"
+	for i := 0; i < writers; i++ {
+		if i > 0 {
+			config += ","
+		}
+		config += fmt.Sprintf(`"k%d":0`, i)
+	}
+	m := newTestManager(t, config+"}")
+	for i := 0; i < writers; i++ {
+		if err := m.OnReplacePath(fmt.Sprintf("/k%d", i), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var readers sync.WaitGroup
+	unsubscribes := make([]func(), subscribers)
+	for i := range unsubscribes {
+		ch, unsubscribe := m.Subscribe()
+		unsubscribes[i] = unsubscribe
+
+		readers.Add(1)
+		go func(i int) {
+			defer readers.Done()
+			var last int64
+			for ev := range ch {
+				if ev.Version <= last {
+					t.Errorf("subscriber %d got version %d after %d", i, ev.Version, last)
+				}
+				last = ev.Version
+			}
+		}(i)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 1; j <= writes; j++ {
+				if err := m.Replace(fmt.Sprintf("/k%d", i), j); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, unsubscribe := range unsubscribes {
+		unsubscribe()
+	}
+	readers.Wait()
+}
+
+func TestSubscribeDeliversChanges(t *testing.T) {
+	m := newTestManager(t, `{"port":1,"list":[]}`)
+	replaceable(t, m, "port")
+	list, err := m.ConfigRef().At("list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(list, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ch, unsubscribe := m.Subscribe()
+	if err := m.Replace("/port", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Insert("/list", 0, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Batch([]Operation{{Op: "replace", Path: "/port", Value: 3}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for i := 0; i < 3; i++ {
+		ev := <-ch
+		got = append(got, fmt.Sprintf("%d %s %s", ev.Version, ev.Operation, ev.Path))
+	}
+	v := m.Version()
+	want := []string{fmt.Sprintf("%d replace /port", v-2), fmt.Sprintf("%d insert /list/0", v-1), fmt.Sprintf("%d batch /", v)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+
+	// A subscriber that does not read loses events but never blocks writers
+	for i := 0; i < subscriberBuffer+10; i++ {
+		if err := m.Replace("/port", 10+i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(ch); n != subscriberBuffer {
+		t.Errorf("%d events buffered, want %d", n, subscriberBuffer)
+	}
+
+	unsubscribe()
+	unsubscribe()
+	for range ch {
+	}
+	if err := m.Replace("/port", 1); err != nil {
+		t.Errorf("replace after unsubscribe: %v", err)
+	}
+}
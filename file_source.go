@@ -1,34 +1,53 @@
 package config
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"os"
-	"sync"
+	"path/filepath"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/iancoleman/orderedmap"
 )
 
-func parseConfig(config []byte) (*orderedmap.OrderedMap, error) {
+// watchDebounce is how long Watch waits for a file to settle after a change,
+// since editors often write in several steps
+const watchDebounce = 100 * time.Millisecond
+
+// A failed reload is retried watchRetries times, watchRetryDelay apart,
+// unless the file changes again in the meantime: a read can catch a file
+// half-written by a slow writer, and the writer may not touch it again
+const (
+	watchRetries    = 3
+	watchRetryDelay = time.Second
+)
+
+// parseConfig decodes a config document. The root may be a JSON object
+// (returned as *orderedmap.OrderedMap) or a JSON array ([]interface{}).
+func parseConfig(config []byte) (interface{}, error) {
 	if len(config) == 0 {
 		return nil, fmt.Errorf("config is empty")
 	}
 
-	result := orderedmap.New()
-	err := json.Unmarshal(config, &result)
+	result, err := parseValue(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
 
-	return result, nil
+	switch result.(type) {
+	case *orderedmap.OrderedMap, []interface{}:
+		return result, nil
+	default:
+		return nil, fmt.Errorf("config root must be an object or array, got %T", result)
+	}
 }
 
+// FileSource is a CodecSource reading and writing a JSON file
 type FileSource struct {
-	mu           sync.RWMutex
-	configPath   string
-	configObject *orderedmap.OrderedMap
-	config       string
-	schema       string
+	CodecSource
+	configPath string
+	schemaPath string // empty when the schema was passed inline
 }
 
 func NewFileSource(configPath string, schema string) (*FileSource, error) {
@@ -36,71 +55,162 @@ func NewFileSource(configPath string, schema string) (*FileSource, error) {
 		return nil, fmt.Errorf("config path cannot be empty")
 	}
 
-	configBytes, err := os.ReadFile(configPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+	fs := &FileSource{configPath: configPath}
+	fs.store = &FileStore{Path: configPath}
+	fs.codec = JSONCodec{}
+	fs.schema = schema
+
+	if err := fs.load(); err != nil {
+		return nil, err
 	}
+	return fs, nil
+}
 
-	config, err := parseConfig(configBytes)
+// NewFileSourceWithSchemaFile is NewFileSource with the schema read from the
+// file at schemaPath, which Watch then follows as well
+func NewFileSourceWithSchemaFile(configPath, schemaPath string) (*FileSource, error) {
+	if schemaPath == "" {
+		return nil, fmt.Errorf("schema path cannot be empty")
+	}
+
+	schema, err := readSchemaFile(schemaPath)
 	if err != nil {
 		return nil, err
 	}
 
-	return &FileSource{
-		configPath:   configPath,
-		configObject: config,
-		config:       string(configBytes),
-		schema:       schema,
-	}, nil
+	fs, err := NewFileSource(configPath, schema)
+	if err != nil {
+		return nil, err
+	}
+	fs.schemaPath = schemaPath
+	return fs, nil
 }
 
-func (fs *FileSource) getConfigObject() *orderedmap.OrderedMap {
-	fs.mu.RLock()
-	defer fs.mu.RUnlock()
-	return fs.configObject
+// readSchemaFile reads the schema at path and checks that it compiles
+func readSchemaFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read schema file: %w", err)
+	}
+	if _, err := compileSchema(data); err != nil {
+		return "", fmt.Errorf("invalid schema in %s: %w", path, err)
+	}
+	return string(data), nil
 }
 
-func (fs *FileSource) getConfig() *string {
-	fs.mu.RLock()
-	defer fs.mu.RUnlock()
-	config := fs.config
-	return &config
-}
+// Watch re-reads the file whenever it changes on disk until ctx is done. A
+// changed document that passes the schema replaces the current one and
+// onReload is called with nil; if it cannot be read or fails validation the
+// current config is kept, onReload gets the error and the reload is retried
+// a few times a second apart, so that a read catching the file half-written
+// does not leave the source behind once the writer is done. Writes that
+// leave the content as it is, including the source's own saves, are ignored.
+// Watch returns once the watch is set up.
+//
+// With a schema file (see NewFileSourceWithSchemaFile) a change to either
+// file re-reads both, and the config is validated against the new schema:
+// the pair is only swapped in when they match, so both files can be updated
+// together.
+//
+// Only the source is updated. A Manager using it picks the change up when
+// onReload calls its Reload.
+func (fs *FileSource) Watch(ctx context.Context, onReload func(error)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+
+	// Watch the directories: editors and FileStore.Save replace files by
+	// renaming another one over them, which a watch on the file would not
+	// survive
+	dirs := []string{filepath.Dir(fs.configPath)}
+	if fs.schemaPath != "" && filepath.Dir(fs.schemaPath) != dirs[0] {
+		dirs = append(dirs, filepath.Dir(fs.schemaPath))
+	}
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+	}
 
-func (fs *FileSource) getSchema() *string {
-	fs.mu.RLock()
-	defer fs.mu.RUnlock()
-	schema := fs.schema
-	return &schema
+	go fs.watch(ctx, watcher, onReload)
+	return nil
 }
 
-func (fs *FileSource) setConfig(conf *orderedmap.OrderedMap) error {
-	if conf == nil {
-		return fmt.Errorf("config cannot be nil")
+func (fs *FileSource) watch(ctx context.Context, watcher *fsnotify.Watcher, onReload func(error)) {
+	defer watcher.Close()
+
+	notify := func(err error) {
+		if onReload != nil {
+			onReload(err)
+		}
 	}
 
-	configBytes, err := json.MarshalIndent(conf, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal config: %w", err)
+	watched := func(name string) bool {
+		name = filepath.Clean(name)
+		return name == filepath.Clean(fs.configPath) ||
+			(fs.schemaPath != "" && name == filepath.Clean(fs.schemaPath))
 	}
+	timer := time.NewTimer(watchDebounce)
+	timer.Stop()
+	defer timer.Stop()
+	retries := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if watched(ev.Name) && ev.Has(fsnotify.Write|fsnotify.Create) {
+				timer.Reset(watchDebounce)
+				retries = 0
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			notify(fmt.Errorf("failed to watch config file: %w", err))
+
+		case <-timer.C:
+			changed, err := fs.reload()
+			if err != nil && retries < watchRetries {
+				retries++
+				timer.Reset(watchRetryDelay)
+			}
+			if changed || err != nil {
+				notify(err)
+			}
+		}
+	}
+}
 
-	// Write to temp file first, then rename (atomic operation)
-	tempPath := fs.configPath + ".tmp"
-	err = os.WriteFile(tempPath, configBytes, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write temp file: %w", err)
+// reload makes the file's current content the config, and the schema file's
+// the schema, if either differs and the config passes the schema
+func (fs *FileSource) reload() (bool, error) {
+	schema := *fs.getSchema()
+	if fs.schemaPath != "" {
+		var err error
+		if schema, err = readSchemaFile(fs.schemaPath); err != nil {
+			return false, fmt.Errorf("failed to reload schema: %w", err)
+		}
 	}
 
-	err = os.Rename(tempPath, fs.configPath)
+	config, err := fs.fetch()
 	if err != nil {
-		os.Remove(tempPath) // Clean up temp file
-		return fmt.Errorf("failed to rename temp file: %w", err)
+		return false, fmt.Errorf("failed to reload config: %w", err)
+	}
+	if sameJSON(fs.getConfigObject(), config) && schema == *fs.getSchema() {
+		return false, nil
 	}
 
-	fs.mu.Lock()
-	fs.configObject = conf
-	fs.config = string(configBytes)
-	fs.mu.Unlock()
-
-	return nil
-}
\ No newline at end of file
+	if err := validateJSONAgainstSchema(config, &schema); err != nil {
+		return false, err
+	}
+	return true, fs.adoptWithSchema(config, &schema)
+}
@@ -0,0 +1,243 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileSourceWatchReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"port":1}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := NewFileSource(path, `{"type":"object","properties":{"port":{"type":"integer","maximum":100}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloads := make(chan error, 10)
+	if err := source.Watch(ctx, func(err error) {
+		if err == nil {
+			err = m.Reload()
+		}
+		reloads <- err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	wait := func() error {
+		t.Helper()
+		select {
+		case err := <-reloads:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reload after the file changed")
+			return nil
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(`{"port":2}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	if !equalJSON(source.getConfigObject(), map[string]interface{}{"port": 2}) {
+		t.Errorf("source = %v, want port 2", source.getConfigObject())
+	}
+	if port, _ := m.ConfigRef().GetInt("port"); port != 2 {
+		t.Errorf("manager port = %d, want 2", port)
+	}
+
+	// A document failing the schema is reported and not adopted
+	if err := os.WriteFile(path, []byte(`{"port":500}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := wait(); err == nil {
+		t.Error("document failing the schema reloaded")
+	}
+	if !equalJSON(source.getConfigObject(), map[string]interface{}{"port": 2}) {
+		t.Errorf("source = %v, want port 2 kept", source.getConfigObject())
+	}
+
+	// The source's own saves are not reported
+	replaceable(t, m, "port")
+	if err := m.Replace("/port", 3); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-reloads:
+		t.Errorf("own save reported as a reload: %v", err)
+	case <-time.After(3 * watchDebounce):
+	}
+}
+
+func TestFileSourceWithSchemaFile(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	schemaPath := filepath.Join(dir, "schema.json")
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(configPath, `{"port":50}`)
+	write(schemaPath, `{"type":"object","properties":{"port":{"type":"integer","maximum":100}}}`)
+
+	source, err := NewFileSourceWithSchemaFile(configPath, schemaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalJSON(source.getConfigObject(), map[string]interface{}{"port": 50}) {
+		t.Errorf("config = %v, want port 50", source.getConfigObject())
+	}
+
+	// The schema from the file is the one the manager validates with
+	strict := filepath.Join(dir, "strict.json")
+	write(strict, `{"type":"object","properties":{"port":{"type":"integer","maximum":10}}}`)
+	strictSource, err := NewFileSourceWithSchemaFile(configPath, strict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewManager(strictSource); err == nil {
+		t.Error("config failing the schema file accepted")
+	}
+	if _, err := NewFileSourceWithSchemaFile(configPath, filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("missing schema file accepted")
+	}
+	broken := filepath.Join(dir, "broken.json")
+	write(broken, `{"type":`)
+	if _, err := NewFileSourceWithSchemaFile(configPath, broken); err == nil {
+		t.Error("invalid schema file accepted")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloads := make(chan error, 10)
+	if err := source.Watch(ctx, func(err error) { reloads <- err }); err != nil {
+		t.Fatal(err)
+	}
+	wait := func() error {
+		t.Helper()
+		select {
+		case err := <-reloads:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reload after the schema file changed")
+			return nil
+		}
+	}
+
+	// A tighter schema the current config fails is not adopted
+	write(schemaPath, `{"type":"object","properties":{"port":{"type":"integer","maximum":10}}}`)
+	if err := wait(); err == nil {
+		t.Error("schema rejecting the current config adopted")
+	}
+
+	// A new schema the config passes is
+	write(schemaPath, `{"type":"object","properties":{"port":{"type":"integer","minimum":50}}}`)
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	if schema := *source.getSchema(); !strings.Contains(schema, "minimum") {
+		t.Errorf("schema = %s, want the new one", schema)
+	}
+}
+
+func TestFileSourceWatchSurvivesCorruptReads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"port":1}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	source, err := NewFileSource(path, `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloads := make(chan error, 10)
+	if err := source.Watch(ctx, func(err error) {
+		if err == nil {
+			err = m.Reload()
+		}
+		reloads <- err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	wait := func() error {
+		t.Helper()
+		select {
+		case err := <-reloads:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reload")
+			return nil
+		}
+	}
+
+	// A half-written file is reported, and retried while it stays that way
+	if err := os.WriteFile(path, []byte(`{"port":`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := wait(); err == nil {
+		t.Fatal("corrupt file reloaded")
+	}
+	if err := wait(); err == nil {
+		t.Fatal("corrupt file reloaded on retry")
+	}
+	if port, _ := m.ConfigRef().GetInt("port"); port != 1 {
+		t.Errorf("port = %d during the corrupt read, want 1", port)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"port":2}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	if port, _ := m.ConfigRef().GetInt("port"); port != 2 {
+		t.Errorf("port = %d, want 2", port)
+	}
+}
+
+func TestReloadKeepsConfigOnBadRead(t *testing.T) {
+	store := NewMemoryStore([]byte(`{"port":1}`))
+	source, err := NewCodecSource(store, JSONCodec{}, `{"type":"object","properties":{"port":{"type":"integer"}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := m.Version()
+
+	for _, doc := range []string{`{"port":`, `{"port":"x"}`} {
+		if err := store.Save([]byte(doc)); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Reload(); err == nil {
+			t.Errorf("reload of %s succeeded", doc)
+		}
+		if port, _ := m.ConfigRef().GetInt("port"); port != 1 || m.Version() != before {
+			t.Errorf("after reload of %s: port %d, version %d, want 1, %d", doc, port, m.Version(), before)
+		}
+	}
+}
@@ -1,10 +1,18 @@
 module github.com/majiddarvishan/config_manager
 
-go 1.18
+go 1.21
 
-require github.com/xeipuuv/gojsonschema v1.2.0
+require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/iancoleman/orderedmap v0.3.0
+	github.com/rs/cors v1.11.1
+	github.com/xeipuuv/gojsonschema v1.2.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/xeipuuv/gojsonpointer v0.0.0-20180127040702-4e3ac2762d5f // indirect
 	github.com/xeipuuv/gojsonreference v0.0.0-20180127040603-bd5ef7bd5415 // indirect
+	golang.org/x/sys v0.13.0 // indirect
 )
@@ -0,0 +1,188 @@
+// Package history keeps a bounded, in-memory record of config changes.
+package history
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChangeEvent describes one committed modification. For inserts and removes
+// Path is the path of the element (e.g. "/users/3"), for replaces the path of
+// the replaced node. Values are plain JSON-compatible Go values.
+type ChangeEvent struct {
+	Version   int64       `json:"version"`
+	Timestamp time.Time   `json:"timestamp"`
+	Operation string      `json:"operation"`
+	Path      string      `json:"path"`
+	From      string      `json:"from,omitempty"` // moves only: the element's previous path
+	OldValue  interface{} `json:"old_value,omitempty"`
+	NewValue  interface{} `json:"new_value,omitempty"`
+
+	// ChangedFields lists, for replaces, the paths below Path whose values
+	// actually differ between OldValue and NewValue (Path itself for a
+	// scalar), and for reloads the paths the reload changed; reload events
+	// carry no values
+	ChangedFields []string `json:"changed_fields,omitempty"`
+
+	// Meta is the caller-supplied annotation of the change, e.g. a ticket
+	Meta map[string]string `json:"meta,omitempty"`
+
+	// Count is the number of changes the event stands for; above 1 when
+	// rapid replaces were coalesced, FirstVersion then being the earliest
+	Count        int   `json:"count"`
+	FirstVersion int64 `json:"first_version"`
+}
+
+// ChangeHistory is a fixed-size ring of ChangeEvents; once full, the oldest
+// event is evicted for each new one. It is safe for concurrent use.
+type ChangeHistory struct {
+	mu       sync.RWMutex
+	events   []ChangeEvent
+	start    int // index of the oldest event
+	size     int
+	coalesce time.Duration
+}
+
+// NewChangeHistory creates a history holding at most capacity events
+func NewChangeHistory(capacity int) *ChangeHistory {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &ChangeHistory{events: make([]ChangeEvent, capacity)}
+}
+
+// SetCoalesceWindow makes consecutive replaces of the same path, carrying
+// the same Meta, that are at most window apart collapse into a single event
+// keeping the first OldValue, the last NewValue and a Count. Zero (the
+// default) disables coalescing.
+func (h *ChangeHistory) SetCoalesceWindow(window time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.coalesce = window
+}
+
+// Add records ev, coalescing it into the newest event when allowed
+func (h *ChangeHistory) Add(ev ChangeEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if ev.Count < 1 {
+		ev.Count = 1
+	}
+	if ev.FirstVersion == 0 {
+		ev.FirstVersion = ev.Version
+	}
+
+	if h.size > 0 && h.coalesce > 0 {
+		last := &h.events[(h.start+h.size-1)%len(h.events)]
+		if last.Operation == "replace" && ev.Operation == last.Operation && ev.Path == last.Path &&
+			ev.Timestamp.Sub(last.Timestamp) <= h.coalesce && sameMeta(last.Meta, ev.Meta) {
+			last.Version = ev.Version
+			last.Timestamp = ev.Timestamp
+			last.NewValue = ev.NewValue
+			last.ChangedFields = mergeFields(last.ChangedFields, ev.ChangedFields)
+			last.Count += ev.Count
+			return
+		}
+	}
+
+	if h.size < len(h.events) {
+		h.events[(h.start+h.size)%len(h.events)] = ev
+		h.size++
+		return
+	}
+
+	h.events[h.start] = ev
+	h.start = (h.start + 1) % len(h.events)
+}
+
+// GetAll returns every recorded event, oldest first
+func (h *ChangeHistory) GetAll() []ChangeEvent {
+	return h.filter(func(ChangeEvent) bool { return true })
+}
+
+// Since returns the events recorded after version, oldest first
+func (h *ChangeHistory) Since(version int64) []ChangeEvent {
+	return h.filter(func(ev ChangeEvent) bool { return ev.Version > version })
+}
+
+// GetByPath returns the events at path or below it, oldest first
+func (h *ChangeHistory) GetByPath(path string) []ChangeEvent {
+	return h.filter(func(ev ChangeEvent) bool { return atOrBelow(ev.Path, path) })
+}
+
+// Filter selects events for Query. Zero fields select everything.
+type Filter struct {
+	Path         string    // events at Path or below it, as GetByPath
+	Operation    string    // events of this operation
+	Since        time.Time // events recorded after Since
+	SinceVersion int64     // events after this version, as Since
+}
+
+// Query returns the events matching every criterion of f, oldest first
+func (h *ChangeHistory) Query(f Filter) []ChangeEvent {
+	return h.filter(func(ev ChangeEvent) bool {
+		return (f.Path == "" || atOrBelow(ev.Path, f.Path)) &&
+			(f.Operation == "" || ev.Operation == f.Operation) &&
+			(f.Since.IsZero() || ev.Timestamp.After(f.Since)) &&
+			ev.Version > f.SinceVersion
+	})
+}
+
+// Len returns the number of recorded events
+func (h *ChangeHistory) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.size
+}
+
+// atOrBelow reports whether path is ancestor or a path below it
+func atOrBelow(path, ancestor string) bool {
+	return path == ancestor || strings.HasPrefix(path, strings.TrimSuffix(ancestor, "/")+"/")
+}
+
+// sameMeta reports whether two changes carry the same annotation; only those
+// may be coalesced
+func sameMeta(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeFields appends the fields of b missing from a
+func mergeFields(a, b []string) []string {
+	seen := make(map[string]bool, len(a))
+	for _, f := range a {
+		seen[f] = true
+	}
+	for _, f := range b {
+		if !seen[f] {
+			seen[f] = true
+			a = append(a, f)
+		}
+	}
+	return a
+}
+
+func (h *ChangeHistory) filter(keep func(ChangeEvent) bool) []ChangeEvent {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	out := make([]ChangeEvent, 0)
+	for i := 0; i < h.size; i++ {
+		ev := h.events[(h.start+i)%len(h.events)]
+		if keep(ev) {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
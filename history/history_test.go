@@ -0,0 +1,141 @@
+package history
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCoalesceRapidReplaces(t *testing.T) {
+	h := NewChangeHistory(10)
+	h.SetCoalesceWindow(time.Second)
+
+	start := time.Unix(1000, 0)
+	for i := 1; i <= 5; i++ {
+		h.Add(ChangeEvent{
+			Version:   int64(i),
+			Timestamp: start.Add(time.Duration(i) * 100 * time.Millisecond),
+			Operation: "replace",
+			Path:      "/counter",
+			OldValue:  i - 1,
+			NewValue:  i,
+		})
+	}
+	// Too late to join the previous event
+	h.Add(ChangeEvent{Version: 6, Timestamp: start.Add(3 * time.Second), Operation: "replace", Path: "/counter", OldValue: 5, NewValue: 6})
+	// A different path never joins
+	h.Add(ChangeEvent{Version: 7, Timestamp: start.Add(3 * time.Second), Operation: "replace", Path: "/other", NewValue: 1})
+
+	events := h.GetAll()
+	if len(events) != 3 {
+		t.Fatalf("%d events, want 3: %+v", len(events), events)
+	}
+
+	first := events[0]
+	if first.Count != 5 || first.FirstVersion != 1 || first.Version != 5 || first.OldValue != 0 || first.NewValue != 5 {
+		t.Errorf("coalesced event = %+v, want versions 1..5, count 5, old 0, new 5", first)
+	}
+	if events[1].Count != 1 || events[1].Version != 6 {
+		t.Errorf("second event = %+v, want version 6 on its own", events[1])
+	}
+}
+
+func TestCoalescingOffByDefault(t *testing.T) {
+	h := NewChangeHistory(10)
+	now := time.Now()
+	for i := 1; i <= 3; i++ {
+		h.Add(ChangeEvent{Version: int64(i), Timestamp: now, Operation: "replace", Path: "/a"})
+	}
+	if h.Len() != 3 {
+		t.Errorf("%d events, want 3", h.Len())
+	}
+}
+
+func TestRingEvictsOldest(t *testing.T) {
+	h := NewChangeHistory(3)
+	for i := 1; i <= 5; i++ {
+		h.Add(ChangeEvent{Version: int64(i), Operation: "insert", Path: "/list/0"})
+	}
+
+	events := h.GetAll()
+	if len(events) != 3 || events[0].Version != 3 || events[2].Version != 5 {
+		t.Errorf("events = %+v, want versions 3, 4, 5", events)
+	}
+	if got := h.GetByPath("/list"); len(got) != 3 {
+		t.Errorf("GetByPath(/list) returned %d events, want 3", len(got))
+	}
+	if got := h.GetByPath("/li"); len(got) != 0 {
+		t.Errorf("GetByPath(/li) returned %d events, want 0", len(got))
+	}
+}
+
+func TestCoalescingMergesChangedFields(t *testing.T) {
+	h := NewChangeHistory(10)
+	h.SetCoalesceWindow(time.Minute)
+
+	now := time.Now()
+	h.Add(ChangeEvent{Version: 1, Timestamp: now, Operation: "replace", Path: "/db", ChangedFields: []string{"/db/host"}})
+	h.Add(ChangeEvent{Version: 2, Timestamp: now, Operation: "replace", Path: "/db", ChangedFields: []string{"/db/port", "/db/host"}})
+
+	events := h.GetAll()
+	if len(events) != 1 {
+		t.Fatalf("%d events, want 1", len(events))
+	}
+	if want := []string{"/db/host", "/db/port"}; !reflect.DeepEqual(events[0].ChangedFields, want) {
+		t.Errorf("changed fields = %v, want %v", events[0].ChangedFields, want)
+	}
+}
+
+func TestCoalescingKeepsDifferentMetaApart(t *testing.T) {
+	h := NewChangeHistory(10)
+	h.SetCoalesceWindow(time.Minute)
+
+	now := time.Now()
+	h.Add(ChangeEvent{Version: 1, Timestamp: now, Operation: "replace", Path: "/a", Meta: map[string]string{"ticket": "1"}})
+	h.Add(ChangeEvent{Version: 2, Timestamp: now, Operation: "replace", Path: "/a", Meta: map[string]string{"ticket": "1"}})
+	h.Add(ChangeEvent{Version: 3, Timestamp: now, Operation: "replace", Path: "/a", Meta: map[string]string{"ticket": "2"}})
+
+	events := h.GetAll()
+	if len(events) != 2 || events[0].Count != 2 || events[1].Meta["ticket"] != "2" {
+		t.Errorf("events = %+v, want the two ticket 1 changes coalesced and ticket 2 apart", events)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	h := NewChangeHistory(10)
+	start := time.Unix(1000, 0)
+	h.Add(ChangeEvent{Version: 1, Timestamp: start, Operation: "replace", Path: "/db/host"})
+	h.Add(ChangeEvent{Version: 2, Timestamp: start.Add(time.Minute), Operation: "insert", Path: "/db/replicas"})
+	h.Add(ChangeEvent{Version: 3, Timestamp: start.Add(2 * time.Minute), Operation: "replace", Path: "/dbx"})
+	h.Add(ChangeEvent{Version: 4, Timestamp: start.Add(3 * time.Minute), Operation: "replace", Path: "/db"})
+
+	versions := func(events []ChangeEvent) []int64 {
+		var out []int64
+		for _, ev := range events {
+			out = append(out, ev.Version)
+		}
+		return out
+	}
+
+	for _, tc := range []struct {
+		name   string
+		filter Filter
+		want   []int64
+	}{
+		{"everything", Filter{}, []int64{1, 2, 3, 4}},
+		{"path", Filter{Path: "/db"}, []int64{1, 2, 4}},
+		{"operation", Filter{Operation: "replace"}, []int64{1, 3, 4}},
+		{"since", Filter{Since: start.Add(time.Minute)}, []int64{3, 4}},
+		{"since version", Filter{SinceVersion: 2}, []int64{3, 4}},
+		{"combined", Filter{Path: "/db", Operation: "replace", SinceVersion: 1}, []int64{4}},
+		{"nothing", Filter{Operation: "remove"}, nil},
+	} {
+		if got := versions(h.Query(tc.filter)); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: versions %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	if got := versions(h.GetByPath("/db")); !reflect.DeepEqual(got, []int64{1, 2, 4}) {
+		t.Errorf("GetByPath(/db): versions %v, want [1 2 4]", got)
+	}
+}
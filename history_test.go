@@ -0,0 +1,116 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistoryCoalescesRapidReplaces(t *testing.T) {
+	m := newTestManager(t, `{"counter":0}`, WithHistoryCoalescing(time.Minute))
+	replaceable(t, m, "counter")
+
+	for i := 1; i <= 50; i++ {
+		if err := m.Replace("/counter", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events := m.History().GetAll()
+	if len(events) != 1 {
+		t.Fatalf("%d history events, want 1", len(events))
+	}
+	ev := events[0]
+	if ev.Count != 50 || ev.Version != m.Version() || ev.FirstVersion != m.Version()-49 {
+		t.Errorf("event = %+v, want count 50 spanning versions %d..%d", ev, m.Version()-49, m.Version())
+	}
+	if !equalJSON(ev.OldValue, 0) || !equalJSON(ev.NewValue, 50) {
+		t.Errorf("event values = %v -> %v, want 0 -> 50", ev.OldValue, ev.NewValue)
+	}
+}
+
+func TestHistoryRecordsChangedFields(t *testing.T) {
+	m := newTestManager(t, `{"db":{"host":"a","pool":{"min":1,"max":5}},"port":1}`)
+	replaceable(t, m, "db")
+	replaceable(t, m, "port")
+
+	err := m.Replace("/db", map[string]interface{}{
+		"host": "a",
+		"pool": map[string]interface{}{"min": 1, "max": 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Replace("/port", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	events := m.History().GetAll()
+	if len(events) != 2 {
+		t.Fatalf("%d history events, want 2", len(events))
+	}
+	if got := events[0].ChangedFields; !reflect.DeepEqual(got, []string{"/db/pool/max"}) {
+		t.Errorf("object replace changed fields = %v, want [/db/pool/max]", got)
+	}
+	if got := events[1].ChangedFields; !reflect.DeepEqual(got, []string{"/port"}) {
+		t.Errorf("scalar replace changed fields = %v, want [/port]", got)
+	}
+}
+
+func TestMetaRecordedOnHistoryEvents(t *testing.T) {
+	m := newTestManager(t, `{"port":1,"list":["a"]}`)
+	replaceable(t, m, "port")
+	list, err := m.ConfigRef().At("list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(list, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnRemove(list, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	meta := map[string]string{"ticket": "OPS-123", "deploy": "v2.3"}
+	if err := m.ReplaceWithMeta("/port", 2, meta); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.InsertWithMeta("/list", 1, "b", map[string]string{"ticket": "OPS-124"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RemoveWithMeta("/list", 0, map[string]string{"ticket": "OPS-125"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Replace("/port", 3); err != nil {
+		t.Fatal(err)
+	}
+	// The recorded meta is a copy
+	meta["ticket"] = "changed"
+
+	events := m.History().GetAll()
+	want := []map[string]string{
+		{"ticket": "OPS-123", "deploy": "v2.3"},
+		{"ticket": "OPS-124"},
+		{"ticket": "OPS-125"},
+		nil,
+	}
+	if len(events) != len(want) {
+		t.Fatalf("%d history events, want %d", len(events), len(want))
+	}
+	for i, ev := range events {
+		if !reflect.DeepEqual(ev.Meta, want[i]) {
+			t.Errorf("event %d (%s %s) meta = %v, want %v", i, ev.Operation, ev.Path, ev.Meta, want[i])
+		}
+	}
+
+	// and survives the history export
+	exported, err := json.Marshal(events[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(exported), `"meta":{"deploy":"v2.3","ticket":"OPS-123"}`) {
+		t.Errorf("exported event %s, want its meta", exported)
+	}
+}
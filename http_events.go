@@ -0,0 +1,95 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// sseHeartbeat is how often an idle event stream gets a comment line, so
+// proxies do not time it out
+const sseHeartbeat = 15 * time.Second
+
+func (hs *http_server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		hs.onGetEvents(w, r)
+	case http.MethodOptions:
+		hs.onOptions(w)
+	default:
+		hs.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// onGetEvents streams the changes as server-sent events, one "change" event
+// per history event with the event as JSON data and its version as id, until
+// the client goes away or the server shuts down. Events a slow client cannot
+// keep up with are dropped, as for any subscriber; clients can catch up with
+// GET /config/changes from the last id they saw.
+func (hs *http_server) onGetEvents(w http.ResponseWriter, r *http.Request) {
+	if !hs.checkAccess(r) {
+		hs.writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if _, ok := w.(http.Flusher); !ok {
+		hs.writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	events, unsubscribe := hs.manager.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+
+	// The stream outlives the server's write timeout, so every write gets
+	// its own deadline
+	rc := http.NewResponseController(w)
+	send := func(format string, args ...interface{}) error {
+		if err := rc.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil && err != http.ErrNotSupported {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if err := send(": version %d\n\n", hs.manager.Version()); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				hs.log().Error("failed to encode change event", "version", ev.Version, "error", err)
+				continue
+			}
+			if err := send("id: %d\nevent: change\ndata: %s\n\n", ev.Version, data); err != nil {
+				return
+			}
+
+		case <-heartbeat.C:
+			if err := send(": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/majiddarvishan/config_manager/history"
+)
+
+// readEvent reads the stream up to the end of the next "change" event and
+// returns its id and data lines
+func readEvent(t *testing.T, r *bufio.Reader) (id, data string) {
+	t.Helper()
+
+	var event string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading the stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && event == "change":
+			return id, data
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func subscriberCount(m *Manager) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.changeSubscribers)
+}
+
+func TestEventsStreamChanges(t *testing.T) {
+	m := newTestManager(t, `{"a":1}`)
+	replaceable(t, m, "a")
+
+	conf := parseNode(map[string]interface{}{"address": "127.0.0.1", "port": 0, "api_key": "k"})
+	hs, err := NewHttpServer(m, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(nil)
+	srv.Config = hs.newServer()
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/config/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 401 {
+		t.Errorf("without the API key: status = %d, want 401", resp.StatusCode)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/config/events", nil)
+	req.Header.Set("X-API-Key", "k")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != 200 || ct != "text/event-stream" {
+		t.Fatalf("status = %d, Content-Type = %q, want an event stream", resp.StatusCode, ct)
+	}
+
+	// The stream opens with a comment giving the current version, by which
+	// time the subscription is in place
+	stream := bufio.NewReader(resp.Body)
+	if line, err := stream.ReadString('\n'); err != nil || line != ": version 1\n" {
+		t.Fatalf("first line = %q, %v, want the version comment", line, err)
+	}
+
+	if err := m.Replace("/a", 2); err != nil {
+		t.Fatal(err)
+	}
+	id, data := readEvent(t, stream)
+	var ev history.ChangeEvent
+	if err := json.Unmarshal([]byte(data), &ev); err != nil {
+		t.Fatalf("data %q: %v", data, err)
+	}
+	if id != "2" || ev.Version != 2 || ev.Path != "/a" || ev.Operation != "replace" {
+		t.Errorf("id = %s, event = %+v, want the replace of /a at version 2", id, ev)
+	}
+
+	// Disconnecting drops the subscription
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for subscriberCount(m) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("subscription still in place after the client went away")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEventsEndOnShutdown(t *testing.T) {
+	m := newTestManager(t, `{"a":1}`)
+
+	hs, err := NewHttpServer(m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(nil)
+	srv.Config = hs.newServer()
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/config/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if _, err := bufio.NewReader(resp.Body).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Config.Shutdown(ctx); err != nil {
+		t.Errorf("shutdown with an open stream: %v", err)
+	}
+	if n := subscriberCount(m); n != 0 {
+		t.Errorf("%d subscriptions left after shutdown, want 0", n)
+	}
+}
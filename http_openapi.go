@@ -0,0 +1,236 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+// apiEndpoint describes one method of a route for the OpenAPI document
+type apiEndpoint struct {
+	path    string
+	method  string
+	summary string
+	query   []string // query parameters, all strings
+	body    string   // request body schema name under components, "" for none
+	admin   bool     // needs the admin key; only listed when one is set
+	public  bool     // served without the API key
+}
+
+// apiEndpoints lists what newServer serves. Keep the two in step.
+var apiEndpoints = []apiEndpoint{
+	{path: "/config", method: http.MethodGet, summary: "Get the config with its version and registered paths", query: []string{"fields"}},
+	{path: "/config", method: http.MethodPost, summary: "Insert, remove or replace a value", body: "Operation"},
+	{path: "/config", method: http.MethodPatch, summary: "Apply a JSON Merge Patch (RFC 7396) atomically", body: "MergePatch"},
+	{path: "/config/value", method: http.MethodGet, summary: "Get the value at a path", query: []string{"path"}},
+	{path: "/config/value", method: http.MethodPut, summary: "Replace the value at a path with the body", query: []string{"path"}, body: "Value"},
+	{path: "/config/tree", method: http.MethodGet, summary: "Get the config as a tree of typed nodes"},
+	{path: "/config/query", method: http.MethodGet, summary: "Run a query expression against the config", query: []string{"q", "mode"}},
+	{path: "/config/export", method: http.MethodGet, summary: "Export the config with its version and checksum"},
+	{path: "/config/import", method: http.MethodPost, summary: "Replace the whole config", body: "Import"},
+	{path: "/config/batch", method: http.MethodPost, summary: "Apply several operations, atomically by default", query: []string{"mode"}, body: "Batch"},
+	{path: "/config/patch", method: http.MethodPost, summary: "Apply a JSON Patch (RFC 6902) atomically", body: "Patch"},
+	{path: "/config/changes", method: http.MethodGet, summary: "Get the changes since a version", query: []string{"since"}},
+	{path: "/config/history", method: http.MethodGet, summary: "Get a page of the recorded changes, filtered", query: []string{"path", "operation", "since", "sinceVersion", "limit", "offset"}},
+	{path: "/config/events", method: http.MethodGet, summary: "Stream the changes as server-sent events"},
+	{path: "/config/diff", method: http.MethodPost, summary: "Diff a config document against the current config", body: "Config"},
+	{path: "/config/stats", method: http.MethodGet, summary: "Get manager and source statistics"},
+	{path: "/config/validate-document", method: http.MethodPost, summary: "Validate a config document against the schema", body: "Config"},
+	{path: "/config/modifiables", method: http.MethodPost, summary: "Register a path as insertable, removable or replaceable", body: "Modifiable", admin: true},
+	{path: "/config/openapi.json", method: http.MethodGet, summary: "Get this document"},
+	{path: "/health", method: http.MethodGet, summary: "Check that the server is up", public: true},
+}
+
+func (hs *http_server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		hs.onGetOpenAPI(w, r)
+	case http.MethodOptions:
+		hs.onOptions(w)
+	default:
+		hs.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// onGetOpenAPI writes the OpenAPI document as is, without the response
+// envelope, so that tools can read it directly
+func (hs *http_server) onGetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if !hs.checkAccess(r) {
+		hs.writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	doc, err := hs.openAPIDocument()
+	if err != nil {
+		hs.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	streamJSON(w, doc, "", 0)
+}
+
+// openAPIDocument describes the API as configured: its address, the
+// endpoints enabled, the authentication they need and the config schema,
+// which request bodies carrying config documents refer to
+func (hs *http_server) openAPIDocument() (*orderedmap.OrderedMap, error) {
+	schema, err := parseSchema(hs.manager.Source().getSchema())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+
+	paths := orderedmap.New()
+	for _, e := range apiEndpoints {
+		if e.admin && hs.adminKeyHash == nil {
+			continue
+		}
+
+		item, ok := paths.Get(e.path)
+		if !ok {
+			item = orderedmap.New()
+			paths.Set(e.path, item)
+		}
+		item.(*orderedmap.OrderedMap).Set(strings.ToLower(e.method), hs.openAPIOperation(e))
+	}
+
+	schemes := orderedmap.New()
+	if hs.apiKey != "" {
+		schemes.Set("apiKey", apiKeyScheme("API key"))
+	}
+	if hs.adminKeyHash != nil {
+		schemes.Set("adminKey", apiKeyScheme("admin API key"))
+	}
+
+	schemas := orderedmap.New()
+	schemas.Set("Config", schema)
+	schemas.Set("Value", map[string]interface{}{"description": "any JSON value"})
+	schemas.Set("Operation", map[string]interface{}{
+		"type":     "object",
+		"required": []string{"op", "path"},
+		"properties": map[string]interface{}{
+			"op":    map[string]interface{}{"type": "string", "enum": []string{"insert", "remove", "replace"}},
+			"path":  map[string]interface{}{"type": "string"},
+			"index": map[string]interface{}{"type": "integer", "description": "insert and remove only"},
+			"value": map[string]interface{}{"description": "insert and replace only"},
+			"meta":  map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "string"}},
+		},
+	})
+	schemas.Set("Batch", map[string]interface{}{
+		"type":     "object",
+		"required": []string{"operations"},
+		"properties": map[string]interface{}{
+			"operations": map[string]interface{}{"type": "array", "items": schemaRef("Operation")},
+		},
+	})
+	schemas.Set("Patch", map[string]interface{}{
+		"type": "array",
+		"items": map[string]interface{}{
+			"type":     "object",
+			"required": []string{"op", "path"},
+			"properties": map[string]interface{}{
+				"op":    map[string]interface{}{"type": "string", "enum": []string{"add", "remove", "replace", "move", "copy", "test"}},
+				"path":  map[string]interface{}{"type": "string", "description": "JSON Pointer"},
+				"from":  map[string]interface{}{"type": "string", "description": "move and copy only"},
+				"value": map[string]interface{}{"description": "add, replace and test only"},
+			},
+		},
+	})
+	schemas.Set("MergePatch", map[string]interface{}{
+		"type":        "object",
+		"description": "merged into the config; null removes a key",
+	})
+	schemas.Set("Import", map[string]interface{}{
+		"type":     "object",
+		"required": []string{"version", "checksum", "config"},
+		"properties": map[string]interface{}{
+			"version":  map[string]interface{}{"type": "integer"},
+			"checksum": map[string]interface{}{"type": "string"},
+			"config":   schemaRef("Config"),
+		},
+	})
+	schemas.Set("Modifiable", map[string]interface{}{
+		"type":     "object",
+		"required": []string{"type", "path"},
+		"properties": map[string]interface{}{
+			"type": map[string]interface{}{"type": "string", "enum": []string{"insert", "remove", "replace"}},
+			"path": map[string]interface{}{"type": "string"},
+		},
+	})
+
+	components := orderedmap.New()
+	components.Set("schemas", schemas)
+	if len(schemes.Keys()) > 0 {
+		components.Set("securitySchemes", schemes)
+	}
+
+	info := orderedmap.New()
+	info.Set("title", "Config API")
+	info.Set("version", "1.0.0")
+
+	doc := orderedmap.New()
+	doc.Set("openapi", "3.1.0")
+	doc.Set("info", info)
+	doc.Set("servers", []interface{}{map[string]string{"url": fmt.Sprintf("http://%s:%d", hs.address, hs.port)}})
+	doc.Set("paths", paths)
+	doc.Set("components", components)
+	return doc, nil
+}
+
+func (hs *http_server) openAPIOperation(e apiEndpoint) *orderedmap.OrderedMap {
+	op := orderedmap.New()
+	op.Set("summary", e.summary)
+
+	if len(e.query) > 0 {
+		params := make([]interface{}, 0, len(e.query))
+		for _, name := range e.query {
+			params = append(params, map[string]interface{}{
+				"name":   name,
+				"in":     "query",
+				"schema": map[string]string{"type": "string"},
+			})
+		}
+		op.Set("parameters", params)
+	}
+
+	if e.body != "" {
+		op.Set("requestBody", map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": schemaRef(e.body)},
+			},
+		})
+	}
+
+	switch {
+	case e.admin:
+		op.Set("security", []interface{}{map[string][]string{"adminKey": {}}})
+	case !e.public && hs.apiKey != "":
+		op.Set("security", []interface{}{map[string][]string{"apiKey": {}}})
+	}
+
+	responses := orderedmap.New()
+	responses.Set("200", map[string]string{"description": "success"})
+	if e.method == http.MethodPost && e.path == "/config" {
+		responses.Set("201", map[string]string{"description": "inserted; Location points at the new element"})
+	}
+	if e.body != "" {
+		responses.Set("400", map[string]string{"description": "invalid request or rejected change"})
+	}
+	if !e.public && (hs.apiKey != "" || e.admin) {
+		responses.Set("401", map[string]string{"description": "missing or wrong API key"})
+	}
+	op.Set("responses", responses)
+
+	return op
+}
+
+func apiKeyScheme(description string) map[string]string {
+	return map[string]string{"type": "apiKey", "in": "header", "name": "X-API-Key", "description": description}
+}
+
+func schemaRef(name string) map[string]string {
+	return map[string]string{"$ref": "#/components/schemas/" + name}
+}
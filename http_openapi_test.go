@@ -0,0 +1,109 @@
+package config
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOpenAPIDocument(t *testing.T) {
+	schema := `{"type":"object","properties":{"port":{"type":"integer"}}}`
+	source, err := NewStrSource(`{"port":1}`, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(conf *Node, key string, opts ...ServerOption) (int, map[string]interface{}) {
+		t.Helper()
+		hs, err := NewHttpServer(m, conf, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("GET", "/config/openapi.json", nil)
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		hs.newServer().Handler.ServeHTTP(w, r)
+
+		var doc map[string]interface{}
+		if w.Code == 200 {
+			if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+				t.Fatalf("invalid document %q: %v", w.Body, err)
+			}
+		}
+		return w.Code, doc
+	}
+
+	conf := parseNode(map[string]interface{}{"address": "example.test", "port": 9000, "api_key": "k"})
+	if code, _ := get(conf, ""); code != 401 {
+		t.Errorf("without the API key: status = %d, want 401", code)
+	}
+
+	code, doc := get(conf, "k")
+	if code != 200 {
+		t.Fatalf("status = %d, want 200", code)
+	}
+	if doc["openapi"] != "3.1.0" {
+		t.Errorf("openapi = %v", doc["openapi"])
+	}
+	if !equalJSON(doc["servers"], []interface{}{map[string]interface{}{"url": "http://example.test:9000"}}) {
+		t.Errorf("servers = %v", doc["servers"])
+	}
+
+	paths, _ := doc["paths"].(map[string]interface{})
+	configPath, _ := paths["/config"].(map[string]interface{})
+	if configPath["get"] == nil || configPath["post"] == nil {
+		t.Errorf("/config = %v, want get and post", configPath)
+	}
+	post, _ := configPath["post"].(map[string]interface{})
+	if !equalJSON(post["security"], []interface{}{map[string]interface{}{"apiKey": []interface{}{}}}) {
+		t.Errorf("POST /config security = %v, want apiKey", post["security"])
+	}
+	health, _ := paths["/health"].(map[string]interface{})
+	if get, _ := health["get"].(map[string]interface{}); get == nil || get["security"] != nil {
+		t.Errorf("GET /health = %v, want no security", health["get"])
+	}
+	if _, ok := paths["/config/modifiables"]; ok {
+		t.Error("admin endpoint listed without an admin key")
+	}
+
+	components, _ := doc["components"].(map[string]interface{})
+	schemes, _ := components["securitySchemes"].(map[string]interface{})
+	want := map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key", "description": "API key"}
+	if !equalJSON(schemes["apiKey"], want) {
+		t.Errorf("apiKey scheme = %v, want %v", schemes["apiKey"], want)
+	}
+
+	// The config schema is embedded and request bodies refer to it
+	schemas, _ := components["schemas"].(map[string]interface{})
+	var wantSchema interface{}
+	json.Unmarshal([]byte(schema), &wantSchema)
+	if !equalJSON(schemas["Config"], wantSchema) {
+		t.Errorf("Config schema = %v, want %v", schemas["Config"], wantSchema)
+	}
+	diff, _ := paths["/config/diff"].(map[string]interface{})
+	diffPost, _ := diff["post"].(map[string]interface{})
+	body, _ := diffPost["requestBody"].(map[string]interface{})
+	ref := map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]interface{}{"$ref": "#/components/schemas/Config"}}}
+	if !equalJSON(body["content"], ref) {
+		t.Errorf("POST /config/diff body = %v, want a reference to Config", body["content"])
+	}
+
+	// Without an API key nothing is secured; an admin key adds its endpoint
+	_, doc = get(nil, "", WithAdminAPIKey("admin"))
+	paths, _ = doc["paths"].(map[string]interface{})
+	configPath, _ = paths["/config"].(map[string]interface{})
+	if post, _ := configPath["post"].(map[string]interface{}); post["security"] != nil {
+		t.Errorf("POST /config security = %v without an API key", post["security"])
+	}
+	modifiables, _ := paths["/config/modifiables"].(map[string]interface{})
+	adminPost, _ := modifiables["post"].(map[string]interface{})
+	if !equalJSON(adminPost["security"], []interface{}{map[string]interface{}{"adminKey": []interface{}{}}}) {
+		t.Errorf("POST /config/modifiables = %v, want adminKey security", modifiables)
+	}
+}
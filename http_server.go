@@ -6,44 +6,153 @@ import (
 	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/iancoleman/orderedmap"
+	"github.com/majiddarvishan/config_manager/history"
 	"github.com/rs/cors"
 )
 
 const (
-	maxBodySize       = 10 * 1024 * 1024 // 10MB max request body
-	defaultAddress    = "localhost"
-	defaultPort       = 8080
-	shutdownTimeout   = 30 * time.Second
-	readTimeout       = 15 * time.Second
-	writeTimeout      = 15 * time.Second
-	idleTimeout       = 60 * time.Second
+	maxBodySize        = 10 * 1024 * 1024 // 10MB max request body
+	defaultAddress     = "localhost"
+	defaultPort        = 8080
+	shutdownTimeout    = 30 * time.Second
+	readTimeout        = 15 * time.Second
+	writeTimeout       = 15 * time.Second
+	idleTimeout        = 60 * time.Second
+	maxProjectedFields = 32              // max query expressions in ?fields=
+	maxProjectionSize  = 1 * 1024 * 1024 // 1MB max projected payload
+	defaultMaxDepth    = 64              // max nesting of values in request bodies
+	defaultHistoryPage = 100             // history events per page unless ?limit= says otherwise
+	maxHistoryPage     = 1000            // max ?limit= for the history
+)
+
+// ResponseFormat selects the JSON envelope used for API responses
+type ResponseFormat string
+
+const (
+	// ResponseWrapped wraps bodies as {"success":true,"data":...} and
+	// {"success":false,"error":{"message":...,"code":...}}
+	ResponseWrapped ResponseFormat = "wrapped"
+	// ResponseFlat writes data at the top level and errors as
+	// {"error":...,"code":...}, relying on the status code for success
+	ResponseFlat ResponseFormat = "flat"
 )
 
 type http_server struct {
-	address   string
-	port      int
-	apiKey    string
-	apiKeyHash [32]byte // Store hash for comparison
-	manager   *Manager
-	server    *http.Server
+	address      string
+	port         int
+	apiKey       string
+	apiKeyHash   [32]byte  // Store hash for comparison
+	adminKeyHash *[32]byte // nil disables the admin endpoints
+	manager      *Manager
+	server       *http.Server
+
+	responseFormat        ResponseFormat
+	conflictIncludesState bool
+	strictRequests        bool
+	maxValueDepth         int
+	encoders              map[string]Codec // alternative GET /config formats by media type
+	logger                *slog.Logger     // nil means the manager's logger
+}
+
+// ServerOption customises an http_server at construction time
+type ServerOption func(*http_server)
+
+// WithResponseFormat selects the response envelope. Defaults to ResponseWrapped.
+func WithResponseFormat(format ResponseFormat) ServerOption {
+	return func(hs *http_server) {
+		hs.responseFormat = format
+	}
+}
+
+// WithConflictIncludesState makes 409 responses to requests made against the
+// whole config's version without naming a path, such as an import, carry the
+// current config next to the current version, so clients can rebase without
+// another GET. Off by default since the config can be large. Conflicts on a
+// path always carry the current value there.
+func WithConflictIncludesState(include bool) ServerOption {
+	return func(hs *http_server) {
+		hs.conflictIncludesState = include
+	}
+}
+
+// WithStrictRequests makes POST /config reject bodies with top-level keys
+// other than op, path, index, value, version, path_version and meta instead
+// of ignoring them; batch and patch operations are held to their own fields.
+func WithStrictRequests(strict bool) ServerOption {
+	return func(hs *http_server) {
+		hs.strictRequests = strict
+	}
+}
+
+// WithMaxValueDepth limits how deeply the values in request bodies may nest;
+// deeper requests are rejected with 400 before they are decoded. Defaults to
+// 64.
+func WithMaxValueDepth(depth int) ServerOption {
+	return func(hs *http_server) {
+		hs.maxValueDepth = depth
+	}
+}
+
+// WithResponseCodec makes GET /config answer requests accepting mediaType
+// with the config document encoded by codec. YAML (application/yaml,
+// application/x-yaml, text/yaml) and TOML (application/toml, text/toml) are
+// registered by default; JSON stays the default format.
+func WithResponseCodec(mediaType string, codec Codec) ServerOption {
+	return func(hs *http_server) {
+		hs.encoders[strings.ToLower(mediaType)] = codec
+	}
+}
+
+// WithAdminAPIKey enables the admin endpoints (POST /config/modifiables),
+// which accept only requests sending key in X-API-Key. It can also be set as
+// "admin_api_key" in the server config. Without it they answer 403.
+func WithAdminAPIKey(key string) ServerOption {
+	return func(hs *http_server) {
+		hs.setAdminKey(key)
+	}
+}
+
+// WithHTTPLogger routes the server's logging to logger. Defaults to the
+// manager's logger.
+func WithHTTPLogger(logger *slog.Logger) ServerOption {
+	return func(hs *http_server) {
+		hs.logger = logger
+	}
 }
 
-func NewHttpServer(m *Manager, conf *Node) (*http_server, error) {
+func NewHttpServer(m *Manager, conf *Node, opts ...ServerOption) (*http_server, error) {
 	if m == nil {
 		return nil, fmt.Errorf("manager cannot be nil")
 	}
 
 	hs := &http_server{
-		manager: m,
-		address: defaultAddress,
-		port:    defaultPort,
+		manager:        m,
+		address:        defaultAddress,
+		port:           defaultPort,
+		responseFormat: ResponseWrapped,
+		maxValueDepth:  defaultMaxDepth,
+		encoders: map[string]Codec{
+			"application/yaml":   YAMLCodec{},
+			"application/x-yaml": YAMLCodec{},
+			"text/yaml":          YAMLCodec{},
+			"application/toml":   TOMLCodec{},
+			"text/toml":          TOMLCodec{},
+		},
 	}
 
 	if conf != nil {
@@ -65,41 +174,89 @@ func NewHttpServer(m *Manager, conf *Node) (*http_server, error) {
 				hs.apiKeyHash = sha256.Sum256([]byte(key))
 			}
 		}
+
+		if keyNode, err := conf.At("admin_api_key"); err == nil {
+			if key, err := keyNode.GetString(); err == nil {
+				hs.setAdminKey(key)
+			}
+		}
+
+		if formatNode, err := conf.At("response_format"); err == nil {
+			if format, err := formatNode.GetString(); err == nil && format != "" {
+				hs.responseFormat = ResponseFormat(format)
+			}
+		}
+	}
+
+	for _, opt := range opts {
+		opt(hs)
+	}
+
+	switch hs.responseFormat {
+	case ResponseWrapped, ResponseFlat:
+	default:
+		return nil, fmt.Errorf("unsupported response format '%s'", hs.responseFormat)
 	}
 
 	return hs, nil
 }
 
 func (hs *http_server) Start() error {
+	hs.server = hs.newServer()
+
+	hs.log().Info("starting HTTP server", "addr", hs.server.Addr)
+
+	if err := hs.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("server error: %w", err)
+	}
+
+	return nil
+}
+
+func (hs *http_server) newServer() *http.Server {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/config", hs.handleConfig)
+	mux.HandleFunc("/config", compressed(hs.handleConfig))
+	mux.HandleFunc("/config/value", hs.handleValue)
+	mux.HandleFunc("/config/tree", hs.handleTree)
+	mux.HandleFunc("/config/query", compressed(hs.handleQuery))
+	mux.HandleFunc("/config/export", compressed(hs.handleExport))
+	mux.HandleFunc("/config/import", hs.handleImport)
+	mux.HandleFunc("/config/batch", hs.handleBatch)
+	mux.HandleFunc("/config/patch", hs.handlePatch)
+	mux.HandleFunc("/config/changes", compressed(hs.handleChanges))
+	mux.HandleFunc("/config/events", hs.handleEvents)
+	mux.HandleFunc("/config/history", compressed(hs.handleHistory))
+	mux.HandleFunc("/config/diff", hs.handleDiff)
+	mux.HandleFunc("/config/stats", hs.handleStats)
+	mux.HandleFunc("/config/validate-document", hs.handleValidateDocument)
+	mux.HandleFunc("/config/modifiables", hs.handleModifiables)
+	mux.HandleFunc("/config/openapi.json", hs.handleOpenAPI)
 	mux.HandleFunc("/health", hs.handleHealth)
 
 	addr := fmt.Sprintf("%s:%d", hs.address, hs.port)
 
 	handler := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization", "X-API-Key"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", "X-API-Key", "If-Match", "If-None-Match"},
+		ExposedHeaders:   []string{"ETag", "X-Config-Version", "Location"},
 		AllowCredentials: false,
 		MaxAge:           3600,
 	}).Handler(mux)
 
-	hs.server = &http.Server{
+	// Requests see their context cancelled on shutdown, which ends event
+	// streams instead of holding the shutdown up
+	ctx, cancel := context.WithCancel(context.Background())
+	server := &http.Server{
 		Addr:         addr,
 		Handler:      handler,
 		ReadTimeout:  readTimeout,
 		WriteTimeout: writeTimeout,
 		IdleTimeout:  idleTimeout,
+		BaseContext:  func(net.Listener) context.Context { return ctx },
 	}
-
-	log.Printf("Starting HTTP server on %s", addr)
-
-	if err := hs.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		return fmt.Errorf("server error: %w", err)
-	}
-
-	return nil
+	server.RegisterOnShutdown(cancel)
+	return server
 }
 
 func (hs *http_server) Shutdown(ctx context.Context) error {
@@ -107,27 +264,221 @@ func (hs *http_server) Shutdown(ctx context.Context) error {
 		return nil
 	}
 
-	log.Println("Shutting down HTTP server...")
+	hs.log().Info("shutting down HTTP server")
 	return hs.server.Shutdown(ctx)
 }
 
+// Run serves until ctx is cancelled, then shuts the server down gracefully,
+// giving in-flight requests up to 30 seconds. It returns the error that
+// stopped the server from serving (such as a failure to bind the address)
+// or the error of the shutdown.
+func (hs *http_server) Run(ctx context.Context) error {
+	hs.server = hs.newServer()
+	hs.log().Info("starting HTTP server", "addr", hs.server.Addr)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- hs.server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-served:
+		if err != nil && err != http.ErrServerClosed {
+			return fmt.Errorf("server error: %w", err)
+		}
+		return nil
+
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		err := hs.Shutdown(shutdownCtx)
+		<-served
+		return err
+	}
+}
+
+// RunHttpServer creates an HTTP server for the manager from conf and opts,
+// as NewHttpServer does, and runs it until ctx is cancelled
+func (m *Manager) RunHttpServer(ctx context.Context, conf *Node, opts ...ServerOption) error {
+	hs, err := NewHttpServer(m, conf, opts...)
+	if err != nil {
+		return err
+	}
+	return hs.Run(ctx)
+}
+
+// RunUntilSignal is RunHttpServer stopping on SIGINT or SIGTERM
+func (m *Manager) RunUntilSignal(conf *Node, opts ...ServerOption) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return m.RunHttpServer(ctx, conf, opts...)
+}
+
 func (hs *http_server) handleConfig(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
 		hs.onGet(w, r)
 	case http.MethodPost:
 		hs.onPost(w, r)
+	case http.MethodPatch:
+		hs.onMergePatch(w, r)
+	case http.MethodOptions:
+		hs.onOptions(w)
+	default:
+		hs.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (hs *http_server) handleValue(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		hs.onGetValue(w, r)
+	case http.MethodPut:
+		hs.onPutValue(w, r)
+	case http.MethodOptions:
+		hs.onOptions(w)
+	default:
+		hs.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (hs *http_server) handleTree(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		hs.onGetTree(w, r)
+	case http.MethodOptions:
+		hs.onOptions(w)
+	default:
+		hs.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (hs *http_server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		hs.onGetQuery(w, r)
+	case http.MethodOptions:
+		hs.onOptions(w)
+	default:
+		hs.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (hs *http_server) handleExport(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		hs.onExport(w, r)
+	case http.MethodOptions:
+		hs.onOptions(w)
+	default:
+		hs.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (hs *http_server) handleImport(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		hs.onImport(w, r)
+	case http.MethodOptions:
+		hs.onOptions(w)
+	default:
+		hs.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (hs *http_server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		hs.onBatch(w, r)
+	case http.MethodOptions:
+		hs.onOptions(w)
+	default:
+		hs.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (hs *http_server) handlePatch(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		hs.onPatch(w, r)
+	case http.MethodOptions:
+		hs.onOptions(w)
+	default:
+		hs.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (hs *http_server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		hs.onGetHistory(w, r)
+	case http.MethodOptions:
+		hs.onOptions(w)
+	default:
+		hs.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (hs *http_server) handleChanges(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		hs.onGetChanges(w, r)
+	case http.MethodOptions:
+		hs.onOptions(w)
+	default:
+		hs.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (hs *http_server) handleDiff(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		hs.onDiff(w, r)
+	case http.MethodOptions:
+		hs.onOptions(w)
+	default:
+		hs.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (hs *http_server) handleStats(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		hs.onGetStats(w, r)
+	case http.MethodOptions:
+		hs.onOptions(w)
+	default:
+		hs.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (hs *http_server) handleValidateDocument(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		hs.onValidateDocument(w, r)
+	case http.MethodOptions:
+		hs.onOptions(w)
+	default:
+		hs.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (hs *http_server) handleModifiables(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		hs.onRegisterModifiable(w, r)
 	case http.MethodOptions:
 		hs.onOptions(w)
 	default:
-		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		hs.writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 	}
 }
 
 func (hs *http_server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"status":"ok"}`))
+	fmt.Fprintf(w, `{"status":"ok","fingerprint":"%s"}`, hs.manager.Fingerprint())
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -136,17 +487,107 @@ func (hs *http_server) handleHealth(w http.ResponseWriter, r *http.Request) {
 
 func (hs *http_server) onGet(w http.ResponseWriter, r *http.Request) {
 	if !hs.checkAccess(r) {
-		writeError(w, http.StatusUnauthorized, "unauthorized")
+		hs.writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if fields := r.URL.Query().Get("fields"); fields != "" {
+		hs.onGetFields(w, fields)
+		return
+	}
+
+	if mediaType, codec := hs.negotiate(r); codec != nil {
+		hs.onGetEncoded(w, mediaType, codec)
 		return
 	}
 
 	data, err := hs.buildConfigState()
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to build config: %s", err))
+		hs.writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to build config: %s", err))
+		return
+	}
+
+	hs.writeSuccess(w, data)
+}
+
+// negotiate returns the first media type in the Accept header that has a
+// registered codec, or a nil codec when the response should be JSON
+func (hs *http_server) negotiate(r *http.Request) (string, Codec) {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(accepted, ";", 2)[0]))
+		switch mediaType {
+		case "application/json", "*/*":
+			return "", nil
+		}
+		if codec, ok := hs.encoders[mediaType]; ok && codec != nil {
+			return mediaType, codec
+		}
+	}
+	return "", nil
+}
+
+// onGetEncoded writes the bare config document encoded by codec, with its
+// version in the X-Config-Version header
+func (hs *http_server) onGetEncoded(w http.ResponseWriter, mediaType string, codec Codec) {
+	config, version, err := hs.manager.export()
+	if err != nil {
+		hs.writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to build config: %s", err))
+		return
+	}
+
+	out, err := codec.Encode(config)
+	if err != nil {
+		hs.writeError(w, http.StatusNotAcceptable, fmt.Sprintf("cannot encode config as %s: %s", mediaType, err))
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("X-Config-Version", strconv.FormatInt(version, 10))
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+}
+
+// onGetFields answers GET /config?fields=q1,q2 with only the subtrees matched
+// by each query, keyed by their concrete path
+func (hs *http_server) onGetFields(w http.ResponseWriter, fields string) {
+	exprs := strings.Split(fields, ",")
+	if len(exprs) > maxProjectedFields {
+		hs.writeError(w, http.StatusBadRequest,
+			fmt.Sprintf("too many fields: %d (max %d)", len(exprs), maxProjectedFields))
+		return
+	}
+
+	version := hs.manager.Version()
+	projected := orderedmap.New()
+	for _, expr := range exprs {
+		expr = strings.TrimSpace(expr)
+		results, err := hs.manager.Query(expr)
+		if err != nil {
+			hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid field '%s': %s", expr, err))
+			return
+		}
+		for _, res := range results {
+			projected.Set(res.Path, res.Node.toInterface())
+		}
+	}
+
+	encoded, err := json.Marshal(projected)
+	if err != nil {
+		hs.writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to build fields: %s", err))
+		return
+	}
+	if len(encoded) > maxProjectionSize {
+		hs.writeError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("projected result exceeds %d bytes", maxProjectionSize))
 		return
 	}
 
-	writeSuccess(w, data)
+	data := orderedmap.New()
+	data.Set("fields", projected)
+	data.Set("version", version)
+
+	hs.writeSuccess(w, data)
 }
 
 ////////////////////////////////////////////////////////////////////////////////
@@ -155,7 +596,7 @@ func (hs *http_server) onGet(w http.ResponseWriter, r *http.Request) {
 
 func (hs *http_server) onPost(w http.ResponseWriter, r *http.Request) {
 	if !hs.checkAccess(r) {
-		writeError(w, http.StatusUnauthorized, "unauthorized")
+		hs.writeError(w, http.StatusUnauthorized, "unauthorized")
 		return
 	}
 
@@ -165,152 +606,1142 @@ func (hs *http_server) onPost(w http.ResponseWriter, r *http.Request) {
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Sprintf("could not read body: %s", err))
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("could not read body: %s", err))
 		return
 	}
 
 	if len(body) == 0 {
-		writeError(w, http.StatusBadRequest, "request body is empty")
+		hs.writeError(w, http.StatusBadRequest, "request body is empty")
 		return
 	}
 
-	bodyJSON := orderedmap.New()
-	if err := json.Unmarshal(body, &bodyJSON); err != nil {
-		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON: %s", err))
+	// The value sits one level inside the request object
+	if err := hs.checkValueDepth(body, 1); err != nil {
+		hs.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	bodyJSON, err := decodeBody(body)
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON: %s", err))
 		return
 	}
 
+	if hs.strictRequests {
+		if err := checkKnownKeys(bodyJSON, "op", "path", "index", "value", "version", "path_version", "meta"); err != nil {
+			hs.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
 	op, err := getString(bodyJSON, "op")
 	if err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
+		hs.writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	path, err := getString(bodyJSON, "path")
 	if err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
+		hs.writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	// Validate path format
-	if path == "" || path[0] != '/' {
-		writeError(w, http.StatusBadRequest, "path must start with '/'")
+	if path, err = normalizePath(path); err != nil {
+		hs.writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	value, hasValue := bodyJSON.Get("value")
 
-	// Version-based optimistic locking (better than hash)
-	var expectedVersion int64
+	var opts mutationOptions
+
+	// Version-based optimistic locking (better than hash), checked under the
+	// lock of the change
 	if versionVal, ok := bodyJSON.Get("version"); ok {
-		if versionFloat, ok := versionVal.(float64); ok {
-			expectedVersion = int64(versionFloat)
+		if version, ok := requestInt(versionVal); ok {
+			opts.version = version
 		} else {
-			writeError(w, http.StatusBadRequest, "version must be a number")
+			hs.writeError(w, http.StatusBadRequest, "version must be a number")
+			return
+		}
+
+		// Versions start at 1, and 0 would mean no precondition
+		if opts.version < 1 {
+			hs.writeConflict(w, opts.version, op, path)
 			return
 		}
+	}
 
-		currentVersion := hs.manager.Version()
-		if currentVersion != expectedVersion {
-			writeError(w, http.StatusConflict,
-				fmt.Sprintf("version mismatch: expected %d, current %d", expectedVersion, currentVersion))
+	// A path version only conflicts with changes to the affected subtree
+	if raw, ok := bodyJSON.Get("path_version"); ok {
+		pathVersion, isNumber := requestInt(raw)
+		if !isNumber || pathVersion < 1 {
+			hs.writeError(w, http.StatusBadRequest, "path_version must be a positive number")
 			return
 		}
+		opts.pathVersion = pathVersion
+	}
+
+	if opts.meta, err = getMeta(bodyJSON); err != nil {
+		hs.writeError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
 	// Execute operation
+	status := http.StatusOK
 	switch op {
 	case "insert":
 		if !hasValue {
-			writeError(w, http.StatusBadRequest, "value is required for insert")
+			hs.writeError(w, http.StatusBadRequest, "value is required for insert")
 			return
 		}
 
 		index, err := getIndex(bodyJSON)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, err.Error())
+			hs.writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
 
-		if err := hs.manager.insert(path, index, value); err != nil {
-			writeError(w, http.StatusBadRequest, err.Error())
+		if err := hs.manager.insert(path, index, value, opts); err != nil {
+			hs.writeMutationError(w, err, "insert")
 			return
 		}
 
+		// The new element is a resource of its own
+		w.Header().Set("Location", valueLocation(fmt.Sprintf("%s/%d", strings.TrimSuffix(path, "/"), index)))
+		status = http.StatusCreated
+
 	case "remove":
 		index, err := getIndex(bodyJSON)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, err.Error())
+			hs.writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
 
-		if err := hs.manager.remove(path, index); err != nil {
-			writeError(w, http.StatusBadRequest, err.Error())
+		if err := hs.manager.remove(path, index, opts); err != nil {
+			hs.writeMutationError(w, err, "remove")
 			return
 		}
 
 	case "replace":
 		if !hasValue {
-			writeError(w, http.StatusBadRequest, "value is required for replace")
+			hs.writeError(w, http.StatusBadRequest, "value is required for replace")
 			return
 		}
 
-		if err := hs.manager.replace(path, value); err != nil {
-			writeError(w, http.StatusBadRequest, err.Error())
+		if err := hs.manager.replace(path, value, opts); err != nil {
+			hs.writeMutationError(w, err, "replace")
 			return
 		}
 
 	default:
-		writeError(w, http.StatusBadRequest, fmt.Sprintf("unsupported operation: %s", op))
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("unsupported operation: %s", op))
 		return
 	}
 
 	// Build updated config for response
 	data, err := hs.buildConfigState()
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to build config: %s", err))
+		hs.writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to build config: %s", err))
 		return
 	}
 
-	writeSuccess(w, data)
+	hs.writeSuccessStatus(w, status, data)
 }
 
-////////////////////////////////////////////////////////////////////////////////
-// OPTIONS
-////////////////////////////////////////////////////////////////////////////////
-
-func (hs *http_server) onOptions(w http.ResponseWriter) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, X-API-Key")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.WriteHeader(http.StatusOK)
+// valueLocation is the URL of the value at path. Slashes are left unescaped
+// to keep the path readable.
+func valueLocation(path string) string {
+	return "/config/value?path=" + strings.ReplaceAll(url.QueryEscape(path), "%2F", "/")
 }
 
 ////////////////////////////////////////////////////////////////////////////////
-// BUILD CONFIG STATE
+// VALUE (GET / PUT of a single subtree)
 ////////////////////////////////////////////////////////////////////////////////
 
-func (hs *http_server) buildConfigState() (*orderedmap.OrderedMap, error) {
-	confJSON := orderedmap.New()
-	schemaJSON := orderedmap.New()
-
-	configStr := hs.manager.Source().getConfig()
-	if configStr == nil {
-		return nil, fmt.Errorf("config is nil")
+func (hs *http_server) onGetValue(w http.ResponseWriter, r *http.Request) {
+	if !hs.checkAccess(r) {
+		hs.writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
 	}
 
-	if err := json.Unmarshal([]byte(*configStr), &confJSON); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	path, err := normalizePath(r.URL.Query().Get("path"))
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	schemaStr := hs.manager.Source().getSchema()
-	if schemaStr != nil {
-		if err := json.Unmarshal([]byte(*schemaStr), &schemaJSON); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal schema: %w", err)
-		}
+	node, version, err := hs.manager.lookup(path)
+	if err != nil {
+		hs.writeError(w, http.StatusNotFound, err.Error())
+		return
 	}
 
-	paths := orderedmap.New()
+	// The path version is read separately and may be newer than the value,
+	// which only makes a conditional write conflict needlessly
+	pathVersion, err := hs.manager.PathVersion(path)
+	if err != nil {
+		hs.writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	data := orderedmap.New()
+	data.Set("path", path)
+	data.Set("value", node.toInterface())
+	data.Set("version", version)
+	data.Set("path_version", pathVersion)
+
+	hs.writeSuccess(w, data)
+}
+
+// onPutValue replaces the subtree at ?path= with the request body. The body
+// is diffed against the current subtree and the diff is returned; an empty
+// diff leaves the config (and its version) untouched. A version from If-Match
+// or ?version= is checked under the same lock as the diff and the write.
+func (hs *http_server) onPutValue(w http.ResponseWriter, r *http.Request) {
+	if !hs.checkAccess(r) {
+		hs.writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	path, err := normalizePath(r.URL.Query().Get("path"))
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("could not read body: %s", err))
+		return
+	}
+
+	if err := hs.checkValueDepth(body, 0); err != nil {
+		hs.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	value, err := parseValue(body)
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON: %s", err))
+		return
+	}
+
+	expectedVersion, hasVersion, err := requestVersion(r)
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Versions start at 1, and 0 would mean no precondition to replaceDiff
+	if hasVersion && expectedVersion < 1 {
+		hs.writeConflict(w, expectedVersion, "replace", path)
+		return
+	}
+
+	// The precondition, the diff and the write happen under one lock
+	diff, version, err := hs.manager.replaceDiff(path, value, mutationOptions{version: expectedVersion})
+	if err != nil {
+		var notFound notFoundError
+		if errors.As(err, &notFound) {
+			hs.writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		hs.writeMutationError(w, err, "replace")
+		return
+	}
+
+	data := orderedmap.New()
+	data.Set("path", path)
+	data.Set("diff", diff)
+	data.Set("version", version)
+
+	hs.writeSuccess(w, data)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// TREE
+////////////////////////////////////////////////////////////////////////////////
+
+func (hs *http_server) onGetTree(w http.ResponseWriter, r *http.Request) {
+	if !hs.checkAccess(r) {
+		hs.writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	data := orderedmap.New()
+	data.Set("tree", hs.manager.Tree())
+	data.Set("version", hs.manager.Version())
+
+	hs.writeSuccess(w, data)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// QUERY
+////////////////////////////////////////////////////////////////////////////////
+
+// onGetQuery runs the query expression in q with Manager.Query and returns
+// the matches as {path, node}. With mode=count only their number is
+// returned. truncated tells that a limit set with WithQueryLimits was hit.
+func (hs *http_server) onGetQuery(w http.ResponseWriter, r *http.Request) {
+	if !hs.checkAccess(r) {
+		hs.writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	expr := r.URL.Query().Get("q")
+	if expr == "" {
+		hs.writeError(w, http.StatusBadRequest, "'q' is missing")
+		return
+	}
+	if _, err := parseQuery(expr); err != nil {
+		hs.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode != "" && mode != "count" {
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("query mode '%s' is not supported, use mode=count or leave it out", mode))
+		return
+	}
+
+	data := orderedmap.New()
+	var err error
+	if mode == "count" {
+		var count int
+		count, err = hs.manager.QueryCount(expr)
+		data.Set("count", count)
+	} else {
+		var results []QueryResult
+		results, err = hs.manager.Query(expr)
+		data.Set("results", results)
+		data.Set("count", len(results))
+	}
+	if err != nil && !errors.Is(err, ErrQueryTruncated) {
+		hs.writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	data.Set("truncated", err != nil)
+	data.Set("version", hs.manager.Version())
+
+	hs.writeSuccess(w, data)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// DIFF
+////////////////////////////////////////////////////////////////////////////////
+
+// onDiff takes a complete proposed config document as the body and answers
+// with its differences from the current config and whether it passes the
+// schema. Nothing is applied.
+func (hs *http_server) onDiff(w http.ResponseWriter, r *http.Request) {
+	if !hs.checkAccess(r) {
+		hs.writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("could not read body: %s", err))
+		return
+	}
+
+	if err := hs.checkValueDepth(body, 0); err != nil {
+		hs.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result, err := hs.manager.Compare(body)
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid config: %s", err))
+		return
+	}
+
+	data := orderedmap.New()
+	data.Set("diff", result.Diff)
+	data.Set("valid", result.Valid)
+	if !result.Valid {
+		data.Set("validation_error", result.Error)
+	}
+	data.Set("version", result.Version)
+
+	hs.writeSuccess(w, data)
+}
+
+// onValidateDocument checks the body, a complete config document, against
+// the schema and answers with {valid, errors}. The current config plays no
+// part.
+func (hs *http_server) onValidateDocument(w http.ResponseWriter, r *http.Request) {
+	if !hs.checkAccess(r) {
+		hs.writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("could not read body: %s", err))
+		return
+	}
+
+	if err := hs.checkValueDepth(body, 0); err != nil {
+		hs.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	fieldErrors := make([]FieldError, 0)
+	if err := hs.manager.ValidateDocument(body); err != nil {
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid config: %s", err))
+			return
+		}
+		fieldErrors = verr.Errors
+	}
+
+	data := orderedmap.New()
+	data.Set("valid", len(fieldErrors) == 0)
+	data.Set("errors", fieldErrors)
+
+	hs.writeSuccess(w, data)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// MODIFIABLES (admin)
+////////////////////////////////////////////////////////////////////////////////
+
+// onRegisterModifiable makes a path editable at runtime, without a handler.
+// The body is {"type": "insert"|"remove"|"replace", "path": "..."}.
+func (hs *http_server) onRegisterModifiable(w http.ResponseWriter, r *http.Request) {
+	if hs.adminKeyHash == nil {
+		hs.writeError(w, http.StatusForbidden, "admin access is not enabled")
+		return
+	}
+	if !hs.checkAdmin(r) {
+		hs.writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("could not read body: %s", err))
+		return
+	}
+
+	bodyJSON, err := decodeBody(body)
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON: %s", err))
+		return
+	}
+
+	typ, err := getString(bodyJSON, "type")
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	path, err := getString(bodyJSON, "path")
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if path, err = normalizePath(path); err != nil {
+		hs.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var register func(string, handler_t) error
+	switch typ {
+	case Insertable.String():
+		register = hs.manager.OnInsertPath
+	case Removable.String():
+		register = hs.manager.OnRemovePath
+	case Replaceable.String():
+		register = hs.manager.OnReplacePath
+	default:
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("unsupported type: %s", typ))
+		return
+	}
+
+	if err := register(path, nil); err != nil {
+		hs.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hs.log().Info("modifiable registered over HTTP", "type", typ, "path", path)
+
+	data := orderedmap.New()
+	data.Set("type", typ)
+	data.Set("path", path)
+
+	hs.writeSuccessStatus(w, http.StatusCreated, data)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// STATS
+////////////////////////////////////////////////////////////////////////////////
+
+// onGetStats reports the current version, the size of the change history and,
+// when the source measures it, how its backing store has been performing
+func (hs *http_server) onGetStats(w http.ResponseWriter, r *http.Request) {
+	if !hs.checkAccess(r) {
+		hs.writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	data := orderedmap.New()
+	data.Set("version", hs.manager.Version())
+	data.Set("fingerprint", hs.manager.Fingerprint())
+	data.Set("history_size", hs.manager.History().Len())
+	if stats, ok := hs.manager.SourceStats(); ok {
+		data.Set("source", stats)
+	}
+
+	hs.writeSuccess(w, data)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// EXPORT / IMPORT
+////////////////////////////////////////////////////////////////////////////////
+
+// onExport writes {version, config, schema, checksum} as a downloadable
+// document. The body is never wrapped so it can be posted back to /config/import.
+func (hs *http_server) onExport(w http.ResponseWriter, r *http.Request) {
+	if !hs.checkAccess(r) {
+		hs.writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	config, version, err := hs.manager.export()
+	if err != nil {
+		hs.writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to export config: %s", err))
+		return
+	}
+
+	checksum, err := configChecksum(config)
+	if err != nil {
+		hs.writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to export config: %s", err))
+		return
+	}
+
+	schemaJSON := orderedmap.New()
+	if schemaStr := hs.manager.Source().getSchema(); schemaStr != nil {
+		if err := json.Unmarshal([]byte(*schemaStr), &schemaJSON); err != nil {
+			hs.writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to unmarshal schema: %s", err))
+			return
+		}
+	}
+
+	doc := orderedmap.New()
+	doc.Set("version", version)
+	doc.Set("config", config)
+	doc.Set("schema", schemaJSON)
+	doc.Set("checksum", checksum)
+
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		hs.writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to export config: %s", err))
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="config-v%d.json"`, version))
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+}
+
+// onImport applies a document produced by /config/export. The config must
+// match its checksum and the manager must still be at the exported version.
+func (hs *http_server) onImport(w http.ResponseWriter, r *http.Request) {
+	if !hs.checkAccess(r) {
+		hs.writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("could not read body: %s", err))
+		return
+	}
+
+	// The config sits one level inside the request object
+	if err := hs.checkValueDepth(body, 1); err != nil {
+		hs.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	bodyJSON, err := decodeBody(body)
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON: %s", err))
+		return
+	}
+
+	versionVal, ok := bodyJSON.Get("version")
+	expectedVersion, isNumber := requestInt(versionVal)
+	if !ok || !isNumber {
+		hs.writeError(w, http.StatusBadRequest, "version must be a number")
+		return
+	}
+
+	checksum, err := getString(bodyJSON, "checksum")
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	config, ok := bodyJSON.Get("config")
+	if !ok {
+		hs.writeError(w, http.StatusBadRequest, "'config' is missing")
+		return
+	}
+
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid config: %s", err))
+		return
+	}
+
+	if HashSHA256(string(configBytes)) != checksum {
+		hs.writeError(w, http.StatusBadRequest, "checksum mismatch")
+		return
+	}
+
+	// Versions start at 1, and 0 would mean no precondition to importData,
+	// which checks the version under its lock
+	if expectedVersion < 1 {
+		hs.writeConflict(w, expectedVersion, "import", "")
+		return
+	}
+
+	if err := hs.manager.importData(configBytes, expectedVersion); err != nil {
+		hs.writeMutationError(w, err, "import")
+		return
+	}
+
+	data, err := hs.buildConfigState()
+	if err != nil {
+		hs.writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to build config: %s", err))
+		return
+	}
+
+	hs.writeSuccess(w, data)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// BATCH
+////////////////////////////////////////////////////////////////////////////////
+
+// onBatch applies the operations in the body all or nothing with Batch, or
+// one by one with BatchIndependent when ?mode=independent
+func (hs *http_server) onBatch(w http.ResponseWriter, r *http.Request) {
+	if !hs.checkAccess(r) {
+		hs.writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = "atomic"
+	}
+	if mode != "atomic" && mode != "independent" {
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("batch mode '%s' is not supported, use mode=atomic or mode=independent", mode))
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("could not read body: %s", err))
+		return
+	}
+
+	// Values sit inside the operations array
+	if err := hs.checkValueDepth(body, 2); err != nil {
+		hs.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	bodyJSON, err := decodeBody(body)
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON: %s", err))
+		return
+	}
+
+	rawOps, ok := bodyJSON.Get("operations")
+	list, isList := rawOps.([]interface{})
+	if !ok || !isList {
+		hs.writeError(w, http.StatusBadRequest, "'operations' must be an array")
+		return
+	}
+
+	if mode == "atomic" {
+		hs.onAtomicBatch(w, list)
+		return
+	}
+
+	// Malformed operations fail on their own; the rest are applied in order
+	results := make([]OperationResult, len(list))
+	ops := make([]Operation, 0, len(list))
+	positions := make([]int, 0, len(list))
+	for i, raw := range list {
+		op, err := hs.parseOperation(raw)
+		if err != nil {
+			results[i] = OperationResult{Index: i, Error: err.Error()}
+			continue
+		}
+		ops = append(ops, op)
+		positions = append(positions, i)
+	}
+
+	applied, version := hs.manager.BatchIndependent(ops)
+	for i, result := range applied {
+		result.Index = positions[i]
+		results[positions[i]] = result
+	}
+
+	data := orderedmap.New()
+	data.Set("results", results)
+	data.Set("version", version)
+
+	hs.writeSuccess(w, data)
+}
+
+// onAtomicBatch applies the operations with Manager.Batch: all of them or,
+// if any is malformed or fails, none
+func (hs *http_server) onAtomicBatch(w http.ResponseWriter, list []interface{}) {
+	ops := make([]Operation, 0, len(list))
+	for i, raw := range list {
+		op, err := hs.parseOperation(raw)
+		if err != nil {
+			hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("operation %d: %s", i, err))
+			return
+		}
+		ops = append(ops, op)
+	}
+
+	if err := hs.manager.Batch(ops); err != nil {
+		hs.writeMutationError(w, err, BatchOperation)
+		return
+	}
+
+	data := orderedmap.New()
+	data.Set("version", hs.manager.Version())
+
+	hs.writeSuccess(w, data)
+}
+
+// parseOperation reads one batch operation, applying the same checks as
+// POST /config
+func (hs *http_server) parseOperation(raw interface{}) (Operation, error) {
+	var obj *orderedmap.OrderedMap
+	switch v := raw.(type) {
+	case *orderedmap.OrderedMap:
+		obj = v
+	case orderedmap.OrderedMap:
+		obj = &v
+	default:
+		return Operation{}, fmt.Errorf("operation must be an object")
+	}
+
+	if hs.strictRequests {
+		if err := checkKnownKeys(obj, "op", "path", "index", "value"); err != nil {
+			return Operation{}, err
+		}
+	}
+
+	var op Operation
+	var err error
+	if op.Op, err = getString(obj, "op"); err != nil {
+		return Operation{}, err
+	}
+	if op.Path, err = getString(obj, "path"); err != nil {
+		return Operation{}, err
+	}
+	if op.Path, err = normalizePath(op.Path); err != nil {
+		return Operation{}, err
+	}
+
+	value, hasValue := obj.Get("value")
+	switch op.Op {
+	case "insert", "replace":
+		if !hasValue {
+			return Operation{}, fmt.Errorf("value is required for %s", op.Op)
+		}
+		op.Value = value
+	}
+	switch op.Op {
+	case "insert", "remove":
+		if op.Index, err = getIndex(obj); err != nil {
+			return Operation{}, err
+		}
+	}
+
+	return op, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// PATCH
+////////////////////////////////////////////////////////////////////////////////
+
+// onPatch applies a JSON Patch (RFC 6902) document with Manager.Patch. A
+// failed "test" operation answers 409 and changes nothing.
+func (hs *http_server) onPatch(w http.ResponseWriter, r *http.Request) {
+	if !hs.checkAccess(r) {
+		hs.writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("could not read body: %s", err))
+		return
+	}
+
+	// Values sit inside the operations array
+	if err := hs.checkValueDepth(body, 2); err != nil {
+		hs.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	value, err := parseValue(body)
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON: %s", err))
+		return
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		hs.writeError(w, http.StatusBadRequest, "request body must be a JSON Patch array")
+		return
+	}
+
+	ops := make([]JSONPatchOperation, 0, len(list))
+	for i, raw := range list {
+		op, err := hs.parsePatchOperation(raw)
+		if err != nil {
+			hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("operation %d: %s", i, err))
+			return
+		}
+		ops = append(ops, op)
+	}
+
+	if err := hs.manager.Patch(ops); err != nil {
+		hs.writeMutationError(w, err, PatchOperation)
+		return
+	}
+
+	data := orderedmap.New()
+	data.Set("version", hs.manager.Version())
+
+	hs.writeSuccess(w, data)
+}
+
+// parsePatchOperation reads one JSON Patch operation. Unlike the other
+// endpoints, paths are always JSON Pointers and "" is the whole config.
+func (hs *http_server) parsePatchOperation(raw interface{}) (JSONPatchOperation, error) {
+	var obj *orderedmap.OrderedMap
+	switch v := raw.(type) {
+	case *orderedmap.OrderedMap:
+		obj = v
+	case orderedmap.OrderedMap:
+		obj = &v
+	default:
+		return JSONPatchOperation{}, fmt.Errorf("operation must be an object")
+	}
+
+	if hs.strictRequests {
+		if err := checkKnownKeys(obj, "op", "path", "from", "value"); err != nil {
+			return JSONPatchOperation{}, err
+		}
+	}
+
+	var op JSONPatchOperation
+	var err error
+	if op.Op, err = getString(obj, "op"); err != nil {
+		return JSONPatchOperation{}, err
+	}
+	if op.Path, err = getPointer(obj, "path"); err != nil {
+		return JSONPatchOperation{}, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		value, ok := obj.Get("value")
+		if !ok {
+			return JSONPatchOperation{}, fmt.Errorf("value is required for %s", op.Op)
+		}
+		op.Value = value
+	case "move", "copy":
+		if op.From, err = getPointer(obj, "from"); err != nil {
+			return JSONPatchOperation{}, err
+		}
+	case "remove":
+	default:
+		return JSONPatchOperation{}, fmt.Errorf("unsupported patch operation: %s", op.Op)
+	}
+
+	return op, nil
+}
+
+// onMergePatch applies the body as a JSON Merge Patch (RFC 7396) with
+// Manager.MergePatch. An If-Match header or version parameter makes it
+// conditional on the config version.
+func (hs *http_server) onMergePatch(w http.ResponseWriter, r *http.Request) {
+	if !hs.checkAccess(r) {
+		hs.writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("could not read body: %s", err))
+		return
+	}
+
+	if err := hs.checkValueDepth(body, 0); err != nil {
+		hs.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	patch, err := parseValue(body)
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON: %s", err))
+		return
+	}
+
+	expectedVersion, hasVersion, err := requestVersion(r)
+	if err != nil {
+		hs.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Versions start at 1, and 0 would mean no precondition to
+	// mergePatchData, which checks the version under its lock
+	if hasVersion && expectedVersion < 1 {
+		hs.writeConflict(w, expectedVersion, MergePatchOperation, "")
+		return
+	}
+
+	version, err := hs.manager.mergePatchData(patch, expectedVersion)
+	if err != nil {
+		hs.writeMutationError(w, err, MergePatchOperation)
+		return
+	}
+
+	data := orderedmap.New()
+	data.Set("version", version)
+
+	hs.writeSuccess(w, data)
+}
+
+// getPointer reads a JSON Pointer, which may be empty
+func getPointer(m *orderedmap.OrderedMap, key string) (string, error) {
+	v, ok := m.Get(key)
+	if !ok {
+		return "", fmt.Errorf("'%s' is missing", key)
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("'%s' must be a string", key)
+	}
+	if s != "" && !strings.HasPrefix(s, "/") {
+		return "", fmt.Errorf("'%s' must be a JSON Pointer", key)
+	}
+	return s, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// CHANGES
+////////////////////////////////////////////////////////////////////////////////
+
+// onGetChanges returns the change events after the version given by the since
+// parameter or, failing that, an If-None-Match ETag. The response carries the
+// current version both as X-Config-Version and as a weak ETag. 204 means
+// nothing changed; 410 means the history no longer covers the requested
+// version and the client has to fetch the full config.
+func (hs *http_server) onGetChanges(w http.ResponseWriter, r *http.Request) {
+	if !hs.checkAccess(r) {
+		hs.writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	raw := r.URL.Query().Get("since")
+	if raw == "" {
+		raw = strings.Trim(strings.TrimPrefix(strings.TrimSpace(r.Header.Get("If-None-Match")), "W/"), `"`)
+	}
+	if raw == "" {
+		hs.writeError(w, http.StatusBadRequest, "'since' is missing")
+		return
+	}
+	since, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || since < 0 {
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid version '%s'", raw))
+		return
+	}
+
+	events, current, complete := hs.manager.changesSince(since)
+
+	w.Header().Set("X-Config-Version", strconv.FormatInt(current, 10))
+	w.Header().Set("ETag", fmt.Sprintf(`W/"%d"`, current))
+
+	if !complete {
+		hs.writeError(w, http.StatusGone,
+			fmt.Sprintf("changes since version %d are no longer available, current version is %d", since, current))
+		return
+	}
+	if len(events) == 0 {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	data := orderedmap.New()
+	data.Set("changes", events)
+	data.Set("version", current)
+
+	hs.writeSuccess(w, data)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// HISTORY
+////////////////////////////////////////////////////////////////////////////////
+
+// onGetHistory returns a page of the recorded change events, oldest first,
+// filtered by the path (events at or below it), operation, since (an RFC 3339
+// timestamp) and sinceVersion parameters. limit and offset select the page;
+// total is the number of matching events.
+func (hs *http_server) onGetHistory(w http.ResponseWriter, r *http.Request) {
+	if !hs.checkAccess(r) {
+		hs.writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	query := r.URL.Query()
+	var filter history.Filter
+	var err error
+
+	if raw := query.Get("path"); raw != "" {
+		if filter.Path, err = normalizePath(raw); err != nil {
+			hs.writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	filter.Operation = query.Get("operation")
+	if raw := query.Get("since"); raw != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, raw); err != nil {
+			hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid since '%s', expected an RFC 3339 timestamp", raw))
+			return
+		}
+	}
+	if raw := query.Get("sinceVersion"); raw != "" {
+		if filter.SinceVersion, err = strconv.ParseInt(raw, 10, 64); err != nil || filter.SinceVersion < 0 {
+			hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid version '%s'", raw))
+			return
+		}
+	}
+
+	limit, err := queryInt(query, "limit", defaultHistoryPage)
+	if err != nil || limit < 1 || limit > maxHistoryPage {
+		hs.writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxHistoryPage))
+		return
+	}
+	offset, err := queryInt(query, "offset", 0)
+	if err != nil || offset < 0 {
+		hs.writeError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+		return
+	}
+
+	var events []history.ChangeEvent
+	if filter.Operation == "" && filter.Since.IsZero() && filter.SinceVersion == 0 && filter.Path != "" {
+		events = hs.manager.History().GetByPath(filter.Path)
+	} else {
+		events = hs.manager.History().Query(filter)
+	}
+
+	total := len(events)
+	page := events[min(offset, total):min(offset+limit, total)]
+
+	data := orderedmap.New()
+	data.Set("changes", page)
+	data.Set("total", total)
+	data.Set("limit", limit)
+	data.Set("offset", offset)
+	data.Set("version", hs.manager.Version())
+
+	hs.writeSuccess(w, data)
+}
+
+// queryInt reads an integer query parameter, def when it is absent
+func queryInt(query url.Values, key string, def int) (int, error) {
+	raw := query.Get(key)
+	if raw == "" {
+		return def, nil
+	}
+	return strconv.Atoi(raw)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// OPTIONS
+////////////////////////////////////////////////////////////////////////////////
+
+func (hs *http_server) onOptions(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, X-API-Key, If-Match, If-None-Match")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, OPTIONS")
+	w.WriteHeader(http.StatusOK)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// BUILD CONFIG STATE
+////////////////////////////////////////////////////////////////////////////////
+
+func (hs *http_server) buildConfigState() (*orderedmap.OrderedMap, error) {
+	if err := hs.manager.checkRead(); err != nil {
+		return nil, err
+	}
+
+	schemaJSON := orderedmap.New()
+
+	configStr := hs.manager.Source().getConfig()
+	if configStr == nil {
+		return nil, fmt.Errorf("config is nil")
+	}
+
+	confJSON, err := parseConfig([]byte(*configStr))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+
+	schemaStr := hs.manager.Source().getSchema()
+	if schemaStr != nil {
+		if err := json.Unmarshal([]byte(*schemaStr), &schemaJSON); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal schema: %w", err)
+		}
+	}
+
+	paths := orderedmap.New()
 	paths.Set("insertable", hs.manager.getInsertablePaths())
 	paths.Set("removable", hs.manager.getRemovablePaths())
 	paths.Set("replaceable", hs.manager.getReplaceablePaths())
@@ -328,39 +1759,179 @@ func (hs *http_server) buildConfigState() (*orderedmap.OrderedMap, error) {
 // HELPERS
 ////////////////////////////////////////////////////////////////////////////////
 
+func (hs *http_server) log() *slog.Logger {
+	if hs.logger != nil {
+		return hs.logger
+	}
+	return hs.manager.log()
+}
+
 func HashSHA256(s string) string {
 	sum := sha256.Sum256([]byte(s))
 	return hex.EncodeToString(sum[:])
 }
 
-func writeError(w http.ResponseWriter, code int, msg string) {
+func (hs *http_server) writeError(w http.ResponseWriter, code int, msg string) {
+	hs.writeErrorDetails(w, code, msg, nil)
+}
+
+// writeErrorDetails is writeError with extra fields, placed next to the
+// message in the error object (or at the top level in the flat format)
+func (hs *http_server) writeErrorDetails(w http.ResponseWriter, code int, msg string, details *orderedmap.OrderedMap) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 
 	errObj := orderedmap.New()
-	errObj.Set("message", msg)
+	resp := errObj
+	if hs.responseFormat == ResponseFlat {
+		errObj.Set("error", msg)
+	} else {
+		resp = orderedmap.New()
+		resp.Set("success", false)
+		resp.Set("error", errObj)
+
+		errObj.Set("message", msg)
+	}
 	errObj.Set("code", code)
 
-	resp := orderedmap.New()
-	resp.Set("success", false)
-	resp.Set("error", errObj)
+	if details != nil {
+		for _, key := range details.Keys() {
+			value, _ := details.Get(key)
+			errObj.Set(key, value)
+		}
+	}
 
 	out, _ := json.MarshalIndent(resp, "", "  ")
 	w.Write(out)
 }
 
-func writeSuccess(w http.ResponseWriter, data *orderedmap.OrderedMap) {
+func (hs *http_server) writeSuccess(w http.ResponseWriter, data *orderedmap.OrderedMap) {
+	hs.writeSuccessStatus(w, http.StatusOK, data)
+}
+
+func (hs *http_server) writeSuccessStatus(w http.ResponseWriter, status int, data *orderedmap.OrderedMap) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
+
+	// Streamed so large configs are never held in memory as a whole; once
+	// the header is out a failure can only cut the body short
+	var err error
+	if hs.responseFormat == ResponseFlat {
+		err = streamJSON(w, data, "", streamDepth-1)
+	} else {
+		resp := orderedmap.New()
+		resp.Set("success", true)
+		resp.Set("data", data)
+
+		err = streamJSON(w, resp, "", streamDepth)
+	}
+	if err != nil {
+		hs.log().Warn("failed to write response", "error", err)
+	}
+}
 
-	resp := orderedmap.New()
-	resp.Set("success", true)
-	resp.Set("data", data)
+// writeConflict reports that the config is no longer at expectedVersion, the
+// version a request for op at path was made against
+func (hs *http_server) writeConflict(w http.ResponseWriter, expectedVersion int64, op, path string) {
+	conflict := &ConflictError{Path: path, Operation: op, ExpectedVersion: expectedVersion, configVersion: true}
+
+	// The value and the version come from the same read
+	conflict.CurrentVersion = hs.manager.Version()
+	if path != "" {
+		if node, version, err := hs.manager.lookup(path); err == nil {
+			conflict.CurrentVersion = version
+			conflict.CurrentValue = node.toInterface()
+			conflict.HasCurrentValue = true
+		}
+	}
 
-	out, _ := json.MarshalIndent(resp, "", "  ")
-	w.Write(out)
+	hs.writeConflictError(w, conflict.Error(), conflict)
+}
+
+// writeConflictError reports a failed version precondition as a structured
+// 409: the path and operation, the version the client had and the current
+// one, and the current value at path, null included, so the client can retry
+// without another GET. current_value is left out when the path is gone. With
+// WithConflictIncludesState a conflict on the whole config carries the
+// current config as well.
+func (hs *http_server) writeConflictError(w http.ResponseWriter, msg string, conflict *ConflictError) {
+	details := orderedmap.New()
+	if conflict.Path != "" {
+		details.Set("path", conflict.Path)
+	}
+	if conflict.Operation != "" {
+		details.Set("operation", conflict.Operation)
+	}
+	details.Set("your_version", conflict.ExpectedVersion)
+
+	currentVersion := conflict.CurrentVersion
+	if conflict.Path == "" && hs.conflictIncludesState {
+		if config, version, err := hs.manager.export(); err == nil {
+			currentVersion = version
+			details.Set("config", config)
+		}
+	}
+
+	details.Set("current_version", currentVersion)
+	if conflict.HasCurrentValue {
+		details.Set("current_value", conflict.CurrentValue)
+	}
+
+	hs.writeErrorDetails(w, http.StatusConflict, msg, details)
+}
+
+// writeMutationError reports a failed modification: version conflicts as
+// writeConflictError does, anything else with mutationStatus
+func (hs *http_server) writeMutationError(w http.ResponseWriter, err error, op string) {
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		conflict.Operation = op
+		hs.writeConflictError(w, conflict.Error(), conflict)
+		return
+	}
+	hs.writeError(w, mutationStatus(err), err.Error())
+}
+
+// checkKnownKeys rejects the keys of m that are not in allowed, listing both
+func checkKnownKeys(m *orderedmap.OrderedMap, allowed ...string) error {
+	if unknown := unknownKeys(m, allowed...); len(unknown) > 0 {
+		return fmt.Errorf("unknown fields: %s (allowed: %s)", strings.Join(unknown, ", "), strings.Join(allowed, ", "))
+	}
+	return nil
+}
+
+// unknownKeys returns the keys of m, in order, that are not in allowed
+func unknownKeys(m *orderedmap.OrderedMap, allowed ...string) []string {
+	out := make([]string, 0)
+	for _, key := range m.Keys() {
+		known := false
+		for _, a := range allowed {
+			if key == a {
+				known = true
+				break
+			}
+		}
+		if !known {
+			out = append(out, key)
+		}
+	}
+	return out
+}
+
+// mutationStatus maps a failed modification onto a status code: 409 when a
+// concurrent writer got to the source first or a patch test failed, 429 when
+// rate limited, 400 otherwise
+func mutationStatus(err error) int {
+	if errors.Is(err, ErrSourceConflict) || errors.Is(err, ErrVersionConflict) || errors.Is(err, ErrPatchTestFailed) {
+		return http.StatusConflict
+	}
+	var rateErr *RateLimitError
+	if errors.As(err, &rateErr) {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusBadRequest
 }
 
 func getString(m *orderedmap.OrderedMap, key string) (string, error) {
@@ -378,19 +1949,105 @@ func getString(m *orderedmap.OrderedMap, key string) (string, error) {
 	return s, nil
 }
 
+// getMeta returns the optional "meta" object of string values annotating a
+// change
+func getMeta(m *orderedmap.OrderedMap) (map[string]string, error) {
+	raw, ok := m.Get("meta")
+	if !ok {
+		return nil, nil
+	}
+
+	var obj *orderedmap.OrderedMap
+	switch v := raw.(type) {
+	case *orderedmap.OrderedMap:
+		obj = v
+	case orderedmap.OrderedMap:
+		obj = &v
+	default:
+		return nil, errors.New("'meta' must be an object")
+	}
+
+	meta := make(map[string]string, len(obj.Keys()))
+	for _, key := range obj.Keys() {
+		value, _ := obj.Get(key)
+		str, isString := value.(string)
+		if !isString {
+			return nil, fmt.Errorf("meta '%s' must be a string", key)
+		}
+		meta[key] = str
+	}
+	return meta, nil
+}
+
+// requestVersion reads the expected config version from the If-Match header
+// (plain or quoted, optionally weak) or, failing that, the version query parameter
+func requestVersion(r *http.Request) (int64, bool, error) {
+	raw := r.Header.Get("If-Match")
+	if raw == "" {
+		raw = r.URL.Query().Get("version")
+	}
+	if raw == "" {
+		return 0, false, nil
+	}
+
+	raw = strings.Trim(strings.TrimPrefix(strings.TrimSpace(raw), "W/"), `"`)
+	version, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid version '%s'", raw)
+	}
+	return version, true, nil
+}
+
 func getIndex(m *orderedmap.OrderedMap) (int, error) {
 	val, ok := m.Get("index")
 	if !ok {
 		return 0, fmt.Errorf("'index' is missing")
 	}
-	f, ok := val.(float64)
+	index, ok := requestInt(val)
 	if !ok {
 		return 0, fmt.Errorf("'index' must be a number")
 	}
-	if f < 0 {
+	if index < 0 {
 		return 0, fmt.Errorf("'index' must be non-negative")
 	}
-	return int(f), nil
+	return int(index), nil
+}
+
+// decodeBody decodes a request body that must be a JSON object
+func decodeBody(body []byte) (*orderedmap.OrderedMap, error) {
+	value, err := parseValue(body)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := value.(*orderedmap.OrderedMap)
+	if !ok {
+		return nil, errors.New("request body must be an object")
+	}
+	return obj, nil
+}
+
+// requestInt reads a number from a decoded request body
+func requestInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case float64:
+		return int64(n), true
+	default:
+		return 0, false
+	}
+}
+
+// checkValueDepth rejects a body whose values nest deeper than allowed.
+// envelope is the number of levels the request format wraps values in.
+func (hs *http_server) checkValueDepth(body []byte, envelope int) error {
+	if hs.maxValueDepth <= 0 {
+		return nil
+	}
+	if checkJSONDepth(body, hs.maxValueDepth+envelope) != nil {
+		return fmt.Errorf("value is nested deeper than %d levels", hs.maxValueDepth)
+	}
+	return nil
 }
 
 func (hs *http_server) checkAccess(r *http.Request) bool {
@@ -406,4 +2063,28 @@ func (hs *http_server) checkAccess(r *http.Request) bool {
 	// Constant-time comparison to prevent timing attacks
 	providedHash := sha256.Sum256([]byte(providedKey))
 	return subtle.ConstantTimeCompare(hs.apiKeyHash[:], providedHash[:]) == 1
+}
+
+// checkAdmin reports whether the request carries the admin key
+func (hs *http_server) checkAdmin(r *http.Request) bool {
+	if hs.adminKeyHash == nil {
+		return false
+	}
+
+	providedKey := r.Header.Get("X-API-Key")
+	if providedKey == "" {
+		return false
+	}
+
+	providedHash := sha256.Sum256([]byte(providedKey))
+	return subtle.ConstantTimeCompare(hs.adminKeyHash[:], providedHash[:]) == 1
+}
+
+func (hs *http_server) setAdminKey(key string) {
+	if key == "" {
+		hs.adminKeyHash = nil
+		return
+	}
+	hash := sha256.Sum256([]byte(key))
+	hs.adminKeyHash = &hash
 }
\ No newline at end of file
@@ -0,0 +1,994 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// newTestManager returns a manager over an in-memory config with an empty
+// schema
+func newTestManager(t *testing.T, config string, opts ...ManagerOption) *Manager {
+	t.Helper()
+
+	source, err := NewStrSource(config, `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// serve sends a request to a server for m and returns the status and the
+// decoded response body
+func serve(t *testing.T, m *Manager, method, url, body string, opts ...ServerOption) (int, map[string]interface{}) {
+	t.Helper()
+
+	hs, err := NewHttpServer(m, nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	hs.newServer().Handler.ServeHTTP(w, httptest.NewRequest(method, url, bytes.NewBufferString(body)))
+
+	var decoded map[string]interface{}
+	if w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), &decoded); err != nil {
+			t.Fatalf("%s %s: invalid JSON response %q: %v", method, url, w.Body.String(), err)
+		}
+	}
+	return w.Code, decoded
+}
+
+// equalJSON reports whether a and b encode to the same JSON, so numbers
+// compare equal whatever their Go type
+func equalJSON(a, b interface{}) bool {
+	ea, errA := json.Marshal(a)
+	eb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ea, eb)
+}
+
+// jsonString encodes v for building request bodies and URLs
+func jsonString(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// replaceable registers the top-level key of m for replaces without a
+// handler
+func replaceable(t *testing.T, m *Manager, key string) {
+	t.Helper()
+
+	node, err := m.ConfigRef().At(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnReplace(node, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPutValueReplacesSubtree(t *testing.T) {
+	m := newTestManager(t, `{"db":{"host":"a","port":5432,"tags":["x"]}}`)
+	replaceable(t, m, "db")
+	before := m.Version()
+
+	code, body := serve(t, m, "PUT", "/config/value?path=/db", `{"host":"b","port":5432,"user":"admin"}`)
+	if code != 200 {
+		t.Fatalf("status = %d, want 200: %v", code, body)
+	}
+
+	data, _ := body["data"].(map[string]interface{})
+	var want interface{}
+	json.Unmarshal([]byte(`[{"path":"/db/host","op":"change","old":"a","new":"b"},`+
+		`{"path":"/db/tags","op":"remove","old":["x"],"new":null},`+
+		`{"path":"/db/user","op":"add","old":null,"new":"admin"}]`), &want)
+	if !reflect.DeepEqual(data["diff"], want) {
+		t.Errorf("diff = %v, want %v", data["diff"], want)
+	}
+	if m.Version() != before+1 || data["version"] != float64(m.Version()) {
+		t.Errorf("version = %v, manager version = %d, want %d", data["version"], m.Version(), before+1)
+	}
+
+	host, err := m.ConfigRef().At("db")
+	if err == nil {
+		host, err = host.At("host")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := host.GetString(); s != "b" {
+		t.Errorf("/db/host = %q, want %q", s, "b")
+	}
+}
+
+func TestPutValueUnchangedSubtreeKeepsVersion(t *testing.T) {
+	m := newTestManager(t, `{"db":{"host":"a"}}`)
+	replaceable(t, m, "db")
+	before := m.Version()
+
+	code, body := serve(t, m, "PUT", "/config/value?path=/db", `{"host":"a"}`)
+	if code != 200 {
+		t.Fatalf("status = %d, want 200: %v", code, body)
+	}
+	data, _ := body["data"].(map[string]interface{})
+	if diff, _ := data["diff"].([]interface{}); len(diff) != 0 {
+		t.Errorf("diff = %v, want empty", diff)
+	}
+	if m.Version() != before {
+		t.Errorf("version = %d, want %d", m.Version(), before)
+	}
+}
+
+func TestPutValueVersionMismatch(t *testing.T) {
+	m := newTestManager(t, `{"db":{"host":"a"}}`)
+	replaceable(t, m, "db")
+	before := m.Version()
+
+	req := httptest.NewRequest("PUT", "/config/value?path=/db", bytes.NewBufferString(`{"host":"b"}`))
+	req.Header.Set("If-Match", `"7"`)
+	w := httptest.NewRecorder()
+	hs, err := NewHttpServer(m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs.handleValue(w, req)
+
+	if w.Code != 409 {
+		t.Fatalf("status = %d, want 409: %s", w.Code, w.Body)
+	}
+	if m.Version() != before {
+		t.Errorf("version = %d, want %d", m.Version(), before)
+	}
+}
+
+func TestGetFieldsProjection(t *testing.T) {
+	m := newTestManager(t, `{"db":{"host":"a","port":5432},"users":[{"name":"ann","age":3},{"name":"bob"}]}`)
+
+	code, body := serve(t, m, "GET", "/config?fields=/db/port,/users/[*]/name", "")
+	if code != 200 {
+		t.Fatalf("status = %d, want 200: %v", code, body)
+	}
+
+	data, _ := body["data"].(map[string]interface{})
+	want := map[string]interface{}{
+		"/db/port":      float64(5432),
+		"/users/0/name": "ann",
+		"/users/1/name": "bob",
+	}
+	if !reflect.DeepEqual(data["fields"], want) {
+		t.Errorf("fields = %v, want %v", data["fields"], want)
+	}
+}
+
+func TestGetFieldsLimits(t *testing.T) {
+	m := newTestManager(t, `{"a":1}`)
+
+	fields := strings.Repeat("/a,", maxProjectedFields) + "/a"
+	if code, body := serve(t, m, "GET", "/config?fields="+fields, ""); code != 400 {
+		t.Errorf("%d fields: status = %d, want 400: %v", maxProjectedFields+1, code, body)
+	}
+	if code, body := serve(t, m, "GET", "/config?fields=a", ""); code != 400 {
+		t.Errorf("invalid field: status = %d, want 400: %v", code, body)
+	}
+}
+
+func TestResponseFormats(t *testing.T) {
+	m := newTestManager(t, `{"a":1}`)
+
+	tests := []struct {
+		format ResponseFormat
+		url    string
+		code   int
+		want   string
+	}{
+		{ResponseWrapped, "/config?fields=/a", 200, `{"success":true,"data":{"fields":{"/a":1},"version":1}}`},
+		{ResponseWrapped, "/config?fields=a", 400, `{"success":false,"error":{"code":400}}`},
+		{ResponseFlat, "/config?fields=/a", 200, `{"fields":{"/a":1},"version":1}`},
+		{ResponseFlat, "/config?fields=a", 400, `{"code":400}`},
+	}
+	for _, tt := range tests {
+		code, body := serve(t, m, "GET", tt.url, "", WithResponseFormat(tt.format))
+		if code != tt.code {
+			t.Errorf("%s %s: status = %d, want %d", tt.format, tt.url, code, tt.code)
+		}
+
+		// Error messages are free text; only check that one is there
+		if errObj, ok := body["error"].(map[string]interface{}); ok {
+			if _, ok := errObj["message"].(string); !ok {
+				t.Errorf("%s %s: error has no message: %v", tt.format, tt.url, body)
+			}
+			delete(errObj, "message")
+		} else if code >= 400 {
+			if _, ok := body["error"].(string); !ok {
+				t.Errorf("%s %s: error is not a string: %v", tt.format, tt.url, body)
+			}
+			delete(body, "error")
+		}
+
+		var want map[string]interface{}
+		json.Unmarshal([]byte(tt.want), &want)
+		if !reflect.DeepEqual(body, want) {
+			t.Errorf("%s %s: body = %v, want %v", tt.format, tt.url, body, want)
+		}
+	}
+
+	if _, err := NewHttpServer(m, nil, WithResponseFormat("xml")); err == nil {
+		t.Error("unknown response format accepted")
+	}
+}
+
+func TestConflictCarriesCurrentState(t *testing.T) {
+	m := newTestManager(t, `{"db":{"host":"a"},"port":1}`)
+	replaceable(t, m, "db")
+	version := float64(m.Version())
+
+	// A conflict on a path always carries the current value there
+	code, body := serve(t, m, "PUT", "/config/value?path=/db&version=99", `{"host":"b"}`)
+	errObj, _ := body["error"].(map[string]interface{})
+	if code != 409 || errObj["current_version"] != version || errObj["path"] != "/db" ||
+		!reflect.DeepEqual(errObj["current_value"], map[string]interface{}{"host": "a"}) {
+		t.Fatalf("status = %d, error = %v, want 409 with the current value of /db", code, errObj)
+	}
+	if _, ok := errObj["config"]; ok {
+		t.Errorf("error = %v, want no config for a conflict on a path", errObj)
+	}
+
+	// Without a path, as for an import, the config is only included with
+	// WithConflictIncludesState
+	config := `{"a":1}`
+	stale := fmt.Sprintf(`{"version":99,"checksum":%q,"config":%s}`, HashSHA256(config), config)
+	code, body = serve(t, m, "POST", "/config/import", stale)
+	errObj, _ = body["error"].(map[string]interface{})
+	if _, ok := errObj["config"]; code != 409 || ok {
+		t.Errorf("status = %d, error = %v, want 409 without the config", code, errObj)
+	}
+	if errObj["operation"] != "import" {
+		t.Errorf("error = %v, want operation import", errObj)
+	}
+
+	code, body = serve(t, m, "POST", "/config/import", stale, WithConflictIncludesState(true))
+	errObj, _ = body["error"].(map[string]interface{})
+	want := map[string]interface{}{"db": map[string]interface{}{"host": "a"}, "port": float64(1)}
+	if code != 409 || errObj["current_version"] != version || !reflect.DeepEqual(errObj["config"], want) {
+		t.Errorf("status = %d, error = %v, want 409 with the current config", code, errObj)
+	}
+}
+
+func TestConflictBodyShape(t *testing.T) {
+	m := newTestManager(t, `{"db":{"host":"a"},"list":[1,2]}`)
+	replaceable(t, m, "db")
+	list, err := m.ConfigRef().At("list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(list, nil); err != nil {
+		t.Fatal(err)
+	}
+	stale, _ := m.PathVersion("/db")
+	if err := m.Replace("/db", map[string]interface{}{"host": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	current, _ := m.PathVersion("/db")
+
+	// A stale path_version fails inside the manager and is reported from
+	// its *ConflictError
+	code, body := serve(t, m, "POST", "/config",
+		fmt.Sprintf(`{"op":"replace","path":"/db","value":{"host":"c"},"path_version":%d}`, stale))
+	if code != 409 || body["success"] != false {
+		t.Fatalf("status = %d, body = %v, want a failed 409", code, body)
+	}
+	errObj, _ := body["error"].(map[string]interface{})
+	want := map[string]interface{}{
+		"code":            float64(409),
+		"message":         (&ConflictError{Path: "/db", ExpectedVersion: stale, CurrentVersion: current}).Error(),
+		"path":            "/db",
+		"operation":       "replace",
+		"your_version":    float64(stale),
+		"current_version": float64(current),
+		"current_value":   map[string]interface{}{"host": "b"},
+	}
+	if !reflect.DeepEqual(errObj, want) {
+		t.Errorf("error = %v, want %v", errObj, want)
+	}
+
+	code, body = serve(t, m, "POST", "/config", `{"op":"insert","path":"/list","index":0,"value":0,"version":99}`)
+	errObj, _ = body["error"].(map[string]interface{})
+	if code != 409 || errObj["operation"] != "insert" || errObj["your_version"] != float64(99) ||
+		!reflect.DeepEqual(errObj["current_value"], []interface{}{float64(1), float64(2)}) {
+		t.Errorf("status = %d, error = %v, want 409 for insert with the current list", code, errObj)
+	}
+}
+
+func TestInsertAnswersCreated(t *testing.T) {
+	m := newTestManager(t, `{"users":["a","b","c"],"port":1}`)
+	users, err := m.ConfigRef().At("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(users, nil); err != nil {
+		t.Fatal(err)
+	}
+	replaceable(t, m, "port")
+
+	hs, err := NewHttpServer(m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := hs.newServer().Handler
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/config", strings.NewReader(`{"op":"insert","path":"/users","index":3,"value":"d"}`)))
+	if w.Code != 201 {
+		t.Fatalf("insert: status = %d: %s", w.Code, w.Body)
+	}
+	location := w.Header().Get("Location")
+	if location != "/config/value?path=/users/3" {
+		t.Errorf("Location = %q, want /config/value?path=/users/3", location)
+	}
+
+	// The Location resolves to the new element
+	code, body := serve(t, m, "GET", location, "")
+	if data, _ := body["data"].(map[string]interface{}); code != 200 || data["value"] != "d" {
+		t.Errorf("GET %s = %d %v, want d", location, code, body)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/config", strings.NewReader(`{"op":"replace","path":"/port","value":2}`)))
+	if w.Code != 200 || w.Header().Get("Location") != "" {
+		t.Errorf("replace: status = %d, Location = %q, want 200 without Location", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestRegisterModifiableOverHTTP(t *testing.T) {
+	m := newTestManager(t, `{"limits":{"rate":10},"tags":["a"]}`)
+
+	hs, err := NewHttpServer(m, nil, WithAdminAPIKey("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := hs.newServer().Handler
+	do := func(body, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/config/modifiables", strings.NewReader(body))
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	if code, _ := serve(t, m, "POST", "/config", `{"op":"replace","path":"/limits/rate","value":20}`); code == 200 {
+		t.Fatal("replace allowed before registration")
+	}
+
+	if w := do(`{"type":"replace","path":"/limits/rate"}`, ""); w.Code != 401 {
+		t.Errorf("without key: status = %d, want 401", w.Code)
+	}
+	if w := do(`{"type":"replace","path":"/limits/rate"}`, "wrong"); w.Code != 401 {
+		t.Errorf("wrong key: status = %d, want 401", w.Code)
+	}
+	if w := do(`{"type":"rename","path":"/limits/rate"}`, "secret"); w.Code != 400 {
+		t.Errorf("unknown type: status = %d, want 400", w.Code)
+	}
+	if w := do(`{"type":"insert","path":"/missing"}`, "secret"); w.Code != 400 {
+		t.Errorf("missing path: status = %d, want 400", w.Code)
+	}
+
+	if w := do(`{"type":"replace","path":"/limits/rate"}`, "secret"); w.Code != 201 {
+		t.Fatalf("replace: status = %d: %s", w.Code, w.Body)
+	}
+	if w := do(`{"type":"insert","path":"/tags"}`, "secret"); w.Code != 201 {
+		t.Fatalf("insert: status = %d: %s", w.Code, w.Body)
+	}
+
+	if code, body := serve(t, m, "POST", "/config", `{"op":"replace","path":"/limits/rate","value":20}`); code != 200 {
+		t.Errorf("replace after registration: status = %d: %v", code, body)
+	}
+	if code, body := serve(t, m, "POST", "/config", `{"op":"insert","path":"/tags","index":1,"value":"b"}`); code != 201 {
+		t.Errorf("insert after registration: status = %d: %v", code, body)
+	}
+	if rate, _ := m.ConfigRef().AtPath("/limits/rate"); rate == nil || !equalJSON(rate, 20) {
+		t.Errorf("limits.rate = %v, want 20", rate)
+	}
+
+	// Without an admin key the endpoint is disabled
+	if code, _ := serve(t, m, "POST", "/config/modifiables", `{"type":"replace","path":"/limits/rate"}`); code != 403 {
+		t.Errorf("no admin key configured: status = %d, want 403", code)
+	}
+}
+
+func TestStrictRequestsRejectUnknownFields(t *testing.T) {
+	m := newTestManager(t, `{"list":[]}`)
+	list, err := m.ConfigRef().At("list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(list, nil); err != nil {
+		t.Fatal(err)
+	}
+	body := `{"op":"insert","path":"/list","idx":0,"index":0,"value":"a"}`
+
+	code, resp := serve(t, m, "POST", "/config", body, WithStrictRequests(true))
+	errObj, _ := resp["error"].(map[string]interface{})
+	if msg, _ := errObj["message"].(string); code != 400 || !strings.Contains(msg, "idx") {
+		t.Errorf("strict: status = %d, error = %v, want 400 naming idx", code, errObj)
+	}
+	if got, _ := list.GetArray(); len(got) != 0 {
+		t.Errorf("strict: list has %d elements, want 0", len(got))
+	}
+
+	if code, resp := serve(t, m, "POST", "/config", body); code != 201 {
+		t.Errorf("lenient: status = %d, want 201: %v", code, resp)
+	}
+	if got, _ := list.GetArray(); len(got) != 1 {
+		t.Errorf("lenient: list has %d elements, want 1", len(got))
+	}
+}
+
+func TestMalformedPathsRejected(t *testing.T) {
+	m := newTestManager(t, `{"a":{"b":1}}`)
+
+	for _, path := range []string{"", "/a//b", "/a/%01b", "/a/../a"} {
+		if code, body := serve(t, m, "GET", "/config/value?path="+path, ""); code != 400 {
+			t.Errorf("GET %q: status = %d, want 400: %v", path, code, body)
+		}
+	}
+	body := `{"op":"replace","path":"/a//b","value":2}`
+	if code, resp := serve(t, m, "POST", "/config", body); code != 400 {
+		t.Errorf("POST /a//b: status = %d, want 400: %v", code, resp)
+	}
+}
+
+func TestGetChangesSince(t *testing.T) {
+	m := newTestManager(t, `{"a":0}`, WithHistorySize(2))
+	replaceable(t, m, "a")
+	start := m.Version()
+	for i := 1; i <= 3; i++ {
+		if err := m.Replace("/a", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	current := m.Version()
+
+	code, body := serve(t, m, "GET", fmt.Sprintf("/config/changes?since=%d", current-1), "")
+	data, _ := body["data"].(map[string]interface{})
+	changes, _ := data["changes"].([]interface{})
+	if code != 200 || len(changes) != 1 || !equalJSON(data["version"], current) {
+		t.Fatalf("since %d: status = %d, body = %v, want the last change", current-1, code, body)
+	}
+	if change, _ := changes[0].(map[string]interface{}); !equalJSON(change["new_value"], 3) {
+		t.Errorf("change = %v, want new_value 3", change)
+	}
+
+	if code, body := serve(t, m, "GET", fmt.Sprintf("/config/changes?since=%d", current), ""); code != 204 {
+		t.Errorf("since current: status = %d, want 204: %v", code, body)
+	}
+
+	// A history of two events no longer covers the first replace
+	if code, body := serve(t, m, "GET", fmt.Sprintf("/config/changes?since=%d", start), ""); code != 410 {
+		t.Errorf("since %d: status = %d, want 410: %v", start, code, body)
+	}
+
+	if code, body := serve(t, m, "GET", "/config/changes", ""); code != 400 {
+		t.Errorf("no since: status = %d, want 400: %v", code, body)
+	}
+}
+
+func TestDeepValuesRejected(t *testing.T) {
+	m := newTestManager(t, `{"a":1}`)
+	replaceable(t, m, "a")
+	before := m.Version()
+
+	nested := func(depth int) string {
+		return strings.Repeat("[", depth) + "1" + strings.Repeat("]", depth)
+	}
+
+	code, body := serve(t, m, "POST", "/config", `{"op":"replace","path":"/a","value":`+nested(4)+`}`,
+		WithMaxValueDepth(3))
+	errObj, _ := body["error"].(map[string]interface{})
+	if msg, _ := errObj["message"].(string); code != 400 || !strings.Contains(msg, "deeper than 3") {
+		t.Errorf("depth 4: status = %d, error = %v, want 400 naming the limit", code, errObj)
+	}
+	if m.Version() != before {
+		t.Errorf("version = %d, want %d", m.Version(), before)
+	}
+
+	// Brackets inside strings do not count
+	value := `["` + strings.Repeat("[", 10) + `"]`
+	if code, body := serve(t, m, "POST", "/config", `{"op":"replace","path":"/a","value":`+value+`}`,
+		WithMaxValueDepth(3)); code != 200 {
+		t.Errorf("bracketed string: status = %d, want 200: %v", code, body)
+	}
+
+	// The default limit stops values that would exhaust the stack
+	if code, body := serve(t, m, "POST", "/config", `{"op":"replace","path":"/a","value":`+nested(100000)+`}`); code != 400 {
+		t.Errorf("depth 100000: status = %d, want 400: %v", code, body)
+	}
+
+	if _, err := parseValue([]byte(nested(maxParseDepth + 1))); err == nil {
+		t.Errorf("parseValue accepted a value nested %d levels", maxParseDepth+1)
+	}
+}
+
+func TestGetConfigNegotiatesFormat(t *testing.T) {
+	const config = `{"name":"svc","db":{"host":"a","port":5432},"tags":["x","y"],"debug":true}`
+	var want interface{}
+	json.Unmarshal([]byte(config), &want)
+
+	m := newTestManager(t, config)
+	hs, err := NewHttpServer(m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		accept    string
+		mediaType string
+		decode    func([]byte, interface{}) error
+	}{
+		{"application/yaml", "application/yaml", yaml.Unmarshal},
+		{"text/html, text/toml;q=0.9", "text/toml", toml.Unmarshal},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/config", nil)
+		req.Header.Set("Accept", tt.accept)
+		w := httptest.NewRecorder()
+		hs.handleConfig(w, req)
+
+		if w.Code != 200 || w.Header().Get("Content-Type") != tt.mediaType {
+			t.Errorf("Accept %s: status = %d, content type = %q, want 200 %s", tt.accept, w.Code, w.Header().Get("Content-Type"), tt.mediaType)
+			continue
+		}
+		var got interface{}
+		if err := tt.decode(w.Body.Bytes(), &got); err != nil {
+			t.Errorf("Accept %s: %v in %s", tt.accept, err, w.Body)
+			continue
+		}
+		if !equalJSON(got, want) {
+			t.Errorf("Accept %s: decoded %v, want %v", tt.accept, got, want)
+		}
+	}
+
+	// JSON stays the default
+	code, body := serve(t, m, "GET", "/config", "")
+	if data, _ := body["data"].(map[string]interface{}); code != 200 || !equalJSON(data["config"], want) {
+		t.Errorf("default: status = %d, body = %v, want the JSON config", code, body)
+	}
+}
+
+func TestGetConfigTOMLRejectsNull(t *testing.T) {
+	m := newTestManager(t, `{"a":null}`)
+	hs, err := NewHttpServer(m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/config", nil)
+	req.Header.Set("Accept", "application/toml")
+	w := httptest.NewRecorder()
+	hs.handleConfig(w, req)
+	if w.Code != 406 {
+		t.Errorf("status = %d, want 406: %s", w.Code, w.Body)
+	}
+}
+
+func TestPostDiffComparesProposedConfig(t *testing.T) {
+	source, err := NewStrSource(`{"host":"a","port":1,"tags":["x"]}`,
+		`{"type":"object","properties":{"port":{"type":"number","maximum":100}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := m.Version()
+
+	code, body := serve(t, m, "POST", "/config/diff", `{"host":"b","port":2,"debug":true}`)
+	if code != 200 {
+		t.Fatalf("status = %d, want 200: %v", code, body)
+	}
+	data, _ := body["data"].(map[string]interface{})
+	var want interface{}
+	json.Unmarshal([]byte(`[{"path":"/host","op":"change","old":"a","new":"b"},`+
+		`{"path":"/port","op":"change","old":1,"new":2},`+
+		`{"path":"/tags","op":"remove","old":["x"],"new":null},`+
+		`{"path":"/debug","op":"add","old":null,"new":true}]`), &want)
+	if !reflect.DeepEqual(data["diff"], want) {
+		t.Errorf("diff = %v, want %v", data["diff"], want)
+	}
+	if data["valid"] != true {
+		t.Errorf("valid = %v, want true: %v", data["valid"], data)
+	}
+	if m.Version() != before {
+		t.Errorf("version = %d, want %d: the proposal must not be applied", m.Version(), before)
+	}
+
+	code, body = serve(t, m, "POST", "/config/diff", `{"host":"a","port":500,"tags":["x"]}`)
+	data, _ = body["data"].(map[string]interface{})
+	if code != 200 || data["valid"] != false || data["validation_error"] == nil {
+		t.Errorf("invalid proposal: status = %d, data = %v, want valid false with the error", code, data)
+	}
+
+	if code, body := serve(t, m, "POST", "/config/diff", `not json`); code != 400 {
+		t.Errorf("malformed proposal: status = %d, want 400: %v", code, body)
+	}
+}
+
+// serverConf returns a server config for address
+func serverConf(t *testing.T, address string) *Node {
+	t.Helper()
+
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parseNode(map[string]interface{}{"address": host, "port": port})
+}
+
+func TestRunShutsDownWhenCancelled(t *testing.T) {
+	m := newTestManager(t, `{"a":1}`)
+
+	// Find a free port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- m.RunHttpServer(ctx, serverConf(t, address))
+	}()
+
+	url := "http://" + address + "/health"
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server never came up: %v", err)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("RunHttpServer = %v, want nil after a clean shutdown", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunHttpServer did not return after cancellation")
+	}
+
+	if resp, err := http.Get(url); err == nil {
+		resp.Body.Close()
+		t.Error("server still answering after shutdown")
+	}
+}
+
+func TestRunReturnsBindError(t *testing.T) {
+	m := newTestManager(t, `{"a":1}`)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.RunHttpServer(ctx, serverConf(t, l.Addr().String())); err == nil || ctx.Err() != nil {
+		t.Errorf("RunHttpServer on a taken port = %v, want a bind error", err)
+	}
+}
+
+func TestHistoryOverHTTP(t *testing.T) {
+	m := newTestManager(t, `{"db":{"host":"a"},"list":[]}`)
+	replaceable(t, m, "db")
+	list, err := m.ConfigRef().At("list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(list, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := m.Replace("/db", map[string]interface{}{"host": fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Insert("/list", i, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page := func(url string) (versions []float64, data map[string]interface{}) {
+		t.Helper()
+		code, body := serve(t, m, "GET", url, "")
+		if code != 200 {
+			t.Fatalf("GET %s: status = %d, body = %v", url, code, body)
+		}
+		data, _ = body["data"].(map[string]interface{})
+		changes, _ := data["changes"].([]interface{})
+		for _, c := range changes {
+			ev, _ := c.(map[string]interface{})
+			v, _ := ev["version"].(float64)
+			versions = append(versions, v)
+		}
+		return versions, data
+	}
+
+	versions, data := page("/config/history")
+	if !reflect.DeepEqual(versions, []float64{2, 3, 4, 5, 6, 7}) || data["total"] != float64(6) {
+		t.Errorf("all: versions %v, data %v, want 2..7", versions, data)
+	}
+	if versions, _ := page("/config/history?path=/db"); !reflect.DeepEqual(versions, []float64{2, 4, 6}) {
+		t.Errorf("path=/db: versions %v, want [2 4 6]", versions)
+	}
+	if versions, _ := page("/config/history?operation=insert&sinceVersion=3"); !reflect.DeepEqual(versions, []float64{5, 7}) {
+		t.Errorf("inserts since 3: versions %v, want [5 7]", versions)
+	}
+	versions, data = page("/config/history?limit=2&offset=3")
+	if !reflect.DeepEqual(versions, []float64{5, 6}) || data["total"] != float64(6) || data["limit"] != float64(2) || data["offset"] != float64(3) {
+		t.Errorf("page: versions %v, data %v, want [5 6] of 6", versions, data)
+	}
+	if versions, _ := page("/config/history?offset=10"); len(versions) != 0 {
+		t.Errorf("past the end: versions %v, want none", versions)
+	}
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	if versions, _ := page("/config/history?since=" + future); len(versions) != 0 {
+		t.Errorf("since the future: versions %v, want none", versions)
+	}
+
+	for _, bad := range []string{"since=yesterday", "sinceVersion=-1", "limit=0", "limit=1001", "offset=-1", "path=db"} {
+		if code, _ := serve(t, m, "GET", "/config/history?"+bad, ""); code != 400 {
+			t.Errorf("%s: status = %d, want 400", bad, code)
+		}
+	}
+
+	conf := parseNode(map[string]interface{}{"address": "127.0.0.1", "port": 0, "api_key": "k"})
+	hs, err := NewHttpServer(m, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	hs.newServer().Handler.ServeHTTP(w, httptest.NewRequest("GET", "/config/history", nil))
+	if w.Code != 401 {
+		t.Errorf("without the API key: status = %d, want 401", w.Code)
+	}
+}
+
+func TestQueryOverHTTP(t *testing.T) {
+	m := newTestManager(t, `{"users":[{"name":"a","age":20},{"name":"b","age":10}],"admin":{"name":"c"}}`)
+
+	query := func(url string) map[string]interface{} {
+		t.Helper()
+		code, body := serve(t, m, "GET", url, "")
+		if code != 200 {
+			t.Fatalf("GET %s: status = %d, body = %v", url, code, body)
+		}
+		data, _ := body["data"].(map[string]interface{})
+		return data
+	}
+
+	data := query("/config/query?q=/users/*/name")
+	want := []interface{}{
+		map[string]interface{}{"path": "/users/0/name", "node": "a"},
+		map[string]interface{}{"path": "/users/1/name", "node": "b"},
+	}
+	if !reflect.DeepEqual(data["results"], want) || data["count"] != float64(2) || data["truncated"] != false {
+		t.Errorf("wildcard: data = %v, want %v", data, want)
+	}
+
+	data = query("/config/query?q=/users/[1]")
+	want = []interface{}{map[string]interface{}{"path": "/users/1", "node": map[string]interface{}{"name": "b", "age": float64(10)}}}
+	if !reflect.DeepEqual(data["results"], want) {
+		t.Errorf("index: results = %v, want %v", data["results"], want)
+	}
+
+	data = query("/config/query?q=//name&mode=count")
+	if _, ok := data["results"]; ok || data["count"] != float64(3) {
+		t.Errorf("count: data = %v, want only a count of 3", data)
+	}
+
+	for _, bad := range []string{"", "?q=users", "?q=/users/[x]", "?q=/users&mode=sum"} {
+		if code, body := serve(t, m, "GET", "/config/query"+bad, ""); code != 400 {
+			t.Errorf("%q: status = %d, body = %v, want 400", bad, code, body)
+		}
+	}
+
+	limited := newTestManager(t, `{"a":1,"b":2,"c":3}`, WithQueryLimits(QueryLimits{MaxResults: 2}))
+	code, body := serve(t, limited, "GET", "/config/query?q=/*", "")
+	data, _ = body["data"].(map[string]interface{})
+	if code != 200 || data["count"] != float64(2) || data["truncated"] != true {
+		t.Errorf("limited: status = %d, data = %v, want 2 results, truncated", code, data)
+	}
+}
+
+func TestConflictReportsNullValue(t *testing.T) {
+	m := newTestManager(t, `{"a":1,"b":2}`)
+	if err := m.OnReplacePath("/a", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Replace("/a", nil); err != nil {
+		t.Fatal(err)
+	}
+	pathVersion, err := m.PathVersion("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, body := serve(t, m, "POST", "/config", `{"op":"replace","path":"/a","value":3,"path_version":`+
+		jsonString(pathVersion+1)+`}`)
+	if code != 409 {
+		t.Fatalf("status = %d, want 409: %v", code, body)
+	}
+
+	details, _ := body["error"].(map[string]interface{})
+	want := map[string]interface{}{
+		"path":            "/a",
+		"operation":       "replace",
+		"your_version":    float64(pathVersion + 1),
+		"current_version": float64(pathVersion),
+	}
+	for key, value := range want {
+		if details[key] != value {
+			t.Errorf("%s = %v, want %v", key, details[key], value)
+		}
+	}
+
+	// A null value is reported as such, not left out
+	value, ok := details["current_value"]
+	if !ok || value != nil {
+		t.Errorf("current_value = %v (present %v), want null", value, ok)
+	}
+}
+
+func TestConflictErrorCarriesValue(t *testing.T) {
+	m := newTestManager(t, `{"a":{"x":1}}`)
+	if err := m.OnReplacePath("/a", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	err := m.CompareAndSwap("/a", 0, 2)
+	conflict, ok := err.(*ConflictError)
+	if !ok {
+		t.Fatalf("CompareAndSwap = %v, want a *ConflictError", err)
+	}
+	if !conflict.HasCurrentValue || !sameJSON(conflict.CurrentValue, map[string]interface{}{"x": int64(1)}) {
+		t.Errorf("CurrentValue = %v (present %v), want {\"x\":1}", conflict.CurrentValue, conflict.HasCurrentValue)
+	}
+}
+
+func TestPutValueVersionPrecondition(t *testing.T) {
+	m := newTestManager(t, `{"a":1}`)
+	if err := m.OnReplacePath("/a", nil); err != nil {
+		t.Fatal(err)
+	}
+	stale := m.Version()
+	if err := m.Replace("/a", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	code, body := serve(t, m, "PUT", "/config/value?path=/a&version="+jsonString(stale), `3`)
+	if code != 409 {
+		t.Fatalf("status = %d, want 409: %v", code, body)
+	}
+	details, _ := body["error"].(map[string]interface{})
+	if details["current_value"] != float64(2) || details["current_version"] != float64(stale+1) {
+		t.Errorf("conflict = %v, want the value 2 at version %d", details, stale+1)
+	}
+	if node, _ := m.LookupPath("/a"); !sameJSON(node.toInterface(), 2) {
+		t.Errorf("/a = %v after a conflict, want 2", node.toInterface())
+	}
+
+	code, body = serve(t, m, "PUT", "/config/value?path=/a&version="+jsonString(stale+1), `3`)
+	if code != 200 {
+		t.Fatalf("status = %d, want 200: %v", code, body)
+	}
+	data, _ := body["data"].(map[string]interface{})
+	if data["version"] != float64(stale+2) {
+		t.Errorf("version = %v, want %d", data["version"], stale+2)
+	}
+	if diff, _ := data["diff"].([]interface{}); len(diff) != 1 {
+		t.Errorf("diff = %v, want one change", data["diff"])
+	}
+}
+
+func TestMetaReachesHistory(t *testing.T) {
+	m := newTestManager(t, `{"a":1}`)
+	replaceable(t, m, "a")
+
+	code, body := serve(t, m, "POST", "/config",
+		`{"op":"replace","path":"/a","value":2,"meta":{"ticket":"OPS-123","deploy":"v2.3"}}`)
+	if code != 200 {
+		t.Fatalf("status = %d, body = %v, want 200", code, body)
+	}
+	events := m.History().GetAll()
+	want := map[string]string{"ticket": "OPS-123", "deploy": "v2.3"}
+	if len(events) != 1 || !reflect.DeepEqual(events[0].Meta, want) {
+		t.Errorf("history = %+v, want one event with meta %v", events, want)
+	}
+
+	for _, bad := range []string{
+		`{"op":"replace","path":"/a","value":3,"meta":"OPS-123"}`,
+		`{"op":"replace","path":"/a","value":3,"meta":{"ticket":123}}`,
+	} {
+		if code, body := serve(t, m, "POST", "/config", bad); code != 400 {
+			t.Errorf("%s: status = %d, body = %v, want 400", bad, code, body)
+		}
+	}
+	if n := len(m.History().GetAll()); n != 1 {
+		t.Errorf("%d history events after rejected requests, want 1", n)
+	}
+}
+
+func TestMetaCarriedIntoHistory(t *testing.T) {
+	m := newTestManager(t, `{"list":["a"]}`)
+	list, err := m.ConfigRef().At("list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(list, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnRemove(list, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, body := serve(t, m, "POST", "/config",
+		`{"op":"insert","path":"/list","index":1,"value":"b","meta":{"ticket":"OPS-1"}}`); code != 201 {
+		t.Fatalf("insert: status = %d, body = %v", code, body)
+	}
+	if code, body := serve(t, m, "POST", "/config",
+		`{"op":"remove","path":"/list","index":0,"meta":{"ticket":"OPS-2","by":"ann"}}`); code != 200 {
+		t.Fatalf("remove: status = %d, body = %v", code, body)
+	}
+	if code, body := serve(t, m, "POST", "/config", `{"op":"insert","path":"/list","index":0,"value":"c"}`); code != 201 {
+		t.Fatalf("insert without meta: status = %d, body = %v", code, body)
+	}
+
+	// The annotations come back with the history
+	code, body := serve(t, m, "GET", "/config/history", "")
+	data, _ := body["data"].(map[string]interface{})
+	changes, _ := data["changes"].([]interface{})
+	if code != 200 || len(changes) != 3 {
+		t.Fatalf("history: status = %d, data = %v, want 3 changes", code, data)
+	}
+	want := []interface{}{
+		map[string]interface{}{"ticket": "OPS-1"},
+		map[string]interface{}{"ticket": "OPS-2", "by": "ann"},
+		nil,
+	}
+	for i, c := range changes {
+		ev, _ := c.(map[string]interface{})
+		if !reflect.DeepEqual(ev["meta"], want[i]) {
+			t.Errorf("change %d (%v %v) meta = %v, want %v", i, ev["operation"], ev["path"], ev["meta"], want[i])
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// HTTPSource is a CodecSource fetching a JSON document from a URL and
+// POSTing changes back to it (or to the URL set with WithWriteURL)
+type HTTPSource struct {
+	CodecSource
+}
+
+// HTTPSourceOption customises an HTTPSource at construction time
+type HTTPSourceOption func(*HTTPStore)
+
+// WithSourceHeader adds a header to every request, e.g. for authentication
+func WithSourceHeader(key, value string) HTTPSourceOption {
+	return func(s *HTTPStore) {
+		s.Header.Add(key, value)
+	}
+}
+
+// WithSourceTimeout bounds each request. Defaults to 10 seconds.
+func WithSourceTimeout(d time.Duration) HTTPSourceOption {
+	return func(s *HTTPStore) {
+		if d > 0 {
+			s.Client.Timeout = d
+		}
+	}
+}
+
+// WithWriteURL POSTs changes to url instead of the URL the config is read
+// from
+func WithWriteURL(url string) HTTPSourceOption {
+	return func(s *HTTPStore) {
+		s.WriteURL = url
+	}
+}
+
+func NewHTTPSource(url, schema string, opts ...HTTPSourceOption) (*HTTPSource, error) {
+	if url == "" {
+		return nil, fmt.Errorf("url cannot be empty")
+	}
+
+	store := &HTTPStore{
+		URL:      url,
+		WriteURL: url,
+		Header:   make(http.Header),
+		Client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(store)
+	}
+
+	s := &HTTPSource{}
+	s.store = store
+	s.codec = JSONCodec{}
+	s.schema = schema
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload refetches the document and makes it current if it passes the
+// schema. It only updates the source: when the source backs a Manager, use
+// Manager.Reload instead, which also updates the manager's tree.
+func (s *HTTPSource) Reload() error {
+	config, err := s.fetch()
+	if err != nil {
+		return fmt.Errorf("failed to reload config: %w", err)
+	}
+
+	if err := validateJSONAgainstSchema(config, s.getSchema()); err != nil {
+		return err
+	}
+	return s.adopt(config)
+}
+
+// HTTPStore GETs the document from URL and POSTs it to WriteURL. A 409 or
+// 412 answer to a POST is reported as ErrSourceConflict.
+type HTTPStore struct {
+	URL      string
+	WriteURL string
+	Header   http.Header
+	Client   *http.Client
+}
+
+func (s *HTTPStore) Load() ([]byte, error) {
+	data, _, err := s.do(http.MethodGet, s.URL, nil)
+	return data, err
+}
+
+func (s *HTTPStore) Save(data []byte) error {
+	_, status, err := s.do(http.MethodPost, s.WriteURL, data)
+	if status == http.StatusConflict || status == http.StatusPreconditionFailed {
+		return fmt.Errorf("%s: %w", s.WriteURL, ErrSourceConflict)
+	}
+	return err
+}
+
+func (s *HTTPStore) do(method, url string, body []byte) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(context.Background(), method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, values := range s.Header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to %s %s: %w", method, url, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to read response from %s: %w", url, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, resp.StatusCode, fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	return data, resp.StatusCode, nil
+}
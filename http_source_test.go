@@ -0,0 +1,156 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// docServer serves a JSON document over GET and replaces it on POST
+type docServer struct {
+	mu       sync.Mutex
+	doc      string
+	token    string
+	conflict bool
+}
+
+func (d *docServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if d.token != "" && r.Header.Get("Authorization") != "Bearer "+d.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		io.WriteString(w, d.doc)
+	case http.MethodPost:
+		if d.conflict {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		d.doc = string(body)
+	}
+}
+
+func (d *docServer) set(doc string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.doc = doc
+}
+
+func (d *docServer) get() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.doc
+}
+
+// sameDoc reports whether two JSON documents hold the same value
+func sameDoc(a, b string) bool {
+	var va, vb interface{}
+	return json.Unmarshal([]byte(a), &va) == nil && json.Unmarshal([]byte(b), &vb) == nil && equalJSON(va, vb)
+}
+
+const httpSourceSchema = `{"type":"object","properties":{"port":{"type":"integer","maximum":100}}}`
+
+func TestHTTPSourceLoadsAndPersists(t *testing.T) {
+	docs := &docServer{doc: `{"port":1}`, token: "t"}
+	srv := httptest.NewServer(docs)
+	defer srv.Close()
+
+	if _, err := NewHTTPSource(srv.URL, httpSourceSchema); err == nil {
+		t.Error("source loaded without the auth header")
+	}
+
+	source, err := NewHTTPSource(srv.URL, httpSourceSchema, WithSourceHeader("Authorization", "Bearer t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaceable(t, m, "port")
+
+	if err := m.Replace("/port", 2); err != nil {
+		t.Fatal(err)
+	}
+	if got := docs.get(); !sameDoc(got, `{"port":2}`) {
+		t.Errorf("remote document = %s, want {\"port\":2}", got)
+	}
+
+	docs.mu.Lock()
+	docs.conflict = true
+	docs.mu.Unlock()
+	if err := m.Replace("/port", 3); !errors.Is(err, ErrSourceConflict) {
+		t.Errorf("replace on 412: %v, want ErrSourceConflict", err)
+	}
+}
+
+func TestHTTPSourceReload(t *testing.T) {
+	docs := &docServer{doc: `{"port":1}`, token: "t"}
+	srv := httptest.NewServer(docs)
+	defer srv.Close()
+
+	source, err := NewHTTPSource(srv.URL, httpSourceSchema, WithSourceHeader("Authorization", "Bearer t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docs.set(`{"port":5}`)
+	if err := source.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !equalJSON(source.getConfigObject(), map[string]interface{}{"port": 5}) {
+		t.Errorf("after reload = %v, want port 5", source.getConfigObject())
+	}
+
+	docs.set(`{"port":500}`)
+	if err := source.Reload(); err == nil {
+		t.Error("document failing the schema reloaded")
+	}
+	if !equalJSON(source.getConfigObject(), map[string]interface{}{"port": 5}) {
+		t.Errorf("after rejected reload = %v, want port 5", source.getConfigObject())
+	}
+}
+
+func TestHTTPSourceWriteURLAndTimeout(t *testing.T) {
+	reads := &docServer{doc: `{"port":1}`}
+	writes := &docServer{}
+	readSrv := httptest.NewServer(reads)
+	defer readSrv.Close()
+	writeSrv := httptest.NewServer(writes)
+	defer writeSrv.Close()
+
+	source, err := NewHTTPSource(readSrv.URL+"/", `{}`, WithWriteURL(writeSrv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaceable(t, m, "port")
+	if err := m.Replace("/port", 2); err != nil {
+		t.Fatal(err)
+	}
+	if !sameDoc(reads.get(), `{"port":1}`) || !sameDoc(writes.get(), `{"port":2}`) {
+		t.Errorf("read URL has %s, write URL has %s, want the change at the write URL only", reads.get(), writes.get())
+	}
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(w, `{}`)
+	}))
+	defer slow.Close()
+	if _, err := NewHTTPSource(slow.URL, `{}`, WithSourceTimeout(20*time.Millisecond)); err == nil {
+		t.Error("slow source loaded despite the timeout")
+	}
+}
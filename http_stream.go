@@ -0,0 +1,190 @@
+package config
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+// streamDepth is how many levels of a response are written piece by piece;
+// deeper values are marshalled whole. With the response envelope this streams
+// each top-level member of the config (or each history event) separately.
+const streamDepth = 4
+
+// streamJSON writes v to w as json.MarshalIndent(v, prefix, "  ") would,
+// without building the whole document in memory first: objects and arrays
+// in the first levels are written member by member.
+func streamJSON(w io.Writer, v interface{}, prefix string, levels int) error {
+	if levels > 0 {
+		switch x := v.(type) {
+		case *orderedmap.OrderedMap:
+			keys := x.Keys()
+			return streamContainer(w, "{", "}", len(keys), prefix, func(i int) error {
+				key, err := json.Marshal(keys[i])
+				if err != nil {
+					return err
+				}
+				if _, err := w.Write(append(key, ": "...)); err != nil {
+					return err
+				}
+				value, _ := x.Get(keys[i])
+				return streamJSON(w, value, prefix+"  ", levels-1)
+			})
+
+		case orderedmap.OrderedMap:
+			return streamJSON(w, &x, prefix, levels)
+		}
+
+		rv := reflect.ValueOf(v)
+		if rv.Kind() == reflect.Slice && !rv.IsNil() && rv.Type().Elem().Kind() != reflect.Uint8 {
+			return streamContainer(w, "[", "]", rv.Len(), prefix, func(i int) error {
+				return streamJSON(w, rv.Index(i).Interface(), prefix+"  ", levels-1)
+			})
+		}
+	}
+
+	out, err := json.MarshalIndent(v, prefix, "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+func streamContainer(w io.Writer, open, close string, n int, prefix string, member func(i int) error) error {
+	if n == 0 {
+		_, err := io.WriteString(w, open+close)
+		return err
+	}
+
+	if _, err := io.WriteString(w, open+"\n"); err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if _, err := io.WriteString(w, prefix+"  "); err != nil {
+			return err
+		}
+		if err := member(i); err != nil {
+			return err
+		}
+		sep := "\n"
+		if i < n-1 {
+			sep = ",\n"
+		}
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, prefix+close)
+	return err
+}
+
+// compressed gzips the responses of next for clients that accept it
+func compressed(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsGzip(r) {
+			next(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		next(gw, r)
+	}
+}
+
+// acceptsGzip reports whether Accept-Encoding allows gzip: listed with a
+// non-zero q, or not listed while "*" is. A q of 0 refuses it.
+func acceptsGzip(r *http.Request) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(enc, ";")
+		q, ok := qualityOf(params)
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
+
+// qualityOf returns the q parameter of an Accept-Encoding entry, 1 when it
+// has none; ok is false when q is malformed
+func qualityOf(params string) (float64, bool) {
+	for _, param := range strings.Split(params, ";") {
+		key, value, _ := strings.Cut(param, "=")
+		if !strings.EqualFold(strings.TrimSpace(key), "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0, false
+		}
+		return q, true
+	}
+	return 1, true
+}
+
+// gzipResponseWriter compresses the body on the fly. Compression starts with
+// the header, so responses without a body (204, 304) are left alone.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+
+	h := g.Header()
+	h.Add("Vary", "Accept-Encoding")
+	if code != http.StatusNoContent && code != http.StatusNotModified && h.Get("Content-Encoding") == "" {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(code)
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz != nil {
+		return g.gz.Write(b)
+	}
+	return g.ResponseWriter.Write(b)
+}
+
+// Flush sends what has been compressed so far
+func (g *gzipResponseWriter) Flush() {
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (g *gzipResponseWriter) close() {
+	if g.gz != nil {
+		g.gz.Close()
+	}
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStreamJSONMatchesMarshalIndent(t *testing.T) {
+	doc, err := parseConfig([]byte(`{"a":{"b":[1,{"c":null}],"e":{}},"f":[],"g":"x\"y","h":[[true]]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := json.MarshalIndent(doc, "", "  ")
+
+	for levels := 0; levels <= 5; levels++ {
+		var buf bytes.Buffer
+		if err := streamJSON(&buf, doc, "", levels); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != string(want) {
+			t.Errorf("%d levels:\n%s\nwant\n%s", levels, buf.String(), want)
+		}
+	}
+}
+
+func TestLargeConfigStreamedAndCompressed(t *testing.T) {
+	const n = 5000
+	var sb strings.Builder
+	sb.WriteString(`{"services":[`)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, `{"name":"svc-%d","port":%d,"tags":["a","b"]}`, i, i)
+	}
+	sb.WriteString(`]}`)
+	m := newTestManager(t, sb.String())
+
+	hs, err := NewHttpServer(m, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := compressed(hs.handleConfig)
+
+	for _, gz := range []bool{false, true} {
+		req := httptest.NewRequest("GET", "/config", nil)
+		if gz {
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		if w.Code != 200 {
+			t.Fatalf("gzip %v: status = %d", gz, w.Code)
+		}
+		var body io.Reader = w.Body
+		if gz {
+			if w.Header().Get("Content-Encoding") != "gzip" {
+				t.Fatalf("Content-Encoding = %q, want gzip", w.Header().Get("Content-Encoding"))
+			}
+			zr, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body = zr
+		} else if w.Header().Get("Content-Encoding") != "" {
+			t.Errorf("Content-Encoding = %q without Accept-Encoding", w.Header().Get("Content-Encoding"))
+		}
+
+		var resp struct {
+			Data struct {
+				Config struct {
+					Services []struct {
+						Name string   `json:"name"`
+						Port int      `json:"port"`
+						Tags []string `json:"tags"`
+					} `json:"services"`
+				} `json:"config"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(body).Decode(&resp); err != nil {
+			t.Fatalf("gzip %v: %v", gz, err)
+		}
+		services := resp.Data.Config.Services
+		if len(services) != n {
+			t.Fatalf("gzip %v: %d services, want %d", gz, len(services), n)
+		}
+		last := services[n-1]
+		if last.Name != fmt.Sprintf("svc-%d", n-1) || last.Port != n-1 || len(last.Tags) != 2 {
+			t.Errorf("gzip %v: last service = %+v", gz, last)
+		}
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	cases := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"deflate, gzip;q=0.5", true},
+		{"gzip;q=0.8", true},
+		{"gzip; q=1.0", true},
+		{"gzip;q=0", false},
+		{"gzip;q=0.0", false},
+		{"gzip;q=0.000", false},
+		{"*", true},
+		{"*;q=0", false},
+		{"gzip;q=0, *", false},
+		{"*;q=0, gzip", true},
+		{"br, deflate", false},
+		{"gzip;q=abc", false},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/config", nil)
+		if c.header != "" {
+			r.Header.Set("Accept-Encoding", c.header)
+		}
+		if got := acceptsGzip(r); got != c.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", c.header, got, c.want)
+		}
+	}
+}
@@ -1,13 +1,18 @@
 package config
 
-import "github.com/iancoleman/orderedmap"
+import "errors"
+
+// ErrSourceConflict is returned (wrapped) by sources whose backing store
+// detected that another writer changed the config since it was loaded
+var ErrSourceConflict = errors.New("config was modified concurrently in the source")
 
 // ISource defines the interface for configuration sources
 // Implementations should be thread-safe
 type ISource interface {
-	// getConfigObject returns the internal OrderedMap representation
+	// getConfigObject returns the parsed config: an *orderedmap.OrderedMap
+	// for object roots or a []interface{} for array roots
 	// Implementations should protect concurrent access
-	getConfigObject() *orderedmap.OrderedMap
+	getConfigObject() interface{}
 
 	// getConfig returns the JSON string representation
 	// The returned pointer should not be mutated
@@ -20,5 +25,5 @@ type ISource interface {
 	// setConfig updates the configuration atomically
 	// Must validate and persist the configuration
 	// Returns error if validation or persistence fails
-	setConfig(*orderedmap.OrderedMap) error
+	setConfig(interface{}) error
 }
\ No newline at end of file
@@ -0,0 +1,434 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/majiddarvishan/config_manager/history"
+)
+
+// Import replaces the whole config with data after validating it against the
+// schema and persisting it through the source. Registered modifiables are
+// re-bound to the nodes now found at their paths (the registered *Node values
+// stay valid); registrations whose path no longer exists are dropped.
+// Handlers are not called. Importing unchanged content does not bump the
+// version.
+func (m *Manager) Import(data []byte) error {
+	return m.importData(data, 0)
+}
+
+// ImportDiff brings the config to data by replacing, one by one, the
+// registered replaceable nodes whose subtree differs, so that validation,
+// critical and best-effort handlers, history and subscribers see each change
+// as they would for Replace. Every difference must lie at or below a
+// replaceable registration; it is applied by replacing the deepest such
+// node, deeper nodes first. As with Replace, registrations below a replaced
+// node are dropped afterwards.
+//
+// The import is all or nothing: data is validated as a whole first, and when
+// a replace fails the ones already applied are undone, again as replaces. It
+// returns the events of the applied replaces.
+func (m *Manager) ImportDiff(data []byte) ([]history.ChangeEvent, error) {
+	parsed, err := parseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	m.lockForWrite()
+	defer m.unlockForWrite()
+
+	if err := m.validateSchema(parsed, m.source.getSchema()); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
+	targets, err := m.diffTargetsLocked(parseNode(parsed))
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := cloneJSON(m.source.getConfigObject())
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone config: %w", err)
+	}
+
+	events := make([]history.ChangeEvent, 0, len(targets))
+	applied := make([]string, 0, len(targets))
+	for _, path := range targets {
+		ev, err := m.replaceFromLocked(path, parsed)
+		if err != nil {
+			err = fmt.Errorf("import failed at '%s': %w", m.externalPath(path), err)
+			return nil, m.undoReplacesLocked(applied, snapshot, err)
+		}
+		applied = append(applied, path)
+		events = append(events, ev)
+	}
+
+	return events, nil
+}
+
+// diffTargetsLocked returns the paths of the replaceable registrations to
+// replace to turn the config into incoming, deepest first
+func (m *Manager) diffTargetsLocked(incoming *Node) ([]string, error) {
+	replaceable := make([]string, 0, len(m.modifiables))
+	for _, mod := range m.modifiables {
+		if mod.Type == Replaceable {
+			replaceable = append(replaceable, mod.Path)
+		}
+	}
+
+	targets := make(map[string]bool)
+	for _, d := range diffPointers(m.config, incoming) {
+		target := ""
+		for _, path := range replaceable {
+			if isPathWithin(d.Path, path) && len(path) > len(target) {
+				target = path
+			}
+		}
+		if target == "" {
+			return nil, fmt.Errorf("'%s' differs but is not within a replaceable path", m.externalPath(d.Path))
+		}
+		targets[target] = true
+	}
+
+	out := make([]string, 0, len(targets))
+	for path := range targets {
+		out = append(out, path)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		di, dj := len(pointerSegments(out[i])), len(pointerSegments(out[j]))
+		if di != dj {
+			return di > dj
+		}
+		return out[i] < out[j]
+	})
+	return out, nil
+}
+
+// replaceFromLocked replaces the registered node at path with the value at
+// the same path in doc and returns the change's event
+func (m *Manager) replaceFromLocked(path string, doc interface{}) (history.ChangeEvent, error) {
+	mod, err := m.findModifiableLocked(Replaceable, path)
+	if err != nil {
+		return history.ChangeEvent{}, err
+	}
+
+	value, err := jsonValueAt(doc, path)
+	if err != nil {
+		return history.ChangeEvent{}, err
+	}
+
+	node := mod.Node
+	old := node.DeepCopy()
+	if _, err := m.replaceLocked(mod.Path, node, mod, value, mutationOptions{}); err != nil {
+		return history.ChangeEvent{}, err
+	}
+
+	return m.changeEventLocked(pathEvent{op: Replaceable, path: m.externalPath(path), old: old, new: node}), nil
+}
+
+// undoReplacesLocked reverts the replaces at paths, newest first, to their
+// values in snapshot after cause made an import fail. Paths already back at
+// their old value (restored along with an ancestor) are skipped. If a revert
+// fails too, snapshot is restored directly, without handlers.
+func (m *Manager) undoReplacesLocked(paths []string, snapshot interface{}, cause error) error {
+	before := parseNode(snapshot)
+	for i := len(paths) - 1; i >= 0; i-- {
+		current, err := findNodeByPath(m.config, paths[i])
+		if err == nil {
+			if old, err := findNodeByPath(before, paths[i]); err == nil && len(DiffNodes(current, old)) == 0 {
+				continue
+			}
+		}
+
+		if _, err := m.replaceFromLocked(paths[i], snapshot); err != nil {
+			m.log().Error("failed to undo import, restoring the previous config", "path", m.externalPath(paths[i]), "error", err)
+			if err := m.source.setConfig(snapshot); err != nil {
+				return fmt.Errorf("%w (restoring the previous config failed: %v)", cause, err)
+			}
+			m.rebindModifiablesLocked(before)
+			m.version++
+			m.touchPathLocked("/")
+			break
+		}
+	}
+	return cause
+}
+
+// importData is Import with an optional precondition: when expectedVersion
+// is non-zero the import only happens if the config is still at that version
+func (m *Manager) importData(data []byte, expectedVersion int64) error {
+	parsed, err := parseConfig(data)
+	if err != nil {
+		return err
+	}
+
+	m.lockForWrite()
+	defer m.unlockForWrite()
+
+	if expectedVersion != 0 && expectedVersion != m.version {
+		return m.conflictLocked("", expectedVersion, m.version)
+	}
+
+	if err := m.validateSchema(parsed, m.source.getSchema()); err != nil {
+		return fmt.Errorf("validation failed: %w", err)
+	}
+
+	if sameJSON(m.source.getConfigObject(), parsed) {
+		return nil
+	}
+
+	if _, err := m.runValidationServiceLocked(nil, parsed); err != nil {
+		return err
+	}
+
+	if err := m.source.setConfig(parsed); err != nil {
+		m.log().Error("failed to persist imported config", "error", err)
+		return fmt.Errorf("failed to persist config: %w", err)
+	}
+
+	m.rebindModifiablesLocked(parseNode(parsed))
+	m.version++
+	m.touchPathLocked("/")
+
+	return nil
+}
+
+// RestoreOperation is the Operation of the history events recorded by
+// Restore
+const RestoreOperation = "restore"
+
+// Snapshot returns the current config as JSON together with its version, for
+// Restore to bring it back later. Unlike RevertTo it does not depend on the
+// history.
+func (m *Manager) Snapshot() ([]byte, int64) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data, err := json.Marshal(m.source.getConfigObject())
+	if err != nil {
+		m.log().Error("failed to serialize config snapshot", "error", err)
+		return nil, m.version
+	}
+	return data, m.version
+}
+
+// Restore brings back a config taken with Snapshot. The data is validated
+// against the schema and persisted through the source; the version then goes
+// up by one (restoring never goes back to the snapshot's version) and a
+// "restore" event at "/" listing the changed paths is recorded. Restoring a
+// snapshot identical to the current config changes nothing.
+//
+// As with Import, registered nodes stay attached where their paths still
+// exist, and handlers and subscribers are not called.
+func (m *Manager) Restore(data []byte) error {
+	parsed, err := parseConfig(data)
+	if err != nil {
+		return err
+	}
+
+	m.lockForWrite()
+	defer m.unlockForWrite()
+
+	if err := m.validateSchema(parsed, m.source.getSchema()); err != nil {
+		return fmt.Errorf("validation failed: %w", err)
+	}
+
+	if sameJSON(m.source.getConfigObject(), parsed) {
+		return nil
+	}
+
+	if _, err := m.runValidationServiceLocked(nil, parsed); err != nil {
+		return err
+	}
+
+	if err := m.source.setConfig(parsed); err != nil {
+		m.log().Error("failed to persist restored config", "error", err)
+		return fmt.Errorf("failed to persist config: %w", err)
+	}
+
+	root := parseNode(parsed)
+	changed := diffPointers(m.config, root)
+
+	m.rebindModifiablesLocked(root)
+	m.version++
+	for _, d := range changed {
+		m.touchPathLocked(d.Path)
+	}
+	m.recordRootChangeLocked(RestoreOperation, changed)
+
+	return nil
+}
+
+// export returns a detached copy of the parsed config with its version
+func (m *Manager) export() (interface{}, int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	config, err := cloneJSON(m.source.getConfigObject())
+	if err != nil {
+		return nil, 0, err
+	}
+	return config, m.version, nil
+}
+
+// reloader is implemented by sources that can re-read their backing store
+type reloader interface {
+	fetch() (interface{}, error)
+	adopt(config interface{}) error
+}
+
+// ReloadOperation is the Operation of the history events recorded by Reload
+const ReloadOperation = "reload"
+
+// Reload re-reads the config from the source's backing store after it was
+// changed outside the manager, e.g. by another instance sharing the store.
+// Sources without a backing store to re-read (any ISource other than a
+// CodecSource) are taken to have updated their config object themselves,
+// e.g. with HTTPSource.Reload. The new config is validated before it
+// replaces the current one: when it cannot be read or does not conform, for
+// instance because the file was caught half-written, the current config is
+// kept, a warning logged and the error returned. Reloading unchanged content
+// does not bump the version; otherwise a "reload" event at "/" is recorded in
+// the history, listing the changed paths but not the values.
+//
+// As with Import, a registered *Node stays valid where its path still exists:
+// it is grafted into the new tree and holds the reloaded value there, and its
+// handler keeps firing. Registrations whose path is gone, or is no longer an
+// array for insert and remove, are dropped (see WithModifiableDropHandler and
+// ValidateModifiables); registering a node again re-enables it.
+func (m *Manager) Reload() error {
+	var config interface{}
+	r, ok := m.source.(reloader)
+	if ok {
+		fetched, err := r.fetch()
+		if err != nil {
+			m.log().Warn("failed to reload config, keeping the current one", "error", err)
+			return fmt.Errorf("failed to reload config: %w", err)
+		}
+		config = fetched
+	}
+
+	m.lockForWrite()
+	defer m.unlockForWrite()
+
+	if !ok {
+		config = m.source.getConfigObject()
+	}
+
+	// Compare with the tree rather than the source, which may already have
+	// picked the change up itself (see FileSource.Watch)
+	root := parseNode(config)
+	if sameJSON(m.config.toInterface(), root.toInterface()) {
+		return nil
+	}
+
+	if err := m.validateSchema(config, m.source.getSchema()); err != nil {
+		m.log().Warn("reloaded config is invalid, keeping the current one", "error", err)
+		return fmt.Errorf("validation failed: %w", err)
+	}
+
+	if ok {
+		if err := r.adopt(config); err != nil {
+			return err
+		}
+	}
+
+	changed := diffPointers(m.config, root)
+
+	m.rebindModifiablesLocked(root)
+	m.version++
+	m.touchPathLocked("/")
+	m.recordRootChangeLocked(ReloadOperation, changed)
+
+	return nil
+}
+
+// sameJSON reports whether a and b serialize to the same JSON, i.e. whether
+// storing one in place of the other changes nothing
+func sameJSON(a, b interface{}) bool {
+	x, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	y, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(x, y)
+}
+
+// SwapSource replaces the manager's source with newSource, e.g. to move the
+// config to another backend at runtime. The new config must pass the new
+// source's schema, and every registered path must still exist in it (as an
+// array for insert and remove registrations); otherwise the swap is refused
+// and nothing changes. Registered nodes and their handlers, subscribers,
+// transformers, validators and the history are kept, and the version is
+// bumped.
+func (m *Manager) SwapSource(newSource ISource) error {
+	if newSource == nil {
+		return errors.New("source cannot be nil")
+	}
+
+	root := parseNode(newSource.getConfigObject())
+	if root == nil {
+		return errors.New("failed to parse config root")
+	}
+
+	if err := m.validateSchema(newSource.getConfigObject(), newSource.getSchema()); err != nil {
+		return fmt.Errorf("new source validation failed: %w", err)
+	}
+
+	m.lockForWrite()
+	defer m.unlockForWrite()
+
+	lost := make([]string, 0)
+	for _, mod := range m.modifiables {
+		node, err := findNodeByPath(root, mod.Path)
+		if err != nil || (mod.Type != Replaceable && node.Type() != Array) {
+			lost = append(lost, fmt.Sprintf("%s (%s)", m.externalPath(mod.Path), mod.Type))
+		}
+	}
+	if len(lost) > 0 {
+		return fmt.Errorf("swap would drop registrations at %s", strings.Join(lost, ", "))
+	}
+
+	m.source = newSource
+	m.rebindModifiablesLocked(root)
+	m.version++
+	m.touchPathLocked("/")
+
+	return nil
+}
+
+// rebindModifiablesLocked swaps in newRoot as the config tree, keeping the
+// root *Node and every registered node attached at its path
+func (m *Manager) rebindModifiablesLocked(newRoot *Node) {
+	*m.config = *newRoot
+
+	validMods := make([]modifiable, 0, len(m.modifiables))
+	for _, mod := range m.modifiables {
+		if err := graftNode(m.config, mod.Path, mod.Node); err != nil {
+			m.dropModifiableLocked(mod)
+			continue
+		}
+		if mod.Type != Replaceable && mod.Node.Type() != Array {
+			m.dropModifiableLocked(mod)
+			continue
+		}
+		validMods = append(validMods, mod)
+	}
+	m.modifiables = validMods
+}
+
+// configChecksum is the hex sha256 of the compact JSON encoding of config
+func configChecksum(config interface{}) (string, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	return HashSHA256(string(data)), nil
+}
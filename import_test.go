@@ -0,0 +1,415 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImportReplacesConfig(t *testing.T) {
+	m := newTestManager(t, `{"a":1,"list":[1],"gone":{"x":1}}`)
+	list, err := m.ConfigRef().At("list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(list, nil); err != nil {
+		t.Fatal(err)
+	}
+	gone, err := m.ConfigRef().At("gone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnReplace(gone, nil); err != nil {
+		t.Fatal(err)
+	}
+	before := m.Version()
+
+	if err := m.Import([]byte(`{"a":2,"list":[1,2]}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Version() != before+1 {
+		t.Errorf("version = %d, want %d", m.Version(), before+1)
+	}
+	if got, _ := list.GetArray(); len(got) != 2 {
+		t.Errorf("registered array has %d elements, want 2", len(got))
+	}
+	if paths := m.getReplaceablePaths(); len(paths) != 0 {
+		t.Errorf("replaceable paths = %v, want the registration on the missing path dropped", paths)
+	}
+	if err := m.Import([]byte(`[1`)); err == nil {
+		t.Error("importing invalid JSON succeeded")
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	source := newTestManager(t, `{"db":{"host":"a","port":1},"tags":["x","y"]}`)
+	target := newTestManager(t, `{"db":{"host":"b"}}`)
+
+	exported := exportDocument(t, source)
+	if code, body := serve(t, target, "POST", "/config/import", exported); code != 200 {
+		t.Fatalf("import: status = %d, want 200: %v", code, body)
+	}
+
+	var want, got interface{}
+	json.Unmarshal([]byte(*source.Source().getConfig()), &want)
+	json.Unmarshal([]byte(*target.Source().getConfig()), &got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("imported config = %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(target.Config().toInterface(), source.Config().toInterface()) {
+		t.Errorf("imported tree = %v, want %v", target.Config().toInterface(), source.Config().toInterface())
+	}
+}
+
+func TestImportRejectsChecksumMismatch(t *testing.T) {
+	m := newTestManager(t, `{"a":1}`)
+
+	tampered := strings.Replace(exportDocument(t, m), `"a": 1`, `"a": 2`, 1)
+	code, body := serve(t, m, "POST", "/config/import", tampered)
+	if code != 400 {
+		t.Fatalf("status = %d, want 400: %v", code, body)
+	}
+	if a, _ := m.Config().GetInt("a"); a != 1 {
+		t.Errorf("/a = %d, want 1", a)
+	}
+}
+
+func TestImportRejectsStaleVersion(t *testing.T) {
+	m := newTestManager(t, `{"a":1}`)
+	exported := exportDocument(t, m)
+
+	if err := m.Import([]byte(`{"a":3}`)); err != nil {
+		t.Fatal(err)
+	}
+	if code, body := serve(t, m, "POST", "/config/import", exported); code != 409 {
+		t.Fatalf("status = %d, want 409: %v", code, body)
+	}
+}
+
+// exportDocument returns the body of GET /config/export for m
+func exportDocument(t *testing.T, m *Manager) string {
+	t.Helper()
+
+	code, body := serve(t, m, "GET", "/config/export", "")
+	if code != 200 {
+		t.Fatalf("export: status = %d, want 200: %v", code, body)
+	}
+	for _, key := range []string{"version", "config", "schema", "checksum"} {
+		if _, ok := body[key]; !ok {
+			t.Fatalf("export has no %q: %v", key, body)
+		}
+	}
+	out, err := json.MarshalIndent(body, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestImportReportsDroppedModifiables(t *testing.T) {
+	var dropped []string
+	m := newTestManager(t, `{"list":[],"name":"a"}`, WithModifiableDropHandler(func(path, op string) {
+		dropped = append(dropped, op+" "+path)
+	}))
+	list, err := m.ConfigRef().At("list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(list, nil); err != nil {
+		t.Fatal(err)
+	}
+	replaceable(t, m, "name")
+
+	if got := m.ValidateModifiables(); len(got) != 0 {
+		t.Fatalf("ValidateModifiables() = %v before the import, want none", got)
+	}
+
+	if err := m.Import([]byte(`{"name":"b"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := m.ValidateModifiables(); !reflect.DeepEqual(got, []string{"/list"}) {
+		t.Errorf("ValidateModifiables() = %v, want [/list]", got)
+	}
+	if !reflect.DeepEqual(dropped, []string{"insert /list"}) {
+		t.Errorf("drop callback saw %v, want [insert /list]", dropped)
+	}
+}
+
+func TestSwapSourceKeepsHandlersAndHistory(t *testing.T) {
+	m := newTestManager(t, `{"host":"a","port":1}`)
+	host, err := m.ConfigRef().At("host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var handled []string
+	if err := m.OnReplace(host, func(n *Node) {
+		s, _ := n.GetString()
+		handled = append(handled, s)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Replace("/host", "b"); err != nil {
+		t.Fatal(err)
+	}
+	before := m.Version()
+
+	store := NewMemoryStore([]byte(`{"host":"c","port":2}`))
+	next, err := NewCodecSource(store, JSONCodec{}, `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SwapSource(next); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Version() != before+1 {
+		t.Errorf("version = %d, want %d", m.Version(), before+1)
+	}
+	// The registered node now holds the new source's value
+	if s, _ := host.GetString(); s != "c" {
+		t.Errorf("host = %q, want c", s)
+	}
+	if events := m.History().GetAll(); len(events) != 1 || events[0].Path != "/host" {
+		t.Errorf("history = %+v, want the replace made before the swap", events)
+	}
+
+	if err := m.Replace("/host", "d"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b", "d"}; !reflect.DeepEqual(handled, want) {
+		t.Errorf("handler saw %v, want %v", handled, want)
+	}
+	data, _ := store.Load()
+	if !strings.Contains(string(data), `"host": "d"`) {
+		t.Errorf("new store holds %s, want host d", data)
+	}
+}
+
+func TestSwapSourceRefusesToDropRegistrations(t *testing.T) {
+	m := newTestManager(t, `{"host":"a","list":[]}`)
+	replaceable(t, m, "host")
+	list, err := m.ConfigRef().At("list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(list, nil); err != nil {
+		t.Fatal(err)
+	}
+	before := m.Version()
+
+	for _, config := range []string{`{"list":[]}`, `{"host":"b","list":{}}`} {
+		next, err := NewStrSource(config, `{}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.SwapSource(next); err == nil {
+			t.Errorf("swap to %s accepted", config)
+		}
+	}
+	if m.Version() != before {
+		t.Errorf("version = %d, want %d", m.Version(), before)
+	}
+	if err := m.Replace("/host", "b"); err != nil {
+		t.Errorf("replace after refused swaps: %v", err)
+	}
+}
+
+func TestImportDiffFiresHandlers(t *testing.T) {
+	m := newTestManager(t, `{"a":1,"b":{"x":1},"c":1}`)
+
+	fired := map[string]interface{}{}
+	for _, path := range []string{"/a", "/b", "/c"} {
+		path := path
+		if err := m.OnReplacePath(path, func(n *Node) { fired[path] = n.toInterface() }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := m.ImportDiff([]byte(`{"a":2,"b":{"x":3},"c":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fired) != 2 || !equalJSON(fired["/a"], 2) || !equalJSON(fired["/b"], map[string]interface{}{"x": 3}) {
+		t.Errorf("handlers fired with %v, want /a and /b with their new values", fired)
+	}
+	if len(events) != 2 {
+		t.Errorf("%d events returned, want 2", len(events))
+	}
+	if n := len(m.History().GetAll()); n != 2 {
+		t.Errorf("%d history events, want 2", n)
+	}
+}
+
+func TestReloadRecordsHistoryAndRebinds(t *testing.T) {
+	store := NewMemoryStore([]byte(`{"db":{"host":"a"},"tags":["x"],"port":1}`))
+	source, err := NewCodecSource(store, JSONCodec{}, `{"type":"object","properties":{"port":{"type":"integer"}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := m.ConfigRef().At("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seen []interface{}
+	if err := m.OnReplace(db, func(n *Node) { seen = append(seen, n.toInterface()) }); err != nil {
+		t.Fatal(err)
+	}
+	tags, err := m.ConfigRef().At("tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(tags, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reloading unchanged content is not a change
+	before := m.Version()
+	if err := m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Version() != before || len(m.History().GetAll()) != 0 {
+		t.Fatalf("unchanged reload: version %d -> %d, %d history events", before, m.Version(), len(m.History().GetAll()))
+	}
+
+	if err := store.Save([]byte(`{"db":{"host":"b"},"tags":{},"port":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Version() != before+1 {
+		t.Errorf("version = %d, want %d", m.Version(), before+1)
+	}
+
+	events := m.History().GetAll()
+	if len(events) != 1 {
+		t.Fatalf("%d history events, want 1", len(events))
+	}
+	ev := events[0]
+	if ev.Operation != ReloadOperation || ev.Path != "/" || !reflect.DeepEqual(ev.ChangedFields, []string{"/db/host", "/tags"}) {
+		t.Errorf("history event = %+v, want a reload changing /db/host and /tags", ev)
+	}
+
+	// The registered node holds the reloaded value and its handler still fires
+	if host, _ := db.GetString("host"); host != "b" {
+		t.Errorf("registered node host = %q, want b", host)
+	}
+	if err := m.Replace("/db", map[string]interface{}{"host": "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if !equalJSON(seen, []interface{}{map[string]interface{}{"host": "c"}}) {
+		t.Errorf("handler saw %v, want the replaced value", seen)
+	}
+
+	// /tags is no longer an array, so its registration is gone
+	if err := m.Insert("/tags", 0, "y"); err == nil {
+		t.Error("insert into a dropped registration accepted")
+	}
+
+	// A document failing the schema is rejected
+	if err := store.Save([]byte(`{"port":"x"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reload(); err == nil {
+		t.Error("document failing the schema reloaded")
+	}
+	if host, _ := db.GetString("host"); host != "c" {
+		t.Errorf("after rejected reload host = %q, want c", host)
+	}
+}
+
+func TestSnapshotAndRestore(t *testing.T) {
+	source, err := NewStrSource(`{"port":1,"tags":["a"],"db":{"host":"h"}}`, `{"type":"object","properties":{"port":{"type":"integer"}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source, WithHistorySize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaceable(t, m, "db")
+	tags, err := m.ConfigRef().At("tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(tags, nil); err != nil {
+		t.Fatal(err)
+	}
+	handled := 0
+	port, _ := m.ConfigRef().At("port")
+	if err := m.OnReplace(port, func(*Node) { handled++ }); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, version := m.Snapshot()
+	if version != m.Version() {
+		t.Errorf("snapshot version = %d, want %d", version, m.Version())
+	}
+	original := m.ConfigRef().toInterface()
+
+	// More changes than the history holds, so RevertTo could not undo them
+	for i := 2; i <= 6; i++ {
+		if err := m.Replace("/port", i); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Insert("/tags", 0, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Replace("/db", map[string]interface{}{"host": "x", "user": "u"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RevertTo(version); err == nil {
+		t.Fatal("RevertTo undid evicted history")
+	}
+	handled = 0
+	before := m.Version()
+
+	if err := m.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.ConfigRef().toInterface(); !equalJSON(got, original) {
+		t.Errorf("restored = %v, want %v", got, original)
+	}
+	var stored interface{}
+	if err := json.Unmarshal([]byte(*m.Source().getConfig()), &stored); err != nil || !equalJSON(stored, original) {
+		t.Errorf("stored config = %v, want the restored one", stored)
+	}
+	if m.Version() != before+1 || handled != 0 {
+		t.Errorf("version = %d, handler calls %d, want %d and 0", m.Version(), handled, before+1)
+	}
+	events := m.History().GetAll()
+	last := events[len(events)-1]
+	if last.Operation != RestoreOperation || !reflect.DeepEqual(last.ChangedFields, []string{"/db/host", "/db/user", "/port", "/tags/0", "/tags/1", "/tags/2", "/tags/3", "/tags/4", "/tags/5"}) {
+		t.Errorf("last event = %+v, want a restore listing the changed paths", last)
+	}
+
+	// Registrations follow the restored tree
+	if err := m.Replace("/port", 7); err != nil || handled != 1 {
+		t.Errorf("replace after restore: %v, handler calls %d", err, handled)
+	}
+
+	// Restoring the current config changes nothing; invalid data is refused
+	current, _ := m.Snapshot()
+	before = m.Version()
+	if err := m.Restore(current); err != nil || m.Version() != before {
+		t.Errorf("restoring the current config: %v, version %d -> %d", err, before, m.Version())
+	}
+	for _, data := range []string{`{"port":"x"}`, `{"port":`} {
+		if err := m.Restore([]byte(data)); err == nil {
+			t.Errorf("restore of %s accepted", data)
+		}
+	}
+	if m.Version() != before {
+		t.Errorf("version = %d after refused restores, want %d", m.Version(), before)
+	}
+}
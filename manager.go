@@ -1,14 +1,52 @@
 package config
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/majiddarvishan/config_manager/history"
 )
 
+const defaultHistorySize = 100
+
 type handler_t func(*Node)
 
+// errHandler_t is a modification handler that can report failure
+type errHandler_t func(*Node) error
+
+// ctxHandler_t is errHandler_t receiving a context that is cancelled when the
+// handler's timeout expires
+type ctxHandler_t func(context.Context, *Node) error
+
+// ErrHandlerTimeout is returned for a handler that did not finish within its
+// timeout
+var ErrHandlerTimeout = errors.New("handler timed out")
+
+// HandlerOptions controls how a handler registered with one of the
+// On*WithOptions methods takes part in a modification.
+//
+// A critical handler (BestEffort false) runs before the change is applied;
+// returning an error aborts the modification so nothing is persisted. A
+// best-effort handler runs after the change has been persisted; its error is
+// only logged.
+//
+// A handler still running after Timeout (or the manager's WithHandlerTimeout
+// default when zero) is abandoned: it keeps running but its result is
+// ignored, and it counts as having failed with ErrHandlerTimeout, so a
+// critical handler aborts the modification.
+type HandlerOptions struct {
+	BestEffort bool
+	Timeout    time.Duration
+}
+
 type modifiableType int
 
 const (
@@ -17,11 +55,35 @@ const (
 	Replaceable
 )
 
+func (t modifiableType) String() string {
+	switch t {
+	case Insertable:
+		return "insert"
+	case Removable:
+		return "remove"
+	case Replaceable:
+		return "replace"
+	default:
+		return "unknown"
+	}
+}
+
 type modifiable struct {
-	Type    modifiableType
-	Path    string
-	Node    *Node
-	Handler handler_t
+	Type       modifiableType
+	Path       string
+	Node       *Node
+	Handler    ctxHandler_t
+	BestEffort bool
+	Timeout    time.Duration
+}
+
+// modifiablePattern makes every node whose path matches a query pattern
+// modifiable, as if each was registered on its own
+type modifiablePattern struct {
+	Type     modifiableType
+	Pattern  string
+	segments []querySegment
+	Handler  ctxHandler_t
 }
 
 type Manager struct {
@@ -30,10 +92,50 @@ type Manager struct {
 	source      ISource
 	config      *Node
 	modifiables []modifiable
+	patterns    []modifiablePattern
 	version     int64 // Version counter for optimistic locking
+
+	handlerSlots chan struct{} // nil means handlers are not throttled
+	transformers []transformer
+	validators   []customValidator
+	queryLimits  QueryLimits
+
+	caseInsensitiveKeys bool
+	pointerPaths        bool         // paths are exchanged as RFC 6901 pointers
+	logger              *slog.Logger // nil means slog.Default()
+
+	handlerTimeout    time.Duration // 0 means handlers may run indefinitely
+	pathSubscribers   []pathSubscriber
+	pendingEvents     []pathDelivery // committed, not yet delivered to consumers
+	dispatching       bool           // a writer is delivering pendingEvents
+	changeSubscribers []*changeSubscriber
+	rateLimits        []changeRateLimit
+	lastChanges       map[string]time.Time // last change at each rate limited path
+	validationService ValidationService    // nil for none, see SetValidationService
+	validationTimeout time.Duration
+
+	droppedPaths        map[string]bool // registrations lost to a config change
+	onModifiableDropped func(path string, op string)
+
+	history       *history.ChangeHistory
+	historySize   int
+	historyWindow time.Duration
+	pathVersions  map[string]int64 // version of the last change at each path
+
+	validateOnRead bool
+	arrayIndexMode ArrayIndexMode
+	staging        bool             // scratch manager staging a batch, see Batch
+	staged         []stagedEffect   // handler calls and events deferred while staging
+	writeQueue     *writeQueue      // nil unless WithFIFOWrites
+	unvalidated    [][]querySegment // subtrees left out of schema validation
+	optionErr      error            // invalid option, reported by NewManager
+	readCheckMu    sync.Mutex
+	readCheck      int64  // version the cached read check ran at, 0 for none
+	readCheckDoc   string // source document the cached read check saw
+	readCheckErr   error
 }
 
-func NewManager(source ISource) (*Manager, error) {
+func NewManager(source ISource, opts ...ManagerOption) (*Manager, error) {
 	if source == nil {
 		return nil, errors.New("source cannot be nil")
 	}
@@ -48,293 +150,1481 @@ func NewManager(source ISource) (*Manager, error) {
 		config:      root,
 		modifiables: make([]modifiable, 0),
 		version:     1,
+		historySize: defaultHistorySize,
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	m.touchPathLocked("/")
+	m.history = history.NewChangeHistory(m.historySize)
+	m.history.SetCoalesceWindow(m.historyWindow)
+
+	if m.optionErr != nil {
+		return nil, m.optionErr
+	}
+
+	if err := m.validateSchema(source.getConfigObject(), source.getSchema()); err != nil {
+		return nil, fmt.Errorf("initial config validation failed: %w", err)
+	}
+
+	return m, nil
+}
+
+// Config returns a deep copy of the config to prevent data races: later
+// modifications never change the returned tree. Copying takes about 10ms and
+// 6.5MB for a 1MB config, so callers reading often should keep the snapshot.
+func (m *Manager) Config() *Node {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if err := m.checkReadLocked(); err != nil {
+		m.log().Error("config failed validation on read", "version", m.version, "error", err)
+	}
+	return m.config.DeepCopy()
+}
+
+// ConfigRef returns the live config tree. Nodes passed to the On* methods
+// must come from it, since registrations are tied to the node itself. The
+// tree is changed in place by modifications, so it must not be walked while
+// another goroutine may be modifying the config; use Config for a snapshot.
+func (m *Manager) ConfigRef() *Node {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.config
+}
+
+// ReadConfig is Config for managers created WithValidateOnRead: it also
+// returns the error when the current config no longer passes the schema
+func (m *Manager) ReadConfig() (*Node, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.config.DeepCopy(), m.checkReadLocked()
+}
+
+// checkRead is ReadConfig without the copy
+func (m *Manager) checkRead() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.checkReadLocked()
+}
+
+// checkReadLocked revalidates the config tree and the source's document when
+// the manager validates on read. The result is cached per version and
+// source document, so a document changed in place is checked again.
+func (m *Manager) checkReadLocked() error {
+	if !m.validateOnRead {
+		return nil
+	}
+
+	m.readCheckMu.Lock()
+	defer m.readCheckMu.Unlock()
+
+	doc := m.source.getConfig()
+	if m.readCheck == m.version && *doc == m.readCheckDoc {
+		return m.readCheckErr
+	}
+
+	err := m.validateSchema(m.config.toInterface(), m.source.getSchema())
+	if err != nil {
+		err = fmt.Errorf("config tree is invalid: %w", err)
+	} else if err = m.validateSchema(m.source.getConfigObject(), m.source.getSchema()); err != nil {
+		err = fmt.Errorf("source config is invalid: %w", err)
+	}
+
+	m.readCheck, m.readCheckDoc, m.readCheckErr = m.version, *doc, err
+	return err
+}
+
+func (m *Manager) Source() ISource {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.source
+}
+
+func (m *Manager) Version() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.version
+}
+
+// History returns the record of changes made through the manager
+func (m *Manager) History() *history.ChangeHistory {
+	return m.history
+}
+
+// lookup resolves path in the live tree and returns a detached copy
+// together with the version it was read at
+func (m *Manager) lookup(path string) (*Node, int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.checkReadLocked(); err != nil {
+		return nil, 0, err
+	}
+
+	path, err := m.resolvePathLocked(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	node, err := findNodeByPath(m.config, path)
+	if err != nil {
+		return nil, 0, err
+	}
+	return node.DeepCopy(), m.version, nil
+}
+
+// LookupPath returns a detached copy of the node at path, or false when the
+// path does not resolve
+func (m *Manager) LookupPath(path string) (*Node, bool) {
+	node, _, err := m.lookup(path)
+	return node, err == nil
+}
+
+func (m *Manager) log() *slog.Logger {
+	if m.logger != nil {
+		return m.logger
+	}
+	return slog.Default()
+}
+
+// resolvePathLocked turns a path passed to the manager into the internal
+// JSON Pointer form and, when the manager matches keys case-insensitively,
+// maps its keys onto the stored keys
+func (m *Manager) resolvePathLocked(path string) (string, error) {
+	path, err := m.internalPath(path)
+	if err != nil || !m.caseInsensitiveKeys {
+		return path, err
+	}
+	return foldPathKeys(m.config, path)
+}
+
+// internalPath converts a path as exchanged with callers into a JSON Pointer.
+// Plain paths cannot contain '/' in a key, so only '~' needs escaping.
+func (m *Manager) internalPath(path string) (string, error) {
+	if m.pointerPaths {
+		return path, validatePointerEscapes(path)
+	}
+	return strings.ReplaceAll(path, "~", "~0"), nil
+}
+
+// externalPath converts an internal JSON Pointer into the form exchanged
+// with callers
+func (m *Manager) externalPath(path string) string {
+	if m.pointerPaths {
+		return path
+	}
+	return unescapePointerSegment(path)
+}
+
+// Query runs a query expression against the current config. The returned
+// nodes are detached copies and are safe to use after further mutations.
+// When a limit set with WithQueryLimits is hit, the partial results are
+// returned with ErrQueryTruncated.
+func (m *Manager) Query(expr string) ([]QueryResult, error) {
+	segments, err := parseQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.checkReadLocked(); err != nil {
+		return nil, err
+	}
+
+	budget := &queryBudget{limits: m.queryLimits}
+	results := executeQuery(m.config, segments, budget)
+	for i := range results {
+		results[i].Node = results[i].Node.DeepCopy()
+	}
+	if budget.truncated {
+		return results, ErrQueryTruncated
+	}
+	return results, nil
+}
+
+// QueryCount is Query returning only the number of matches, without copying
+// them
+func (m *Manager) QueryCount(expr string) (int, error) {
+	segments, err := parseQuery(expr)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.checkReadLocked(); err != nil {
+		return 0, err
+	}
+
+	budget := &queryBudget{limits: m.queryLimits}
+	count := len(executeQuery(m.config, segments, budget))
+	if budget.truncated {
+		return count, ErrQueryTruncated
+	}
+	return count, nil
+}
+
+// FindAll returns detached copies of every config node matching the
+// predicate, in the same stable order as Node.FindAll. match runs under the
+// manager's read lock and must not call back into the manager. Results are
+// cut off silently at the limits set with WithQueryLimits.
+func (m *Manager) FindAll(match func(path string, node *Node) bool) []QueryResult {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	results, _ := m.config.FindAllWithLimits(match, m.queryLimits)
+	for i := range results {
+		results[i].Node = results[i].Node.DeepCopy()
+	}
+	return results
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// INSERT (improved with proper rollback)
+////////////////////////////////////////////////////////////////////////////////
+
+// Insert adds value at index to the insertable array at path
+func (m *Manager) Insert(path string, index int, value interface{}) error {
+	return m.insert(path, index, value, mutationOptions{})
+}
+
+// InsertWithMeta is Insert, recording meta (e.g. a ticket or deploy id) on
+// the change's history event
+func (m *Manager) InsertWithMeta(path string, index int, value interface{}, meta map[string]string) error {
+	return m.insert(path, index, value, mutationOptions{meta: meta})
+}
+
+// InsertOrCreate is Insert that also handles an absent array: when the last
+// key of path is missing from its object, the array is created holding just
+// value (index must then be 0). Adding the key changes the parent object, so
+// the parent must be registered as replaceable; the change is validated
+// against the schema, runs the parent's replace handler and is recorded as a
+// replace of the parent. A key that is present but null is not created.
+func (m *Manager) InsertOrCreate(path string, index int, value interface{}) error {
+	m.lockForWrite()
+
+	internal, err := m.resolvePathLocked(path)
+	if err != nil {
+		m.unlockForWrite()
+		return err
+	}
+	if node, err := findNodeByPath(m.config, internal); err == nil {
+		m.unlockForWrite()
+		if node.Type() == Null {
+			segments := pointerSegments(internal)
+			return fmt.Errorf("path element '%s' is null", segments[len(segments)-1])
+		}
+		return m.insert(path, index, value, mutationOptions{})
+	}
+	defer m.unlockForWrite()
+
+	return m.createArrayLocked(internal, index, value)
+}
+
+func (m *Manager) createArrayLocked(path string, index int, value interface{}) error {
+	segments := pointerSegments(path)
+	if len(segments) == 0 {
+		return errors.New("the root cannot be created")
+	}
+	parentPath := joinPointer(segments[:len(segments)-1])
+	key := segments[len(segments)-1]
+
+	parent, err := findNodeByPath(m.config, parentPath)
+	if err != nil {
+		return err
+	}
+	obj, err := parent.GetObject()
+	if err != nil {
+		return fmt.Errorf("cannot create '%s': parent is not an object", m.externalPath(path))
+	}
+	if child, present := obj[key]; present {
+		if child.Type() == Null {
+			return fmt.Errorf("path element '%s' is null", key)
+		}
+		return fmt.Errorf("path element '%s' is %v, not array", key, child.Type())
+	}
+	if index != 0 {
+		return fmt.Errorf("index %d out of bounds [0,0]", index)
+	}
+
+	mod, err := m.findModifiableLocked(Replaceable, parentPath)
+	if err != nil {
+		return fmt.Errorf("cannot create array at '%s': %w", m.externalPath(path), err)
+	}
+
+	value = plainValue(value)
+	if err := m.validateArrayItemLocked(path, index, value); err != nil {
+		return err
+	}
+
+	// The parent with the new key, built from the source to keep key order
+	jsonConfig, err := cloneJSON(m.source.getConfigObject())
+	if err != nil {
+		return fmt.Errorf("failed to clone config: %w", err)
+	}
+	if jsonConfig, err = jsonSetByPath(jsonConfig, path, []interface{}{value}); err != nil {
+		return err
+	}
+	parentValue := jsonConfig
+	if parentPath != "/" {
+		container, last, err := jsonLocate(jsonConfig, parentPath)
+		if err != nil {
+			return err
+		}
+		if parentValue, err = jsonChild(container, last); err != nil {
+			return err
+		}
+	}
+
+	_, err = m.replaceLocked(mod.Path, mod.Node, mod, parentValue, mutationOptions{})
+	return err
+}
+
+func (m *Manager) insert(path string, index int, value interface{}, opts mutationOptions) error {
+	m.lockForWrite()
+	defer m.unlockForWrite()
+
+	path, err := m.resolvePathLocked(path)
+	if err != nil {
+		return err
+	}
+
+	mod, err := m.findModifiableLocked(Insertable, path)
+	if err != nil {
+		return err
+	}
+
+	if err := m.checkPreconditionsLocked(mod.Path, opts); err != nil {
+		return err
+	}
+
+	value = plainValue(value)
+
+	// Validate index bounds first
+	array, err := mod.Node.GetArray()
+	if err != nil {
+		return err
+	}
+	if index < 0 || index > len(array) {
+		return fmt.Errorf("index %d out of bounds [0,%d]", index, len(array))
+	}
+
+	// Check the new element on its own first for a targeted error
+	if !m.staging {
+		if err := m.validateArrayItemLocked(path, index, value); err != nil {
+			return err
+		}
+	}
+
+	// Clone and validate
+	build := func(value interface{}) (interface{}, error) {
+		jsonConfig, err := cloneJSON(m.source.getConfigObject())
+		if err != nil {
+			return nil, fmt.Errorf("failed to clone config: %w", err)
+		}
+
+		jsonConfig, err = jsonInsertByPath(jsonConfig, path, index, value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert: %w", err)
+		}
+
+		if err := m.validateDocumentLocked(jsonConfig); err != nil {
+			return nil, fmt.Errorf("validation failed: %w", err)
+		}
+		return jsonConfig, nil
+	}
+
+	jsonConfig, err := build(value)
+	if err != nil {
+		return err
+	}
+
+	// Normalise the new element and validate the result again
+	value, changed, err := m.applyTransformersLocked(path+"/"+strconv.Itoa(index), value)
+	if err != nil {
+		return err
+	}
+	if changed {
+		if jsonConfig, err = build(value); err != nil {
+			return err
+		}
+	}
+
+	newNode := parseNode(value)
+
+	err = m.runValidatorsLocked(pathEvent{
+		op:        Insertable,
+		path:      m.externalPath(elementPath(path, index)),
+		container: m.externalPath(path),
+		new:       newNode,
+	})
+	if err != nil {
+		return err
+	}
+
+	if mod, err = m.runValidationServiceLocked(mod, jsonConfig); err != nil {
+		return err
+	}
+
+	// Critical handlers may veto the change before anything is applied
+	if !opts.suppressHandlers {
+		if mod, err = m.runCriticalHandlerLocked(mod, newNode); err != nil {
+			return err
+		}
+	}
+
+	arrayPath := mod.Path
+
+	// Create backup for rollback
+	oldArray := make([]*Node, len(array))
+	copy(oldArray, array)
+
+	// Mutate in-memory node
+	newArr := make([]*Node, 0, len(array)+1)
+	newArr = append(newArr, array[:index]...)
+	newArr = append(newArr, newNode)
+	newArr = append(newArr, array[index:]...)
+	*mod.Node = Node{newArr}
+
+	// Persist changes
+	if err := m.source.setConfig(jsonConfig); err != nil {
+		// Rollback on failure
+		*mod.Node = Node{oldArray}
+		m.log().Error("failed to persist config", "op", Insertable.String(), "path", arrayPath, "error", err)
+		return fmt.Errorf("failed to persist config: %w", err)
+	}
+
+	m.version++
+	m.touchPathLocked(arrayPath)
+	m.updateModifiablesLocked()
+
+	ev := pathEvent{op: Insertable, path: m.externalPath(elementPath(arrayPath, index)), container: m.externalPath(arrayPath), new: newNode, meta: opts.meta}
+	m.recordLocked(ev)
+
+	// Call handler AFTER successful persistence, outside of critical section
+	if !opts.suppressHandlers {
+		m.callHandlerLocked(mod, newNode)
+	}
+	m.publishLocked(ev)
+
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// REMOVE (improved with proper rollback)
+////////////////////////////////////////////////////////////////////////////////
+
+// Remove deletes the element at index from the removable array at path
+func (m *Manager) Remove(path string, index int) error {
+	return m.remove(path, index, mutationOptions{})
+}
+
+// RemoveWithMeta is Remove, recording meta on the change's history event
+func (m *Manager) RemoveWithMeta(path string, index int, meta map[string]string) error {
+	return m.remove(path, index, mutationOptions{meta: meta})
+}
+
+func (m *Manager) remove(path string, index int, opts mutationOptions) error {
+	m.lockForWrite()
+	defer m.unlockForWrite()
+
+	path, err := m.resolvePathLocked(path)
+	if err != nil {
+		return err
+	}
+
+	mod, err := m.findModifiableLocked(Removable, path)
+	if err != nil {
+		return err
+	}
+
+	if err := m.checkPreconditionsLocked(mod.Path, opts); err != nil {
+		return err
+	}
+
+	array, err := mod.Node.GetArray()
+	if err != nil {
+		return err
+	}
+
+	if index < 0 || index >= len(array) {
+		return fmt.Errorf("index %d out of bounds [0,%d)", index, len(array))
+	}
+
+	jsonConfig, err := cloneJSON(m.source.getConfigObject())
+	if err != nil {
+		return fmt.Errorf("failed to clone config: %w", err)
+	}
+
+	jsonConfig, err = jsonRemoveByPath(jsonConfig, path, index)
+	if err != nil {
+		return fmt.Errorf("failed to remove: %w", err)
+	}
+
+	if err := m.validateDocumentLocked(jsonConfig); err != nil {
+		return fmt.Errorf("validation failed: %w", err)
+	}
+
+	removedNode := array[index]
+
+	// Removal validators see the element and the array left behind
+	remaining := make([]*Node, 0, len(array)-1)
+	remaining = append(remaining, array[:index]...)
+	remaining = append(remaining, array[index+1:]...)
+
+	err = m.runValidatorsLocked(pathEvent{
+		op:        Removable,
+		path:      m.externalPath(elementPath(path, index)),
+		container: m.externalPath(path),
+		old:       removedNode,
+		new:       &Node{remaining},
+	})
+	if err != nil {
+		return err
+	}
+
+	if mod, err = m.runValidationServiceLocked(mod, jsonConfig); err != nil {
+		return err
+	}
+	if !opts.suppressHandlers {
+		if mod, err = m.runCriticalHandlerLocked(mod, removedNode); err != nil {
+			return err
+		}
+	}
+
+	arrayPath := mod.Path
+
+	// Backup for rollback
+	oldArray := make([]*Node, len(array))
+	copy(oldArray, array)
+
+	// Mutate
+	*mod.Node = Node{remaining}
+
+	// Persist
+	if err := m.source.setConfig(jsonConfig); err != nil {
+		*mod.Node = Node{oldArray}
+		m.log().Error("failed to persist config", "op", Removable.String(), "path", arrayPath, "error", err)
+		return fmt.Errorf("failed to persist config: %w", err)
+	}
+
+	m.version++
+	m.touchPathLocked(arrayPath)
+	m.updateModifiablesLocked()
+
+	ev := pathEvent{op: Removable, path: m.externalPath(elementPath(arrayPath, index)), container: m.externalPath(arrayPath), old: removedNode, meta: opts.meta}
+	m.recordLocked(ev)
+
+	if !opts.suppressHandlers {
+		m.callHandlerLocked(mod, removedNode)
+	}
+	m.publishLocked(ev)
+
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// MOVE
+////////////////////////////////////////////////////////////////////////////////
+
+// MoveOperation is the Operation of the history events recorded by Move
+const MoveOperation = "move"
+
+// Move takes the element at fromIndex out of the removable array at fromPath
+// and inserts it at toIndex into the insertable array at toPath, which may be
+// the same array (toIndex then counts without the element). The result is
+// validated and persisted in one step and the version goes up by one.
+// Validators and critical handlers run as for Remove followed by Insert, and
+// after the change both best-effort handlers run and subscribers see a
+// remove and an insert. One "move" history event records the element's new
+// path, with the old one in From. Registrations inside the element move with
+// it.
+func (m *Manager) Move(fromPath string, fromIndex int, toPath string, toIndex int) error {
+	m.lockForWrite()
+	defer m.unlockForWrite()
+
+	from, err := m.resolvePathLocked(fromPath)
+	if err != nil {
+		return err
+	}
+	to, err := m.resolvePathLocked(toPath)
+	if err != nil {
+		return err
+	}
+	if from != to && isPathWithin(to, from) {
+		return fmt.Errorf("cannot move from '%s' into an array inside it", fromPath)
+	}
+
+	fromMod, err := m.findModifiableLocked(Removable, from)
+	if err != nil {
+		return err
+	}
+	toMod, err := m.findModifiableLocked(Insertable, to)
+	if err != nil {
+		return err
+	}
+
+	source, err := fromMod.Node.GetArray()
+	if err != nil {
+		return err
+	}
+	if fromIndex < 0 || fromIndex >= len(source) {
+		return fmt.Errorf("index %d out of bounds [0,%d)", fromIndex, len(source))
+	}
+	target, err := toMod.Node.GetArray()
+	if err != nil {
+		return err
+	}
+	size := len(target)
+	if from == to {
+		size--
+	}
+	if toIndex < 0 || toIndex > size {
+		return fmt.Errorf("index %d out of bounds [0,%d]", toIndex, size)
+	}
+
+	if from == to && fromIndex == toIndex {
+		return nil
+	}
+
+	jsonConfig, err := cloneJSON(m.source.getConfigObject())
+	if err != nil {
+		return fmt.Errorf("failed to clone config: %w", err)
+	}
+
+	// Take the stored value, which keeps its key order
+	value, err := jsonValueAt(jsonConfig, elementPath(from, fromIndex))
+	if err != nil {
+		return err
+	}
+	if err := m.validateArrayItemLocked(to, toIndex, value); err != nil {
+		return err
+	}
+
+	if jsonConfig, err = jsonRemoveByPath(jsonConfig, from, fromIndex); err != nil {
+		return fmt.Errorf("failed to remove: %w", err)
+	}
+	if jsonConfig, err = jsonInsertByPath(jsonConfig, to, toIndex, value); err != nil {
+		return fmt.Errorf("failed to insert: %w", err)
+	}
+	if err := m.validateDocumentLocked(jsonConfig); err != nil {
+		return fmt.Errorf("validation failed: %w", err)
+	}
+
+	moved := source[fromIndex]
+	remaining := make([]*Node, 0, len(source)-1)
+	remaining = append(remaining, source[:fromIndex]...)
+	remaining = append(remaining, source[fromIndex+1:]...)
+
+	removeEv := pathEvent{op: Removable, path: m.externalPath(elementPath(from, fromIndex)), container: m.externalPath(from), old: moved, new: &Node{remaining}}
+	insertEv := pathEvent{op: Insertable, path: m.externalPath(elementPath(to, toIndex)), container: m.externalPath(to), new: moved}
+	if err := m.runValidatorsLocked(removeEv); err != nil {
+		return err
+	}
+	if err := m.runValidatorsLocked(insertEv); err != nil {
+		return err
+	}
+
+	if fromMod, err = m.runValidationServiceLocked(fromMod, jsonConfig); err != nil {
+		return err
+	}
+
+	// Critical handlers may veto the move; each re-checks that nothing changed
+	if _, err = m.runCriticalHandlerLocked(fromMod, moved); err != nil {
+		return err
+	}
+	if toMod, err = m.findModifiableLocked(Insertable, to); err != nil {
+		return err
+	}
+	if toMod, err = m.runCriticalHandlerLocked(toMod, moved); err != nil {
+		return err
+	}
+	if fromMod, err = m.findModifiableLocked(Removable, from); err != nil {
+		return err
+	}
+
+	// Backup for rollback
+	oldSource := make([]*Node, len(source))
+	copy(oldSource, source)
+	oldTarget := make([]*Node, len(target))
+	copy(oldTarget, target)
+
+	// Mutate
+	if from == to {
+		target = remaining
+	}
+	inserted := make([]*Node, 0, len(target)+1)
+	inserted = append(inserted, target[:toIndex]...)
+	inserted = append(inserted, moved)
+	inserted = append(inserted, target[toIndex:]...)
+	*fromMod.Node = Node{remaining}
+	*toMod.Node = Node{inserted}
+
+	// Persist
+	if err := m.source.setConfig(jsonConfig); err != nil {
+		*fromMod.Node = Node{oldSource}
+		*toMod.Node = Node{oldTarget}
+		m.log().Error("failed to persist config", "op", MoveOperation, "from", from, "path", to, "error", err)
+		return fmt.Errorf("failed to persist config: %w", err)
+	}
+
+	m.version++
+	m.touchPathLocked(from)
+	m.touchPathLocked(to)
+	m.updateModifiablesLocked()
+
+	ev := m.changeEventLocked(insertEv)
+	ev.Operation = MoveOperation
+	ev.From = removeEv.path
+	m.addHistoryLocked(ev)
+
+	m.callHandlerLocked(fromMod, moved)
+	m.callHandlerLocked(toMod, moved)
+	m.publishLocked(pathEvent{op: Removable, path: removeEv.path, container: removeEv.container, old: moved})
+	m.publishLocked(insertEv)
+
+	return nil
+}
+
+// SwapOperation and ReorderOperation are the Operations of the history events
+// recorded by Swap and Reorder
+const (
+	SwapOperation    = "swap"
+	ReorderOperation = "reorder"
+)
+
+// Swap exchanges the elements at i and j of the array at path. It behaves
+// as Reorder, recording a "swap" history event.
+func (m *Manager) Swap(path string, i, j int) error {
+	m.lockForWrite()
+	defer m.unlockForWrite()
+
+	return m.reorderLocked(SwapOperation, path, func(n int) ([]int, error) {
+		if i < 0 || i >= n || j < 0 || j >= n {
+			return nil, fmt.Errorf("indices %d and %d must be in [0,%d)", i, j, n)
+		}
+		order := identityOrder(n)
+		order[i], order[j] = j, i
+		return order, nil
+	})
+}
+
+// Reorder rearranges the array at path so that position k holds the element
+// previously at newOrder[k]; newOrder must be a permutation of [0,len). The
+// array must be registered for insert, remove or replace. The elements keep
+// their identity, so registrations inside them follow. The result is
+// validated and persisted in one step, the version goes up by one, a
+// "reorder" history event is recorded, and the array's replace handler,
+// validators and subscribers, if any, see it as a replace.
+func (m *Manager) Reorder(path string, newOrder []int) error {
+	m.lockForWrite()
+	defer m.unlockForWrite()
+
+	return m.reorderLocked(ReorderOperation, path, func(n int) ([]int, error) {
+		if len(newOrder) != n {
+			return nil, fmt.Errorf("order has %d entries, the array has %d elements", len(newOrder), n)
+		}
+		seen := make([]bool, n)
+		for _, index := range newOrder {
+			if index < 0 || index >= n || seen[index] {
+				return nil, fmt.Errorf("order %v is not a permutation of [0,%d)", newOrder, n)
+			}
+			seen[index] = true
+		}
+		return newOrder, nil
+	})
+}
+
+func identityOrder(n int) []int {
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	return order
+}
+
+// reorderLocked permutes the array at path by the order permute returns for
+// its length and records the change as op
+func (m *Manager) reorderLocked(op string, path string, permute func(n int) ([]int, error)) error {
+	path, err := m.resolvePathLocked(path)
+	if err != nil {
+		return err
+	}
+
+	var mod *modifiable
+	for _, t := range []modifiableType{Replaceable, Insertable, Removable} {
+		if mod, err = m.findModifiableLocked(t, path); err == nil {
+			break
+		}
+	}
+	if mod == nil {
+		return fmt.Errorf("path '%s' is not registered for insert, remove or replace", m.externalPath(path))
+	}
+
+	array, err := mod.Node.GetArray()
+	if err != nil {
+		return err
+	}
+	order, err := permute(len(array))
+	if err != nil {
+		return err
+	}
+
+	identity := true
+	for k, index := range order {
+		identity = identity && k == index
+	}
+	if identity {
+		return nil
+	}
+
+	jsonConfig, err := cloneJSON(m.source.getConfigObject())
+	if err != nil {
+		return fmt.Errorf("failed to clone config: %w", err)
+	}
+	current, err := jsonValueAt(jsonConfig, path)
+	if err != nil {
+		return err
+	}
+	list, ok := current.([]interface{})
+	if !ok || len(list) != len(array) {
+		return fmt.Errorf("path '%s' is not an array", m.externalPath(path))
+	}
+
+	reordered := make([]*Node, len(array))
+	reorderedJSON := make([]interface{}, len(list))
+	for k, index := range order {
+		reordered[k] = array[index]
+		reorderedJSON[k] = list[index]
+	}
+
+	if jsonConfig, err = jsonSetByPath(jsonConfig, path, reorderedJSON); err != nil {
+		return fmt.Errorf("failed to set: %w", err)
+	}
+	if err := m.validateDocumentLocked(jsonConfig); err != nil {
+		return fmt.Errorf("validation failed: %w", err)
+	}
+
+	oldNode := &Node{array}
+	newNode := &Node{reordered}
+
+	if err := m.runValidatorsLocked(pathEvent{op: Replaceable, path: m.externalPath(path), old: oldNode, new: newNode}); err != nil {
+		return err
+	}
+
+	if mod, err = m.runValidationServiceLocked(mod, jsonConfig); err != nil {
+		return err
+	}
+
+	replaceMod, _ := m.findModifiableLocked(Replaceable, path)
+	if replaceMod != nil {
+		if replaceMod, err = m.runCriticalHandlerLocked(replaceMod, newNode); err != nil {
+			return err
+		}
+		if mod, err = m.findModifiableLocked(mod.Type, path); err != nil {
+			return err
+		}
+	}
+
+	// Mutate, keeping a backup for rollback
+	arrayNode := mod.Node
+	*arrayNode = *newNode
+
+	// Persist
+	if err := m.source.setConfig(jsonConfig); err != nil {
+		*arrayNode = *oldNode
+		m.log().Error("failed to persist config", "op", op, "path", path, "error", err)
+		return fmt.Errorf("failed to persist config: %w", err)
+	}
+
+	m.version++
+	m.touchPathLocked(path)
+	m.updateModifiablesLocked()
+
+	ev := pathEvent{op: Replaceable, path: m.externalPath(path), old: oldNode, new: arrayNode}
+	change := m.changeEventLocked(ev)
+	change.Operation = op
+	m.addHistoryLocked(change)
+
+	if replaceMod != nil {
+		m.callHandlerLocked(replaceMod, arrayNode)
+	}
+	m.publishLocked(ev)
+
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+// REPLACE (improved with proper rollback)
+////////////////////////////////////////////////////////////////////////////////
+
+// Replace sets the replaceable node at path to value. Replacing a value with
+// an equal one is a no-op: the version is not bumped, no history event is
+// recorded and neither handlers nor subscribers are called.
+func (m *Manager) Replace(path string, value interface{}) error {
+	return m.replace(path, value, mutationOptions{})
+}
+
+// ReplaceWithMeta is Replace, recording meta on the change's history event
+func (m *Manager) ReplaceWithMeta(path string, value interface{}, meta map[string]string) error {
+	return m.replace(path, value, mutationOptions{meta: meta})
+}
+
+func (m *Manager) replace(path string, value interface{}, opts mutationOptions) error {
+	m.lockForWrite()
+	defer m.unlockForWrite()
+
+	path, err := m.resolvePathLocked(path)
+	if err != nil {
+		return err
+	}
+
+	mod, err := m.findModifiableLocked(Replaceable, path)
+	if err != nil {
+		return err
+	}
+
+	if err := m.checkPreconditionsLocked(mod.Path, opts); err != nil {
+		return err
+	}
+
+	_, err = m.replaceLocked(mod.Path, mod.Node, mod, value, opts)
+	return err
+}
+
+// replaceDiff is replace for callers reporting what changed: the diff
+// between the node at path and value is taken under the lock of the write,
+// after the preconditions, and returned with the version the write produced.
+// An empty diff changes nothing and returns the current version. A path
+// that does not resolve is a notFoundError.
+func (m *Manager) replaceDiff(path string, value interface{}, opts mutationOptions) ([]DiffEntry, int64, error) {
+	m.lockForWrite()
+	defer m.unlockForWrite()
+
+	internal, err := m.resolvePathLocked(path)
+	if err != nil {
+		return nil, 0, notFoundError{err}
+	}
+	node, err := findNodeByPath(m.config, internal)
+	if err != nil {
+		return nil, 0, notFoundError{err}
+	}
+
+	if err := m.checkPreconditionsLocked(internal, opts); err != nil {
+		return nil, 0, err
+	}
+
+	value = plainValue(value)
+	diff := DiffNodes(node, parseNode(value))
+	if len(diff) == 0 {
+		return diff, m.version, nil
+	}
+
+	mod, err := m.findModifiableLocked(Replaceable, internal)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	if err := validate(source.getConfig(), source.getSchema()); err != nil {
-		return nil, fmt.Errorf("initial config validation failed: %w", err)
+	version, err := m.replaceLocked(mod.Path, mod.Node, mod, value, opts)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	return m, nil
+	prefix := strings.TrimSuffix(m.externalPath(internal), "/")
+	for i := range diff {
+		diff[i].Path = prefix + diff[i].Path
+	}
+	return diff, version, nil
 }
 
-// Config returns a deep copy of the config to prevent data races
-func (m *Manager) Config() *Node {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	// return m.config.DeepCopy()
-    return m.config
+// notFoundError is the error for a path that does not resolve
+type notFoundError struct {
+	error
 }
 
-func (m *Manager) Source() ISource {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	return m.source
+func (e notFoundError) Unwrap() error {
+	return e.error
 }
 
-func (m *Manager) Version() int64 {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	return m.version
-}
+// ArrayIndexMode decides what ReplaceIndex does with an index past the end
+// of the array
+type ArrayIndexMode int
 
-////////////////////////////////////////////////////////////////////////////////
-// INSERT (improved with proper rollback)
-////////////////////////////////////////////////////////////////////////////////
+const (
+	ArrayIndexStrict ArrayIndexMode = iota // the index must exist (the default)
+	ArrayIndexSparse                       // the array is padded with nulls up to the index
+)
 
-func (m *Manager) insert(path string, index int, value interface{}) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// ReplaceIndex sets the element at index of the replaceable array at path to
+// value. It is a Replace of the whole array, so its handler, history event
+// and validation apply. An index past the end is an error unless the manager
+// was created WithArrayIndexMode(ArrayIndexSparse), in which case the gap is
+// filled with nulls; the padded array must pass the schema like any other.
+func (m *Manager) ReplaceIndex(path string, index int, value interface{}) error {
+	m.lockForWrite()
+	defer m.unlockForWrite()
 
-	mod, err := m.findModifiableLocked(Insertable, path)
+	path, err := m.resolvePathLocked(path)
 	if err != nil {
 		return err
 	}
 
-	// Validate index bounds first
-	array, err := mod.Node.GetArray()
+	mod, err := m.findModifiableLocked(Replaceable, path)
 	if err != nil {
 		return err
 	}
-	if index < 0 || index > len(array) {
-		return fmt.Errorf("index %d out of bounds [0,%d]", index, len(array))
-	}
 
-	// Clone and validate
-	jsonConfig, err := Clone(m.source.getConfigObject())
+	current, err := jsonValueAt(m.source.getConfigObject(), path)
 	if err != nil {
-		return fmt.Errorf("failed to clone config: %w", err)
+		return err
+	}
+	list, ok := current.([]interface{})
+	if !ok {
+		return fmt.Errorf("path '%s' is not an array", m.externalPath(path))
 	}
 
-	if err := jsonInsertByPath(jsonConfig, path, index, value); err != nil {
-		return fmt.Errorf("failed to insert: %w", err)
+	if index < 0 || (index >= len(list) && m.arrayIndexMode != ArrayIndexSparse) {
+		return fmt.Errorf("index %d out of bounds [0,%d)", index, len(list))
 	}
 
-	if err := validateJSONAgainstSchema(jsonConfig, m.source.getSchema()); err != nil {
-		return fmt.Errorf("validation failed: %w", err)
+	size := len(list)
+	if index >= size {
+		size = index + 1
 	}
+	updated := make([]interface{}, size)
+	copy(updated, list)
+	updated[index] = plainValue(value)
 
-	// Create backup for rollback
-	oldArray := make([]*Node, len(array))
-	copy(oldArray, array)
+	_, err = m.replaceLocked(mod.Path, mod.Node, mod, updated, mutationOptions{})
+	return err
+}
 
-	// Mutate in-memory node
-	newNode := parseNode(value)
-	newArr := make([]*Node, 0, len(array)+1)
-	newArr = append(newArr, array[:index]...)
-	newArr = append(newArr, newNode)
-	newArr = append(newArr, array[index:]...)
-	*mod.Node = Node{newArr}
+// MutateSubtree replaces the node at path with the result of fn, which gets a
+// deep copy of the current node. The read, the transform and the validated,
+// persisted write happen under one lock, so no other change can slip in
+// between. The path does not need to be registered as replaceable, but a
+// replace handler registered for it runs as for Replace. fn runs with the
+// manager locked and must not call back into it; an error from fn aborts the
+// change.
+func (m *Manager) MutateSubtree(path string, fn func(subtree *Node) (interface{}, error)) error {
+	if fn == nil {
+		return fmt.Errorf("mutate function cannot be nil")
+	}
 
-	// Persist changes
-	if err := m.source.setConfig(jsonConfig); err != nil {
-		// Rollback on failure
-		*mod.Node = Node{oldArray}
-		return fmt.Errorf("failed to persist config: %w", err)
+	m.lockForWrite()
+	defer m.unlockForWrite()
+
+	path, err := m.resolvePathLocked(path)
+	if err != nil {
+		return err
 	}
 
-	m.version++
-	m.updateModifiablesLocked()
+	node, err := findNodeByPath(m.config, path)
+	if err != nil {
+		return err
+	}
 
-	// Call handler AFTER successful persistence, outside of critical section
-	handler := mod.Handler
-	handlerNode := newNode
+	value, err := fn(node.DeepCopy())
+	if err != nil {
+		return fmt.Errorf("mutate function failed: %w", err)
+	}
 
-	if handler != nil {
-		// Unlock during handler execution to avoid deadlocks
-		// Handler gets a copy so it can't corrupt state
-		m.mu.Unlock()
-		// handler(handlerNode.DeepCopy())
-        handler(handlerNode)
-		m.mu.Lock()
+	// Only a registration for this very node carries a handler
+	mod, _ := m.findModifiableLocked(Replaceable, path)
+	if mod != nil && mod.Node != node {
+		mod = nil
 	}
 
-	return nil
+	_, err = m.replaceLocked(path, node, mod, value, mutationOptions{})
+	return err
 }
 
-////////////////////////////////////////////////////////////////////////////////
-// REMOVE (improved with proper rollback)
-////////////////////////////////////////////////////////////////////////////////
+// replaceLocked sets target, found at path, to value and returns the version
+// it produced, the current one when value is already there. mod is the
+// replaceable registration whose handlers run, or nil.
+func (m *Manager) replaceLocked(path string, target *Node, mod *modifiable, value interface{}, opts mutationOptions) (int64, error) {
+	value = plainValue(value)
 
-func (m *Manager) remove(path string, index int) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	build := func(value interface{}) (interface{}, error) {
+		jsonConfig, err := cloneJSON(m.source.getConfigObject())
+		if err != nil {
+			return nil, fmt.Errorf("failed to clone config: %w", err)
+		}
 
-	mod, err := m.findModifiableLocked(Removable, path)
+		jsonConfig, err = jsonSetByPath(jsonConfig, path, value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set: %w", err)
+		}
+
+		if err := m.validateDocumentLocked(jsonConfig); err != nil {
+			return nil, fmt.Errorf("validation failed: %w", err)
+		}
+		return jsonConfig, nil
+	}
+
+	jsonConfig, err := build(value)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	array, err := mod.Node.GetArray()
+	value, changed, err := m.applyTransformersLocked(path, value)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if changed {
+		if jsonConfig, err = build(value); err != nil {
+			return 0, err
+		}
 	}
 
-	if index < 0 || index >= len(array) {
-		return fmt.Errorf("index %d out of bounds [0,%d)", index, len(array))
+	// Storing what is already there is not a change: the version stays and
+	// nothing is recorded or notified
+	if current, err := jsonValueAt(m.source.getConfigObject(), path); err == nil && sameJSON(current, value) {
+		return m.version, nil
 	}
 
-	jsonConfig, err := Clone(m.source.getConfigObject())
+	newNode := parseNode(value)
+
+	err = m.runValidatorsLocked(pathEvent{op: Replaceable, path: m.externalPath(path), old: target, new: newNode})
 	if err != nil {
-		return fmt.Errorf("failed to clone config: %w", err)
+		return 0, err
 	}
 
-	if err := jsonRemoveByPath(jsonConfig, path, index); err != nil {
-		return fmt.Errorf("failed to remove: %w", err)
+	if mod, err = m.runValidationServiceLocked(mod, jsonConfig); err != nil {
+		return 0, err
 	}
-
-	if err := validateJSONAgainstSchema(jsonConfig, m.source.getSchema()); err != nil {
-		return fmt.Errorf("validation failed: %w", err)
+	if mod != nil && !opts.suppressHandlers {
+		if mod, err = m.runCriticalHandlerLocked(mod, newNode); err != nil {
+			return 0, err
+		}
+		target, path = mod.Node, mod.Path
 	}
 
+	nodePath := path
+
 	// Backup for rollback
-	oldArray := make([]*Node, len(array))
-	copy(oldArray, array)
-	removedNode := array[index]
+	oldNode := *target
 
 	// Mutate
-	newArr := make([]*Node, 0, len(array)-1)
-	newArr = append(newArr, array[:index]...)
-	newArr = append(newArr, array[index+1:]...)
-	*mod.Node = Node{newArr}
+	*target = *newNode
 
 	// Persist
 	if err := m.source.setConfig(jsonConfig); err != nil {
-		*mod.Node = Node{oldArray}
-		return fmt.Errorf("failed to persist config: %w", err)
+		*target = oldNode
+		m.log().Error("failed to persist config", "op", Replaceable.String(), "path", nodePath, "error", err)
+		return 0, fmt.Errorf("failed to persist config: %w", err)
 	}
 
 	m.version++
+	version := m.version
+	m.touchPathLocked(nodePath)
 	m.updateModifiablesLocked()
 
-	handler := mod.Handler
-	handlerNode := removedNode
+	ev := pathEvent{op: Replaceable, path: m.externalPath(nodePath), old: &oldNode, new: newNode, meta: opts.meta}
+	m.recordLocked(ev)
 
-	if handler != nil {
-		m.mu.Unlock()
-		// handler(handlerNode.DeepCopy())
-        handler(handlerNode)
-		m.mu.Lock()
+	if mod != nil && !opts.suppressHandlers {
+		m.callHandlerLocked(mod, target)
 	}
+	m.publishLocked(ev)
 
-	return nil
+	return version, nil
 }
 
-////////////////////////////////////////////////////////////////////////////////
-// REPLACE (improved with proper rollback)
-////////////////////////////////////////////////////////////////////////////////
+// mutationOptions carries the optional parts of a modification
+type mutationOptions struct {
+	version     int64             // precondition on the config's version, 0 for none
+	pathVersion int64             // precondition on the affected subtree, 0 for none
+	meta        map[string]string // recorded on the history event
 
-func (m *Manager) replace(path string, value interface{}) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	suppressHandlers bool // see WithHandlersSuppressed
+}
 
-	mod, err := m.findModifiableLocked(Replaceable, path)
-	if err != nil {
-		return err
+// runCriticalHandlerLocked lets a critical handler veto a modification
+// before it is applied. The handler runs with the lock released; if the
+// config changed in the meantime the modification is aborted. On success the
+// modifiable is looked up again since registrations may have moved. A
+// manager staging a batch defers the call to the commit.
+func (m *Manager) runCriticalHandlerLocked(mod *modifiable, node *Node) (*modifiable, error) {
+	if mod.Handler == nil || mod.BestEffort {
+		return mod, nil
+	}
+	if m.staging {
+		m.deferHandlerLocked(mod, node)
+		return mod, nil
 	}
 
-	jsonConfig, err := Clone(m.source.getConfigObject())
-	if err != nil {
-		return fmt.Errorf("failed to clone config: %w", err)
+	t, path := mod.Type, mod.Path
+	if err := m.vetoLocked(mod, node); err != nil {
+		return nil, err
 	}
+	return m.findModifiableLocked(t, path)
+}
 
-	if err := jsonSetByPath(jsonConfig, path, value); err != nil {
-		return fmt.Errorf("failed to set: %w", err)
+// vetoLocked runs mod's critical handler with the lock released and fails if
+// it rejects the change or the config changed while it ran
+func (m *Manager) vetoLocked(mod *modifiable, node *Node) error {
+	t, path, version := mod.Type, mod.Path, m.version
+
+	m.mu.Unlock()
+	err := m.invokeHandler(mod, node)
+	m.mu.Lock()
+
+	if err != nil {
+		return fmt.Errorf("handler rejected %s at '%s': %w", t, path, err)
+	}
+	if m.version != version {
+		return fmt.Errorf("config changed while %s handler for '%s' was running", t, path)
 	}
+	return nil
+}
 
-	if err := validateJSONAgainstSchema(jsonConfig, m.source.getSchema()); err != nil {
-		return fmt.Errorf("validation failed: %w", err)
+// callHandlerLocked runs a best-effort handler after the change has been
+// persisted, with the manager lock released so the handler can read from
+// the manager without deadlocking. Failures are logged only. A manager
+// staging a batch defers the call to the commit.
+func (m *Manager) callHandlerLocked(mod *modifiable, node *Node) {
+	if mod.Handler == nil || !mod.BestEffort {
+		return
+	}
+	if m.staging {
+		m.deferHandlerLocked(mod, node)
+		return
 	}
 
-	// Backup for rollback
-	oldNode := *mod.Node
+	handler, t, path := *mod, mod.Type, mod.Path
 
-	// Mutate
-	newNode := parseNode(value)
-	*mod.Node = *newNode
+	m.mu.Unlock()
+	defer m.mu.Lock()
 
-	// Persist
-	if err := m.source.setConfig(jsonConfig); err != nil {
-		*mod.Node = oldNode
-		return fmt.Errorf("failed to persist config: %w", err)
+	if err := m.invokeHandler(&handler, node); err != nil {
+		m.log().Warn("best-effort handler failed", "op", t.String(), "path", path, "error", err)
 	}
+}
 
-	m.version++
-	m.updateModifiablesLocked()
+// WithHandlersSuppressed runs fn with a scope whose modifications skip the
+// handlers: they are still validated, persisted, recorded and published to
+// path subscribers, but neither critical nor best-effort handlers are
+// called. Suppression is carried by the scope, so changes made elsewhere
+// while fn runs, e.g. by the HTTP server, call their handlers as usual.
+// Scopes may be nested.
+func (m *Manager) WithHandlersSuppressed(fn func(s SuppressedScope) error) error {
+	return fn(SuppressedScope{m: m})
+}
+
+// SuppressedScope makes modifications without calling handlers, see
+// WithHandlersSuppressed
+type SuppressedScope struct {
+	m *Manager
+}
 
-	handler := mod.Handler
-	handlerNode := mod.Node
+// Insert is Manager.Insert without handlers
+func (s SuppressedScope) Insert(path string, index int, value interface{}) error {
+	return s.m.insert(path, index, value, mutationOptions{suppressHandlers: true})
+}
+
+// Remove is Manager.Remove without handlers
+func (s SuppressedScope) Remove(path string, index int) error {
+	return s.m.remove(path, index, mutationOptions{suppressHandlers: true})
+}
+
+// Replace is Manager.Replace without handlers
+func (s SuppressedScope) Replace(path string, value interface{}) error {
+	return s.m.replace(path, value, mutationOptions{suppressHandlers: true})
+}
 
-	if handler != nil {
-		m.mu.Unlock()
-		// handler(handlerNode.DeepCopy())
-        handler(handlerNode)
-		m.mu.Lock()
+// invokeHandler calls mod's handler, first waiting for a free slot when a
+// handler limit is configured. With a timeout the handler runs on its own
+// goroutine and is abandoned when the timeout expires; it keeps its slot
+// until it actually returns. Must be called without holding m.mu.
+func (m *Manager) invokeHandler(mod *modifiable, node *Node) error {
+	if m.handlerSlots != nil {
+		m.handlerSlots <- struct{}{}
+	}
+	release := func() {
+		if m.handlerSlots != nil {
+			<-m.handlerSlots
+		}
 	}
 
-	return nil
+	timeout := mod.Timeout
+	if timeout <= 0 {
+		timeout = m.handlerTimeout
+	}
+	if timeout <= 0 {
+		defer release()
+		return mod.Handler(context.Background(), node)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	t, path := mod.Type, m.externalPath(mod.Path)
+	done := make(chan error, 1)
+	go func() {
+		defer release()
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("handler panicked: %v", r)
+			}
+		}()
+		done <- mod.Handler(ctx, node)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		m.log().Warn("handler timed out, abandoning it", "op", t.String(), "path", path, "timeout", timeout)
+		go func() {
+			<-done
+			m.log().Info("abandoned handler finished", "op", t.String(), "path", path, "elapsed", time.Since(start))
+		}()
+		return fmt.Errorf("%w after %v", ErrHandlerTimeout, timeout)
+	}
 }
 
 ////////////////////////////////////////////////////////////////////////////////
 // REGISTRATION
 ////////////////////////////////////////////////////////////////////////////////
 
+// OnInsert registers node as insertable. handler is called after each
+// successful insert with the inserted node.
 func (m *Manager) OnInsert(node *Node, handler handler_t) error {
-	if node == nil {
-		return errors.New("node cannot be nil")
+	return m.OnInsertWithOptions(node, wrapHandler(handler), HandlerOptions{BestEffort: true})
+}
+
+// OnRemove registers node as removable. handler is called after each
+// successful remove with the removed node.
+func (m *Manager) OnRemove(node *Node, handler handler_t) error {
+	return m.OnRemoveWithOptions(node, wrapHandler(handler), HandlerOptions{BestEffort: true})
+}
+
+// OnReplace registers node as replaceable. handler is called after each
+// successful replace with the updated node.
+func (m *Manager) OnReplace(node *Node, handler handler_t) error {
+	return m.OnReplaceWithOptions(node, wrapHandler(handler), HandlerOptions{BestEffort: true})
+}
+
+// OnInsertPath is OnInsert for the array currently at path
+func (m *Manager) OnInsertPath(path string, handler handler_t) error {
+	node, err := m.liveNode(path)
+	if err != nil {
+		return err
 	}
-	if node.Type() != Array {
-		return errors.New("node must be array for insert operations")
+	return m.OnInsert(node, handler)
+}
+
+// OnRemovePath is OnRemove for the array currently at path
+func (m *Manager) OnRemovePath(path string, handler handler_t) error {
+	node, err := m.liveNode(path)
+	if err != nil {
+		return err
 	}
+	return m.OnRemove(node, handler)
+}
 
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// OnReplacePath is OnReplace for the node currently at path
+func (m *Manager) OnReplacePath(path string, handler handler_t) error {
+	node, err := m.liveNode(path)
+	if err != nil {
+		return err
+	}
+	return m.OnReplace(node, handler)
+}
 
-	p, err := m.findAndSanitizeNodePathLocked(node)
+// OnInsertPattern registers every array whose path matches pattern (a query
+// expression such as "/tenants/*/users", see Node.Query) as insertable, with
+// handler called after each successful insert into any of them. Arrays are
+// matched when an insert happens, so ones added later, e.g. by a reload, are
+// covered too. A registration on the array itself takes precedence.
+func (m *Manager) OnInsertPattern(pattern string, handler handler_t) error {
+	segments, err := parseQuery(pattern)
 	if err != nil {
 		return err
 	}
 
-	m.modifiables = append(m.modifiables, modifiable{
-		Type:    Insertable,
-		Path:    p,
-		Node:    node,
-		Handler: handler,
-	})
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
+	m.patterns = append(m.patterns, modifiablePattern{
+		Type:     Insertable,
+		Pattern:  pattern,
+		segments: segments,
+		Handler:  withoutContext(wrapHandler(handler)),
+	})
 	return nil
 }
 
-func (m *Manager) OnRemove(node *Node, handler handler_t) error {
+// liveNode returns the node of the live tree at path
+func (m *Manager) liveNode(path string) (*Node, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	internal, err := m.resolvePathLocked(path)
+	if err != nil {
+		return nil, err
+	}
+	node, err := findNodeByPath(m.config, internal)
+	if err != nil {
+		return nil, fmt.Errorf("path '%s' not found: %w", path, err)
+	}
+	return node, nil
+}
+
+func (m *Manager) OnInsertWithOptions(node *Node, handler errHandler_t, opts HandlerOptions) error {
+	return m.OnInsertWithContext(node, withoutContext(handler), opts)
+}
+
+func (m *Manager) OnRemoveWithOptions(node *Node, handler errHandler_t, opts HandlerOptions) error {
+	return m.OnRemoveWithContext(node, withoutContext(handler), opts)
+}
+
+func (m *Manager) OnReplaceWithOptions(node *Node, handler errHandler_t, opts HandlerOptions) error {
+	return m.OnReplaceWithContext(node, withoutContext(handler), opts)
+}
+
+// OnInsertWithContext is OnInsertWithOptions for a handler taking a context,
+// which is cancelled when the handler's timeout expires
+func (m *Manager) OnInsertWithContext(node *Node, handler ctxHandler_t, opts HandlerOptions) error {
 	if node == nil {
 		return errors.New("node cannot be nil")
 	}
 	if node.Type() != Array {
-		return errors.New("node must be array for remove operations")
+		return errors.New("node must be array for insert operations")
 	}
 
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	return m.register(Insertable, node, handler, opts)
+}
 
-	p, err := m.findAndSanitizeNodePathLocked(node)
-	if err != nil {
-		return err
+// OnRemoveWithContext is OnRemoveWithOptions for a handler taking a context
+func (m *Manager) OnRemoveWithContext(node *Node, handler ctxHandler_t, opts HandlerOptions) error {
+	if node == nil {
+		return errors.New("node cannot be nil")
+	}
+	if node.Type() != Array {
+		return errors.New("node must be array for remove operations")
 	}
 
-	m.modifiables = append(m.modifiables, modifiable{
-		Type:    Removable,
-		Path:    p,
-		Node:    node,
-		Handler: handler,
-	})
-
-	return nil
+	return m.register(Removable, node, handler, opts)
 }
 
-func (m *Manager) OnReplace(node *Node, handler handler_t) error {
+// OnReplaceWithContext is OnReplaceWithOptions for a handler taking a context
+func (m *Manager) OnReplaceWithContext(node *Node, handler ctxHandler_t, opts HandlerOptions) error {
 	if node == nil {
 		return errors.New("node cannot be nil")
 	}
 
+	return m.register(Replaceable, node, handler, opts)
+}
+
+func (m *Manager) register(t modifiableType, node *Node, handler ctxHandler_t, opts HandlerOptions) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -343,16 +1633,39 @@ func (m *Manager) OnReplace(node *Node, handler handler_t) error {
 		return err
 	}
 
+	delete(m.droppedPaths, p)
+
 	m.modifiables = append(m.modifiables, modifiable{
-		Type:    Replaceable,
-		Path:    p,
-		Node:    node,
-		Handler: handler,
+		Type:       t,
+		Path:       p,
+		Node:       node,
+		Handler:    handler,
+		BestEffort: opts.BestEffort,
+		Timeout:    opts.Timeout,
 	})
 
 	return nil
 }
 
+func wrapHandler(handler handler_t) errHandler_t {
+	if handler == nil {
+		return nil
+	}
+	return func(n *Node) error {
+		handler(n)
+		return nil
+	}
+}
+
+func withoutContext(handler errHandler_t) ctxHandler_t {
+	if handler == nil {
+		return nil
+	}
+	return func(_ context.Context, n *Node) error {
+		return handler(n)
+	}
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 // PATH HELPERS
 ////////////////////////////////////////////////////////////////////////////////
@@ -368,7 +1681,7 @@ func (m *Manager) getPathsLocked(t modifiableType) []string {
 	out := make([]string, 0, len(m.modifiables))
 	for _, v := range m.modifiables {
 		if v.Type == t {
-			out = append(out, v.Path)
+			out = append(out, m.externalPath(v.Path))
 		}
 	}
 	return out
@@ -380,7 +1693,26 @@ func (m *Manager) findModifiableLocked(t modifiableType, path string) (*modifiab
 			return &m.modifiables[i], nil
 		}
 	}
-	return nil, fmt.Errorf("path '%s' not modifiable for operation type %d", path, t)
+	if mod := m.matchPatternLocked(t, path); mod != nil {
+		return mod, nil
+	}
+	return nil, fmt.Errorf("path '%s' not modifiable for operation type %d", m.externalPath(path), t)
+}
+
+// matchPatternLocked returns a registration for the node at path when it
+// matches a pattern registered for t
+func (m *Manager) matchPatternLocked(t modifiableType, path string) *modifiable {
+	for _, p := range m.patterns {
+		if p.Type != t || !matchSegments(p.segments, pointerSegments(path)) {
+			continue
+		}
+		node, err := findNodeByPath(m.config, path)
+		if err != nil || (t != Replaceable && node.Type() != Array) {
+			continue
+		}
+		return &modifiable{Type: t, Path: path, Node: node, Handler: p.Handler, BestEffort: true}
+	}
+	return nil
 }
 
 func (m *Manager) updateModifiablesLocked() {
@@ -390,11 +1722,52 @@ func (m *Manager) updateModifiablesLocked() {
 		if path := m.findNodePathLocked(mod.Node); path != "" {
 			mod.Path = path
 			validMods = append(validMods, mod)
+		} else {
+			m.dropModifiableLocked(mod)
 		}
 	}
 	m.modifiables = validMods
 }
 
+// dropModifiableLocked records a registration that no longer resolves and
+// reports it to the drop callback, if any
+func (m *Manager) dropModifiableLocked(mod modifiable) {
+	if m.droppedPaths == nil {
+		m.droppedPaths = make(map[string]bool)
+	}
+	m.droppedPaths[mod.Path] = true
+
+	if m.onModifiableDropped != nil {
+		m.onModifiableDropped(m.externalPath(mod.Path), mod.Type.String())
+	}
+}
+
+// ValidateModifiables returns, sorted, the paths of registrations that no
+// longer resolve: those dropped because a change, reload or import removed
+// their node, and any whose path now leads to a different node. A path is
+// cleared from the report when it is registered again.
+func (m *Manager) ValidateModifiables() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	missing := make(map[string]bool, len(m.droppedPaths))
+	for path := range m.droppedPaths {
+		missing[path] = true
+	}
+	for _, mod := range m.modifiables {
+		if node, err := findNodeByPath(m.config, mod.Path); err != nil || node != mod.Node {
+			missing[mod.Path] = true
+		}
+	}
+
+	out := make([]string, 0, len(missing))
+	for path := range missing {
+		out = append(out, m.externalPath(path))
+	}
+	sort.Strings(out)
+	return out
+}
+
 func (m *Manager) findNodePathLocked(n *Node) string {
 	return findNodePath(m.config, n)
 }
@@ -402,7 +1775,7 @@ func (m *Manager) findNodePathLocked(n *Node) string {
 func (m *Manager) findAndSanitizeNodePathLocked(n *Node) (string, error) {
 	p := m.findNodePathLocked(n)
 	if p == "" {
-		return "", errors.New("node has no valid path in config tree")
+		return "", errors.New("node has no valid path in config tree (nodes from Config() are copies, use ConfigRef())")
 	}
 	return p, nil
 }
@@ -411,6 +1784,30 @@ func (m *Manager) findAndSanitizeNodePathLocked(n *Node) (string, error) {
 // HELPERS
 ////////////////////////////////////////////////////////////////////////////////
 
+// validateDocumentLocked checks a modified config document against the
+// schema. A manager staging a batch skips it: only the batch's result has to
+// conform.
+func (m *Manager) validateDocumentLocked(doc interface{}) error {
+	if m.staging {
+		return nil
+	}
+	return m.validateSchema(doc, m.source.getSchema())
+}
+
+// validateArrayItemLocked checks a value about to be added at index of the
+// array at path against the array's item schema, leaving out what is exempt
+// from validation
+func (m *Manager) validateArrayItemLocked(path string, index int, value interface{}) error {
+	if len(m.unvalidated) > 0 {
+		element := elementPath(path, index)
+		if m.isUnvalidated(element) {
+			return nil
+		}
+		value = m.exciseUnvalidated(value, element)
+	}
+	return validateArrayItem(m.source.getSchema(), path, value)
+}
+
 func validateJSONAgainstSchema(obj interface{}, schema *string) error {
 	b, err := json.Marshal(obj)
 	if err != nil {
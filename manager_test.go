@@ -0,0 +1,942 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMaxConcurrentHandlers(t *testing.T) {
+	const limit, writes = 2, 8
+
+	m := newTestManager(t, `{"k0":0,"k1":0,"k2":0,"k3":0,"k4":0,"k5":0,"k6":0,"k7":0}`,
+		WithMaxConcurrentHandlers(limit))
+
+	var mu sync.Mutex
+	running, peak := 0, 0
+	handler := func(*Node) {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+	}
+
+	for i := 0; i < writes; i++ {
+		node, err := m.ConfigRef().At(fmt.Sprintf("k%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.OnReplace(node, handler); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < writes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := m.Replace(fmt.Sprintf("/k%d", i), 1); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if peak > limit {
+		t.Errorf("%d handlers ran at once, want at most %d", peak, limit)
+	}
+	if peak == 0 {
+		t.Error("no handler ran")
+	}
+}
+
+func TestArrayRootConfig(t *testing.T) {
+	m := newTestManager(t, `[{"name":"a","tags":[]},{"name":"b"}]`)
+
+	root := m.ConfigRef()
+	if root.Type() != Array {
+		t.Fatalf("root type = %v, want array", root.Type())
+	}
+	first, err := root.At(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := first.At("name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, err := first.At("tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnReplace(name, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(tags, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Replace("/0/name", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Insert("/0/tags", 0, "x"); err != nil {
+		t.Fatal(err)
+	}
+
+	var persisted interface{}
+	if err := json.Unmarshal([]byte(*m.Source().getConfig()), &persisted); err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{
+		map[string]interface{}{"name": "c", "tags": []interface{}{"x"}},
+		map[string]interface{}{"name": "b"},
+	}
+	if !reflect.DeepEqual(persisted, want) {
+		t.Errorf("persisted config = %v, want %v", persisted, want)
+	}
+}
+
+func TestBestEffortHandlerErrorKeepsChange(t *testing.T) {
+	m := newTestManager(t, `{"a":1}`)
+	node, err := m.ConfigRef().At("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing := func(*Node) error { return errors.New("downstream unavailable") }
+	if err := m.OnReplaceWithOptions(node, failing, HandlerOptions{BestEffort: true}); err != nil {
+		t.Fatal(err)
+	}
+	before := m.Version()
+
+	if err := m.Replace("/a", 2); err != nil {
+		t.Fatalf("replace failed: %v", err)
+	}
+	if m.Version() != before+1 {
+		t.Errorf("version = %d, want %d", m.Version(), before+1)
+	}
+	if got := *m.Source().getConfig(); !strings.Contains(got, `"a": 2`) {
+		t.Errorf("persisted config = %s, want a = 2", got)
+	}
+}
+
+func TestCriticalHandlerErrorRollsBack(t *testing.T) {
+	m := newTestManager(t, `{"a":1}`)
+	node, err := m.ConfigRef().At("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing := func(*Node) error { return errors.New("rejected") }
+	if err := m.OnReplaceWithOptions(node, failing, HandlerOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	before := m.Version()
+
+	if err := m.Replace("/a", 2); err == nil {
+		t.Fatal("replace succeeded, want the handler's error")
+	}
+	if m.Version() != before {
+		t.Errorf("version = %d, want %d", m.Version(), before)
+	}
+	if got, _ := node.GetInt(); got != 1 {
+		t.Errorf("/a = %d, want 1", got)
+	}
+	if got := *m.Source().getConfig(); strings.Contains(got, "2") {
+		t.Errorf("persisted config = %s, want it unchanged", got)
+	}
+}
+
+func TestCaseInsensitiveKeys(t *testing.T) {
+	m := newTestManager(t, `{"Server":{"Port":1},"dup":{"Key":1,"KEY":2}}`, WithCaseInsensitiveKeys(true))
+	server, err := m.ConfigRef().At("Server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnReplace(server, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	node, _, err := m.lookup("/server/port")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port, _ := node.GetInt(); port != 1 {
+		t.Errorf("/server/port = %d, want 1", port)
+	}
+
+	if err := m.Replace("/SERVER", map[string]interface{}{"Port": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if got := *m.Source().getConfig(); !strings.Contains(got, `"Server"`) || strings.Contains(got, `"SERVER"`) {
+		t.Errorf("persisted config = %s, want the stored key kept as Server", got)
+	}
+
+	if _, _, err := m.lookup("/dup/key"); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("lookup(/dup/key) error = %v, want an ambiguous key error", err)
+	}
+	if _, _, err := m.lookup("/dup/Key"); err != nil {
+		t.Errorf("exact match /dup/Key: %v", err)
+	}
+
+	strict := newTestManager(t, `{"Server":{"Port":1}}`)
+	if _, _, err := strict.lookup("/server/port"); err == nil {
+		t.Error("lookup(/server/port) succeeded without WithCaseInsensitiveKeys")
+	}
+}
+
+func TestMutateSubtreeSortsArray(t *testing.T) {
+	m := newTestManager(t, `{"hosts":["c","a","b"]}`)
+	before := m.Version()
+
+	err := m.MutateSubtree("/hosts", func(subtree *Node) (interface{}, error) {
+		items, err := subtree.GetArray()
+		if err != nil {
+			return nil, err
+		}
+		var hosts []string
+		for _, item := range items {
+			s, _ := item.GetString()
+			hosts = append(hosts, s)
+		}
+		sort.Strings(hosts)
+
+		sorted := make([]interface{}, len(hosts))
+		for i, h := range hosts {
+			sorted[i] = h
+		}
+		return sorted, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	persisted := *m.source.getConfig()
+	var got map[string]interface{}
+	json.Unmarshal([]byte(persisted), &got)
+	if want := []interface{}{"a", "b", "c"}; !reflect.DeepEqual(got["hosts"], want) {
+		t.Errorf("persisted hosts = %v, want %v", got["hosts"], want)
+	}
+	if m.Version() != before+1 {
+		t.Errorf("version = %d, want %d", m.Version(), before+1)
+	}
+	if err := m.VerifyConsistency(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMutateSubtreeErrorAbortsChange(t *testing.T) {
+	m := newTestManager(t, `{"hosts":["c","a","b"]}`)
+	before := m.Version()
+
+	err := m.MutateSubtree("/hosts", func(subtree *Node) (interface{}, error) {
+		// fn works on a copy, so this must not leak into the config
+		items, _ := subtree.GetArray()
+		*items[0] = *parseNode("z")
+		return nil, errors.New("boom")
+	})
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("err = %v, want the function's error", err)
+	}
+	if m.Version() != before {
+		t.Errorf("version = %d, want %d", m.Version(), before)
+	}
+	hosts, _ := m.ConfigRef().At("hosts")
+	if first, _ := hosts.GetArray(); len(first) == 0 || first[0].value != "c" {
+		t.Errorf("hosts = %v, want unchanged", hosts.toInterface())
+	}
+}
+
+func TestValidateOnReadSurfacesCorruption(t *testing.T) {
+	source, err := NewStrSource(`{"port":1}`,
+		`{"type":"object","properties":{"port":{"type":"number","maximum":100}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source, WithValidateOnRead(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaceable(t, m, "port")
+
+	// Corrupt the stored document behind the manager's back
+	corrupt, err := parseConfig([]byte(`{"port":500}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := source.setConfig(corrupt); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.ReadConfig(); err == nil || !strings.Contains(err.Error(), "source config is invalid") {
+		t.Errorf("ReadConfig err = %v, want the source to be reported invalid", err)
+	}
+	if _, err := m.Query("/port"); err == nil {
+		t.Error("Query succeeded on a corrupted config")
+	}
+	if code, body := serve(t, m, "GET", "/config", ""); code < 400 {
+		t.Errorf("GET /config: status = %d, want an error: %v", code, body)
+	}
+
+	// A write stores a valid document again and the next read rechecks it
+	if err := m.Replace("/port", 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ReadConfig(); err != nil {
+		t.Errorf("ReadConfig after repair: %v", err)
+	}
+}
+
+func TestValidateOnReadOffByDefault(t *testing.T) {
+	source, err := NewStrSource(`{"port":1}`,
+		`{"type":"object","properties":{"port":{"type":"number","maximum":100}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt, _ := parseConfig([]byte(`{"port":500}`))
+	source.setConfig(corrupt)
+
+	if _, err := m.ReadConfig(); err != nil {
+		t.Errorf("ReadConfig err = %v, want nil without WithValidateOnRead", err)
+	}
+}
+
+func TestConfigCopyNeverMutates(t *testing.T) {
+	m := newTestManager(t, `{"counter":0,"list":[]}`)
+	replaceable(t, m, "counter")
+	list, err := m.ConfigRef().At("list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(list, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	var writer sync.WaitGroup
+	writer.Add(1)
+	go func() {
+		defer writer.Done()
+		for i := 1; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := m.Replace("/counter", i); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := m.Insert("/list", 0, i); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	var readers sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for i := 0; i < 200; i++ {
+				snapshot := m.Config()
+				before, _ := json.Marshal(snapshot.toInterface())
+				// Give the writer time to change the live tree
+				time.Sleep(10 * time.Microsecond)
+				after, _ := json.Marshal(snapshot.toInterface())
+				if string(before) != string(after) {
+					t.Errorf("config copy changed from %s to %s", before, after)
+					return
+				}
+			}
+		}()
+	}
+	readers.Wait()
+	close(done)
+	writer.Wait()
+}
+
+func BenchmarkConfigDeepCopy(b *testing.B) {
+	// About 1MB of JSON
+	var sb strings.Builder
+	sb.WriteString(`{"items":[`)
+	for i := 0; i < 10000; i++ {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, `{"id":%d,"name":"item-%d","enabled":true,"tags":["a","b","c"],"limits":{"cpu":0.5,"memory":"%dMi"}}`, i, i, i)
+	}
+	sb.WriteString(`]}`)
+
+	source, err := NewStrSource(sb.String(), `{}`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	m, err := NewManager(source)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(sb.Len()))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		m.Config()
+	}
+}
+
+func TestInsertOrCreateMissingArray(t *testing.T) {
+	m := newTestManager(t, `{"svc":{"name":"a","tags":null}}`)
+	replaceable(t, m, "svc")
+	before := m.Version()
+
+	// Plain Insert does not create the array
+	if err := m.Insert("/svc/ports", 0, 80); err == nil {
+		t.Fatal("Insert created a missing array")
+	}
+
+	if err := m.InsertOrCreate("/svc/ports", 1, 80); err == nil {
+		t.Error("InsertOrCreate into a new array at index 1 succeeded")
+	}
+	if err := m.InsertOrCreate("/svc/ports", 0, 80); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"svc": map[string]interface{}{"name": "a", "tags": nil, "ports": []interface{}{80}}}
+	if got := m.Config().toInterface(); !equalJSON(got, want) {
+		t.Errorf("config = %v, want %v", got, want)
+	}
+	if m.Version() != before+1 {
+		t.Errorf("version = %d, want %d", m.Version(), before+1)
+	}
+	if err := m.VerifyConsistency(); err != nil {
+		t.Error(err)
+	}
+
+	// A null key is not absent
+	err := m.InsertOrCreate("/svc/tags", 0, "x")
+	if err == nil || !strings.Contains(err.Error(), "'tags' is null") {
+		t.Errorf("insert into null tags: err = %v, want it to say tags is null", err)
+	}
+	if _, err := findNodeByPath(m.ConfigRef(), "/svc/tags/0"); err == nil || !strings.Contains(err.Error(), "'tags' is null") {
+		t.Errorf("lookup through null tags: err = %v, want it to say tags is null", err)
+	}
+	if _, err := findNodeByPath(m.ConfigRef(), "/svc/missing/0"); err == nil || strings.Contains(err.Error(), "null") {
+		t.Errorf("lookup through an absent key: err = %v, want a not found error", err)
+	}
+}
+
+func TestInsertOrCreateNeedsReplaceableParent(t *testing.T) {
+	m := newTestManager(t, `{"svc":{}}`)
+	if err := m.InsertOrCreate("/svc/ports", 0, 80); err == nil {
+		t.Error("created an array under an unregistered parent")
+	}
+}
+
+func TestSlowCriticalHandlerTimesOut(t *testing.T) {
+	m := newTestManager(t, `{"a":1}`, WithHandlerTimeout(20*time.Millisecond))
+	node, err := m.ConfigRef().At("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled := make(chan struct{})
+	err = m.OnReplaceWithContext(node, func(ctx context.Context, _ *Node) error {
+		<-ctx.Done()
+		close(cancelled)
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	}, HandlerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := m.Version()
+
+	start := time.Now()
+	err = m.Replace("/a", 2)
+	if !errors.Is(err, ErrHandlerTimeout) {
+		t.Fatalf("err = %v, want ErrHandlerTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Replace took %v despite the timeout", elapsed)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("handler context was not cancelled")
+	}
+	// A timed out critical handler rolls the change back
+	if v, _ := node.GetInt(); v != 1 || m.Version() != before {
+		t.Errorf("a = %d at version %d, want 1 at %d", v, m.Version(), before)
+	}
+}
+
+func TestSlowBestEffortHandlerTimesOut(t *testing.T) {
+	m := newTestManager(t, `{"a":1}`)
+	node, err := m.ConfigRef().At("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	err = m.OnReplaceWithOptions(node, func(*Node) error {
+		<-release
+		return nil
+	}, HandlerOptions{BestEffort: true, Timeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- m.Replace("/a", 2) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("best-effort replace: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Replace blocked on a hung best-effort handler")
+	}
+	if v, _ := node.GetInt(); v != 2 {
+		t.Errorf("a = %d, want 2", v)
+	}
+}
+
+func TestPerRegistrationTimeoutOverridesDefault(t *testing.T) {
+	m := newTestManager(t, `{"a":1}`, WithHandlerTimeout(10*time.Millisecond))
+	node, err := m.ConfigRef().At("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.OnReplaceWithOptions(node, func(*Node) error {
+		time.Sleep(40 * time.Millisecond)
+		return nil
+	}, HandlerOptions{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Replace("/a", 2); err != nil {
+		t.Errorf("handler within its own timeout: %v", err)
+	}
+}
+
+func TestOnInsertPatternCoversMatchingArrays(t *testing.T) {
+	m := newTestManager(t, `{"tenants":{"a":{"users":[]},"b":{"users":["x"]},"c":{"groups":[]}}}`)
+
+	var inserted []string
+	if err := m.OnInsertPattern("/tenants/*/users", func(n *Node) {
+		name, _ := n.GetString()
+		inserted = append(inserted, name)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Insert("/tenants/a/users", 0, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Insert("/tenants/b/users", 1, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(inserted, []string{"alice", "bob"}) {
+		t.Errorf("handler saw %v, want [alice bob]", inserted)
+	}
+
+	if err := m.Insert("/tenants/c/groups", 0, "g"); err == nil {
+		t.Error("insert into an array outside the pattern accepted")
+	}
+
+	// Arrays appearing later, e.g. by an import, match too
+	if err := m.Import([]byte(`{"tenants":{"d":{"users":[]}}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Insert("/tenants/d/users", 0, "dave"); err != nil {
+		t.Fatal(err)
+	}
+	if len(inserted) != 3 || inserted[2] != "dave" {
+		t.Errorf("handler saw %v, want dave last", inserted)
+	}
+
+	if err := m.OnInsertPattern("tenants/*/users", nil); err == nil {
+		t.Error("malformed pattern accepted")
+	}
+}
+
+func TestUnchangedWritesKeepVersion(t *testing.T) {
+	m := newTestManager(t, `{"db":{"host":"a","port":1},"n":1.5}`)
+
+	handled := 0
+	db, err := m.ConfigRef().At("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnReplace(db, func(*Node) { handled++ }); err != nil {
+		t.Fatal(err)
+	}
+	replaceable(t, m, "n")
+	notified := 0
+	if err := m.OnPathReplace("/*", func(string, *Node, *Node) { notified++ }); err != nil {
+		t.Fatal(err)
+	}
+
+	before := m.Version()
+	fingerprint := m.Fingerprint()
+	if err := m.Replace("/db", map[string]interface{}{"host": "a", "port": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Replace("/n", 1.5); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Import([]byte(`{"db":{"host":"a","port":1},"n":1.5}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Version() != before || m.Fingerprint() != fingerprint {
+		t.Errorf("version %d -> %d, want unchanged", before, m.Version())
+	}
+	if n := len(m.History().GetAll()); n != 0 {
+		t.Errorf("%d history events, want 0", n)
+	}
+	if handled != 0 || notified != 0 {
+		t.Errorf("handler called %d times, subscriber %d times, want 0", handled, notified)
+	}
+
+	// A real change still counts
+	if err := m.Replace("/db", map[string]interface{}{"host": "b", "port": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if m.Version() != before+1 || handled != 1 || notified != 1 || len(m.History().GetAll()) != 1 {
+		t.Errorf("after a change: version %d, handler %d, subscriber %d, want %d, 1, 1", m.Version(), handled, notified, before+1)
+	}
+}
+
+func TestMoveBetweenArrays(t *testing.T) {
+	store := &slowStore{MemoryStore: NewMemoryStore([]byte(`{"todo":["a","b","c"],"done":["x"]}`))}
+	source, err := NewCodecSource(store, JSONCodec{}, `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls []string
+	for _, key := range []string{"todo", "done"} {
+		key := key
+		node, err := m.ConfigRef().At(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.OnInsert(node, func(*Node) { calls = append(calls, "insert "+key) }); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.OnRemove(node, func(*Node) { calls = append(calls, "remove "+key) }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := func() interface{} { return m.ConfigRef().toInterface() }
+	before := m.Version()
+
+	if err := m.Move("/todo", 1, "/done", 0); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"todo": []interface{}{"a", "c"}, "done": []interface{}{"b", "x"}}
+	if !equalJSON(config(), want) {
+		t.Errorf("config = %v, want %v", config(), want)
+	}
+	if m.Version() != before+1 {
+		t.Errorf("version = %d, want %d", m.Version(), before+1)
+	}
+	if !reflect.DeepEqual(calls, []string{"remove todo", "insert done"}) {
+		t.Errorf("handlers = %v, want remove then insert", calls)
+	}
+	events := m.History().GetAll()
+	if len(events) != 1 || events[0].Operation != MoveOperation || events[0].Path != "/done/0" || events[0].From != "/todo/1" {
+		t.Errorf("history = %+v, want one move from /todo/1 to /done/0", events)
+	}
+
+	// Within one array the target index counts without the element
+	if err := m.Move("/todo", 0, "/todo", 1); err != nil {
+		t.Fatal(err)
+	}
+	if todo, _ := m.ConfigRef().At("todo"); !equalJSON(todo.toInterface(), []interface{}{"c", "a"}) {
+		t.Errorf("todo = %v, want [c a]", todo.toInterface())
+	}
+
+	for _, tt := range []struct {
+		from      string
+		fromIndex int
+		to        string
+		toIndex   int
+	}{
+		{"/todo", 2, "/done", 0},
+		{"/todo", 0, "/done", 3},
+		{"/todo", 0, "/todo", 2},
+		{"/missing", 0, "/done", 0},
+	} {
+		if err := m.Move(tt.from, tt.fromIndex, tt.to, tt.toIndex); err == nil {
+			t.Errorf("Move(%s, %d, %s, %d) accepted", tt.from, tt.fromIndex, tt.to, tt.toIndex)
+		}
+	}
+
+	// A failed save leaves both arrays as they were
+	before = m.Version()
+	snapshot := config()
+	store.mu.Lock()
+	store.failing = true
+	store.mu.Unlock()
+	if err := m.Move("/todo", 0, "/done", 0); err == nil {
+		t.Error("move accepted although the save failed")
+	}
+	if m.Version() != before || !equalJSON(config(), snapshot) {
+		t.Errorf("after failed save: version %d, config %v, want %d, %v", m.Version(), config(), before, snapshot)
+	}
+}
+
+func TestReplaceIndexModes(t *testing.T) {
+	const config = `{"slots":["a","b"]}`
+
+	strict := newTestManager(t, config)
+	replaceable(t, strict, "slots")
+	if err := strict.ReplaceIndex("/slots", 1, "B"); err != nil {
+		t.Fatal(err)
+	}
+	if slots, _ := strict.ConfigRef().At("slots"); !equalJSON(slots.toInterface(), []interface{}{"a", "B"}) {
+		t.Errorf("slots = %v, want [a B]", slots.toInterface())
+	}
+	before := strict.Version()
+	if err := strict.ReplaceIndex("/slots", 2, "c"); err == nil {
+		t.Error("strict: index past the end accepted")
+	}
+	if err := strict.ReplaceIndex("/slots", -1, "c"); err == nil {
+		t.Error("strict: negative index accepted")
+	}
+	if strict.Version() != before {
+		t.Errorf("version = %d after rejected replaces, want %d", strict.Version(), before)
+	}
+
+	sparse := newTestManager(t, config, WithArrayIndexMode(ArrayIndexSparse))
+	replaceable(t, sparse, "slots")
+	if err := sparse.ReplaceIndex("/slots", 2, "c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sparse.ReplaceIndex("/slots", 5, "f"); err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{"a", "b", "c", nil, nil, "f"}
+	if slots, _ := sparse.ConfigRef().At("slots"); !equalJSON(slots.toInterface(), want) {
+		t.Errorf("slots = %v, want %v", slots.toInterface(), want)
+	}
+
+	// The padded array must pass the schema
+	source, err := NewStrSource(config, `{"type":"object","properties":{"slots":{"type":"array","items":{"type":"string"}}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	typed, err := NewManager(source, WithArrayIndexMode(ArrayIndexSparse))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaceable(t, typed, "slots")
+	if err := typed.ReplaceIndex("/slots", 2, "c"); err != nil {
+		t.Errorf("just past the end: %v", err)
+	}
+	if err := typed.ReplaceIndex("/slots", 4, "e"); err == nil {
+		t.Error("padding with nulls accepted by a schema requiring strings")
+	}
+}
+
+func TestSwapAndReorder(t *testing.T) {
+	m := newTestManager(t, `{"items":[{"id":"a"},{"id":"b"},{"id":"c"}],"tags":["x","y"]}`)
+	items, err := m.ConfigRef().At("items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seen []interface{}
+	if err := m.OnReplace(items, func(n *Node) { seen = append(seen, n.toInterface()) }); err != nil {
+		t.Fatal(err)
+	}
+	// A registration inside an element follows it
+	first, err := items.At(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnReplace(first, nil); err != nil {
+		t.Fatal(err)
+	}
+	tags, err := m.ConfigRef().At("tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(tags, nil); err != nil {
+		t.Fatal(err)
+	}
+	ids := func() []string {
+		var out []string
+		arr, _ := items.GetArray()
+		for _, item := range arr {
+			id, _ := item.GetString("id")
+			out = append(out, id)
+		}
+		return out
+	}
+	before := m.Version()
+
+	if err := m.Swap("/items", 0, 2); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(); !reflect.DeepEqual(got, []string{"c", "b", "a"}) {
+		t.Errorf("after swap = %v, want [c b a]", got)
+	}
+	if err := m.Reorder("/items", []int{1, 2, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(); !reflect.DeepEqual(got, []string{"b", "a", "c"}) {
+		t.Errorf("after reorder = %v, want [b a c]", got)
+	}
+	if m.Version() != before+2 {
+		t.Errorf("version = %d, want %d", m.Version(), before+2)
+	}
+	if len(seen) != 2 {
+		t.Errorf("replace handler called %d times, want 2", len(seen))
+	}
+	var ops []string
+	for _, ev := range m.History().GetAll() {
+		ops = append(ops, ev.Operation+" "+ev.Path)
+	}
+	if !reflect.DeepEqual(ops, []string{"swap /items", "reorder /items"}) {
+		t.Errorf("history = %v, want a swap and a reorder of /items", ops)
+	}
+
+	if err := m.Replace("/items/1", map[string]interface{}{"id": "A"}); err != nil {
+		t.Fatalf("replace of the moved element: %v", err)
+	}
+	if got := ids(); !reflect.DeepEqual(got, []string{"b", "A", "c"}) {
+		t.Errorf("after replace = %v, want [b A c]", got)
+	}
+
+	// Any registration for the array allows reordering it
+	if err := m.Swap("/tags", 0, 1); err != nil {
+		t.Errorf("swap of an insertable array: %v", err)
+	}
+
+	before = m.Version()
+	for _, order := range [][]int{{0, 1}, {0, 1, 1}, {0, 1, 3}, {-1, 0, 1}} {
+		if err := m.Reorder("/items", order); err == nil {
+			t.Errorf("Reorder(%v) accepted", order)
+		}
+	}
+	if err := m.Swap("/items", 0, 3); err == nil {
+		t.Error("swap out of bounds accepted")
+	}
+	if err := m.Reorder("/items", []int{0, 1, 2}); err != nil {
+		t.Errorf("identity order: %v", err)
+	}
+	if m.Version() != before {
+		t.Errorf("version = %d after rejected and no-op reorders, want %d", m.Version(), before)
+	}
+}
+
+func TestReplaceVersionPreconditionIsAtomic(t *testing.T) {
+	m := newTestManager(t, `{"a":0}`)
+	if err := m.OnReplacePath("/a", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every writer was made against the same version, so only one may win
+	const writers = 20
+	version := m.Version()
+	errs := make(chan error, writers)
+	var wg sync.WaitGroup
+	for i := 1; i <= writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- m.replace("/a", i, mutationOptions{version: version})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	won := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			won++
+		case !errors.Is(err, ErrVersionConflict):
+			t.Errorf("replace = %v, want nil or a version conflict", err)
+		}
+	}
+	if won != 1 {
+		t.Errorf("%d writers succeeded, want 1", won)
+	}
+	if m.Version() != version+1 {
+		t.Errorf("version = %d, want %d", m.Version(), version+1)
+	}
+}
+
+func TestWithHandlersSuppressed(t *testing.T) {
+	m := newTestManager(t, `{"list":[],"a":1}`)
+	fired := make(chan string, 10)
+	if err := m.OnInsertPath("/list", func(*Node) { fired <- "insert" }); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnReplacePath("/a", func(*Node) { fired <- "replace" }); err != nil {
+		t.Fatal(err)
+	}
+	version := m.Version()
+
+	err := m.WithHandlersSuppressed(func(s SuppressedScope) error {
+		if err := s.Insert("/list", 0, "x"); err != nil {
+			return err
+		}
+		// Nested scopes and unsuppressed changes made meanwhile are independent
+		err := m.WithHandlersSuppressed(func(inner SuppressedScope) error {
+			return inner.Insert("/list", 1, "y")
+		})
+		if err != nil {
+			return err
+		}
+		return m.Replace("/a", 2)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	close(fired)
+	var calls []string
+	for call := range fired {
+		calls = append(calls, call)
+	}
+	if len(calls) != 1 || calls[0] != "replace" {
+		t.Errorf("handlers fired for %v, want only the unsuppressed replace", calls)
+	}
+
+	if node, _ := m.LookupPath("/list"); !sameJSON(node.toInterface(), []interface{}{"x", "y"}) {
+		t.Errorf("/list = %v, want [x y]", node.toInterface())
+	}
+	if m.Version() != version+3 {
+		t.Errorf("version = %d, want %d", m.Version(), version+3)
+	}
+	if n := len(m.History().GetAll()); n != 3 {
+		t.Errorf("%d history events, want 3", n)
+	}
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+// MergePatchOperation is the Operation of the history events recorded by
+// MergePatch
+const MergePatchOperation = "merge-patch"
+
+// MergePatch applies a JSON Merge Patch (RFC 7396): objects in patch are
+// merged into the config key by key, a null removes the key and any other
+// value, arrays included, replaces what is there.
+//
+// Every change must fall under a replaceable path: each changed value is
+// replaced through the closest registration at or above it, so a patch
+// touching anything that is not replaceable fails. As with Batch, the
+// replaces are applied to a copy of the config, validated against the schema
+// once and persisted in a single write, bumping the version once and
+// recording one "merge-patch" event at "/". If anything fails nothing
+// changes; a patch changing nothing leaves the version alone.
+func (m *Manager) MergePatch(patch interface{}) error {
+	_, err := m.mergePatchData(patch, 0)
+	return err
+}
+
+// mergePatchData is MergePatch with an optional precondition, as for
+// importData: when expectedVersion is non-zero the patch only applies if the
+// config is still at that version. It returns the version the patch left
+// the config at.
+func (m *Manager) mergePatchData(patch interface{}, expectedVersion int64) (int64, error) {
+	patch = plainValue(patch)
+	if patch != nil {
+		var err error
+		if patch, err = cloneJSON(patch); err != nil {
+			return 0, fmt.Errorf("invalid merge patch: %w", err)
+		}
+	}
+
+	m.lockForWrite()
+	defer m.unlockForWrite()
+
+	if expectedVersion != 0 && expectedVersion != m.version {
+		return 0, m.conflictLocked("", expectedVersion, m.version)
+	}
+
+	current, err := cloneJSON(m.source.getConfigObject())
+	if err != nil {
+		return 0, fmt.Errorf("failed to clone config: %w", err)
+	}
+	merged := mergePatch(current, patch)
+
+	targets, err := m.mergeTargetsLocked(diffPointers(m.config, parseNode(merged)), merged)
+	if err != nil {
+		return 0, err
+	}
+	if len(targets) == 0 {
+		return m.version, nil
+	}
+
+	scratch, err := m.stageWithLocked(func(scratch *Manager) error {
+		for _, target := range targets {
+			value, err := jsonValueAt(merged, target)
+			if err != nil {
+				return err
+			}
+			if err := scratch.replace(m.externalPath(target), value, mutationOptions{}); err != nil {
+				return fmt.Errorf("'%s': %w", m.externalPath(target), err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return m.commitStagedLocked(scratch, MergePatchOperation)
+}
+
+// mergeTargetsLocked returns the replaceable paths to replace for the
+// changes to take effect: for each change, the closest path at or above it
+// that is registered replaceable and still exists in merged. Targets under
+// another target are left out.
+func (m *Manager) mergeTargetsLocked(changes []DiffEntry, merged interface{}) ([]string, error) {
+	var targets []string
+	for _, change := range changes {
+		target := ""
+		segments := pointerSegments(change.Path)
+		for i := len(segments); i >= 0 && target == ""; i-- {
+			path := joinPointer(segments[:i])
+			if _, err := m.findModifiableLocked(Replaceable, path); err != nil {
+				continue
+			}
+			if _, err := jsonValueAt(merged, path); err == nil {
+				target = path
+			}
+		}
+		if target == "" {
+			return nil, fmt.Errorf("merge patch changes '%s', which is not replaceable", m.externalPath(change.Path))
+		}
+		targets = append(targets, target)
+	}
+
+	out := make([]string, 0, len(targets))
+	for _, t := range targets {
+		covered := false
+		for _, other := range targets {
+			if other != t && isPathWithin(t, other) {
+				covered = true
+				break
+			}
+		}
+		for _, seen := range out {
+			if seen == t {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+// mergePatch applies patch to target as RFC 7396 describes and returns the
+// result. target is modified in place.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(*orderedmap.OrderedMap)
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(*orderedmap.OrderedMap)
+	if !ok {
+		t = orderedmap.New()
+	}
+	for _, key := range p.Keys() {
+		value, _ := p.Get(key)
+		if value == nil {
+			t.Delete(key)
+			continue
+		}
+		existing, _ := t.Get(key)
+		t.Set(key, mergePatch(existing, value))
+	}
+	return t
+}
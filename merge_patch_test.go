@@ -0,0 +1,168 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMergePatchMergesObjects(t *testing.T) {
+	m := newTestManager(t, `{"server":{"host":"a","port":1,"tags":["x"]},"debug":true,"name":"n"}`)
+	if err := m.OnReplacePath("/server", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnReplacePath("/debug", nil); err != nil {
+		t.Fatal(err)
+	}
+	before := m.Version()
+
+	// Nested keys merge, null removes and arrays are replaced whole
+	err := m.MergePatch(map[string]interface{}{
+		"server": map[string]interface{}{"port": 9090, "host": nil, "tags": []interface{}{"y", "z"}},
+		"debug":  false,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configIs(t, m, `{"server":{"port":9090,"tags":["y","z"]},"debug":false,"name":"n"}`)
+
+	events := m.History().GetAll()
+	if m.Version() != before+1 || len(events) != 1 || events[0].Operation != MergePatchOperation {
+		t.Errorf("version = %d, history = %+v, want %d and one merge-patch event", m.Version(), events, before+1)
+	}
+
+	// A patch changing nothing leaves the version alone
+	if err := m.MergePatch(map[string]interface{}{"server": map[string]interface{}{"port": 9090}}); err != nil {
+		t.Fatal(err)
+	}
+	if m.Version() != before+1 {
+		t.Errorf("version = %d after a no-op patch, want %d", m.Version(), before+1)
+	}
+}
+
+func TestMergePatchRespectsReplaceable(t *testing.T) {
+	source, err := NewStrSource(`{"server":{"port":1},"name":"n"}`,
+		`{"type":"object","properties":{"server":{"type":"object","properties":{"port":{"type":"integer","maximum":100}}}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnReplacePath("/server/port", nil); err != nil {
+		t.Fatal(err)
+	}
+	before := m.Version()
+
+	// Nothing changes when any part of the patch is not replaceable
+	err = m.MergePatch(map[string]interface{}{"server": map[string]interface{}{"port": 2}, "name": "m"})
+	if err == nil || !strings.Contains(err.Error(), "'/name', which is not replaceable") {
+		t.Errorf("patch of /name: err = %v, want it named as not replaceable", err)
+	}
+	// Adding a key under server needs server itself to be replaceable
+	err = m.MergePatch(map[string]interface{}{"server": map[string]interface{}{"host": "h"}})
+	if err == nil || !strings.Contains(err.Error(), "not replaceable") {
+		t.Errorf("new key under /server: err = %v, want not replaceable", err)
+	}
+	// So does removing the registered path
+	if err := m.MergePatch(map[string]interface{}{"server": map[string]interface{}{"port": nil}}); err == nil {
+		t.Error("removal of /server/port accepted")
+	}
+	// The result is validated against the schema
+	if err := m.MergePatch(map[string]interface{}{"server": map[string]interface{}{"port": 200}}); err == nil {
+		t.Error("patch failing the schema accepted")
+	}
+	if configIs(t, m, `{"server":{"port":1},"name":"n"}`) && m.Version() != before {
+		t.Errorf("version = %d after failed patches, want %d", m.Version(), before)
+	}
+
+	if err := m.MergePatch(map[string]interface{}{"server": map[string]interface{}{"port": 2}}); err != nil {
+		t.Fatal(err)
+	}
+	configIs(t, m, `{"server":{"port":2},"name":"n"}`)
+}
+
+func TestMergePatchOverHTTP(t *testing.T) {
+	m := newTestManager(t, `{"server":{"port":1},"name":"n"}`)
+	if err := m.OnReplacePath("/server", nil); err != nil {
+		t.Fatal(err)
+	}
+	before := m.Version()
+
+	code, body := serve(t, m, "PATCH", "/config", `{"server":{"port":9090}}`)
+	data, _ := body["data"].(map[string]interface{})
+	if code != 200 || data["version"] != float64(before+1) {
+		t.Fatalf("status = %d, body = %v, want 200 with version %d", code, body, before+1)
+	}
+	configIs(t, m, `{"server":{"port":9090},"name":"n"}`)
+
+	if code, body := serve(t, m, "PATCH", "/config", `{"name":"m"}`); code != 400 {
+		t.Errorf("patch of /name: status = %d, body = %v, want 400", code, body)
+	}
+	if code, body := serve(t, m, "PATCH", "/config", `{"server":`); code != 400 {
+		t.Errorf("invalid JSON: status = %d, body = %v, want 400", code, body)
+	}
+}
+
+func TestMergePatchVersionPrecondition(t *testing.T) {
+	m := newTestManager(t, `{"a":1,"b":1}`)
+	if err := m.OnReplacePath("/", nil); err != nil {
+		t.Fatal(err)
+	}
+	stale := m.Version()
+	if err := m.MergePatch(map[string]interface{}{"a": 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	code, body := serve(t, m, "PATCH", "/config?version="+jsonString(stale), `{"b":2}`)
+	if code != 409 {
+		t.Fatalf("status = %d, want 409: %v", code, body)
+	}
+	if node, _ := m.LookupPath("/b"); !sameJSON(node.toInterface(), 1) {
+		t.Errorf("/b = %v after a conflict, want 1", node.toInterface())
+	}
+
+	code, body = serve(t, m, "PATCH", "/config?version="+jsonString(stale+1), `{"b":2}`)
+	if code != 200 {
+		t.Fatalf("status = %d, want 200: %v", code, body)
+	}
+	data, _ := body["data"].(map[string]interface{})
+	if data["version"] != float64(stale+2) {
+		t.Errorf("version = %v, want %d", data["version"], stale+2)
+	}
+}
+
+func TestMergePatchVersionPreconditionIsAtomic(t *testing.T) {
+	m := newTestManager(t, `{"a":0}`)
+	if err := m.OnReplacePath("/a", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	const writers = 20
+	version := m.Version()
+	versions := make(chan int64, writers)
+	var wg sync.WaitGroup
+	for i := 1; i <= writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			v, err := m.mergePatchData(map[string]interface{}{"a": i}, version)
+			if err == nil {
+				versions <- v
+			} else if !errors.Is(err, ErrVersionConflict) {
+				t.Errorf("mergePatchData = %v, want nil or a version conflict", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(versions)
+
+	if len(versions) != 1 {
+		t.Fatalf("%d patches applied, want 1", len(versions))
+	}
+	if v := <-versions; v != version+1 {
+		t.Errorf("returned version %d, want %d", v, version+1)
+	}
+}
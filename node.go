@@ -1,8 +1,12 @@
 package config
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
 )
 
 type Node struct {
@@ -21,6 +25,27 @@ const (
 	Array
 )
 
+func (t NodeType) String() string {
+	switch t {
+	case Null:
+		return "null"
+	case Boolean:
+		return "boolean"
+	case Integral:
+		return "integer"
+	case FloatingPoint:
+		return "number"
+	case String:
+		return "string"
+	case Object:
+		return "object"
+	case Array:
+		return "array"
+	default:
+		return "unknown"
+	}
+}
+
 func (n *Node) Type() NodeType {
 	if n == nil {
 		return Null
@@ -123,6 +148,182 @@ func (n *Node) GetFloat(param ...string) (float64, error) {
 	return n.getFloat()
 }
 
+// GetIntLenient is a lenient GetInt: besides numbers it accepts strings
+// holding an integer (e.g. "8080", as found in configs fed from environment
+// variables or forms). Surrounding whitespace is ignored.
+func (n *Node) GetIntLenient(param ...string) (int, error) {
+	if len(param) > 1 {
+		return 0, errors.New("too many arguments: expected 0 or 1")
+	}
+	if len(param) == 1 {
+		sn, err := n.atString(param[0])
+		if err != nil {
+			return 0, err
+		}
+		return sn.GetIntLenient()
+	}
+
+	if str, err := n.getString(); err == nil {
+		v, err := strconv.Atoi(strings.TrimSpace(str))
+		if err != nil {
+			return 0, fmt.Errorf("string %q is not an integer", str)
+		}
+		return v, nil
+	}
+	return n.getInt()
+}
+
+// GetFloatLenient is a lenient GetFloat: besides numbers it accepts strings
+// holding a number (e.g. "0.5"). Surrounding whitespace is ignored.
+func (n *Node) GetFloatLenient(param ...string) (float64, error) {
+	if len(param) > 1 {
+		return 0, errors.New("too many arguments: expected 0 or 1")
+	}
+	if len(param) == 1 {
+		sn, err := n.atString(param[0])
+		if err != nil {
+			return 0, err
+		}
+		return sn.GetFloatLenient()
+	}
+
+	if str, err := n.getString(); err == nil {
+		v, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+		if err != nil {
+			return 0, fmt.Errorf("string %q is not a number", str)
+		}
+		return v, nil
+	}
+	return n.getFloat()
+}
+
+// GetAny returns the node's value as its natural Go type: string, bool,
+// int/int64, float64, nil for null, and []interface{} and
+// map[string]interface{} (converted recursively) for arrays and objects. The
+// result encodes to the same JSON as the node.
+func (n *Node) GetAny(param ...string) (interface{}, error) {
+	if len(param) > 1 {
+		return nil, errors.New("too many arguments: expected 0 or 1")
+	}
+	if len(param) == 1 {
+		sn, err := n.atString(param[0])
+		if err != nil {
+			return nil, err
+		}
+		return sn.getAny()
+	}
+	return n.getAny()
+}
+
+// TryString is GetString reporting failure (missing key, wrong type) as
+// false instead of an error
+func (n *Node) TryString(param ...string) (string, bool) {
+	v, err := n.GetString(param...)
+	return v, err == nil
+}
+
+// TryBool is GetBool reporting failure as false instead of an error
+func (n *Node) TryBool(param ...string) (bool, bool) {
+	v, err := n.GetBool(param...)
+	return v, err == nil
+}
+
+// TryInt is GetInt reporting failure as false instead of an error
+func (n *Node) TryInt(param ...string) (int, bool) {
+	v, err := n.GetInt(param...)
+	return v, err == nil
+}
+
+// TryFloat is GetFloat reporting failure as false instead of an error
+func (n *Node) TryFloat(param ...string) (float64, bool) {
+	v, err := n.GetFloat(param...)
+	return v, err == nil
+}
+
+// GetStringOr returns the string at the optional key, or def when it is
+// missing or not a string
+func (n *Node) GetStringOr(def string, param ...string) string {
+	if v, ok := n.TryString(param...); ok {
+		return v
+	}
+	return def
+}
+
+// GetIntOr returns the number at the optional key as an int, or def when it
+// is missing or not a number
+func (n *Node) GetIntOr(def int, param ...string) int {
+	if v, ok := n.TryInt(param...); ok {
+		return v
+	}
+	return def
+}
+
+// GetBoolOr returns the bool at the optional key, or def when it is missing
+// or not a bool
+func (n *Node) GetBoolOr(def bool, param ...string) bool {
+	if v, ok := n.TryBool(param...); ok {
+		return v
+	}
+	return def
+}
+
+// GetFloatOr returns the number at the optional key, or def when it is
+// missing or not a number
+func (n *Node) GetFloatOr(def float64, param ...string) float64 {
+	if v, ok := n.TryFloat(param...); ok {
+		return v
+	}
+	return def
+}
+
+// IsPresent reports whether the node exists. At and AtPath return a nil node
+// for a missing key, so a key that is absent, one holding null and one
+// holding an empty value can be told apart:
+//
+//	absent:  IsPresent() == false
+//	null:    IsPresent() == true, IsNull() == true
+//	empty:   IsPresent() == true, IsNull() == false, IsEmpty() == true
+func (n *Node) IsPresent() bool {
+	return n != nil
+}
+
+// IsNull reports whether the node exists and holds null. A missing node is
+// not null; see IsPresent.
+func (n *Node) IsNull() bool {
+	return n != nil && n.value == nil
+}
+
+// IsEmpty reports whether the node holds an empty string, array or object.
+// Neither a missing node nor null is empty.
+func (n *Node) IsEmpty() bool {
+	if n == nil {
+		return false
+	}
+
+	switch v := n.value.(type) {
+	case string:
+		return v == ""
+	case map[string]*Node:
+		return len(v) == 0
+	case []*Node:
+		return len(v) == 0
+	default:
+		return false
+	}
+}
+
+// TryObject is GetObject reporting failure as false instead of an error
+func (n *Node) TryObject() (map[string]*Node, bool) {
+	v, err := n.GetObject()
+	return v, err == nil
+}
+
+// TryArray is GetArray reporting failure as false instead of an error
+func (n *Node) TryArray() ([]*Node, bool) {
+	v, err := n.GetArray()
+	return v, err == nil
+}
+
 func (n *Node) getString() (string, error) {
 	value, err := n.get()
 	if err != nil {
@@ -184,6 +385,20 @@ func (n *Node) getFloat() (float64, error) {
 	}
 }
 
+func (n *Node) getAny() (interface{}, error) {
+	if n == nil {
+		return nil, errors.New("node is nil")
+	}
+	if n.value == nil {
+		return nil, nil
+	}
+
+	if _, err := n.get(); err != nil {
+		return nil, err
+	}
+	return n.toInterface(), nil
+}
+
 func (n *Node) GetObject() (map[string]*Node, error) {
 	value, err := n.get()
 	if err != nil {
@@ -254,6 +469,33 @@ func (n *Node) At(param interface{}) (*Node, error) {
 	}
 }
 
+// AtPath returns the node at path below n, such as "/server/ports/0". Paths
+// are JSON Pointers (RFC 6901): "~1" and "~0" stand for '/' and '~' within a
+// key. A trailing slash is ignored; empty segments are rejected. The error
+// names the part of the path that could not be resolved.
+func (n *Node) AtPath(path string) (*Node, error) {
+	if n == nil {
+		return nil, errors.New("node is nil")
+	}
+
+	normalized, err := normalizePath(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := validatePointerEscapes(normalized); err != nil {
+		return nil, err
+	}
+
+	current := n
+	segments := pointerSegments(normalized)
+	for i, segment := range segments {
+		if current, err = current.atSegment(segment); err != nil {
+			return nil, fmt.Errorf("cannot resolve '%s': %w", joinPointer(segments[:i+1]), err)
+		}
+	}
+	return current, nil
+}
+
 // DeepCopy creates a deep copy of the node tree
 func (n *Node) DeepCopy() *Node {
 	if n == nil {
@@ -279,4 +521,255 @@ func (n *Node) DeepCopy() *Node {
 		// Primitive types are safe to copy directly
 		return &Node{value: v}
 	}
-}
\ No newline at end of file
+}
+
+// MarshalJSON encodes the node tree as JSON. Object keys are written in
+// sorted order, since nodes do not keep the order of their keys.
+func (n *Node) MarshalJSON() ([]byte, error) {
+	return json.Marshal(n.toInterface())
+}
+
+// UnmarshalJSON replaces the node's value with the decoded JSON value
+func (n *Node) UnmarshalJSON(data []byte) error {
+	value, err := parseValue(data)
+	if err != nil {
+		return err
+	}
+	n.value = parseNode(value).value
+	return nil
+}
+
+// Decode stores the node in the value pointed to by v, as json.Unmarshal
+// would with the node's JSON, so struct tags are honoured. It fails when the
+// node, or a value inside it, does not fit the target type.
+func (n *Node) Decode(v interface{}) error {
+	if rv := reflect.ValueOf(v); rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("decode target must be a non-nil pointer, got %T", v)
+	}
+
+	data, err := n.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			if typeErr.Field == "" {
+				return fmt.Errorf("cannot decode %v node into %v: %w", n.Type(), typeErr.Type, err)
+			}
+			return fmt.Errorf("cannot decode %s at '%s' into %v: %w", typeErr.Value, typeErr.Field, typeErr.Type, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// DecodePath is Decode for the node at path below n
+func (n *Node) DecodePath(path string, v interface{}) error {
+	target, err := findNodeByPath(n, path)
+	if err != nil {
+		return err
+	}
+	return target.Decode(v)
+}
+
+// toInterface converts the node tree back into plain Go values
+func (n *Node) toInterface() interface{} {
+	if n == nil {
+		return nil
+	}
+
+	switch v := n.value.(type) {
+	case map[string]*Node:
+		obj := make(map[string]interface{}, len(v))
+		for key, node := range v {
+			obj[key] = node.toInterface()
+		}
+		return obj
+
+	case []*Node:
+		arr := make([]interface{}, len(v))
+		for i, node := range v {
+			arr[i] = node.toInterface()
+		}
+		return arr
+
+	default:
+		return v
+	}
+}
+
+// A Node can be built or changed in place with the setters below; the zero
+// Node is null. A setter fails when the node holds a different kind of value
+// (integers and floats are both numbers, and a null node takes any value);
+// the ForceSet variants replace the value whatever it was. Changing nodes of
+// the live tree (Manager.ConfigRef) bypasses validation and persistence, so
+// use the setters on copies or new nodes and hand them to Insert or Replace.
+
+// SetString stores s in the node
+func (n *Node) SetString(s string) error {
+	if err := n.checkSet(String); err != nil {
+		return err
+	}
+	n.value = s
+	return nil
+}
+
+// SetInt stores i in the node
+func (n *Node) SetInt(i int) error {
+	if err := n.checkSet(Integral); err != nil {
+		return err
+	}
+	n.value = i
+	return nil
+}
+
+// SetFloat stores f in the node
+func (n *Node) SetFloat(f float64) error {
+	if err := n.checkSet(FloatingPoint); err != nil {
+		return err
+	}
+	n.value = f
+	return nil
+}
+
+// SetBool stores b in the node
+func (n *Node) SetBool(b bool) error {
+	if err := n.checkSet(Boolean); err != nil {
+		return err
+	}
+	n.value = b
+	return nil
+}
+
+// SetNull makes the node null, whatever it held
+func (n *Node) SetNull() error {
+	if n == nil {
+		return errors.New("node is nil")
+	}
+	n.value = nil
+	return nil
+}
+
+// SetChild stores child under key in an object node, replacing any existing
+// child. A nil child is stored as null.
+func (n *Node) SetChild(key string, child *Node) error {
+	if err := n.checkSet(Object); err != nil {
+		return err
+	}
+	n.setChild(key, child)
+	return nil
+}
+
+// Append adds child at the end of an array node. A nil child is stored as
+// null.
+func (n *Node) Append(child *Node) error {
+	if err := n.checkSet(Array); err != nil {
+		return err
+	}
+	n.append(child)
+	return nil
+}
+
+// ForceSetString is SetString for a node of any type
+func (n *Node) ForceSetString(s string) error {
+	if n == nil {
+		return errors.New("node is nil")
+	}
+	n.value = s
+	return nil
+}
+
+// ForceSetInt is SetInt for a node of any type
+func (n *Node) ForceSetInt(i int) error {
+	if n == nil {
+		return errors.New("node is nil")
+	}
+	n.value = i
+	return nil
+}
+
+// ForceSetFloat is SetFloat for a node of any type
+func (n *Node) ForceSetFloat(f float64) error {
+	if n == nil {
+		return errors.New("node is nil")
+	}
+	n.value = f
+	return nil
+}
+
+// ForceSetBool is SetBool for a node of any type
+func (n *Node) ForceSetBool(b bool) error {
+	if n == nil {
+		return errors.New("node is nil")
+	}
+	n.value = b
+	return nil
+}
+
+// ForceSetChild is SetChild for a node of any type; a node that is not an
+// object becomes an empty object first
+func (n *Node) ForceSetChild(key string, child *Node) error {
+	if n == nil {
+		return errors.New("node is nil")
+	}
+	if n.Type() != Object {
+		n.value = map[string]*Node{}
+	}
+	n.setChild(key, child)
+	return nil
+}
+
+// ForceAppend is Append for a node of any type; a node that is not an array
+// becomes an empty array first
+func (n *Node) ForceAppend(child *Node) error {
+	if n == nil {
+		return errors.New("node is nil")
+	}
+	if n.Type() != Array {
+		n.value = []*Node{}
+	}
+	n.append(child)
+	return nil
+}
+
+// checkSet fails unless a value of type t may be stored in the node
+func (n *Node) checkSet(t NodeType) error {
+	if n == nil {
+		return errors.New("node is nil")
+	}
+
+	current := n.Type()
+	if current == Null || current == t {
+		return nil
+	}
+	if isNumeric(current) && isNumeric(t) {
+		return nil
+	}
+	return fmt.Errorf("cannot set %v on %v node", t, current)
+}
+
+func isNumeric(t NodeType) bool {
+	return t == Integral || t == FloatingPoint
+}
+
+func (n *Node) setChild(key string, child *Node) {
+	if child == nil {
+		child = &Node{}
+	}
+	obj, ok := n.value.(map[string]*Node)
+	if !ok {
+		obj = map[string]*Node{}
+		n.value = obj
+	}
+	obj[key] = child
+}
+
+func (n *Node) append(child *Node) {
+	if child == nil {
+		child = &Node{}
+	}
+	arr, _ := n.value.([]*Node)
+	n.value = append(arr, child)
+}
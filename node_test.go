@@ -0,0 +1,473 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGetAny(t *testing.T) {
+	m := newTestManager(t, `{"s":"x","b":true,"n":null,"f":1.5,"a":[1,"two",[false]],"o":{"k":{"v":null}}}`)
+	root := m.Config()
+
+	tests := []struct {
+		key  string
+		want interface{}
+	}{
+		{"s", "x"},
+		{"b", true},
+		{"n", nil},
+		{"f", 1.5},
+		{"a", []interface{}{1, "two", []interface{}{false}}},
+		{"o", map[string]interface{}{"k": map[string]interface{}{"v": nil}}},
+	}
+	for _, tt := range tests {
+		got, err := root.GetAny(tt.key)
+		if err != nil {
+			t.Errorf("GetAny(%q): %v", tt.key, err)
+			continue
+		}
+		if reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+			t.Errorf("GetAny(%q) = %T, want %T", tt.key, got, tt.want)
+		}
+		gotJSON, _ := json.Marshal(got)
+		wantJSON, _ := json.Marshal(tt.want)
+		if string(gotJSON) != string(wantJSON) {
+			t.Errorf("GetAny(%q) = %s, want %s", tt.key, gotJSON, wantJSON)
+		}
+	}
+
+	whole, err := root.GetAny()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := whole.(map[string]interface{}); !ok {
+		t.Errorf("GetAny() = %T, want map[string]interface{}", whole)
+	}
+
+	if _, err := root.GetAny("missing"); err == nil {
+		t.Error("GetAny(missing) succeeded")
+	}
+}
+
+func TestTryAccessors(t *testing.T) {
+	m := newTestManager(t, `{"name":"svc","port":8080,"debug":true,"ratio":0.5,"tags":["a"],"db":{}}`)
+	root := m.Config()
+
+	if v, ok := root.TryString("name"); !ok || v != "svc" {
+		t.Errorf("TryString(name) = %q, %v", v, ok)
+	}
+	if v, ok := root.TryInt("port"); !ok || v != 8080 {
+		t.Errorf("TryInt(port) = %d, %v", v, ok)
+	}
+	if v, ok := root.TryBool("debug"); !ok || !v {
+		t.Errorf("TryBool(debug) = %v, %v", v, ok)
+	}
+	if v, ok := root.TryFloat("ratio"); !ok || v != 0.5 {
+		t.Errorf("TryFloat(ratio) = %v, %v", v, ok)
+	}
+
+	// Absent keys and wrong types both report false
+	if _, ok := root.TryString("missing"); ok {
+		t.Error("TryString(missing) reported ok")
+	}
+	if _, ok := root.TryString("port"); ok {
+		t.Error("TryString(port) reported ok for a number")
+	}
+	if _, ok := root.TryInt("name"); ok {
+		t.Error("TryInt(name) reported ok for a string")
+	}
+	if _, ok := root.TryBool("name"); ok {
+		t.Error("TryBool(name) reported ok for a string")
+	}
+
+	tags, _ := root.At("tags")
+	if v, ok := tags.TryArray(); !ok || len(v) != 1 {
+		t.Errorf("TryArray() = %v, %v", v, ok)
+	}
+	if _, ok := tags.TryObject(); ok {
+		t.Error("TryObject() reported ok for an array")
+	}
+
+	if node, ok := m.LookupPath("/tags/0"); !ok {
+		t.Error("LookupPath(/tags/0) not found")
+	} else if s, _ := node.TryString(); s != "a" {
+		t.Errorf("LookupPath(/tags/0) = %q, want %q", s, "a")
+	}
+	if _, ok := m.LookupPath("/tags/1"); ok {
+		t.Error("LookupPath(/tags/1) reported ok")
+	}
+}
+
+func TestLenientNumbers(t *testing.T) {
+	m := newTestManager(t, `{"port":8080,"portStr":" 8080 ","ratio":0.5,"ratioStr":"0.5","name":"svc","flag":true}`)
+	root := m.Config()
+
+	for _, key := range []string{"port", "portStr"} {
+		if v, err := root.GetIntLenient(key); err != nil || v != 8080 {
+			t.Errorf("GetIntLenient(%s) = %d, %v, want 8080", key, v, err)
+		}
+	}
+	for _, key := range []string{"ratio", "ratioStr"} {
+		if v, err := root.GetFloatLenient(key); err != nil || v != 0.5 {
+			t.Errorf("GetFloatLenient(%s) = %v, %v, want 0.5", key, v, err)
+		}
+	}
+	for _, key := range []string{"name", "flag", "ratioStr", "missing"} {
+		if v, err := root.GetIntLenient(key); err == nil {
+			t.Errorf("GetIntLenient(%s) = %d, want an error", key, v)
+		}
+	}
+	if _, err := root.GetFloatLenient("name"); err == nil {
+		t.Error("GetFloatLenient(name) succeeded")
+	}
+
+	// The strict getters are unchanged
+	if _, err := root.GetInt("portStr"); err == nil {
+		t.Error("GetInt(portStr) accepted a string")
+	}
+}
+
+func TestGettersWithDefaults(t *testing.T) {
+	m := newTestManager(t, `{"name":"svc","port":8080,"debug":true,"ratio":0.5}`)
+	root := m.Config()
+
+	// Present and correctly typed
+	if v := root.GetStringOr("def", "name"); v != "svc" {
+		t.Errorf("GetStringOr(name) = %q", v)
+	}
+	if v := root.GetIntOr(1, "port"); v != 8080 {
+		t.Errorf("GetIntOr(port) = %d", v)
+	}
+	if v := root.GetBoolOr(false, "debug"); !v {
+		t.Errorf("GetBoolOr(debug) = %v", v)
+	}
+	if v := root.GetFloatOr(1, "ratio"); v != 0.5 {
+		t.Errorf("GetFloatOr(ratio) = %v", v)
+	}
+
+	// Absent
+	if v := root.GetStringOr("def", "missing"); v != "def" {
+		t.Errorf("GetStringOr(missing) = %q", v)
+	}
+	if v := root.GetIntOr(7, "missing"); v != 7 {
+		t.Errorf("GetIntOr(missing) = %d", v)
+	}
+	if v := root.GetBoolOr(true, "missing"); !v {
+		t.Errorf("GetBoolOr(missing) = %v", v)
+	}
+	if v := root.GetFloatOr(2.5, "missing"); v != 2.5 {
+		t.Errorf("GetFloatOr(missing) = %v", v)
+	}
+
+	// Wrong type
+	if v := root.GetStringOr("def", "port"); v != "def" {
+		t.Errorf("GetStringOr(port) = %q", v)
+	}
+	if v := root.GetIntOr(7, "name"); v != 7 {
+		t.Errorf("GetIntOr(name) = %d", v)
+	}
+	if v := root.GetBoolOr(true, "name"); !v {
+		t.Errorf("GetBoolOr(name) = %v", v)
+	}
+	if v := root.GetFloatOr(2.5, "debug"); v != 2.5 {
+		t.Errorf("GetFloatOr(debug) = %v", v)
+	}
+
+	// Without a key the node itself is read
+	port, _ := root.At("port")
+	if v := port.GetIntOr(1); v != 8080 {
+		t.Errorf("port.GetIntOr() = %d", v)
+	}
+}
+
+func TestNodeSetters(t *testing.T) {
+	var n Node
+	if err := n.SetInt(1); err != nil {
+		t.Fatalf("SetInt on null: %v", err)
+	}
+	if err := n.SetFloat(1.5); err != nil {
+		t.Errorf("SetFloat on a number: %v", err)
+	}
+	if err := n.SetString("x"); err == nil {
+		t.Error("SetString on a number succeeded")
+	}
+	if err := n.ForceSetString("x"); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := n.GetString(); s != "x" {
+		t.Errorf("value = %v, want x", n.value)
+	}
+	if err := n.Append(&Node{}); err == nil {
+		t.Error("Append on a string succeeded")
+	}
+	if err := n.SetNull(); err != nil || n.Type() != Null {
+		t.Errorf("SetNull: %v, type %v", err, n.Type())
+	}
+
+	obj := &Node{}
+	if err := obj.SetChild("name", &Node{value: "svc"}); err != nil {
+		t.Fatal(err)
+	}
+	tags := &Node{}
+	if err := tags.ForceAppend(&Node{value: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tags.Append(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.SetChild("tags", tags); err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.SetBool(true); err == nil {
+		t.Error("SetBool on an object succeeded")
+	}
+
+	got, _ := json.Marshal(obj.toInterface())
+	if want := `{"name":"svc","tags":["a",null]}`; string(got) != want {
+		t.Errorf("built node = %s, want %s", got, want)
+	}
+}
+
+func TestInsertAndReplaceAcceptNodes(t *testing.T) {
+	m := newTestManager(t, `{"db":{"host":"a"},"list":[]}`)
+	replaceable(t, m, "db")
+	list, err := m.ConfigRef().At("list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(list, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	db := &Node{}
+	db.SetChild("host", &Node{value: "b"})
+	db.SetChild("port", &Node{value: 5432})
+	if err := m.Replace("/db", db); err != nil {
+		t.Fatal(err)
+	}
+
+	item := &Node{}
+	item.SetChild("name", &Node{value: "x"})
+	if err := m.Insert("/list", 0, item); err != nil {
+		t.Fatal(err)
+	}
+	// The manager keeps its own copy
+	item.SetChild("name", &Node{value: "changed"})
+
+	want := map[string]interface{}{
+		"db":   map[string]interface{}{"host": "b", "port": 5432},
+		"list": []interface{}{map[string]interface{}{"name": "x"}},
+	}
+	if got := m.Config().toInterface(); !equalJSON(got, want) {
+		t.Errorf("config = %v, want %v", got, want)
+	}
+	if err := m.VerifyConsistency(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNodeJSONRoundTrip(t *testing.T) {
+	const doc = `{"a":{"b":[1,2.5,-3e2,null,true,"s"],"c":{}},"d":[],"e":null,"f":[[{"g":"h"}]]}`
+
+	var n Node
+	if err := json.Unmarshal([]byte(doc), &n); err != nil {
+		t.Fatal(err)
+	}
+	if n.Type() != Object {
+		t.Fatalf("type = %v, want object", n.Type())
+	}
+	if v, err := n.GetAny("e"); err != nil || v != nil {
+		t.Errorf("e = %v, %v, want null", v, err)
+	}
+	a, _ := n.At("a")
+	b, _ := a.At("b")
+	items, _ := b.GetArray()
+	if len(items) != 6 {
+		t.Fatalf("a.b has %d items, want 6", len(items))
+	}
+	if v, err := items[0].GetInt(); err != nil || v != 1 {
+		t.Errorf("a.b[0] = %v, %v, want 1", v, err)
+	}
+	if v, err := items[1].GetFloat(); err != nil || v != 2.5 {
+		t.Errorf("a.b[1] = %v, %v, want 2.5", v, err)
+	}
+
+	out, err := json.Marshal(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, want interface{}
+	json.Unmarshal(out, &got)
+	json.Unmarshal([]byte(doc), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip = %s, want %s", out, doc)
+	}
+
+	// Nodes nested in other values marshal too
+	wrapped, err := json.Marshal(map[string]interface{}{"node": b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"node":[1,2.5,-300,null,true,"s"]}`; string(wrapped) != want {
+		t.Errorf("wrapped = %s, want %s", wrapped, want)
+	}
+
+	if err := json.Unmarshal([]byte(`{"a":`), &n); err == nil {
+		t.Error("truncated JSON accepted")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	m := newTestManager(t, `{"servers":[{"host":"a","port":80,"tls":{"enabled":true}},{"host":"b","port":"http"}]}`)
+	root := m.ConfigRef()
+
+	type tlsConf struct {
+		Enabled bool `json:"enabled"`
+	}
+	type server struct {
+		Host string   `json:"host"`
+		Port int      `json:"port"`
+		TLS  *tlsConf `json:"tls,omitempty"`
+	}
+
+	var s server
+	if err := root.DecodePath("/servers/0", &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Host != "a" || s.Port != 80 || s.TLS == nil || !s.TLS.Enabled {
+		t.Errorf("servers[0] = %+v", s)
+	}
+
+	var bad server
+	err := root.DecodePath("/servers/1", &bad)
+	if err == nil || !strings.Contains(err.Error(), "'port'") {
+		t.Errorf("string port decoded into int: %v", err)
+	}
+
+	var list []server
+	err = root.DecodePath("/servers/0/host", &list)
+	if err == nil || !strings.Contains(err.Error(), "string node") {
+		t.Errorf("string decoded into slice: %v", err)
+	}
+
+	if err := root.Decode(s); err == nil {
+		t.Error("non-pointer target accepted")
+	}
+	if err := root.DecodePath("/missing", &s); err == nil {
+		t.Error("missing path decoded")
+	}
+}
+
+func TestAtPath(t *testing.T) {
+	m := newTestManager(t, `{"server":{"ports":[80,443],"a/b":{"c~d":"x"}},"none":null}`)
+	root := m.ConfigRef()
+
+	for _, tt := range []struct {
+		path string
+		want interface{}
+	}{
+		{"/server/ports/0", 80},
+		{"/server/ports/1/", 443},
+		{"/server/a~1b/c~0d", "x"},
+		{"/none", nil},
+	} {
+		n, err := root.AtPath(tt.path)
+		if err != nil {
+			t.Errorf("AtPath(%q): %v", tt.path, err)
+			continue
+		}
+		if got, _ := json.Marshal(n); !equalJSON(json.RawMessage(got), tt.want) {
+			t.Errorf("AtPath(%q) = %s, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	if n, err := root.AtPath("/"); err != nil || n != root {
+		t.Errorf("AtPath(/) = %v, %v, want the root", n, err)
+	}
+
+	for _, tt := range []struct {
+		path, mention string
+	}{
+		{"/server//ports", ""},
+		{"/server/ports/2", "/server/ports/2"},
+		{"/server/ports/x", "/server/ports/x"},
+		{"/server/missing/0", "/server/missing"},
+		{"/none/x", "/none/x"},
+		{"/server/a~2b", ""},
+	} {
+		_, err := root.AtPath(tt.path)
+		if err == nil {
+			t.Errorf("AtPath(%q) succeeded", tt.path)
+			continue
+		}
+		if tt.mention != "" && !strings.Contains(err.Error(), "'"+tt.mention+"'") {
+			t.Errorf("AtPath(%q) error %q does not name %s", tt.path, err, tt.mention)
+		}
+	}
+}
+
+func TestPresentNullEmpty(t *testing.T) {
+	m := newTestManager(t, `{"null":null,"str":"","arr":[],"obj":{},"zero":0,"no":false,"full":{"k":"v"},"list":[null,""]}`)
+	root := m.ConfigRef()
+
+	at := func(path string) *Node {
+		n, _ := root.AtPath(path)
+		return n
+	}
+	tests := []struct {
+		path                   string
+		present, null, isEmpty bool
+	}{
+		{"/missing", false, false, false},
+		{"/full/missing", false, false, false},
+		{"/list/5", false, false, false},
+		{"/null", true, true, false},
+		{"/list/0", true, true, false},
+		{"/str", true, false, true},
+		{"/list/1", true, false, true},
+		{"/arr", true, false, true},
+		{"/obj", true, false, true},
+		{"/zero", true, false, false},
+		{"/no", true, false, false},
+		{"/full", true, false, false},
+		{"/full/k", true, false, false},
+	}
+	for _, tt := range tests {
+		n := at(tt.path)
+		if n.IsPresent() != tt.present || n.IsNull() != tt.null || n.IsEmpty() != tt.isEmpty {
+			t.Errorf("%s: present %v, null %v, empty %v, want %v, %v, %v",
+				tt.path, n.IsPresent(), n.IsNull(), n.IsEmpty(), tt.present, tt.null, tt.isEmpty)
+		}
+	}
+
+	// At tells the three apart the same way
+	for key, want := range map[string][3]bool{
+		"missing": {false, false, false},
+		"null":    {true, true, false},
+		"obj":     {true, false, true},
+	} {
+		n, _ := root.At(key)
+		if got := [3]bool{n.IsPresent(), n.IsNull(), n.IsEmpty()}; got != want {
+			t.Errorf("At(%q): present, null, empty = %v, want %v", key, got, want)
+		}
+	}
+	list, err := root.At("list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range [][3]bool{{true, true, false}, {true, false, true}, {false, false, false}} {
+		n, _ := list.At(i)
+		if got := [3]bool{n.IsPresent(), n.IsNull(), n.IsEmpty()}; got != want {
+			t.Errorf("list.At(%d): present, null, empty = %v, want %v", i, got, want)
+		}
+	}
+
+	// A path running through a null or a missing key leads to an absent node
+	for _, path := range []string{"/null/k", "/missing/k", "/list/0/k", "/list/5/k"} {
+		if n := at(path); n.IsPresent() {
+			t.Errorf("%s: present, want absent", path)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ManagerOption customises a Manager at construction time
+type ManagerOption func(*Manager)
+
+// WithMaxConcurrentHandlers bounds how many modification handlers may run at
+// the same time. Invocations beyond the limit wait until a running handler
+// returns. A handler that itself mutates the config holds its slot while the
+// nested handler waits, so n must leave room for such chains.
+func WithMaxConcurrentHandlers(n int) ManagerOption {
+	return func(m *Manager) {
+		if n > 0 {
+			m.handlerSlots = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithHandlerTimeout abandons modification handlers that run longer than d,
+// unless their registration sets its own timeout. See HandlerOptions.
+func WithHandlerTimeout(d time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.handlerTimeout = d
+	}
+}
+
+// WithModifiableDropHandler sets fn to be called whenever a registered
+// modifiable is dropped because its node disappeared after a change, reload
+// or import. op is "insert", "remove" or "replace". fn runs with the manager
+// lock held and must not call back into the Manager.
+func WithModifiableDropHandler(fn func(path string, op string)) ManagerOption {
+	return func(m *Manager) {
+		m.onModifiableDropped = fn
+	}
+}
+
+// WithQueryLimits bounds the cost of Query and FindAll on the manager
+func WithQueryLimits(limits QueryLimits) ManagerOption {
+	return func(m *Manager) {
+		m.queryLimits = limits
+	}
+}
+
+// WithFIFOWrites makes mutations take the manager lock in the order they
+// were called, so that under concurrent writes none waits indefinitely and
+// the history records changes in submission order. Without it the order in
+// which waiting writers proceed is unspecified. Reads are unaffected: they
+// keep sharing the read lock between writes. A write keeps its turn while
+// its handlers, path subscribers and the validation service run with the
+// lock released, so no later write gets in before it is done; these callbacks
+// must therefore not modify the manager themselves, or they wait for a turn
+// that only comes after their own write.
+func WithFIFOWrites(enabled bool) ManagerOption {
+	return func(m *Manager) {
+		if enabled {
+			m.writeQueue = newWriteQueue()
+		} else {
+			m.writeQueue = nil
+		}
+	}
+}
+
+// WithUnvalidatedPaths exempts the subtrees matching patterns (query syntax,
+// e.g. "/metadata" or "/plugins/*/settings") from schema validation, for
+// free-form data the schema would reject, such as arbitrary keys where
+// additionalProperties is false. The subtrees are left out of the document
+// only while it is validated; they are stored and persisted as they are.
+// The schema must not require an exempted key. Custom validators (see
+// AddValidator) still run for them. Sources that validate on their own, such
+// as FileSource.Watch, do not know about the exemption.
+func WithUnvalidatedPaths(patterns ...string) ManagerOption {
+	return func(m *Manager) {
+		for _, expr := range patterns {
+			pattern, err := parseQuery(expr)
+			if err != nil {
+				m.optionErr = fmt.Errorf("invalid unvalidated path '%s': %w", expr, err)
+				return
+			}
+			m.unvalidated = append(m.unvalidated, pattern)
+		}
+	}
+}
+
+// WithHistorySize sets how many change events the history keeps. Defaults
+// to 100.
+func WithHistorySize(n int) ManagerOption {
+	return func(m *Manager) {
+		if n > 0 {
+			m.historySize = n
+		}
+	}
+}
+
+// WithHistoryCoalescing collapses consecutive replaces of the same path that
+// happen within window of each other into one history event, so bursts of
+// updates do not evict older events. Off by default.
+func WithHistoryCoalescing(window time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.historyWindow = window
+	}
+}
+
+// WithArrayIndexMode sets how ReplaceIndex treats an index past the end of
+// the array. Defaults to ArrayIndexStrict.
+func WithArrayIndexMode(mode ArrayIndexMode) ManagerOption {
+	return func(m *Manager) {
+		m.arrayIndexMode = mode
+	}
+}
+
+// WithCaseInsensitiveKeys makes paths passed to the manager (and so to the
+// HTTP API) match object keys regardless of case, resolving to the key as
+// stored; the stored keys are never rewritten. A key that matches several
+// stored keys differing only in case is rejected as ambiguous.
+func WithCaseInsensitiveKeys(enabled bool) ManagerOption {
+	return func(m *Manager) {
+		m.caseInsensitiveKeys = enabled
+	}
+}
+
+// WithJSONPointerPaths makes the manager exchange paths as RFC 6901 JSON
+// Pointers: in paths it is given, "~1" and "~0" stand for '/' and '~' within a
+// key, and the paths it reports (in errors, events, history and over HTTP)
+// escape keys the same way. Without it, keys are used verbatim, so a key
+// containing '/' cannot be addressed.
+func WithJSONPointerPaths(enabled bool) ManagerOption {
+	return func(m *Manager) {
+		m.pointerPaths = enabled
+	}
+}
+
+// WithValidateOnRead makes reads revalidate the config against the schema, to
+// catch corruption that write-time validation cannot see. Query, ReadConfig,
+// LookupPath and the HTTP reads fail while the config is invalid; Config
+// logs the error. The result is cached per version and source document, so
+// only the first read after a change pays for the validation.
+func WithValidateOnRead(enabled bool) ManagerOption {
+	return func(m *Manager) {
+		m.validateOnRead = enabled
+	}
+}
+
+// WithLogger routes the manager's logging to logger instead of
+// slog.Default()
+func WithLogger(logger *slog.Logger) ManagerOption {
+	return func(m *Manager) {
+		m.logger = logger
+	}
+}
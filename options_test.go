@@ -0,0 +1,101 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+)
+
+func TestLoggerReceivesHandlerFailures(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	m := newTestManager(t, `{"a":1}`, WithLogger(logger))
+	node, err := m.ConfigRef().At("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing := func(*Node) error { return errors.New("downstream unavailable") }
+	if err := m.OnReplaceWithOptions(node, failing, HandlerOptions{BestEffort: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Replace("/a", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("log output %q: %v", buf.String(), err)
+	}
+	want := map[string]interface{}{
+		"level": "WARN",
+		"msg":   "best-effort handler failed",
+		"op":    "replace",
+		"path":  "/a",
+		"error": "downstream unavailable",
+	}
+	for key, value := range want {
+		if record[key] != value {
+			t.Errorf("log field %s = %v, want %v", key, record[key], value)
+		}
+	}
+}
+
+func TestJSONPointerPaths(t *testing.T) {
+	m := newTestManager(t, `{"a/b":{"c~d":1}}`, WithJSONPointerPaths(true))
+	parent, err := m.ConfigRef().At("a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := parent.At("c~d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnReplace(node, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	const pointer = "/a~1b/c~0d"
+	if paths := m.getReplaceablePaths(); len(paths) != 1 || paths[0] != pointer {
+		t.Errorf("replaceable paths = %v, want [%s]", paths, pointer)
+	}
+
+	var seen string
+	if err := m.OnPathReplace("/*/*", func(path string, _, _ *Node) { seen = path }); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Replace(pointer, 2); err != nil {
+		t.Fatal(err)
+	}
+	if seen != pointer {
+		t.Errorf("event path = %q, want %q", seen, pointer)
+	}
+
+	// The reported path feeds straight back into the manager
+	events := m.History().GetAll()
+	if len(events) != 1 || events[0].Path != pointer {
+		t.Fatalf("history = %+v, want one event at %s", events, pointer)
+	}
+	if err := m.Replace(events[0].Path, 3); err != nil {
+		t.Errorf("replace at reported path: %v", err)
+	}
+
+	if err := m.Replace("/a~1b/c~d", 4); err == nil {
+		t.Error("invalid escape accepted")
+	}
+}
+
+func TestPlainPathsKeepTildes(t *testing.T) {
+	m := newTestManager(t, `{"c~d":1}`)
+	replaceable(t, m, "c~d")
+
+	if err := m.Replace("/c~d", 2); err != nil {
+		t.Fatal(err)
+	}
+	if events := m.History().GetAll(); len(events) != 1 || events[0].Path != "/c~d" {
+		t.Errorf("history = %+v, want one event at /c~d", events)
+	}
+}
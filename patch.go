@@ -0,0 +1,210 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+// ErrPatchTestFailed is returned by Patch when a "test" operation finds a
+// different value than expected
+var ErrPatchTestFailed = errors.New("patch test failed")
+
+// JSONPatchOperation is one operation of a JSON Patch (RFC 6902). Path and
+// From are JSON Pointers, whatever WithJSONPointerPaths says.
+type JSONPatchOperation struct {
+	Op    string      `json:"op"` // add, remove, replace, move, copy or test
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`  // move and copy only
+	Value interface{} `json:"value,omitempty"` // add, replace and test only
+}
+
+// PatchOperation is the Operation of the history events recorded by Patch
+const PatchOperation = "patch"
+
+// Patch applies a JSON Patch atomically, as Batch does: the operations are
+// applied in order to a copy of the config, the result is validated against
+// the schema once and persisted in a single write, bumping the version once
+// and recording one "patch" event at "/". If any operation fails, including
+// a failed "test" (ErrPatchTestFailed), nothing changes.
+//
+// Operations map onto the registered modifications: add into an array is an
+// insert ("-" appends), remove from an array a remove, and replace a
+// replace, each needing the matching registration. Adding or removing an
+// object member replaces the object, which must be replaceable. move and
+// copy are a remove and an add, and an add.
+func (m *Manager) Patch(ops []JSONPatchOperation) error {
+	m.lockForWrite()
+	defer m.unlockForWrite()
+
+	scratch, err := m.stageWithLocked(func(scratch *Manager) error {
+		for i, op := range ops {
+			if err := scratch.applyPatch(op); err != nil {
+				return fmt.Errorf("operation %d (%s '%s'): %w", i, op.Op, op.Path, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	_, err = m.commitStagedLocked(scratch, PatchOperation)
+	return err
+}
+
+// applyPatch applies one patch operation on a scratch manager
+func (m *Manager) applyPatch(op JSONPatchOperation) error {
+	switch op.Op {
+	case "add":
+		return m.patchAdd(op.Path, op.Value)
+
+	case "remove":
+		return m.patchRemove(op.Path)
+
+	case "replace":
+		return m.replace(m.patchPath(op.Path), op.Value, mutationOptions{})
+
+	case "move":
+		if isPathWithin(op.Path, op.From) && op.Path != op.From {
+			return fmt.Errorf("cannot move '%s' into itself", op.From)
+		}
+		value, err := m.patchValue(op.From)
+		if err != nil {
+			return err
+		}
+		if err := m.patchRemove(op.From); err != nil {
+			return err
+		}
+		return m.patchAdd(op.Path, value)
+
+	case "copy":
+		value, err := m.patchValue(op.From)
+		if err != nil {
+			return err
+		}
+		return m.patchAdd(op.Path, value)
+
+	case "test":
+		value, err := m.patchValue(op.Path)
+		if err != nil {
+			return err
+		}
+		if len(DiffNodes(parseNode(value), parseNode(op.Value))) > 0 {
+			return fmt.Errorf("%w: the value at '%s' differs", ErrPatchTestFailed, op.Path)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported patch operation: %s", op.Op)
+	}
+}
+
+func (m *Manager) patchAdd(ptr string, value interface{}) error {
+	parent, key, container, err := m.patchParent(ptr)
+	if err != nil {
+		return err
+	}
+	if parent == "" {
+		return m.replace("/", value, mutationOptions{})
+	}
+
+	switch c := container.(type) {
+	case []interface{}:
+		index := len(c)
+		if key != "-" {
+			if index, err = patchIndex(key); err != nil {
+				return err
+			}
+		}
+		return m.insert(m.patchPath(parent), index, value, mutationOptions{})
+
+	case *orderedmap.OrderedMap:
+		if _, exists := c.Get(key); exists {
+			return m.replace(m.patchPath(ptr), value, mutationOptions{})
+		}
+		c.Set(key, value)
+		return m.replace(m.patchPath(parent), c, mutationOptions{})
+
+	default:
+		return fmt.Errorf("'%s' is not an object or array", parent)
+	}
+}
+
+func (m *Manager) patchRemove(ptr string) error {
+	parent, key, container, err := m.patchParent(ptr)
+	if err != nil {
+		return err
+	}
+	if parent == "" {
+		return fmt.Errorf("cannot remove the root")
+	}
+
+	switch c := container.(type) {
+	case []interface{}:
+		index, err := patchIndex(key)
+		if err != nil {
+			return err
+		}
+		return m.remove(m.patchPath(parent), index, mutationOptions{})
+
+	case *orderedmap.OrderedMap:
+		if _, exists := c.Get(key); !exists {
+			return fmt.Errorf("key '%s' not found in '%s'", key, parent)
+		}
+		c.Delete(key)
+		return m.replace(m.patchPath(parent), c, mutationOptions{})
+
+	default:
+		return fmt.Errorf("'%s' is not an object or array", parent)
+	}
+}
+
+// patchParent splits a patch pointer into its parent's pointer and last key
+// and returns a copy of the parent's current value. parent is empty for the
+// root.
+func (m *Manager) patchParent(ptr string) (parent, key string, container interface{}, err error) {
+	segments := pointerSegments(ptr)
+	if len(segments) == 0 {
+		return "", "", nil, nil
+	}
+
+	parent = joinPointer(segments[:len(segments)-1])
+	container, err = m.patchValue(parent)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return parent, segments[len(segments)-1], container, nil
+}
+
+// patchValue returns a copy of the current value at a patch pointer
+func (m *Manager) patchValue(ptr string) (interface{}, error) {
+	if ptr == "" {
+		ptr = "/"
+	}
+	value, err := jsonValueAt(m.source.getConfigObject(), ptr)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, nil
+	}
+	return cloneJSON(value)
+}
+
+// patchPath turns a patch pointer into a path as the manager takes it
+func (m *Manager) patchPath(ptr string) string {
+	if ptr == "" {
+		return "/"
+	}
+	return m.externalPath(ptr)
+}
+
+func patchIndex(key string) (int, error) {
+	index, err := strconv.Atoi(key)
+	if err != nil || index < 0 || strconv.Itoa(index) != key {
+		return 0, fmt.Errorf("invalid array index '%s'", key)
+	}
+	return index, nil
+}
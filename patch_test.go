@@ -0,0 +1,146 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// newPatchManager returns a manager whose list takes inserts and removes and
+// whose name and obj take replaces
+func newPatchManager(t *testing.T) *Manager {
+	t.Helper()
+
+	m := newTestManager(t, `{"name":"x","list":[1,2,3],"obj":{"a":1}}`)
+	if err := m.OnInsertPath("/list", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnRemovePath("/list", nil); err != nil {
+		t.Fatal(err)
+	}
+	replaceable(t, m, "name")
+	replaceable(t, m, "obj")
+	return m
+}
+
+// configIs reports whether the config of m is the JSON document want
+func configIs(t *testing.T, m *Manager, want string) bool {
+	t.Helper()
+
+	got, err := json.Marshal(m.ConfigRef())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameDoc(string(got), want) {
+		t.Errorf("config = %s, want %s", got, want)
+		return false
+	}
+	return true
+}
+
+func TestPatchAppliesOperations(t *testing.T) {
+	m := newPatchManager(t)
+	before := m.Version()
+
+	err := m.Patch([]JSONPatchOperation{
+		{Op: "test", Path: "/name", Value: "x"},
+		{Op: "add", Path: "/list/-", Value: 4},
+		{Op: "add", Path: "/list/0", Value: 0},
+		{Op: "remove", Path: "/list/2"},
+		{Op: "replace", Path: "/name", Value: "y"},
+		{Op: "add", Path: "/obj/b", Value: 2},
+		{Op: "remove", Path: "/obj/a"},
+		{Op: "copy", From: "/name", Path: "/obj/c"},
+		{Op: "move", From: "/list/0", Path: "/list/-"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configIs(t, m, `{"name":"y","list":[1,3,4,0],"obj":{"b":2,"c":"y"}}`)
+
+	if m.Version() != before+1 {
+		t.Errorf("version = %d, want %d", m.Version(), before+1)
+	}
+	events := m.History().GetAll()
+	if len(events) != 1 || events[0].Operation != PatchOperation || events[0].Path != "/" {
+		t.Errorf("history = %+v, want one patch event at /", events)
+	}
+}
+
+func TestPatchIsAtomic(t *testing.T) {
+	m := newPatchManager(t)
+	before := m.Version()
+
+	// A failed test rejects the whole patch
+	err := m.Patch([]JSONPatchOperation{
+		{Op: "replace", Path: "/name", Value: "y"},
+		{Op: "test", Path: "/obj", Value: map[string]interface{}{"a": 2}},
+	})
+	if !errors.Is(err, ErrPatchTestFailed) || !strings.Contains(err.Error(), "operation 1") {
+		t.Errorf("failed test: err = %v, want ErrPatchTestFailed at operation 1", err)
+	}
+
+	// So does an operation the registrations do not allow
+	err = m.Patch([]JSONPatchOperation{
+		{Op: "add", Path: "/list/0", Value: 0},
+		{Op: "remove", Path: "/name"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "operation 1 (remove '/name')") {
+		t.Errorf("remove of a root member: err = %v, want operation 1 named", err)
+	}
+
+	for _, op := range []JSONPatchOperation{
+		{Op: "add", Path: "/list/9", Value: 0},
+		{Op: "remove", Path: "/obj/missing"},
+		{Op: "move", From: "/obj", Path: "/obj/inner"},
+		{Op: "add", Path: "/list/01", Value: 0},
+		{Op: "frobnicate", Path: "/name"},
+	} {
+		if err := m.Patch([]JSONPatchOperation{op}); err == nil {
+			t.Errorf("%+v: no error", op)
+		}
+	}
+
+	if configIs(t, m, `{"name":"x","list":[1,2,3],"obj":{"a":1}}`) && m.Version() != before {
+		t.Errorf("version = %d after failed patches, want %d", m.Version(), before)
+	}
+}
+
+func TestPatchOverHTTP(t *testing.T) {
+	m := newPatchManager(t)
+	before := m.Version()
+
+	code, body := serve(t, m, "POST", "/config/patch",
+		`[{"op":"add","path":"/list/-","value":4},{"op":"replace","path":"/name","value":"y"}]`)
+	data, _ := body["data"].(map[string]interface{})
+	if code != 200 || data["version"] != float64(before+1) {
+		t.Fatalf("status = %d, body = %v, want 200 with version %d", code, body, before+1)
+	}
+	configIs(t, m, `{"name":"y","list":[1,2,3,4],"obj":{"a":1}}`)
+
+	code, body = serve(t, m, "POST", "/config/patch", `[{"op":"test","path":"/name","value":"x"}]`)
+	if code != 409 {
+		t.Errorf("failed test: status = %d, body = %v, want 409", code, body)
+	}
+
+	for _, bad := range []string{
+		`{"op":"add","path":"/list/-","value":4}`,
+		`[{"op":"add","path":"/list/-"}]`,
+		`[{"op":"move","path":"/list/-"}]`,
+		`[{"op":"replace","path":"name","value":"z"}]`,
+		`[{"op":"frobnicate","path":"/name"}]`,
+		`[1]`,
+	} {
+		if code, body := serve(t, m, "POST", "/config/patch", bad); code != 400 {
+			t.Errorf("%s: status = %d, body = %v, want 400", bad, code, body)
+		}
+	}
+
+	if code, _ := serve(t, m, "GET", "/config/patch", ""); code != 405 {
+		t.Errorf("GET: status = %d, want 405", code)
+	}
+	if m.Version() != before+1 {
+		t.Errorf("version = %d, want %d", m.Version(), before+1)
+	}
+}
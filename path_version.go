@@ -0,0 +1,219 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrVersionConflict is returned by the conditional modifications when the
+// affected subtree changed since the version the caller read
+var ErrVersionConflict = errors.New("version conflict")
+
+// ConflictError is the ErrVersionConflict returned when a version
+// precondition fails. Path is the path of the change, empty for a change to
+// the whole config; the versions are the config's rather than the path's when
+// the precondition was on the config's version. When Path exists,
+// CurrentValue holds its value as of CurrentVersion, taken under the same
+// lock, and HasCurrentValue is set, so a null value can be told from a path
+// that is gone. The HTTP server fills in Operation for its 409 responses.
+type ConflictError struct {
+	Path            string      `json:"path,omitempty"`
+	Operation       string      `json:"operation,omitempty"`
+	ExpectedVersion int64       `json:"your_version"`
+	CurrentVersion  int64       `json:"current_version"`
+	CurrentValue    interface{} `json:"current_value,omitempty"`
+	HasCurrentValue bool        `json:"-"`
+
+	configVersion bool // the precondition was on the config's version
+}
+
+func (e *ConflictError) Error() string {
+	if e.Path == "" || e.configVersion {
+		return fmt.Sprintf("%s: expected version %d, current %d", ErrVersionConflict, e.ExpectedVersion, e.CurrentVersion)
+	}
+	return fmt.Sprintf("%s: '%s' is at version %d, expected %d", ErrVersionConflict, e.Path, e.CurrentVersion, e.ExpectedVersion)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return ErrVersionConflict
+}
+
+// optimisticAttempts bounds how often OptimisticUpdate tries
+const optimisticAttempts = 5
+
+// PathVersion returns the version at which the subtree at path last changed:
+// a change to the node, anything below it or any node above it counts, as
+// does an insert or remove in the array holding it (which shifts indices).
+// Unlike Version it does not move when unrelated paths change, so it can be
+// passed to the *IfPathVersion methods to avoid false conflicts between
+// editors of disjoint parts of the config.
+func (m *Manager) PathVersion(path string) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	path, err := m.resolvePathLocked(path)
+	if err != nil {
+		return 0, err
+	}
+	return m.pathVersionLocked(path), nil
+}
+
+// InsertIfPathVersion is Insert, failing with ErrVersionConflict unless the
+// array at path is still at pathVersion
+func (m *Manager) InsertIfPathVersion(path string, index int, value interface{}, pathVersion int64) error {
+	return m.insert(path, index, value, mutationOptions{pathVersion: pathVersion})
+}
+
+// RemoveIfPathVersion is Remove, failing with ErrVersionConflict unless the
+// array at path is still at pathVersion
+func (m *Manager) RemoveIfPathVersion(path string, index int, pathVersion int64) error {
+	return m.remove(path, index, mutationOptions{pathVersion: pathVersion})
+}
+
+// ReplaceIfPathVersion is Replace, failing with ErrVersionConflict unless the
+// node at path is still at pathVersion
+func (m *Manager) ReplaceIfPathVersion(path string, value interface{}, pathVersion int64) error {
+	return m.replace(path, value, mutationOptions{pathVersion: pathVersion})
+}
+
+// CompareAndSwap replaces the node at path with value if the subtree is
+// still at pathVersion, as returned by PathVersion, and fails with a
+// *ConflictError otherwise
+func (m *Manager) CompareAndSwap(path string, pathVersion int64, value interface{}) error {
+	if pathVersion < 1 {
+		// 0 would mean no precondition to ReplaceIfPathVersion
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+
+		resolved, err := m.resolvePathLocked(path)
+		if err != nil {
+			return err
+		}
+		return m.conflictLocked(resolved, pathVersion, m.pathVersionLocked(resolved))
+	}
+	return m.ReplaceIfPathVersion(path, value, pathVersion)
+}
+
+// OptimisticUpdate replaces the node at path with what fn computes from a
+// copy of its current value, without holding the lock while fn runs: if the
+// subtree changes in the meantime fn is called again on the new value, up to
+// 5 times, after which the *ConflictError is returned. An error from fn
+// aborts the update and is returned as is.
+func (m *Manager) OptimisticUpdate(path string, fn func(current *Node) (interface{}, error)) error {
+	return RetryOnConflict(optimisticAttempts, func() error {
+		current, pathVersion, err := m.lookupWithPathVersion(path)
+		if err != nil {
+			return err
+		}
+
+		value, err := fn(current)
+		if err != nil {
+			return err
+		}
+		return m.ReplaceIfPathVersion(path, value, pathVersion)
+	})
+}
+
+// RetryOnConflict calls fn until it returns anything but an
+// ErrVersionConflict, at most attempts times, and returns its last error
+func RetryOnConflict(attempts int, fn func() error) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = fn(); !errors.Is(err, ErrVersionConflict) {
+			return err
+		}
+	}
+	return err
+}
+
+// lookupWithPathVersion returns a detached copy of the node at path together
+// with the subtree's version
+func (m *Manager) lookupWithPathVersion(path string) (*Node, int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	resolved, err := m.resolvePathLocked(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	node, err := findNodeByPath(m.config, resolved)
+	if err != nil {
+		return nil, 0, err
+	}
+	return node.DeepCopy(), m.pathVersionLocked(resolved), nil
+}
+
+// checkPreconditionsLocked checks the version preconditions of opts for a
+// change at path
+func (m *Manager) checkPreconditionsLocked(path string, opts mutationOptions) error {
+	if opts.version != 0 && opts.version != m.version {
+		conflict := m.conflictLocked(path, opts.version, m.version)
+		conflict.configVersion = true
+		return conflict
+	}
+	return m.checkPathVersionLocked(path, opts.pathVersion)
+}
+
+// checkPathVersionLocked fails unless expected is 0 (no precondition) or the
+// current version of path
+func (m *Manager) checkPathVersionLocked(path string, expected int64) error {
+	if expected == 0 {
+		return nil
+	}
+	if current := m.pathVersionLocked(path); current != expected {
+		return m.conflictLocked(path, expected, current)
+	}
+	return nil
+}
+
+// conflictLocked builds the ConflictError for a failed precondition at the
+// internal path, "" for the whole config, carrying the value there
+func (m *Manager) conflictLocked(path string, expected, current int64) *ConflictError {
+	conflict := &ConflictError{ExpectedVersion: expected, CurrentVersion: current}
+	if path == "" {
+		return conflict
+	}
+
+	conflict.Path = m.externalPath(path)
+	if node, err := findNodeByPath(m.config, path); err == nil {
+		conflict.CurrentValue = node.toInterface()
+		conflict.HasCurrentValue = true
+	}
+	return conflict
+}
+
+func (m *Manager) pathVersionLocked(path string) int64 {
+	var version int64
+	for changed, v := range m.pathVersions {
+		if v > version && (isPathWithin(path, changed) || isPathWithin(changed, path)) {
+			version = v
+		}
+	}
+	return version
+}
+
+// touchPathLocked records that the subtree at path changed at the current
+// version. Entries below path are folded into it since they can no longer
+// raise any path's version above it.
+func (m *Manager) touchPathLocked(path string) {
+	if path == "/" || m.pathVersions == nil {
+		m.pathVersions = map[string]int64{"/": m.version}
+		return
+	}
+
+	for changed := range m.pathVersions {
+		if isPathWithin(changed, path) {
+			delete(m.pathVersions, changed)
+		}
+	}
+	m.pathVersions[path] = m.version
+}
+
+// isPathWithin reports whether path is ancestor itself or lies below it
+func isPathWithin(path, ancestor string) bool {
+	if ancestor == "/" || path == ancestor {
+		return true
+	}
+	return strings.HasPrefix(path, ancestor+"/")
+}
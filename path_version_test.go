@@ -0,0 +1,194 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestDisjointPathsDoNotConflict(t *testing.T) {
+	m := newTestManager(t, `{"db":{"host":"a"},"cache":{"size":1},"list":[]}`)
+	replaceable(t, m, "db")
+	replaceable(t, m, "cache")
+
+	dbVersion, err := m.PathVersion("/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheVersion, err := m.PathVersion("/cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another editor changes /cache; /db is untouched
+	if err := m.ReplaceIfPathVersion("/cache", map[string]interface{}{"size": 2}, cacheVersion); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ReplaceIfPathVersion("/db", map[string]interface{}{"host": "b"}, dbVersion); err != nil {
+		t.Errorf("replace of /db after a change to /cache: %v", err)
+	}
+
+	// The first editor's view of /cache is now stale
+	err = m.ReplaceIfPathVersion("/cache", map[string]interface{}{"size": 3}, cacheVersion)
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("stale replace of /cache: err = %v, want ErrVersionConflict", err)
+	}
+
+	if v, _ := m.PathVersion("/db/host"); v != m.Version() {
+		t.Errorf("/db/host version = %d, want %d after its parent changed", v, m.Version())
+	}
+	if v, _ := m.PathVersion("/cache"); v != m.Version()-1 {
+		t.Errorf("/cache version = %d, want %d", v, m.Version()-1)
+	}
+}
+
+func TestPathVersionOverHTTP(t *testing.T) {
+	m := newTestManager(t, `{"a":1,"b":1}`)
+	replaceable(t, m, "a")
+	replaceable(t, m, "b")
+
+	code, body := serve(t, m, "GET", "/config/value?path=/a", "")
+	data, _ := body["data"].(map[string]interface{})
+	version, ok := data["path_version"].(float64)
+	if code != 200 || !ok {
+		t.Fatalf("GET /a: status = %d, data = %v, want a path_version", code, data)
+	}
+
+	if err := m.Replace("/b", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	req := fmt.Sprintf(`{"op":"replace","path":"/a","value":2,"path_version":%d}`, int64(version))
+	if code, body := serve(t, m, "POST", "/config", req); code != 200 {
+		t.Errorf("replace /a after a change to /b: status = %d, want 200: %v", code, body)
+	}
+	if code, body := serve(t, m, "POST", "/config", req); code != 409 {
+		t.Errorf("replace /a at a stale path version: status = %d, want 409: %v", code, body)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	m := newTestManager(t, `{"counter":1,"other":1}`)
+	replaceable(t, m, "counter")
+	replaceable(t, m, "other")
+
+	stale, err := m.PathVersion("/counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Replace("/counter", 2); err != nil {
+		t.Fatal(err)
+	}
+	current, _ := m.PathVersion("/counter")
+
+	err = m.CompareAndSwap("/counter", stale, 3)
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("stale CompareAndSwap: err = %v, want a *ConflictError", err)
+	}
+	if conflict.Path != "/counter" || conflict.ExpectedVersion != stale || conflict.CurrentVersion != current {
+		t.Errorf("conflict = %+v, want path /counter, expected %d, current %d", conflict, stale, current)
+	}
+	if v, _ := m.ConfigRef().GetInt("counter"); v != 2 {
+		t.Errorf("counter = %d after a failed swap, want 2", v)
+	}
+
+	// An unrelated change does not invalidate the version
+	if err := m.Replace("/other", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CompareAndSwap("/counter", current, 3); err != nil {
+		t.Fatalf("CompareAndSwap at the current version: %v", err)
+	}
+	if v, _ := m.ConfigRef().GetInt("counter"); v != 3 {
+		t.Errorf("counter = %d, want 3", v)
+	}
+
+	// 0 is never a valid version to compare against
+	if err := m.CompareAndSwap("/counter", 0, 4); !errors.As(err, &conflict) {
+		t.Errorf("CompareAndSwap at version 0: err = %v, want a *ConflictError", err)
+	}
+}
+
+func TestOptimisticUpdateRetries(t *testing.T) {
+	m := newTestManager(t, `{"counter":1}`)
+	replaceable(t, m, "counter")
+
+	// The first attempt races with another writer and must be redone on
+	// the value that writer left
+	calls := 0
+	err := m.OptimisticUpdate("/counter", func(current *Node) (interface{}, error) {
+		calls++
+		v, err := current.GetInt()
+		if err != nil {
+			return nil, err
+		}
+		if calls == 1 {
+			if err := m.Replace("/counter", 10); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return v + 1, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := m.ConfigRef().GetInt("counter"); calls != 2 || v != 11 {
+		t.Errorf("counter = %d after %d calls, want 11 after 2", v, calls)
+	}
+
+	// A writer that always wins exhausts the attempts
+	calls = 0
+	err = m.OptimisticUpdate("/counter", func(current *Node) (interface{}, error) {
+		calls++
+		if err := m.Replace("/counter", calls); err != nil {
+			t.Fatal(err)
+		}
+		return 0, nil
+	})
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || calls != optimisticAttempts {
+		t.Errorf("contended update: err = %v after %d calls, want a *ConflictError after %d", err, calls, optimisticAttempts)
+	}
+
+	// An error from fn is not retried
+	boom := errors.New("boom")
+	calls = 0
+	err = m.OptimisticUpdate("/counter", func(current *Node) (interface{}, error) {
+		calls++
+		return nil, boom
+	})
+	if err != boom || calls != 1 {
+		t.Errorf("failing fn: err = %v after %d calls, want boom after 1", err, calls)
+	}
+}
+
+func TestRetryOnConflictStopsOnOtherErrors(t *testing.T) {
+	calls := 0
+	err := RetryOnConflict(3, func() error {
+		calls++
+		if calls == 1 {
+			return &ConflictError{ExpectedVersion: 1, CurrentVersion: 2}
+		}
+		return errors.New("other")
+	})
+	if err == nil || err.Error() != "other" || calls != 2 {
+		t.Errorf("err = %v after %d calls, want other after 2", err, calls)
+	}
+}
+
+func TestImportConflictError(t *testing.T) {
+	m := newTestManager(t, `{"a":1}`)
+
+	err := m.importData([]byte(`{"a":2}`), m.Version()+1)
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("err = %v, want a *ConflictError", err)
+	}
+	if conflict.Path != "" || conflict.CurrentVersion != m.Version() {
+		t.Errorf("conflict = %+v, want no path and current version %d", conflict, m.Version())
+	}
+	if want := fmt.Sprintf("version conflict: expected version %d, current %d", m.Version()+1, m.Version()); err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+}
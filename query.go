@@ -0,0 +1,328 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// QueryResult is a node matched by a query together with its concrete path
+type QueryResult struct {
+	Path string `json:"path"`
+	Node *Node  `json:"node"`
+}
+
+// ErrQueryTruncated is returned together with the partial results when a
+// query hits one of the configured QueryLimits
+var ErrQueryTruncated = errors.New("query truncated: limit exceeded")
+
+// QueryLimits bounds the cost of a query. Zero fields mean no limit.
+type QueryLimits struct {
+	MaxResults      int // results returned
+	MaxNodesVisited int // nodes examined while evaluating the query
+}
+
+// queryBudget tracks a query's cost against its limits
+type queryBudget struct {
+	limits    QueryLimits
+	visited   int
+	truncated bool
+}
+
+// visit accounts for one examined node and reports whether the walk may go on
+func (b *queryBudget) visit() bool {
+	if b.truncated {
+		return false
+	}
+	b.visited++
+	if b.limits.MaxNodesVisited > 0 && b.visited > b.limits.MaxNodesVisited {
+		b.truncated = true
+		return false
+	}
+	return true
+}
+
+// accept reports whether another result fits
+func (b *queryBudget) accept(count int) bool {
+	if b.limits.MaxResults > 0 && count >= b.limits.MaxResults {
+		b.truncated = true
+		return false
+	}
+	return true
+}
+
+type segmentKind int
+
+const (
+	segmentName segmentKind = iota // object key, or array index when numeric
+	segmentIndex
+	segmentWildcard
+)
+
+type querySegment struct {
+	kind      segmentKind
+	name      string
+	index     int
+	recursive bool // segment was preceded by "//" and matches at any depth
+}
+
+// parseQuery splits a query expression into segments. Supported forms:
+//
+//	/db/port        object keys (numeric segments also index arrays)
+//	/users/[0]      explicit array index
+//	/users/*/name   every child of an object or array ([*] is equivalent)
+//	//name          the key at any depth below the current position
+func parseQuery(expr string) ([]querySegment, error) {
+	if expr == "" || expr[0] != '/' {
+		return nil, errors.New("query must start with '/'")
+	}
+
+	segments := make([]querySegment, 0)
+	recursive := false
+
+	parts := strings.Split(expr[1:], "/")
+	for i, part := range parts {
+		if part == "" {
+			if i == len(parts)-1 {
+				break // trailing slash
+			}
+			if recursive {
+				return nil, errors.New("invalid query: '///' is not allowed")
+			}
+			recursive = true
+			continue
+		}
+
+		seg := querySegment{kind: segmentName, name: part, recursive: recursive}
+		recursive = false
+
+		switch {
+		case part == "*" || part == "[*]":
+			seg.kind = segmentWildcard
+		case strings.HasPrefix(part, "[") && strings.HasSuffix(part, "]"):
+			index, err := strconv.Atoi(part[1 : len(part)-1])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid query segment '%s'", part)
+			}
+			seg.kind = segmentIndex
+			seg.index = index
+		}
+
+		segments = append(segments, seg)
+	}
+
+	if recursive {
+		return nil, errors.New("invalid query: '//' must be followed by a segment")
+	}
+
+	return segments, nil
+}
+
+// Query evaluates expr against the node and returns every match in a stable
+// order (object keys sorted, array elements by index). The returned nodes are
+// part of the receiver's tree.
+func (n *Node) Query(expr string) ([]QueryResult, error) {
+	results, _, err := n.QueryWithLimits(expr, QueryLimits{})
+	return results, err
+}
+
+// QueryWithLimits is Query with bounded cost; truncated reports whether a
+// limit was hit. Results beyond MaxResults are dropped. Hitting
+// MaxNodesVisited stops evaluation, so the results may then be incomplete or
+// even empty.
+func (n *Node) QueryWithLimits(expr string, limits QueryLimits) (results []QueryResult, truncated bool, err error) {
+	segments, err := parseQuery(expr)
+	if err != nil {
+		return nil, false, err
+	}
+
+	budget := &queryBudget{limits: limits}
+	results = executeQuery(n, segments, budget)
+	return results, budget.truncated, nil
+}
+
+func executeQuery(root *Node, segments []querySegment, budget *queryBudget) []QueryResult {
+	current := []QueryResult{{Path: "", Node: root}}
+
+	for _, seg := range segments {
+		candidates := current
+		if seg.recursive {
+			candidates = collectDescendants(current, budget)
+		}
+
+		next := make([]QueryResult, 0)
+		for _, c := range candidates {
+			if !budget.visit() {
+				break
+			}
+			next = appendSegmentMatches(next, c, seg)
+		}
+		current = next
+	}
+
+	if budget.limits.MaxResults > 0 && len(current) > budget.limits.MaxResults {
+		current = current[:budget.limits.MaxResults]
+		budget.truncated = true
+	}
+
+	for i := range current {
+		if current[i].Path == "" {
+			current[i].Path = "/"
+		}
+	}
+	return current
+}
+
+func appendSegmentMatches(out []QueryResult, c QueryResult, seg querySegment) []QueryResult {
+	switch c.Node.Type() {
+	case Object:
+		obj, _ := c.Node.GetObject()
+		switch seg.kind {
+		case segmentWildcard:
+			for _, key := range sortedKeys(obj) {
+				out = append(out, QueryResult{Path: c.Path + "/" + key, Node: obj[key]})
+			}
+		case segmentName:
+			if child, ok := obj[seg.name]; ok {
+				out = append(out, QueryResult{Path: c.Path + "/" + seg.name, Node: child})
+			}
+		}
+
+	case Array:
+		arr, _ := c.Node.GetArray()
+		switch seg.kind {
+		case segmentWildcard:
+			for i, child := range arr {
+				out = append(out, QueryResult{Path: c.Path + "/" + strconv.Itoa(i), Node: child})
+			}
+		case segmentIndex, segmentName:
+			index := seg.index
+			if seg.kind == segmentName {
+				var err error
+				if index, err = strconv.Atoi(seg.name); err != nil {
+					return out
+				}
+			}
+			if index >= 0 && index < len(arr) {
+				out = append(out, QueryResult{Path: c.Path + "/" + strconv.Itoa(index), Node: arr[index]})
+			}
+		}
+	}
+
+	return out
+}
+
+// collectDescendants returns the given nodes and all of their descendants,
+// each node at most once, stopping early when the budget runs out
+func collectDescendants(roots []QueryResult, budget *queryBudget) []QueryResult {
+	seen := make(map[*Node]bool)
+	out := make([]QueryResult, 0, len(roots))
+
+	var walk func(r QueryResult)
+	walk = func(r QueryResult) {
+		if seen[r.Node] || !budget.visit() {
+			return
+		}
+		seen[r.Node] = true
+		out = append(out, r)
+
+		children := appendSegmentMatches(nil, r, querySegment{kind: segmentWildcard})
+		for _, child := range children {
+			walk(child)
+		}
+	}
+
+	for _, r := range roots {
+		walk(r)
+	}
+	return out
+}
+
+// FindAll returns every node in the tree rooted at n (n included) for which
+// match returns true. Object keys are visited in sorted order and array
+// elements by index, so repeated calls over the same tree return the same
+// results in the same order.
+func (n *Node) FindAll(match func(path string, node *Node) bool) []QueryResult {
+	results, _ := n.FindAllWithLimits(match, QueryLimits{})
+	return results
+}
+
+// FindAllWithLimits is FindAll with bounded cost. Once a limit is hit the
+// walk stops and the matches found so far are returned with truncated set.
+func (n *Node) FindAllWithLimits(match func(path string, node *Node) bool, limits QueryLimits) (results []QueryResult, truncated bool) {
+	out := make([]QueryResult, 0)
+	budget := &queryBudget{limits: limits}
+	if match != nil {
+		findAllRecursive(n, "", match, &out, budget)
+	}
+	return out, budget.truncated
+}
+
+func findAllRecursive(node *Node, path string, match func(string, *Node) bool, out *[]QueryResult, budget *queryBudget) {
+	if !budget.visit() {
+		return
+	}
+
+	current := path
+	if current == "" {
+		current = "/"
+	}
+	if match(current, node) {
+		if !budget.accept(len(*out)) {
+			return
+		}
+		*out = append(*out, QueryResult{Path: current, Node: node})
+	}
+
+	switch node.Type() {
+	case Object:
+		obj, _ := node.GetObject()
+		for _, key := range sortedKeys(obj) {
+			findAllRecursive(obj[key], path+"/"+key, match, out, budget)
+		}
+	case Array:
+		arr, _ := node.GetArray()
+		for i, child := range arr {
+			findAllRecursive(child, path+"/"+strconv.Itoa(i), match, out, budget)
+		}
+	}
+}
+
+// matchesPath reports whether the concrete path (e.g. "/users/3/email") is
+// matched by the parsed query pattern
+func matchesPath(pattern []querySegment, path string) bool {
+	return matchSegments(pattern, splitPath(path))
+}
+
+func matchSegments(pattern []querySegment, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+
+	seg := pattern[0]
+	if seg.recursive {
+		for i := range parts {
+			if segmentMatches(seg, parts[i]) && matchSegments(pattern[1:], parts[i+1:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(parts) == 0 {
+		return false
+	}
+	return segmentMatches(seg, parts[0]) && matchSegments(pattern[1:], parts[1:])
+}
+
+func segmentMatches(seg querySegment, part string) bool {
+	switch seg.kind {
+	case segmentWildcard:
+		return true
+	case segmentIndex:
+		return part == strconv.Itoa(seg.index)
+	default:
+		return part == seg.name
+	}
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindAllStableOrder(t *testing.T) {
+	m := newTestManager(t, `{"zeta":{"port":1},"alpha":{"port":2},"mid":[{"port":3},{"port":4}],"beta":{"port":5}}`)
+
+	paths := func() []string {
+		var out []string
+		for _, res := range m.FindAll(func(path string, node *Node) bool {
+			return node.Type() != Object && node.Type() != Array
+		}) {
+			out = append(out, res.Path)
+		}
+		return out
+	}
+
+	want := []string{"/alpha/port", "/beta/port", "/mid/0/port", "/mid/1/port", "/zeta/port"}
+	for i := 0; i < 20; i++ {
+		if got := paths(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("call %d: paths = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestQueryAbsentNullEmpty(t *testing.T) {
+	m := newTestManager(t, `{"a":{"v":null},"b":{"v":""},"c":{"v":[]},"d":{}}`)
+	results, err := m.Query("/*/v")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Null and empty values are found; the absent one under d is not
+	var got []string
+	for _, res := range results {
+		got = append(got, fmt.Sprintf("%s %v %v", res.Path, res.Node.IsNull(), res.Node.IsEmpty()))
+	}
+	want := []string{"/a/v true false", "/b/v false true", "/c/v false true"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("results = %v, want %v", got, want)
+	}
+}
+
+// largeConfig returns an object with n entries, each holding a nested object
+func largeConfig(n int) string {
+	var b strings.Builder
+	b.WriteString("{")
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, `"k%d":{"name":"n%d","tags":[1,2]}`, i, i)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func TestQueryLimitsTruncate(t *testing.T) {
+	config := largeConfig(500)
+
+	m := newTestManager(t, config, WithQueryLimits(QueryLimits{MaxResults: 10}))
+	results, err := m.Query("//*")
+	if !errors.Is(err, ErrQueryTruncated) || len(results) != 10 {
+		t.Errorf("MaxResults: %d results, err = %v, want 10 and ErrQueryTruncated", len(results), err)
+	}
+	if got := m.FindAll(func(string, *Node) bool { return true }); len(got) != 10 {
+		t.Errorf("MaxResults: FindAll returned %d results, want 10", len(got))
+	}
+
+	m = newTestManager(t, config, WithQueryLimits(QueryLimits{MaxNodesVisited: 100}))
+	if _, err := m.Query("//name"); !errors.Is(err, ErrQueryTruncated) {
+		t.Errorf("MaxNodesVisited: err = %v, want ErrQueryTruncated", err)
+	}
+	_, truncated := m.Config().FindAllWithLimits(func(string, *Node) bool { return false },
+		QueryLimits{MaxNodesVisited: 100})
+	if !truncated {
+		t.Error("MaxNodesVisited: FindAllWithLimits was not truncated")
+	}
+
+	m = newTestManager(t, config)
+	results, err = m.Query("//name")
+	if err != nil || len(results) != 500 {
+		t.Errorf("no limits: %d results, err = %v, want 500", len(results), err)
+	}
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timeNow is the clock rate limits are measured with
+var timeNow = time.Now
+
+// RateLimitError rejects a change made sooner than SetChangeRateLimit allows
+type RateLimitError struct {
+	Path        string    // the limited path that changed too recently
+	Pattern     string    // the limit's pattern
+	NextAllowed time.Time // when the path may change again
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("'%s' changed too recently (limit '%s'), next change allowed at %s",
+		e.Path, e.Pattern, e.NextAllowed.Format(time.RFC3339Nano))
+}
+
+type changeRateLimit struct {
+	expr     string
+	pattern  []querySegment
+	interval time.Duration
+}
+
+// SetChangeRateLimit rejects changes at or below a path matching pathPattern
+// (a query expression such as "/servers/*") made less than minInterval after
+// the previous change there, with a *RateLimitError telling when the next
+// change is allowed. It applies to inserts, removes, replaces and the
+// operations built on them (moves, swaps and reorders); whole-config changes
+// such as Import, Batch, Patch, MergePatch, Reload, Restore and RevertTo are
+// not limited but count as changes of every path they touch. Replacing an
+// ancestor of a limited path is not limited either. A minInterval of zero or
+// less removes the limit set for pathPattern.
+func (m *Manager) SetChangeRateLimit(pathPattern string, minInterval time.Duration) error {
+	pattern, err := parseQuery(pathPattern)
+	if err != nil {
+		return fmt.Errorf("invalid rate limit pattern: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	limits := make([]changeRateLimit, 0, len(m.rateLimits)+1)
+	for _, l := range m.rateLimits {
+		if l.expr != pathPattern {
+			limits = append(limits, l)
+		}
+	}
+	if minInterval > 0 {
+		limits = append(limits, changeRateLimit{expr: pathPattern, pattern: pattern, interval: minInterval})
+	}
+	m.rateLimits = limits
+	return nil
+}
+
+// checkRateLimitLocked rejects a change at the external path when it or one
+// of its ancestors is rate limited and changed too recently
+func (m *Manager) checkRateLimitLocked(path string) error {
+	now := timeNow()
+	for _, prefix := range pathPrefixes(path) {
+		for _, l := range m.rateLimits {
+			if !matchesPath(l.pattern, prefix) {
+				continue
+			}
+			last, ok := m.lastChanges[prefix]
+			if ok && now.Sub(last) < l.interval {
+				return &RateLimitError{Path: prefix, Pattern: l.expr, NextAllowed: last.Add(l.interval)}
+			}
+		}
+	}
+	return nil
+}
+
+// noteChangeLocked records a change at the external path for the rate limits
+// covering it or its ancestors
+func (m *Manager) noteChangeLocked(path string) {
+	if len(m.rateLimits) == 0 {
+		return
+	}
+
+	now := timeNow()
+	for _, prefix := range pathPrefixes(path) {
+		for _, l := range m.rateLimits {
+			if matchesPath(l.pattern, prefix) {
+				if m.lastChanges == nil {
+					m.lastChanges = make(map[string]time.Time)
+				}
+				m.lastChanges[prefix] = now
+				break
+			}
+		}
+	}
+}
+
+// pathPrefixes returns path and its ancestors, root first
+func pathPrefixes(path string) []string {
+	segments := splitPath(path)
+	prefixes := make([]string, 0, len(segments)+1)
+	for i := 0; i <= len(segments); i++ {
+		prefixes = append(prefixes, "/"+strings.Join(segments[:i], "/"))
+	}
+	return prefixes
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChangeRateLimit(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	t.Cleanup(func() { timeNow = time.Now })
+
+	m := newTestManager(t, `{"a":1,"b":1}`)
+	for _, path := range []string{"/a", "/b"} {
+		if err := m.OnReplacePath(path, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.SetChangeRateLimit("/a", 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Replace("/a", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(5 * time.Second)
+	err := m.Replace("/a", 3)
+	var limited *RateLimitError
+	if !errors.As(err, &limited) {
+		t.Fatalf("second change = %v, want a *RateLimitError", err)
+	}
+	if want := now.Add(5 * time.Second); !limited.NextAllowed.Equal(want) {
+		t.Errorf("NextAllowed = %v, want %v", limited.NextAllowed, want)
+	}
+	if err := m.Replace("/b", 2); err != nil {
+		t.Errorf("unlimited path: %v", err)
+	}
+
+	now = now.Add(5 * time.Second)
+	if err := m.Replace("/a", 3); err != nil {
+		t.Errorf("change after the interval: %v", err)
+	}
+
+	// Over HTTP a limited change is answered with 429
+	if code, body := serve(t, m, "POST", "/config", `{"op":"replace","path":"/a","value":4}`); code != 429 {
+		t.Errorf("limited change over HTTP: status = %d, want 429: %v", code, body)
+	}
+}
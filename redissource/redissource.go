@@ -0,0 +1,141 @@
+// Package redissource keeps a config document in a Redis key shared by
+// several instances. Writes are optimistic and every write is announced on a
+// channel so the other instances reload. Redis is reached through the small
+// Client interface, so any client library (or a fake) can be plugged in
+// without this module depending on it.
+package redissource
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	config "github.com/majiddarvishan/config_manager"
+)
+
+// ErrTxFailed must be returned (possibly wrapped) by Client.SetIfUnchanged
+// when the key was modified by someone else
+var ErrTxFailed = errors.New("redis transaction failed")
+
+// Client is the subset of Redis used by the source
+type Client interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// SetIfUnchanged sets key to value if it still holds old, typically as
+	// WATCH key, GET key, MULTI, SET key value, EXEC
+	SetIfUnchanged(ctx context.Context, key string, old, value []byte) error
+
+	Publish(ctx context.Context, channel, message string) error
+
+	// Subscribe delivers the messages published on channel until ctx is
+	// done, then closes the returned channel
+	Subscribe(ctx context.Context, channel string) (<-chan string, error)
+}
+
+// Store is a config.Store backed by a Redis key. A write only succeeds if
+// the key still holds what this instance last read or wrote; otherwise it
+// fails with config.ErrSourceConflict.
+type Store struct {
+	client   Client
+	key      string
+	channel  string
+	instance string // identifies our own announcements
+
+	mu   sync.Mutex
+	last []byte
+}
+
+func (s *Store) Load() ([]byte, error) {
+	data, err := s.client.Get(context.Background(), s.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get redis key '%s': %w", s.key, err)
+	}
+
+	s.mu.Lock()
+	s.last = data
+	s.mu.Unlock()
+
+	return data, nil
+}
+
+func (s *Store) Save(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctx := context.Background()
+	err := s.client.SetIfUnchanged(ctx, s.key, s.last, data)
+	if errors.Is(err, ErrTxFailed) {
+		return fmt.Errorf("redis key '%s': %w", s.key, config.ErrSourceConflict)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set redis key '%s': %w", s.key, err)
+	}
+	s.last = data
+
+	// Other instances reload on this; a missed announcement only delays them
+	// until their next write conflicts
+	s.client.Publish(ctx, s.channel, s.instance)
+	return nil
+}
+
+// Source is a config source stored in Redis
+type Source struct {
+	*config.CodecSource
+	store *Store
+}
+
+// NewSource loads the JSON document under key. Writes are announced on
+// channel; call Listen to pick up the writes of other instances.
+func NewSource(client Client, key, channel, schema string) (*Source, error) {
+	if client == nil {
+		return nil, errors.New("redis client cannot be nil")
+	}
+	if key == "" || channel == "" {
+		return nil, errors.New("key and channel cannot be empty")
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate instance id: %w", err)
+	}
+
+	store := &Store{
+		client:   client,
+		key:      key,
+		channel:  channel,
+		instance: hex.EncodeToString(id),
+	}
+
+	source, err := config.NewCodecSource(store, config.JSONCodec{}, schema)
+	if err != nil {
+		return nil, err
+	}
+	return &Source{CodecSource: source, store: store}, nil
+}
+
+// Listen reloads m, which must be using s, whenever another instance writes
+// the config. It blocks until ctx is done. Failed reloads are reported to
+// onError when it is not nil.
+func (s *Source) Listen(ctx context.Context, m *config.Manager, onError func(error)) error {
+	if m.Source() != config.ISource(s) {
+		return errors.New("manager does not use this source")
+	}
+
+	messages, err := s.store.client.Subscribe(ctx, s.store.channel)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to '%s': %w", s.store.channel, err)
+	}
+
+	for msg := range messages {
+		if msg == s.store.instance {
+			continue
+		}
+		if err := m.Reload(); err != nil && onError != nil {
+			onError(err)
+		}
+	}
+	return ctx.Err()
+}
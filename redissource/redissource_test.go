@@ -0,0 +1,183 @@
+package redissource
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	config "github.com/majiddarvishan/config_manager"
+)
+
+// fakeRedis is an in-memory Redis shared by several sources
+type fakeRedis struct {
+	mu          sync.Mutex
+	values      map[string][]byte
+	subscribers map[string][]chan string
+}
+
+func newFakeRedis() *fakeRedis {
+	return &fakeRedis{values: map[string][]byte{}, subscribers: map[string][]chan string{}}
+}
+
+func (f *fakeRedis) Get(_ context.Context, key string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	value, ok := f.values[key]
+	if !ok {
+		return nil, errors.New("redis: nil")
+	}
+	return value, nil
+}
+
+func (f *fakeRedis) SetIfUnchanged(_ context.Context, key string, old, value []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !bytes.Equal(f.values[key], old) {
+		return ErrTxFailed
+	}
+	f.values[key] = value
+	return nil
+}
+
+func (f *fakeRedis) Publish(_ context.Context, channel, message string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, ch := range f.subscribers[channel] {
+		ch <- message
+	}
+	return nil
+}
+
+func (f *fakeRedis) Subscribe(ctx context.Context, channel string) (<-chan string, error) {
+	ch := make(chan string, 16)
+	f.mu.Lock()
+	f.subscribers[channel] = append(f.subscribers[channel], ch)
+	f.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		subs := f.subscribers[channel]
+		for i, sub := range subs {
+			if sub == ch {
+				f.subscribers[channel] = append(subs[:i], subs[i+1:]...)
+				break
+			}
+		}
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// instance is one manager sharing the fake Redis
+func instance(t *testing.T, client Client) (*Source, *config.Manager, *config.Node) {
+	t.Helper()
+
+	source, err := NewSource(client, "config", "config-updates", `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := config.NewManager(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := m.ConfigRef().At("port")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnReplace(port, nil); err != nil {
+		t.Fatal(err)
+	}
+	return source, m, port
+}
+
+func TestWriteReloadsOtherInstances(t *testing.T) {
+	client := newFakeRedis()
+	client.values["config"] = []byte(`{"port":1}`)
+
+	_, a, _ := instance(t, client)
+	sourceB, b, portB := instance(t, client)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- sourceB.Listen(ctx, b, func(err error) { t.Error(err) })
+	}()
+
+	// Wait for the subscription before writing
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(time.Millisecond) {
+		client.mu.Lock()
+		n := len(client.subscribers["config-updates"])
+		client.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("instance b never subscribed")
+		}
+	}
+
+	before := b.Version()
+	if err := a.Replace("/port", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	for deadline := time.Now().Add(2 * time.Second); b.Version() == before; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("instance b did not reload")
+		}
+	}
+	// The registered node stays attached and sees the reloaded value
+	if v, _ := portB.GetFloat(); v != 2 {
+		t.Errorf("b port = %v, want 2", v)
+	}
+
+	// Having reloaded, b writes on top of a's change
+	if err := b.Replace("/port", 3); err != nil {
+		t.Errorf("b write after reload: %v", err)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Listen = %v, want context.Canceled", err)
+	}
+}
+
+func TestStaleWriteConflicts(t *testing.T) {
+	client := newFakeRedis()
+	client.values["config"] = []byte(`{"port":1}`)
+
+	_, a, _ := instance(t, client)
+	_, b, _ := instance(t, client)
+
+	if err := a.Replace("/port", 2); err != nil {
+		t.Fatal(err)
+	}
+	// b is not listening, so it still holds the old document
+	if err := b.Replace("/port", 3); !errors.Is(err, config.ErrSourceConflict) {
+		t.Fatalf("err = %v, want ErrSourceConflict", err)
+	}
+
+	if err := b.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Replace("/port", 3); err != nil {
+		t.Errorf("write after Reload: %v", err)
+	}
+}
+
+func TestListenRequiresOwnManager(t *testing.T) {
+	client := newFakeRedis()
+	client.values["config"] = []byte(`{"port":1}`)
+
+	source, _, _ := instance(t, client)
+	_, other, _ := instance(t, client)
+
+	if err := source.Listen(context.Background(), other, nil); err == nil {
+		t.Error("Listen accepted a manager using another source")
+	}
+}
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/majiddarvishan/config_manager/history"
+)
+
+// RevertOperation is the Operation of the history events recorded by RevertTo
+const RevertOperation = "revert"
+
+// RevertTo brings the config back to how it was at version by undoing, newest
+// first, every change recorded in the history since: inserts are removed,
+// removes re-inserted, replaces (and swaps and reorders) set back to their
+// old value and moves moved back. The result is validated against the schema
+// once and persisted in a single write; if anything fails nothing changes.
+//
+// The history must account for every version after version. It cannot when
+// the events were evicted (see WithHistorySize), coalesced across version
+// (see WithHistoryCoalescing), or when a change was recorded without values
+// (import, batch, patches, reload and revert itself); RevertTo then fails.
+//
+// As with Batch, the version goes up by one and one "revert" event at "/"
+// listing the changed paths is recorded; registered nodes stay attached where
+// their paths still exist, and handlers and subscribers are not called.
+func (m *Manager) RevertTo(version int64) error {
+	m.lockForWrite()
+	defer m.unlockForWrite()
+
+	if version == m.version {
+		return nil
+	}
+
+	config, err := m.configAtLocked(version)
+	if err != nil {
+		return fmt.Errorf("cannot revert to version %d: %w", version, err)
+	}
+
+	if err := m.validateDocumentLocked(config); err != nil {
+		return fmt.Errorf("validation failed: %w", err)
+	}
+	if _, err := m.runValidationServiceLocked(nil, config); err != nil {
+		return err
+	}
+
+	if err := m.source.setConfig(config); err != nil {
+		m.log().Error("failed to persist revert", "version", version, "error", err)
+		return fmt.Errorf("failed to persist config: %w", err)
+	}
+
+	root := parseNode(config)
+	changed := diffPointers(m.config, root)
+
+	m.rebindModifiablesLocked(root)
+	m.version++
+	for _, d := range changed {
+		m.touchPathLocked(d.Path)
+	}
+	m.recordRootChangeLocked(RevertOperation, changed)
+
+	return nil
+}
+
+// Diff returns the differences between the config at fromVersion and at
+// toVersion, as DiffNodes reports them. Both configs are reconstructed from
+// the current one and the history, so the same limits as for RevertTo apply:
+// every version after the older of the two must be covered by events that
+// carry their values.
+func (m *Manager) Diff(fromVersion, toVersion int64) ([]DiffEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	from, err := m.configAtLocked(fromVersion)
+	if err != nil {
+		return nil, err
+	}
+	to, err := m.configAtLocked(toVersion)
+	if err != nil {
+		return nil, err
+	}
+	return DiffNodes(parseNode(from), parseNode(to)), nil
+}
+
+// configAtLocked reconstructs the config as it was at version by undoing the
+// changes recorded since, newest first. The result is a detached document.
+func (m *Manager) configAtLocked(version int64) (interface{}, error) {
+	if version < 1 || version > m.version {
+		return nil, fmt.Errorf("version %d does not exist, current version is %d", version, m.version)
+	}
+
+	events := m.history.Since(version)
+	expected := version + 1
+	for _, ev := range events {
+		if ev.FirstVersion != expected {
+			break
+		}
+		expected = ev.Version + 1
+	}
+	if version < m.version && expected != m.version+1 {
+		return nil, fmt.Errorf("the history no longer covers version %d", expected)
+	}
+
+	config, err := cloneJSON(m.source.getConfigObject())
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone config: %w", err)
+	}
+
+	for i := len(events) - 1; i >= 0; i-- {
+		config, err = m.undoEventLocked(config, events[i])
+		if err != nil {
+			return nil, fmt.Errorf("cannot undo %s at '%s' (version %d): %w", events[i].Operation, events[i].Path, events[i].Version, err)
+		}
+	}
+	return config, nil
+}
+
+// undoEventLocked applies the inverse of ev to config and returns the result
+func (m *Manager) undoEventLocked(config interface{}, ev history.ChangeEvent) (interface{}, error) {
+	path, err := m.internalPath(ev.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ev.Operation {
+	case Insertable.String():
+		array, index, err := splitElementPath(path)
+		if err != nil {
+			return nil, err
+		}
+		return jsonRemoveByPath(config, array, index)
+
+	case Removable.String():
+		array, index, err := splitElementPath(path)
+		if err != nil {
+			return nil, err
+		}
+		value, err := cloneEventValue(ev.OldValue)
+		if err != nil {
+			return nil, err
+		}
+		return jsonInsertByPath(config, array, index, value)
+
+	case Replaceable.String(), SwapOperation, ReorderOperation:
+		value, err := cloneEventValue(ev.OldValue)
+		if err != nil {
+			return nil, err
+		}
+		return jsonSetByPath(config, path, value)
+
+	case MoveOperation:
+		from, err := m.internalPath(ev.From)
+		if err != nil {
+			return nil, err
+		}
+		toArray, toIndex, err := splitElementPath(path)
+		if err != nil {
+			return nil, err
+		}
+		fromArray, fromIndex, err := splitElementPath(from)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := jsonValueAt(config, path)
+		if err != nil {
+			return nil, err
+		}
+		if config, err = jsonRemoveByPath(config, toArray, toIndex); err != nil {
+			return nil, err
+		}
+		return jsonInsertByPath(config, fromArray, fromIndex, value)
+
+	default:
+		return nil, fmt.Errorf("the change was recorded without its values")
+	}
+}
+
+// cloneEventValue copies a value recorded in the history, which may be null
+func cloneEventValue(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return cloneJSON(v)
+}
+
+// splitElementPath splits the path of an array element into the array's path
+// and the element's index
+func splitElementPath(path string) (string, int, error) {
+	segments := pointerSegments(path)
+	if len(segments) == 0 {
+		return "", 0, fmt.Errorf("'%s' is not an array element", path)
+	}
+
+	index, err := strconv.Atoi(segments[len(segments)-1])
+	if err != nil || index < 0 {
+		return "", 0, fmt.Errorf("'%s' is not an array element", path)
+	}
+	return joinPointer(segments[:len(segments)-1]), index, nil
+}
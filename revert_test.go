@@ -0,0 +1,111 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRevertToUndoesChanges(t *testing.T) {
+	m := newTestManager(t, `{"port":1,"todo":["a","b"],"done":[]}`)
+	replaceable(t, m, "port")
+	for _, key := range []string{"todo", "done"} {
+		node, err := m.ConfigRef().At(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.OnInsert(node, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.OnRemove(node, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handled := 0
+	port, _ := m.ConfigRef().At("port")
+	if err := m.OnReplace(port, func(*Node) { handled++ }); err != nil {
+		t.Fatal(err)
+	}
+	original := m.ConfigRef().toInterface()
+	start := m.Version()
+
+	steps := []func() error{
+		func() error { return m.Replace("/port", 2) },
+		func() error { return m.Insert("/todo", 2, "c") },
+		func() error { return m.Move("/todo", 0, "/done", 0) },
+		func() error { return m.Swap("/todo", 0, 1) },
+		func() error { return m.Remove("/todo", 0) },
+		func() error { return m.Replace("/port", 3) },
+	}
+	snapshots := []interface{}{original}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+		snapshots = append(snapshots, m.ConfigRef().toInterface())
+	}
+	handled = 0
+
+	// Revert to a version in the middle, then all the way back
+	mid := start + 3
+	if err := m.RevertTo(mid); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.ConfigRef().toInterface(); !equalJSON(got, snapshots[3]) {
+		t.Errorf("after RevertTo(%d) = %v, want %v", mid, got, snapshots[3])
+	}
+	if m.Version() != start+int64(len(steps))+1 {
+		t.Errorf("version = %d, want %d", m.Version(), start+int64(len(steps))+1)
+	}
+	events := m.History().GetAll()
+	last := events[len(events)-1]
+	if last.Operation != RevertOperation || last.Path != "/" {
+		t.Errorf("last event = %+v, want a revert at /", last)
+	}
+	if handled != 0 {
+		t.Errorf("handler called %d times, want 0", handled)
+	}
+
+	// The revert itself carries no values, so it cannot be reverted past
+	if err := m.RevertTo(start); err == nil || !strings.Contains(err.Error(), "without its values") {
+		t.Errorf("revert across a revert: %v", err)
+	}
+
+	// Registered nodes still work
+	if err := m.Replace("/port", 9); err != nil {
+		t.Errorf("replace after revert: %v", err)
+	}
+}
+
+func TestRevertToNeedsCompleteHistory(t *testing.T) {
+	m := newTestManager(t, `{"port":1}`, WithHistorySize(2))
+	replaceable(t, m, "port")
+	start := m.Version()
+	for i := 2; i <= 5; i++ {
+		if err := m.Replace("/port", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := m.RevertTo(start)
+	if err == nil || !strings.Contains(err.Error(), "no longer covers") {
+		t.Errorf("revert past the history: %v", err)
+	}
+	if err := m.RevertTo(m.Version() + 1); err == nil {
+		t.Error("revert to a future version accepted")
+	}
+	if v, _ := m.ConfigRef().GetInt("port"); v != 5 {
+		t.Errorf("port = %d after failed reverts, want 5", v)
+	}
+
+	if err := m.RevertTo(m.Version() - 2); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := m.ConfigRef().GetInt("port"); v != 3 {
+		t.Errorf("port = %d, want 3", v)
+	}
+
+	before := m.Version()
+	if err := m.RevertTo(before); err != nil || m.Version() != before {
+		t.Errorf("revert to the current version: %v, version %d", err, m.Version())
+	}
+}
@@ -0,0 +1,88 @@
+// Package s3source keeps a config document in an S3 object. It talks to S3
+// through the small API interface so that any SDK (or a fake) can be plugged
+// in without this module depending on it.
+package s3source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	config "github.com/majiddarvishan/config_manager"
+)
+
+// ErrPreconditionFailed must be returned (possibly wrapped) by API.PutObject
+// when the object's current ETag does not match ifMatch
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// API is the subset of S3 used by the store
+type API interface {
+	// GetObject returns the object's body and ETag
+	GetObject(ctx context.Context, bucket, key string) (body []byte, etag string, err error)
+
+	// PutObject writes the object, only if its current ETag equals ifMatch
+	// when ifMatch is not empty, and returns the new ETag
+	PutObject(ctx context.Context, bucket, key string, body []byte, ifMatch string) (etag string, err error)
+}
+
+// Store is a config.Store backed by one S3 object. Writes are conditional on
+// the ETag seen by the last Load or Save, so a write racing with another
+// writer fails with config.ErrSourceConflict instead of overwriting it.
+type Store struct {
+	api    API
+	bucket string
+	key    string
+
+	mu   sync.Mutex
+	etag string
+}
+
+func NewStore(api API, bucket, key string) (*Store, error) {
+	if api == nil {
+		return nil, errors.New("s3 api cannot be nil")
+	}
+	if bucket == "" || key == "" {
+		return nil, errors.New("bucket and key cannot be empty")
+	}
+	return &Store{api: api, bucket: bucket, key: key}, nil
+}
+
+func (s *Store) Load() ([]byte, error) {
+	body, etag, err := s.api.GetObject(context.Background(), s.bucket, s.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get s3://%s/%s: %w", s.bucket, s.key, err)
+	}
+
+	s.mu.Lock()
+	s.etag = etag
+	s.mu.Unlock()
+
+	return body, nil
+}
+
+func (s *Store) Save(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	etag, err := s.api.PutObject(context.Background(), s.bucket, s.key, data, s.etag)
+	if errors.Is(err, ErrPreconditionFailed) {
+		return fmt.Errorf("s3://%s/%s: %w", s.bucket, s.key, config.ErrSourceConflict)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to put s3://%s/%s: %w", s.bucket, s.key, err)
+	}
+
+	s.etag = etag
+	return nil
+}
+
+// NewSource loads the JSON document at bucket/key and returns a source
+// persisting changes back to it
+func NewSource(api API, bucket, key, schema string) (*config.CodecSource, error) {
+	store, err := NewStore(api, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return config.NewCodecSource(store, config.JSONCodec{}, schema)
+}
@@ -0,0 +1,128 @@
+package s3source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	config "github.com/majiddarvishan/config_manager"
+)
+
+// fakeS3 keeps objects in memory and honours If-Match like S3 does
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	etags   map[string]string
+	puts    int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]byte{}, etags: map[string]string{}}
+}
+
+func (f *fakeS3) set(bucket, key, body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.puts++
+	f.objects[bucket+"/"+key] = []byte(body)
+	f.etags[bucket+"/"+key] = fmt.Sprintf("etag-%d", f.puts)
+}
+
+func (f *fakeS3) GetObject(_ context.Context, bucket, key string) ([]byte, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, ok := f.objects[bucket+"/"+key]
+	if !ok {
+		return nil, "", errors.New("no such key")
+	}
+	return body, f.etags[bucket+"/"+key], nil
+}
+
+func (f *fakeS3) PutObject(_ context.Context, bucket, key string, body []byte, ifMatch string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if ifMatch != "" && f.etags[bucket+"/"+key] != ifMatch {
+		return "", ErrPreconditionFailed
+	}
+	f.puts++
+	f.objects[bucket+"/"+key] = body
+	f.etags[bucket+"/"+key] = fmt.Sprintf("etag-%d", f.puts)
+	return f.etags[bucket+"/"+key], nil
+}
+
+func newManager(t *testing.T, api API) *config.Manager {
+	t.Helper()
+
+	source, err := NewSource(api, "bucket", "config.json", `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := config.NewManager(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := m.ConfigRef().At("port")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnReplace(port, nil); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestLoadAndSave(t *testing.T) {
+	api := newFakeS3()
+	api.set("bucket", "config.json", `{"port":1}`)
+	m := newManager(t, api)
+
+	port, _ := m.ConfigRef().At("port")
+	if v, _ := port.GetFloat(); v != 1 {
+		t.Fatalf("port = %v, want 1", v)
+	}
+
+	if err := m.Replace("/port", 2); err != nil {
+		t.Fatal(err)
+	}
+	body, _, _ := api.GetObject(context.Background(), "bucket", "config.json")
+	if !strings.Contains(string(body), `"port": 2`) {
+		t.Errorf("object = %s, want port 2", body)
+	}
+
+	// The store tracks the ETag of its own write
+	if err := m.Replace("/port", 3); err != nil {
+		t.Errorf("second save: %v", err)
+	}
+}
+
+func TestConcurrentWriterConflicts(t *testing.T) {
+	api := newFakeS3()
+	api.set("bucket", "config.json", `{"port":1}`)
+	m := newManager(t, api)
+
+	api.set("bucket", "config.json", `{"port":5}`)
+
+	err := m.Replace("/port", 2)
+	if !errors.Is(err, config.ErrSourceConflict) {
+		t.Fatalf("err = %v, want ErrSourceConflict", err)
+	}
+	body, _, _ := api.GetObject(context.Background(), "bucket", "config.json")
+	if string(body) != `{"port":5}` {
+		t.Errorf("object = %s, want the other writer's document", body)
+	}
+}
+
+func TestNewStoreValidates(t *testing.T) {
+	if _, err := NewStore(nil, "b", "k"); err == nil {
+		t.Error("nil api accepted")
+	}
+	if _, err := NewStore(newFakeS3(), "", "k"); err == nil {
+		t.Error("empty bucket accepted")
+	}
+	if _, err := NewSource(newFakeS3(), "b", "missing", `{}`); err == nil {
+		t.Error("missing object loaded")
+	}
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+const maxSchemaRefDepth = 32
+
+// parseSchema decodes a JSON schema document into generic maps
+func parseSchema(schema *string) (map[string]interface{}, error) {
+	if schema == nil {
+		return nil, nil
+	}
+
+	var root map[string]interface{}
+	if err := json.Unmarshal([]byte(*schema), &root); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// schemaForPath returns the sub-schema describing the value at path, or nil
+// when the schema does not describe it. Object keys are looked up in
+// properties (falling back to additionalProperties), array indices in items.
+// Local "$ref"s are followed.
+func schemaForPath(root map[string]interface{}, path string) map[string]interface{} {
+	current := resolveSchemaRef(root, root)
+
+	for _, segment := range pointerSegments(path) {
+		if current == nil {
+			return nil
+		}
+		current = resolveSchemaRef(root, childSchema(current, segment))
+	}
+
+	return current
+}
+
+func childSchema(schema map[string]interface{}, segment string) map[string]interface{} {
+	if props, ok := schema["properties"].(map[string]interface{}); ok {
+		if child, ok := props[segment].(map[string]interface{}); ok {
+			return child
+		}
+	}
+
+	if index, err := strconv.Atoi(segment); err == nil {
+		switch items := schema["items"].(type) {
+		case map[string]interface{}:
+			return items
+		case []interface{}:
+			if index >= 0 && index < len(items) {
+				child, _ := items[index].(map[string]interface{})
+				return child
+			}
+		}
+	}
+
+	if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+		return additional
+	}
+
+	return nil
+}
+
+// resolveSchemaRef follows local ("#/...") references until a concrete schema
+func resolveSchemaRef(root, schema map[string]interface{}) map[string]interface{} {
+	for i := 0; i < maxSchemaRefDepth; i++ {
+		if schema == nil {
+			return nil
+		}
+
+		ref, ok := schema["$ref"].(string)
+		if !ok || !strings.HasPrefix(ref, "#") {
+			return schema
+		}
+
+		var target interface{} = root
+		for _, token := range strings.Split(strings.TrimPrefix(ref[1:], "/"), "/") {
+			if token == "" {
+				continue
+			}
+			token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+			obj, ok := target.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			target = obj[token]
+		}
+
+		schema, _ = target.(map[string]interface{})
+	}
+
+	return nil
+}
+
+// standaloneSchema turns a sub-schema into a document that can be validated
+// on its own by carrying over the root's definitions for local references
+func standaloneSchema(root, sub map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(sub)+2)
+	for k, v := range sub {
+		out[k] = v
+	}
+	for _, key := range []string{"definitions", "$defs"} {
+		if defs, ok := root[key]; ok {
+			if _, exists := out[key]; !exists {
+				out[key] = defs
+			}
+		}
+	}
+	return out
+}
+
+// validateSchema checks doc against schema, leaving out the subtrees exempted
+// with WithUnvalidatedPaths. doc itself is not changed.
+func (m *Manager) validateSchema(doc interface{}, schema *string) error {
+	if len(m.unvalidated) > 0 {
+		if m.isUnvalidated("/") {
+			return nil
+		}
+		doc = m.exciseUnvalidated(doc, "")
+	}
+	return validateJSONAgainstSchema(doc, schema)
+}
+
+// isUnvalidated reports whether the internal path lies within a subtree
+// exempted with WithUnvalidatedPaths
+func (m *Manager) isUnvalidated(path string) bool {
+	if len(m.unvalidated) == 0 {
+		return false
+	}
+
+	segments := splitPath(path)
+	for i := 0; i <= len(segments); i++ {
+		prefix := m.externalPath("/" + strings.Join(segments[:i], "/"))
+		for _, pattern := range m.unvalidated {
+			if matchesPath(pattern, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// exciseUnvalidated returns doc without the exempted subtrees below path
+// (the internal pointer of doc, "" for the root). Containers are copied;
+// values are shared with doc.
+func (m *Manager) exciseUnvalidated(doc interface{}, path string) interface{} {
+	exempt := func(child string) bool {
+		for _, pattern := range m.unvalidated {
+			if matchesPath(pattern, m.externalPath(child)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch v := doc.(type) {
+	case *orderedmap.OrderedMap:
+		out := orderedmap.New()
+		for _, key := range v.Keys() {
+			child := joinEscaped(path, key)
+			if exempt(child) {
+				continue
+			}
+			value, _ := v.Get(key)
+			out.Set(key, m.exciseUnvalidated(value, child))
+		}
+		return out
+
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			child := joinEscaped(path, key)
+			if !exempt(child) {
+				out[key] = m.exciseUnvalidated(value, child)
+			}
+		}
+		return out
+
+	case []interface{}:
+		out := make([]interface{}, 0, len(v))
+		for i, item := range v {
+			child := path + "/" + strconv.Itoa(i)
+			if exempt(child) {
+				continue
+			}
+			out = append(out, m.exciseUnvalidated(item, child))
+		}
+		return out
+
+	default:
+		return doc
+	}
+}
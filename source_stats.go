@@ -0,0 +1,81 @@
+package config
+
+import (
+	"sync"
+	"time"
+)
+
+// OperationStats counts the calls a source made to its backing store for
+// one kind of operation, and how long they took
+type OperationStats struct {
+	Count        int64         `json:"count"`
+	Failures     int64         `json:"failures"`
+	TotalLatency time.Duration `json:"total_latency_ns"`
+	MaxLatency   time.Duration `json:"max_latency_ns"`
+	LastLatency  time.Duration `json:"last_latency_ns"`
+	LastError    string        `json:"last_error,omitempty"`
+}
+
+// AverageLatency returns the mean latency of the recorded calls
+func (s OperationStats) AverageLatency() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.TotalLatency / time.Duration(s.Count)
+}
+
+// SourceStats describes how a source's backing store has been behaving:
+// Load covers reads (initial load and reloads), Persist covers writes
+type SourceStats struct {
+	Load    OperationStats `json:"load"`
+	Persist OperationStats `json:"persist"`
+}
+
+// statsReporter is implemented by sources that measure their store
+type statsReporter interface {
+	Stats() SourceStats
+}
+
+type sourceMeter struct {
+	mu    sync.Mutex
+	stats SourceStats
+}
+
+// observe records a store call that started at start and ended with err
+func (sm *sourceMeter) observe(op func(*SourceStats) *OperationStats, start time.Time, err error) {
+	elapsed := time.Since(start)
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	s := op(&sm.stats)
+	s.Count++
+	s.TotalLatency += elapsed
+	s.LastLatency = elapsed
+	if elapsed > s.MaxLatency {
+		s.MaxLatency = elapsed
+	}
+	if err != nil {
+		s.Failures++
+		s.LastError = err.Error()
+	}
+}
+
+func (sm *sourceMeter) snapshot() SourceStats {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.stats
+}
+
+func loadStats(s *SourceStats) *OperationStats    { return &s.Load }
+func persistStats(s *SourceStats) *OperationStats { return &s.Persist }
+
+// SourceStats returns the store statistics of the manager's source, or
+// false when the source does not measure them
+func (m *Manager) SourceStats() (SourceStats, bool) {
+	reporter, ok := m.Source().(statsReporter)
+	if !ok {
+		return SourceStats{}, false
+	}
+	return reporter.Stats(), true
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowStore is a MemoryStore whose saves take delay and fail while failing
+// is set
+type slowStore struct {
+	*MemoryStore
+	delay time.Duration
+
+	mu      sync.Mutex
+	failing bool
+}
+
+func (s *slowStore) Save(data []byte) error {
+	time.Sleep(s.delay)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failing {
+		return errors.New("disk full")
+	}
+	return s.MemoryStore.Save(data)
+}
+
+func TestSourceStatsRecordLatencyAndFailures(t *testing.T) {
+	const delay = 5 * time.Millisecond
+	store := &slowStore{MemoryStore: NewMemoryStore([]byte(`{"a":1}`)), delay: delay}
+	source, err := NewCodecSource(store, JSONCodec{}, `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaceable(t, m, "a")
+
+	if err := m.Replace("/a", 2); err != nil {
+		t.Fatal(err)
+	}
+	store.mu.Lock()
+	store.failing = true
+	store.mu.Unlock()
+	if err := m.Replace("/a", 3); err == nil {
+		t.Fatal("replace succeeded on a failing store")
+	}
+
+	stats, ok := m.SourceStats()
+	if !ok {
+		t.Fatal("codec source reports no stats")
+	}
+	if stats.Load.Count != 1 || stats.Load.Failures != 0 {
+		t.Errorf("load = %+v, want one successful load", stats.Load)
+	}
+	p := stats.Persist
+	if p.Count != 2 || p.Failures != 1 || p.LastError != "disk full" {
+		t.Errorf("persist = %+v, want two saves with one failure", p)
+	}
+	if p.MaxLatency < delay || p.AverageLatency() < delay || p.TotalLatency < 2*delay {
+		t.Errorf("persist = %+v, want every save to take at least %s", p, delay)
+	}
+
+	code, body := serve(t, m, "GET", "/config/stats", "")
+	data, _ := body["data"].(map[string]interface{})
+	persist, _ := data["source"].(map[string]interface{})["persist"].(map[string]interface{})
+	if code != 200 || !equalJSON(persist["failures"], 1) {
+		t.Errorf("GET /config/stats: status = %d, data = %v, want the persist failure", code, data)
+	}
+}
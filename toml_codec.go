@@ -0,0 +1,158 @@
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/iancoleman/orderedmap"
+)
+
+// TOMLCodec stores configs as TOML. TOML documents are tables, so the root
+// must be an object, and TOML has no null. Decoding keeps the key order of
+// the document; encoding writes keys in the order the TOML encoder uses.
+// As with JSON, integers are decoded as int64 and other numbers as float64.
+type TOMLCodec struct{}
+
+func (TOMLCodec) Decode(data []byte) (interface{}, error) {
+	var raw map[string]interface{}
+	md, err := toml.Decode(string(data), &raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse toml: %w", err)
+	}
+
+	// Position of every key path (array indices left out) in the document
+	order := make(map[string]int)
+	for i, key := range md.Keys() {
+		if _, seen := order[strings.Join(key, "\x00")]; !seen {
+			order[strings.Join(key, "\x00")] = i
+		}
+	}
+
+	return fromTOMLValue(raw, nil, order), nil
+}
+
+func (TOMLCodec) Encode(config interface{}) ([]byte, error) {
+	root, err := toTOMLValue(config, "")
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := root.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("toml requires an object at the root")
+	}
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(root); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func fromTOMLValue(v interface{}, path []string, order map[string]int) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for key := range x {
+			keys = append(keys, key)
+		}
+		position := func(key string) (int, bool) {
+			i, ok := order[strings.Join(append(append([]string(nil), path...), key), "\x00")]
+			return i, ok
+		}
+		sort.SliceStable(keys, func(i, j int) bool {
+			pi, oki := position(keys[i])
+			pj, okj := position(keys[j])
+			if oki != okj {
+				return oki
+			}
+			if pi != pj {
+				return pi < pj
+			}
+			return keys[i] < keys[j]
+		})
+
+		om := orderedmap.New()
+		for _, key := range keys {
+			om.Set(key, fromTOMLValue(x[key], append(append([]string(nil), path...), key), order))
+		}
+		return om
+
+	case []map[string]interface{}:
+		arr := make([]interface{}, 0, len(x))
+		for _, item := range x {
+			arr = append(arr, fromTOMLValue(item, path, order))
+		}
+		return arr
+
+	case []interface{}:
+		arr := make([]interface{}, 0, len(x))
+		for _, item := range x {
+			arr = append(arr, fromTOMLValue(item, path, order))
+		}
+		return arr
+
+	case encoding.TextMarshaler:
+		// Dates and times are kept in their textual form
+		text, err := x.MarshalText()
+		if err != nil {
+			return fmt.Sprint(x)
+		}
+		return string(text)
+
+	default:
+		return x
+	}
+}
+
+func toTOMLValue(v interface{}, path string) (interface{}, error) {
+	switch x := v.(type) {
+	case *orderedmap.OrderedMap:
+		out := make(map[string]interface{}, len(x.Keys()))
+		for _, key := range x.Keys() {
+			value, _ := x.Get(key)
+			converted, err := toTOMLValue(value, path+"/"+escapePointerSegment(key))
+			if err != nil {
+				return nil, err
+			}
+			out[key] = converted
+		}
+		return out, nil
+
+	case orderedmap.OrderedMap:
+		return toTOMLValue(&x, path)
+
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(x))
+		for key, value := range x {
+			converted, err := toTOMLValue(value, path+"/"+escapePointerSegment(key))
+			if err != nil {
+				return nil, err
+			}
+			out[key] = converted
+		}
+		return out, nil
+
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, item := range x {
+			converted, err := toTOMLValue(item, fmt.Sprintf("%s/%d", path, i))
+			if err != nil {
+				return nil, err
+			}
+			out[i] = converted
+		}
+		return out, nil
+
+	case nil:
+		if path == "" {
+			path = "/"
+		}
+		return nil, fmt.Errorf("toml cannot represent null at '%s'", path)
+
+	default:
+		return integralNumber(v), nil
+	}
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+type transformer struct {
+	expr    string
+	pattern []querySegment
+	fn      func(*Node) (*Node, error)
+}
+
+// AddTransformer registers fn to normalise values written to paths matching
+// pathPattern (a query expression such as "/users/*/email"). Transformers run
+// on every inserted or replaced value after validation and before
+// persistence; the transformed document is validated again. fn runs while the
+// manager is locked and must not call back into the manager. Returning a nil
+// node keeps the value unchanged.
+func (m *Manager) AddTransformer(pathPattern string, fn func(*Node) (*Node, error)) error {
+	if fn == nil {
+		return errors.New("transformer cannot be nil")
+	}
+
+	pattern, err := parseQuery(pathPattern)
+	if err != nil {
+		return fmt.Errorf("invalid transformer pattern: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.transformers = append(m.transformers, transformer{
+		expr:    pathPattern,
+		pattern: pattern,
+		fn:      fn,
+	})
+	return nil
+}
+
+// applyTransformersLocked runs the registered transformers over value, which
+// is about to be written at path. changed reports whether any transformer
+// matched; only then is the returned value different from the input.
+func (m *Manager) applyTransformersLocked(path string, value interface{}) (interface{}, bool, error) {
+	if len(m.transformers) == 0 {
+		return value, false, nil
+	}
+
+	node, changed, err := m.transformNodeLocked(parseNode(value), path)
+	if err != nil || !changed {
+		return value, false, err
+	}
+	return node.toInterface(), true, nil
+}
+
+// transformNodeLocked transforms children before their parent so a parent
+// transformer sees already normalised values
+func (m *Manager) transformNodeLocked(node *Node, path string) (*Node, bool, error) {
+	changed := false
+
+	switch node.Type() {
+	case Object:
+		obj, _ := node.GetObject()
+		for key, child := range obj {
+			out, c, err := m.transformNodeLocked(child, path+"/"+escapePointerSegment(key))
+			if err != nil {
+				return nil, false, err
+			}
+			if c {
+				obj[key] = out
+				changed = true
+			}
+		}
+	case Array:
+		arr, _ := node.GetArray()
+		for i, child := range arr {
+			out, c, err := m.transformNodeLocked(child, path+"/"+strconv.Itoa(i))
+			if err != nil {
+				return nil, false, err
+			}
+			if c {
+				arr[i] = out
+				changed = true
+			}
+		}
+	}
+
+	for _, t := range m.transformers {
+		if !matchesPath(t.pattern, m.externalPath(path)) {
+			continue
+		}
+		out, err := t.fn(node)
+		if err != nil {
+			return nil, false, fmt.Errorf("transformer '%s' failed at '%s': %w", t.expr, path, err)
+		}
+		if out != nil {
+			node = out
+			changed = true
+		}
+	}
+
+	return node, changed, nil
+}
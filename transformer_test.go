@@ -0,0 +1,71 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTransformerLowercasesEmailOnInsert(t *testing.T) {
+	m := newTestManager(t, `{"users":[]}`)
+	err := m.AddTransformer("/users/*/email", func(n *Node) (*Node, error) {
+		s, err := n.GetString()
+		if err != nil {
+			return nil, err
+		}
+		return parseNode(strings.ToLower(strings.TrimSpace(s))), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := m.ConfigRef().At("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(users, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Insert("/users", 0, map[string]interface{}{"email": " Ann@Example.COM "}); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := users.At(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	email, err := user.GetString("email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email != "ann@example.com" {
+		t.Errorf("stored email = %q, want %q", email, "ann@example.com")
+	}
+	if got := *m.Source().getConfig(); !strings.Contains(got, `"ann@example.com"`) {
+		t.Errorf("persisted config = %s, want the lowercased email", got)
+	}
+}
+
+func TestTransformerErrorRejectsChange(t *testing.T) {
+	m := newTestManager(t, `{"name":"a"}`)
+	err := m.AddTransformer("/name", func(*Node) (*Node, error) {
+		return nil, errors.New("not allowed")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := m.ConfigRef().At("name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnReplace(name, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Replace("/name", "b"); err == nil {
+		t.Fatal("replace succeeded, want the transformer's error")
+	}
+	if got, _ := name.GetString(); got != "a" {
+		t.Errorf("/name = %q, want %q", got, "a")
+	}
+}
@@ -0,0 +1,64 @@
+package config
+
+import "strconv"
+
+// TreeNode is a serializable view of one config node, meant for rendering
+// generic config editors. Modifiable nodes list the operations registered on them.
+type TreeNode struct {
+	Path         string      `json:"path"`
+	Key          string      `json:"key,omitempty"`
+	Index        *int        `json:"index,omitempty"`
+	Type         string      `json:"type"`
+	Value        interface{} `json:"value,omitempty"`
+	IsModifiable bool        `json:"is_modifiable"`
+	Operations   []string    `json:"operations,omitempty"`
+	Children     []*TreeNode `json:"children,omitempty"`
+}
+
+// Tree returns the current config as a TreeNode hierarchy. Object children
+// are ordered by key, array children by index.
+func (m *Manager) Tree() *TreeNode {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ops := make(map[*Node][]string)
+	for _, mod := range m.modifiables {
+		ops[mod.Node] = append(ops[mod.Node], mod.Type.String())
+	}
+
+	return buildTreeNode(m.config, "", ops)
+}
+
+func buildTreeNode(n *Node, path string, ops map[*Node][]string) *TreeNode {
+	tn := &TreeNode{
+		Path:         path,
+		Type:         n.Type().String(),
+		IsModifiable: len(ops[n]) > 0,
+		Operations:   ops[n],
+	}
+	if tn.Path == "" {
+		tn.Path = "/"
+	}
+
+	switch n.Type() {
+	case Object:
+		obj, _ := n.GetObject()
+		for _, key := range sortedKeys(obj) {
+			child := buildTreeNode(obj[key], path+"/"+key, ops)
+			child.Key = key
+			tn.Children = append(tn.Children, child)
+		}
+	case Array:
+		arr, _ := n.GetArray()
+		for i, item := range arr {
+			child := buildTreeNode(item, path+"/"+strconv.Itoa(i), ops)
+			index := i
+			child.Index = &index
+			tn.Children = append(tn.Children, child)
+		}
+	default:
+		tn.Value = n.toInterface()
+	}
+
+	return tn
+}
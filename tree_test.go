@@ -0,0 +1,52 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestTreeMatchesConfig(t *testing.T) {
+	m := newTestManager(t, `{"name":"svc","hosts":["a","b"],"tls":null}`)
+
+	hosts, err := m.ConfigRef().At("hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(hosts, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnRemove(hosts, nil); err != nil {
+		t.Fatal(err)
+	}
+	name, err := m.ConfigRef().At("name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnReplace(name, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := json.Marshal(m.Tree())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"path":"/","type":"object","is_modifiable":false,"children":[
+		{"path":"/hosts","key":"hosts","type":"array","is_modifiable":true,"operations":["insert","remove"],"children":[
+			{"path":"/hosts/0","index":0,"type":"string","value":"a","is_modifiable":false},
+			{"path":"/hosts/1","index":1,"type":"string","value":"b","is_modifiable":false}]},
+		{"path":"/name","key":"name","type":"string","value":"svc","is_modifiable":true,"operations":["replace"]},
+		{"path":"/tls","key":"tls","type":"null","is_modifiable":false}]}`
+
+	var gotTree, wantTree interface{}
+	if err := json.Unmarshal(got, &gotTree); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantTree); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotTree, wantTree) {
+		t.Errorf("tree = %s\nwant %s", got, want)
+	}
+}
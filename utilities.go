@@ -1,259 +1,315 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"reflect"
 	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/iancoleman/orderedmap"
 )
 
-func jsonSetByPath(jsonMap *orderedmap.OrderedMap, path string, value interface{}) error {
-	if jsonMap == nil {
-		return errors.New("jsonMap cannot be nil")
+// jsonLocate walks every segment of path except the last and returns the
+// container holding the target (an OrderedMap or a []interface{}) together
+// with the last segment. A path without segments addresses the root itself,
+// in which case parent is nil.
+func jsonLocate(root interface{}, path string) (parent interface{}, last string, err error) {
+	if root == nil {
+		return nil, "", errors.New("config cannot be nil")
 	}
 
-	splited_path := strings.Split(path, "/")
-	if len(splited_path) == 0 {
-		return errors.New("invalid path: empty")
+	segments := pointerSegments(path)
+	if len(segments) == 0 {
+		return nil, "", nil
 	}
 
-	foundMap := jsonMap
-
-	for i := 0; i < len(splited_path)-1; {
-		if len(splited_path[i]) == 0 {
-			i++
-			continue
+	current := root
+	for _, segment := range segments[:len(segments)-1] {
+		current, err = jsonDescend(current, segment)
+		if err != nil {
+			return nil, "", err
 		}
-
-		found, present := foundMap.Get(splited_path[i])
-		if !present {
-			return fmt.Errorf("path element '%s' not found", splited_path[i])
+		if current == nil {
+			return nil, "", fmt.Errorf("path element '%s' is null", segment)
 		}
+	}
 
-		k := reflect.TypeOf(found).Kind()
-		switch k {
-		case reflect.Map, reflect.Struct:
-			// Handle both *OrderedMap and OrderedMap
-			if om, ok := found.(*orderedmap.OrderedMap); ok {
-				foundMap = om
-			} else if om, ok := found.(orderedmap.OrderedMap); ok {
-				foundMap = &om
-			} else {
-				return fmt.Errorf("expected OrderedMap at '%s', got %T", splited_path[i], found)
-			}
-		case reflect.Slice:
-			s, ok := found.([]interface{})
-			if !ok {
-				return fmt.Errorf("expected []interface{} at '%s'", splited_path[i])
-			}
+	switch c := current.(type) {
+	case *orderedmap.OrderedMap, []interface{}:
+		return c, segments[len(segments)-1], nil
+	case orderedmap.OrderedMap:
+		return &c, segments[len(segments)-1], nil
+	default:
+		return nil, "", fmt.Errorf("cannot traverse through type '%T' at '%s'", current, segments[len(segments)-1])
+	}
+}
 
-			if i+1 >= len(splited_path) {
-				return errors.New("invalid path: missing index after array")
-			}
+// ValidatePath checks a user-supplied path. A valid path starts with '/',
+// has no empty segments (so "/a//b" is rejected rather than collapsed), no
+// "." or ".." segments and no control characters. "/" addresses the root and
+// a single trailing slash is tolerated.
+func ValidatePath(path string) error {
+	if path == "" {
+		return errors.New("path is empty")
+	}
+	if path[0] != '/' {
+		return errors.New("path must start with '/'")
+	}
 
-			index, err := strconv.ParseInt(splited_path[i+1], 10, 32)
-			if err != nil {
-				return fmt.Errorf("invalid array index '%s': %w", splited_path[i+1], err)
-			}
+	for _, r := range path {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("path %q contains control characters", path)
+		}
+	}
 
-			if index < 0 || int(index) >= len(s) {
-				return fmt.Errorf("array index %d out of bounds [0,%d)", index, len(s))
-			}
+	if strings.Contains(path, "//") {
+		return fmt.Errorf("path '%s' contains an empty segment", path)
+	}
 
-			found = s[index]
-			i++ // Skip index element
+	trimmed := strings.TrimSuffix(path[1:], "/")
+	if trimmed == "" {
+		return nil
+	}
 
-			if om, ok := found.(*orderedmap.OrderedMap); ok {
-				foundMap = om
-			} else if om, ok := found.(orderedmap.OrderedMap); ok {
-				foundMap = &om
-			} else {
-				return fmt.Errorf("expected OrderedMap at array index %d", index)
-			}
-		default:
-			return fmt.Errorf("cannot traverse through type '%v' at '%s'", k, splited_path[i])
+	for _, segment := range strings.Split(trimmed, "/") {
+		switch segment {
+		case ".", "..":
+			return fmt.Errorf("path '%s' contains a relative segment '%s'", path, segment)
 		}
-		i++
 	}
-
-	foundMap.Set(splited_path[len(splited_path)-1], value)
 	return nil
 }
 
-func jsonRemoveByPath(jsonMap *orderedmap.OrderedMap, path string, index int) error {
-	if jsonMap == nil {
-		return errors.New("jsonMap cannot be nil")
+// normalizePath validates path and returns its canonical form, without a
+// trailing slash ("/" for the root)
+func normalizePath(path string) (string, error) {
+	if err := ValidatePath(path); err != nil {
+		return "", err
 	}
+	return "/" + strings.Join(splitPath(path), "/"), nil
+}
 
-	splited_path := strings.Split(path, "/")
-	if len(splited_path) == 0 {
-		return errors.New("invalid path: empty")
-	}
-
-	foundMap := jsonMap
-
-	for i := 0; i < len(splited_path)-1; {
-		if len(splited_path[i]) == 0 {
-			i++
-			continue
-		}
-
-		found, present := foundMap.Get(splited_path[i])
-		if !present {
-			return fmt.Errorf("path element '%s' not found", splited_path[i])
+// splitPath returns the non-empty segments of a slash separated path
+func splitPath(path string) []string {
+	segments := make([]string, 0)
+	for _, segment := range strings.Split(path, "/") {
+		if len(segment) > 0 {
+			segments = append(segments, segment)
 		}
+	}
+	return segments
+}
 
-		k := reflect.TypeOf(found).Kind()
-		switch k {
-		case reflect.Map, reflect.Struct:
-			if om, ok := found.(*orderedmap.OrderedMap); ok {
-				foundMap = om
-			} else if om, ok := found.(orderedmap.OrderedMap); ok {
-				foundMap = &om
-			} else {
-				return fmt.Errorf("expected OrderedMap at '%s'", splited_path[i])
-			}
-		case reflect.Slice:
-			s, ok := found.([]interface{})
-			if !ok {
-				return fmt.Errorf("expected []interface{} at '%s'", splited_path[i])
-			}
-
-			if i+1 >= len(splited_path) {
-				return errors.New("invalid path: missing index after array")
-			}
+// pointerSegments returns the unescaped keys of a JSON Pointer (RFC 6901).
+// Paths inside the manager are pointers, so keys containing '/' or '~' are
+// addressed as "~1" and "~0".
+func pointerSegments(path string) []string {
+	segments := splitPath(path)
+	for i, segment := range segments {
+		segments[i] = unescapePointerSegment(segment)
+	}
+	return segments
+}
 
-			arrayIndex, err := strconv.ParseInt(splited_path[i+1], 10, 32)
-			if err != nil {
-				return fmt.Errorf("invalid array index '%s': %w", splited_path[i+1], err)
-			}
+// joinPointer builds a JSON Pointer from unescaped keys
+func joinPointer(segments []string) string {
+	escaped := make([]string, len(segments))
+	for i, segment := range segments {
+		escaped[i] = escapePointerSegment(segment)
+	}
+	return "/" + strings.Join(escaped, "/")
+}
 
-			if arrayIndex < 0 || int(arrayIndex) >= len(s) {
-				return fmt.Errorf("array index %d out of bounds", arrayIndex)
-			}
+func escapePointerSegment(segment string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(segment, "~", "~0"), "/", "~1")
+}
 
-			found = s[arrayIndex]
-			i++
+func unescapePointerSegment(segment string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(segment, "~1", "/"), "~0", "~")
+}
 
-			if om, ok := found.(*orderedmap.OrderedMap); ok {
-				foundMap = om
-			} else if om, ok := found.(orderedmap.OrderedMap); ok {
-				foundMap = &om
-			} else {
-				return fmt.Errorf("expected OrderedMap at array index %d", arrayIndex)
-			}
-		default:
-			return fmt.Errorf("cannot traverse through type '%v'", k)
+// validatePointerEscapes checks that every '~' in path starts a "~0" or "~1"
+// escape
+func validatePointerEscapes(path string) error {
+	for i := 0; i < len(path); i++ {
+		if path[i] == '~' && (i+1 == len(path) || (path[i+1] != '0' && path[i+1] != '1')) {
+			return fmt.Errorf("path '%s' contains an invalid escape at offset %d", path, i)
 		}
-		i++
 	}
+	return nil
+}
 
-	found_list, present := foundMap.Get(splited_path[len(splited_path)-1])
-	if !present {
-		return fmt.Errorf("path element '%s' not found", splited_path[len(splited_path)-1])
+// jsonChild returns the value stored under segment in an object or array
+func jsonChild(container interface{}, segment string) (interface{}, error) {
+	switch c := container.(type) {
+	case *orderedmap.OrderedMap:
+		found, present := c.Get(segment)
+		if !present {
+			return nil, fmt.Errorf("path element '%s' not found", segment)
+		}
+		return found, nil
+	case orderedmap.OrderedMap:
+		found, present := c.Get(segment)
+		if !present {
+			return nil, fmt.Errorf("path element '%s' not found", segment)
+		}
+		return found, nil
+	case []interface{}:
+		index, err := jsonArrayIndex(c, segment)
+		if err != nil {
+			return nil, err
+		}
+		return c[index], nil
+	default:
+		return nil, fmt.Errorf("cannot traverse through type '%v' at '%s'", reflect.TypeOf(container), segment)
 	}
+}
 
-	list, ok := found_list.([]interface{})
-	if !ok {
-		return errors.New("target is not an array")
+// jsonDescend is jsonChild for traversals that go on to modify the child.
+// Nested objects may be decoded as orderedmap.OrderedMap values; modifying a
+// copy of such a value can be lost (adding a key appends to the copy's key
+// list only), so the child is stored back into its container as a pointer
+// and that pointer is returned.
+func jsonDescend(container interface{}, segment string) (interface{}, error) {
+	child, err := jsonChild(container, segment)
+	if err != nil {
+		return nil, err
 	}
 
-	if index < 0 || index >= len(list) {
-		return fmt.Errorf("index %d out of bounds [0,%d)", index, len(list))
+	if value, ok := child.(orderedmap.OrderedMap); ok {
+		ptr := &value
+		if err := jsonStore(container, segment, ptr); err != nil {
+			return nil, err
+		}
+		return ptr, nil
 	}
-
-	newList := make([]interface{}, 0, len(list)-1)
-	newList = append(newList, list[:index]...)
-	newList = append(newList, list[index+1:]...)
-
-	foundMap.Set(splited_path[len(splited_path)-1], newList)
-	return nil
+	return child, nil
 }
 
-func jsonInsertByPath(jsonMap *orderedmap.OrderedMap, path string, index int, value interface{}) error {
-	if jsonMap == nil {
-		return errors.New("jsonMap cannot be nil")
+// jsonStore writes value under segment in an object or array container
+func jsonStore(container interface{}, segment string, value interface{}) error {
+	switch c := container.(type) {
+	case *orderedmap.OrderedMap:
+		c.Set(segment, value)
+		return nil
+	case []interface{}:
+		index, err := jsonArrayIndex(c, segment)
+		if err != nil {
+			return err
+		}
+		c[index] = value
+		return nil
+	default:
+		return fmt.Errorf("cannot store into type '%T'", container)
 	}
+}
 
-	splited_path := strings.Split(path, "/")
-	if len(splited_path) == 0 {
-		return errors.New("invalid path: empty")
+func jsonArrayIndex(list []interface{}, segment string) (int, error) {
+	index, err := strconv.ParseInt(segment, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid array index '%s': %w", segment, err)
 	}
+	if index < 0 || int(index) >= len(list) {
+		return 0, fmt.Errorf("array index %d out of bounds [0,%d)", index, len(list))
+	}
+	return int(index), nil
+}
 
-	foundMap := jsonMap
-
-	for i := 0; i < len(splited_path)-1; {
-		if len(splited_path[i]) == 0 {
-			i++
-			continue
+// jsonTargetList returns the array addressed by path
+func jsonTargetList(root interface{}, parent interface{}, last string) ([]interface{}, error) {
+	target := root
+	if parent != nil {
+		var err error
+		if target, err = jsonChild(parent, last); err != nil {
+			return nil, err
 		}
+	}
 
-		found, present := foundMap.Get(splited_path[i])
-		if !present {
-			return fmt.Errorf("path element '%s' not found", splited_path[i])
+	list, ok := target.([]interface{})
+	if !ok {
+		if target == nil {
+			return nil, errors.New("target is null, not an array")
 		}
+		return nil, errors.New("target is not an array")
+	}
+	return list, nil
+}
 
-		k := reflect.TypeOf(found).Kind()
-		switch k {
-		case reflect.Map, reflect.Struct:
-			if om, ok := found.(*orderedmap.OrderedMap); ok {
-				foundMap = om
-			} else if om, ok := found.(orderedmap.OrderedMap); ok {
-				foundMap = &om
-			} else {
-				return fmt.Errorf("expected OrderedMap at '%s'", splited_path[i])
-			}
-		case reflect.Slice:
-			s, ok := found.([]interface{})
-			if !ok {
-				return fmt.Errorf("expected []interface{} at '%s'", splited_path[i])
-			}
+// jsonValueAt returns the value at path in a parsed document
+func jsonValueAt(root interface{}, path string) (interface{}, error) {
+	parent, last, err := jsonLocate(root, path)
+	if err != nil {
+		return nil, err
+	}
+	if parent == nil {
+		return root, nil
+	}
+	return jsonChild(parent, last)
+}
 
-			if i+1 >= len(splited_path) {
-				return errors.New("invalid path: missing index after array")
-			}
+// jsonSetByPath stores value at path and returns the resulting root, which
+// differs from root only when path addresses the root itself
+func jsonSetByPath(root interface{}, path string, value interface{}) (interface{}, error) {
+	parent, last, err := jsonLocate(root, path)
+	if err != nil {
+		return nil, err
+	}
+	if parent == nil {
+		return value, nil
+	}
 
-			arrayIndex, err := strconv.ParseInt(splited_path[i+1], 10, 32)
-			if err != nil {
-				return fmt.Errorf("invalid array index '%s': %w", splited_path[i+1], err)
-			}
+	if err := jsonStore(parent, last, value); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
 
-			if arrayIndex < 0 || int(arrayIndex) >= len(s) {
-				return fmt.Errorf("array index %d out of bounds", arrayIndex)
-			}
+func jsonRemoveByPath(root interface{}, path string, index int) (interface{}, error) {
+	parent, last, err := jsonLocate(root, path)
+	if err != nil {
+		return nil, err
+	}
 
-			found = s[arrayIndex]
-			i++
+	list, err := jsonTargetList(root, parent, last)
+	if err != nil {
+		return nil, err
+	}
 
-			if om, ok := found.(*orderedmap.OrderedMap); ok {
-				foundMap = om
-			} else if om, ok := found.(orderedmap.OrderedMap); ok {
-				foundMap = &om
-			} else {
-				return fmt.Errorf("expected OrderedMap at array index %d", arrayIndex)
-			}
-		default:
-			return fmt.Errorf("cannot traverse through type '%v'", k)
-		}
-		i++
+	if index < 0 || index >= len(list) {
+		return nil, fmt.Errorf("index %d out of bounds [0,%d)", index, len(list))
 	}
 
-	found_list, present := foundMap.Get(splited_path[len(splited_path)-1])
-	if !present {
-		return fmt.Errorf("path element '%s' not found", splited_path[len(splited_path)-1])
+	newList := make([]interface{}, 0, len(list)-1)
+	newList = append(newList, list[:index]...)
+	newList = append(newList, list[index+1:]...)
+
+	if parent == nil {
+		return newList, nil
+	}
+	if err := jsonStore(parent, last, newList); err != nil {
+		return nil, err
 	}
+	return root, nil
+}
 
-	list, ok := found_list.([]interface{})
-	if !ok {
-		return errors.New("target is not an array")
+func jsonInsertByPath(root interface{}, path string, index int, value interface{}) (interface{}, error) {
+	parent, last, err := jsonLocate(root, path)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := jsonTargetList(root, parent, last)
+	if err != nil {
+		return nil, err
 	}
 
 	if index < 0 || index > len(list) {
-		return fmt.Errorf("index %d out of bounds [0,%d]", index, len(list))
+		return nil, fmt.Errorf("index %d out of bounds [0,%d]", index, len(list))
 	}
 
 	newList := make([]interface{}, 0, len(list)+1)
@@ -261,21 +317,25 @@ func jsonInsertByPath(jsonMap *orderedmap.OrderedMap, path string, index int, va
 	newList = append(newList, value)
 	newList = append(newList, list[index:]...)
 
-	foundMap.Set(splited_path[len(splited_path)-1], newList)
-	return nil
+	if parent == nil {
+		return newList, nil
+	}
+	if err := jsonStore(parent, last, newList); err != nil {
+		return nil, err
+	}
+	return root, nil
 }
 
+// findNodePath returns the path of desiredNode below parentNode, "/" for
+// parentNode itself, or "" when desiredNode is not part of the tree
 func findNodePath(parentNode *Node, desiredNode *Node) string {
 	if parentNode == desiredNode {
-		return ""
+		return "/"
 	}
 
 	// Use slice of strings for path segments, join at the end
 	var pathSegments []string
 	if findNodePathRecursive(parentNode, desiredNode, &pathSegments) {
-		if len(pathSegments) == 0 {
-			return ""
-		}
 		return "/" + strings.Join(pathSegments, "/")
 	}
 	return ""
@@ -306,7 +366,7 @@ func findNodePathRecursive(parentNode *Node, desiredNode *Node, pathSegments *[]
 		if err == nil {
 			for key, innerNode := range obj {
 				// Add segment
-				*pathSegments = append(*pathSegments, key)
+				*pathSegments = append(*pathSegments, escapePointerSegment(key))
 
 				if findNodePathRecursive(innerNode, desiredNode, pathSegments) {
 					return true
@@ -375,6 +435,8 @@ func parseNode(value any) *Node {
 		node.value = v
 	case int:
 		node.value = v
+	case int64:
+		node.value = v
 	case float64:
 		node.value = v
 	case bool:
@@ -388,6 +450,29 @@ func parseNode(value any) *Node {
 	return node
 }
 
+// cloneJSON creates a deep copy of a parsed config (object or array root)
+func cloneJSON(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, errors.New("cannot clone nil config")
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	return parseValue(data)
+}
+
+// plainValue turns a *Node passed as a value to insert or replace into the
+// plain Go values the rest of the pipeline works with
+func plainValue(value interface{}) interface{} {
+	if n, ok := value.(*Node); ok {
+		return n.toInterface()
+	}
+	return value
+}
+
 // Clone creates a deep copy of an OrderedMap
 func Clone(om *orderedmap.OrderedMap) (*orderedmap.OrderedMap, error) {
 	if om == nil {
@@ -399,10 +484,274 @@ func Clone(om *orderedmap.OrderedMap) (*orderedmap.OrderedMap, error) {
 		return nil, fmt.Errorf("failed to marshal OrderedMap: %w", err)
 	}
 
-	clone := orderedmap.New()
-	if err := json.Unmarshal(data, &clone); err != nil {
+	clone, err := parseValue(data)
+	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal OrderedMap: %w", err)
 	}
 
-	return clone, nil
-}
\ No newline at end of file
+	return clone.(*orderedmap.OrderedMap), nil
+}
+
+// maxParseDepth bounds the nesting of parsed documents so a hostile input
+// cannot exhaust the stack while it is decoded or turned into nodes
+const maxParseDepth = 1000
+
+// parseValue decodes an arbitrary JSON value, keeping object key order.
+// Integers are decoded as int64 so they keep their exact value; other numbers
+// are float64.
+func parseValue(data []byte) (interface{}, error) {
+	if err := checkJSONDepth(data, maxParseDepth); err != nil {
+		return nil, err
+	}
+	return parseJSONValue(data)
+}
+
+// checkJSONDepth fails when the objects and arrays in data nest deeper than
+// max. It only counts brackets outside strings; syntax is left to the
+// decoder.
+func checkJSONDepth(data []byte, max int) error {
+	depth := 0
+	inString, escaped := false, false
+
+	for _, c := range data {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > max {
+				return fmt.Errorf("value is nested deeper than %d levels", max)
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+	return nil
+}
+
+func parseJSONValue(data []byte) (interface{}, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, errors.New("value is empty")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	value, err := decodeJSONValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid character after top-level value")
+	}
+	return value, nil
+}
+
+// decodeJSONValue reads the next value from dec, which must use numbers
+func decodeJSONValue(dec *json.Decoder) (interface{}, error) {
+	token, err := dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	switch t := token.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			om := orderedmap.New()
+			for dec.More() {
+				keyToken, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				key, _ := keyToken.(string)
+				value, err := decodeJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				om.Set(key, value)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return om, nil
+
+		case '[':
+			arr := make([]interface{}, 0)
+			for dec.More() {
+				value, err := decodeJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, value)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return arr, nil
+
+		default:
+			return nil, fmt.Errorf("invalid character '%v'", t)
+		}
+
+	case json.Number:
+		return jsonNumber(t)
+
+	default:
+		return t, nil
+	}
+}
+
+// jsonNumber converts a decoded number: integers that fit are kept exactly
+// as int64, anything else (fractions, exponents, huge values) is a float64
+func jsonNumber(n json.Number) (interface{}, error) {
+	if i, err := n.Int64(); err == nil {
+		return i, nil
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %s: %w", n, err)
+	}
+	return f, nil
+}
+
+func findNodeByPath(root *Node, path string) (*Node, error) {
+	if root == nil {
+		return nil, errors.New("node is nil")
+	}
+
+	current := root
+	segments := pointerSegments(path)
+	for i, segment := range segments {
+		if current.Type() == Null && i > 0 {
+			return nil, fmt.Errorf("path element '%s' is null", segments[i-1])
+		}
+
+		var err error
+		if current, err = current.atSegment(segment); err != nil {
+			return nil, err
+		}
+	}
+
+	return current, nil
+}
+
+// atSegment returns the child of n named by one unescaped path segment: a
+// key of an object or an index into an array
+func (n *Node) atSegment(segment string) (*Node, error) {
+	switch n.Type() {
+	case Object:
+		return n.atString(segment)
+	case Array:
+		index, err := strconv.Atoi(segment)
+		if err != nil {
+			return nil, fmt.Errorf("invalid array index '%s'", segment)
+		}
+		return n.atInt(index)
+	case Null:
+		return nil, fmt.Errorf("cannot traverse through null at '%s'", segment)
+	default:
+		return nil, fmt.Errorf("cannot traverse through type '%v' at '%s'", n.Type(), segment)
+	}
+}
+
+// foldPathKeys rewrites the object keys in path to the keys actually stored
+// in root, matching case-insensitively when there is no exact match. A key
+// that matches several stored keys that differ only in case is an error.
+// Segments past the first unresolvable one are returned unchanged.
+func foldPathKeys(root *Node, path string) (string, error) {
+	segments := pointerSegments(path)
+	current := root
+
+	for i, segment := range segments {
+		if current == nil {
+			break
+		}
+
+		switch current.Type() {
+		case Object:
+			obj, _ := current.GetObject()
+			if child, ok := obj[segment]; ok {
+				current = child
+				continue
+			}
+
+			matches := make([]string, 0, 1)
+			for _, key := range sortedKeys(obj) {
+				if strings.EqualFold(key, segment) {
+					matches = append(matches, key)
+				}
+			}
+			switch len(matches) {
+			case 0:
+				current = nil
+			case 1:
+				segments[i] = matches[0]
+				current = obj[matches[0]]
+			default:
+				return "", fmt.Errorf("ambiguous key '%s': matches %s", segment, strings.Join(matches, ", "))
+			}
+		case Array:
+			arr, _ := current.GetArray()
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(arr) {
+				current = nil
+			} else {
+				current = arr[index]
+			}
+		default:
+			current = nil
+		}
+	}
+
+	return joinPointer(segments), nil
+}
+
+// graftNode makes target the node at path inside root: target takes over
+// the value found there and the parent is re-pointed at target, so existing
+// references to target stay attached to the tree
+func graftNode(root *Node, path string, target *Node) error {
+	found, err := findNodeByPath(root, path)
+	if err != nil {
+		return err
+	}
+	if found == target {
+		return nil
+	}
+
+	segments := pointerSegments(path)
+	if len(segments) == 0 {
+		return errors.New("cannot graft onto the root node")
+	}
+
+	parent, err := findNodeByPath(root, joinPointer(segments[:len(segments)-1]))
+	if err != nil {
+		return err
+	}
+
+	*target = *found
+	last := segments[len(segments)-1]
+	switch v := parent.value.(type) {
+	case map[string]*Node:
+		v[last] = target
+	case []*Node:
+		index, _ := strconv.Atoi(last)
+		v[index] = target
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+func TestValidatePath(t *testing.T) {
+	tests := []struct {
+		path       string
+		valid      bool
+		normalized string
+	}{
+		{"", false, ""},
+		{"a/b", false, ""},
+		{"/a//b", false, ""},
+		{"//a", false, ""},
+		{"/a/\x00b", false, ""},
+		{"/a/b\n", false, ""},
+		{"/a/../b", false, ""},
+		{"/./a", false, ""},
+		{"/", true, "/"},
+		{"/a/b", true, "/a/b"},
+		{"/a/b/", true, "/a/b"},
+		{"/users/0/name", true, "/users/0/name"},
+	}
+	for _, tt := range tests {
+		err := ValidatePath(tt.path)
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidatePath(%q) = %v, want valid %v", tt.path, err, tt.valid)
+			continue
+		}
+		if !tt.valid {
+			continue
+		}
+		if got, err := normalizePath(tt.path); err != nil || got != tt.normalized {
+			t.Errorf("normalizePath(%q) = %q, %v, want %q", tt.path, got, err, tt.normalized)
+		}
+	}
+}
+
+func TestSetInsideArrayOfObjects(t *testing.T) {
+	// Objects nested in arrays may be held by value rather than by pointer
+	item := orderedmap.New()
+	item.Set("name", "a")
+	root := orderedmap.New()
+	root.Set("items", []interface{}{*item})
+
+	if _, err := jsonSetByPath(root, "/items/0/name", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jsonSetByPath(root, "/items/0/port", 80); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := json.Marshal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"items":[{"name":"b","port":80}]}`; string(got) != want {
+		t.Errorf("serialized %s, want %s", got, want)
+	}
+}
+
+func TestLargeIntegersStayExact(t *testing.T) {
+	const big = 9007199254740993 // 2^53 + 1, not representable as float64
+
+	m := newTestManager(t, `{"id":9007199254740993,"ratio":0.5,"ids":[]}`)
+	root := m.Config()
+
+	id, err := root.At("id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Type() != Integral {
+		t.Errorf("id type = %v, want integral", id.Type())
+	}
+	if v, err := id.GetInt(); err != nil || v != big {
+		t.Errorf("GetInt(id) = %d, %v, want %d", v, err, big)
+	}
+	if ratio, _ := root.At("ratio"); ratio.Type() != FloatingPoint {
+		t.Errorf("ratio type = %v, want floating point", ratio.Type())
+	}
+
+	// Through a write and the source back into a new manager
+	ids, err := m.ConfigRef().At("ids")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(ids, nil); err != nil {
+		t.Fatal(err)
+	}
+	code, body := serve(t, m, "POST", "/config", `{"op":"insert","path":"/ids","index":0,"value":9007199254740995}`)
+	if code != 201 {
+		t.Fatalf("insert: status = %d: %v", code, body)
+	}
+
+	persisted := *m.Source().getConfig()
+	for _, want := range []string{"9007199254740993", "9007199254740995"} {
+		if !strings.Contains(persisted, want) {
+			t.Errorf("persisted config %s lacks %s", persisted, want)
+		}
+	}
+
+	reloaded := newTestManager(t, persisted)
+	first, err := reloaded.Config().At("ids")
+	if err == nil {
+		first, err = first.At(0)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := first.GetInt(); v != big+2 {
+		t.Errorf("reloaded ids[0] = %d, want %d", v, big+2)
+	}
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// defaultValidationTimeout bounds a validation service call when
+// SetValidationService is given no timeout
+const defaultValidationTimeout = 5 * time.Second
+
+// ValidationService checks a complete proposed config with an external
+// system, such as a policy engine, before a change is applied. Returning an
+// error rejects the change. config is a copy and must not be modified.
+type ValidationService interface {
+	Validate(ctx context.Context, config interface{}, schema string) error
+}
+
+// SetValidationService makes every insert, remove and replace, the operations
+// built on them (moves, swaps and reorders), batches, patches, imports,
+// restores and reverts ask svc about the resulting config once the schema and
+// the custom validators have passed, before critical handlers run. Each call
+// gets a context cancelled after timeout, or 5 seconds when timeout is zero or
+// less. Reload, which brings back what the source holds, is not checked. A
+// nil svc removes the service.
+//
+// The manager lock is released while svc runs, so readers are not held up;
+// if the config changed in the meantime the modification is aborted, as for
+// critical handlers.
+func (m *Manager) SetValidationService(svc ValidationService, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultValidationTimeout
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.validationService = svc
+	m.validationTimeout = timeout
+}
+
+// runValidationServiceLocked asks the validation service about config, with
+// the manager lock released. mod, the registration the change goes through,
+// is looked up again since registrations may have moved; it may be nil.
+func (m *Manager) runValidationServiceLocked(mod *modifiable, config interface{}) (*modifiable, error) {
+	svc := m.validationService
+	if svc == nil || m.staging {
+		return mod, nil
+	}
+
+	timeout, version, schema := m.validationTimeout, m.version, *m.source.getSchema()
+	config, err := cloneJSON(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone config: %w", err)
+	}
+
+	m.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	err = svc.Validate(ctx, config, schema)
+	cancel()
+	m.mu.Lock()
+
+	if err != nil {
+		return nil, fmt.Errorf("validation service rejected the change: %w", err)
+	}
+	if m.version != version {
+		return nil, fmt.Errorf("config changed while the validation service was running")
+	}
+
+	if mod == nil {
+		return nil, nil
+	}
+	return m.findModifiableLocked(mod.Type, mod.Path)
+}
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeService is a ValidationService calling validate, when set, and
+// recording the configs it was asked about
+type fakeService struct {
+	configs  []string
+	validate func(ctx context.Context) error
+}
+
+func (s *fakeService) Validate(ctx context.Context, config interface{}, schema string) error {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	s.configs = append(s.configs, string(data))
+	if s.validate != nil {
+		return s.validate(ctx)
+	}
+	return nil
+}
+
+func TestValidationServiceSeesProposedConfig(t *testing.T) {
+	m := newTestManager(t, `{"a":1,"list":[1]}`)
+	replaceable(t, m, "a")
+	list, err := m.ConfigRef().At("list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(list, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	svc := &fakeService{}
+	m.SetValidationService(svc, 0)
+
+	if err := m.Replace("/a", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Insert("/list", 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Batch([]Operation{{Op: "replace", Path: "/a", Value: 3}}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`{"a":2,"list":[1]}`, `{"a":2,"list":[1,2]}`, `{"a":3,"list":[1,2]}`}
+	if len(svc.configs) != len(want) {
+		t.Fatalf("service asked about %v, want %v", svc.configs, want)
+	}
+	for i := range want {
+		if !sameDoc(svc.configs[i], want[i]) {
+			t.Errorf("call %d: config %s, want %s", i, svc.configs[i], want[i])
+		}
+	}
+
+	// Rejections abort the change
+	svc.validate = func(context.Context) error { return errors.New("policy says no") }
+	version := m.Version()
+	err = m.Replace("/a", 4)
+	if err == nil || !strings.Contains(err.Error(), "validation service rejected the change: policy says no") {
+		t.Errorf("rejected replace: err = %v", err)
+	}
+	if err := m.Import([]byte(`{"a":5,"list":[]}`)); err == nil {
+		t.Error("rejected import applied")
+	}
+	if v, _ := m.ConfigRef().GetInt("a"); v != 3 || m.Version() != version {
+		t.Errorf("a = %d, version = %d after rejections, want 3 and %d", v, m.Version(), version)
+	}
+
+	// Without a service nothing is asked
+	m.SetValidationService(nil, 0)
+	if err := m.Replace("/a", 6); err != nil {
+		t.Errorf("replace without a service: %v", err)
+	}
+}
+
+func TestValidationServiceRunsUnlocked(t *testing.T) {
+	m := newTestManager(t, `{"a":1,"b":1}`)
+	replaceable(t, m, "a")
+	replaceable(t, m, "b")
+
+	// Readers get through while the service runs, and so do writers, which
+	// makes the modification that was being validated fail
+	svc := &fakeService{}
+	svc.validate = func(context.Context) error {
+		if len(svc.configs) > 1 {
+			return nil
+		}
+		if v := m.Version(); v != 1 {
+			t.Errorf("version read during validation = %d, want 1", v)
+		}
+		if err := m.Replace("/b", 2); err != nil {
+			t.Errorf("write during validation: %v", err)
+		}
+		return nil
+	}
+	m.SetValidationService(svc, time.Second)
+
+	err := m.Replace("/a", 2)
+	if err == nil || !strings.Contains(err.Error(), "config changed while the validation service was running") {
+		t.Errorf("err = %v, want the concurrent change reported", err)
+	}
+	if a, _ := m.ConfigRef().GetInt("a"); a != 1 {
+		t.Errorf("a = %d, want 1", a)
+	}
+	if b, _ := m.ConfigRef().GetInt("b"); b != 2 {
+		t.Errorf("b = %d, want 2", b)
+	}
+}
+
+func TestValidationServiceTimeout(t *testing.T) {
+	m := newTestManager(t, `{"a":1}`)
+	replaceable(t, m, "a")
+
+	m.SetValidationService(&fakeService{validate: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}, 20*time.Millisecond)
+
+	start := time.Now()
+	err := m.Replace("/a", 2)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("replace took %s, want the service cut off", elapsed)
+	}
+}
+
+func TestValidationServiceChecksRestore(t *testing.T) {
+	m := newTestManager(t, `{"a":1}`)
+	replaceable(t, m, "a")
+	snapshot, _ := m.Snapshot()
+	if err := m.Replace("/a", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	svc := &fakeService{validate: func(context.Context) error { return errors.New("frozen") }}
+	m.SetValidationService(svc, 0)
+	if err := m.Restore(snapshot); err == nil || !strings.Contains(err.Error(), "frozen") {
+		t.Errorf("restore: err = %v, want the service's rejection", err)
+	}
+	if len(svc.configs) != 1 || !sameDoc(svc.configs[0], `{"a":1}`) {
+		t.Errorf("service asked about %v, want the snapshot's config", svc.configs)
+	}
+	if a, _ := m.ConfigRef().GetInt("a"); a != 2 {
+		t.Errorf("a = %d after a rejected restore, want 2", a)
+	}
+}
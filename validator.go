@@ -1,13 +1,39 @@
 package config
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/xeipuuv/gojsonschema"
 )
 
+// FieldError is one schema violation
+type FieldError struct {
+	Path    string `json:"path"`    // where the violation is, "/" for the root
+	Type    string `json:"type"`    // the failed check, e.g. "invalid_type"
+	Message string `json:"message"` // human readable, prefixed with the field
+}
+
+// ValidationError reports every schema violation found in a document
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("validation failed:")
+	for i, fe := range e.Errors {
+		sb.WriteString("\n  ")
+		sb.WriteString(fmt.Sprintf("[%d] %s", i+1, fe.Message))
+	}
+	return sb.String()
+}
+
+// validate checks conf against schema and returns a *ValidationError when
+// it does not conform
 func validate(conf, schema *string) error {
 	if conf == nil {
 		return errors.New("config cannot be nil")
@@ -16,23 +42,254 @@ func validate(conf, schema *string) error {
 		return errors.New("schema cannot be nil")
 	}
 
-	loadedSchema := gojsonschema.NewBytesLoader([]byte(*schema))
-	documentLoader := gojsonschema.NewBytesLoader([]byte(*conf))
+	fieldErrors, err := schemaFieldErrors([]byte(*schema), []byte(*conf))
+	if err != nil {
+		return err
+	}
+
+	if len(fieldErrors) > 0 {
+		return &ValidationError{Errors: fieldErrors}
+	}
+
+	return nil
+}
+
+// ValidateDocument checks a complete config document against the schema,
+// independently of the current config. A document that violates the schema
+// yields a *ValidationError listing every violation; any other error means
+// doc is not a config document at all.
+func (m *Manager) ValidateDocument(doc []byte) error {
+	parsed, err := parseConfig(doc)
+	if err != nil {
+		return err
+	}
+	return m.validateSchema(parsed, m.Source().getSchema())
+}
+
+// maxCompiledSchemas bounds the cache of compiled schemas; besides the
+// config schema it holds the sub-schemas used to describe failed branches
+const maxCompiledSchemas = 32
+
+var (
+	compiledMu      sync.Mutex
+	compiledSchemas = make(map[string]*gojsonschema.Schema)
+)
+
+// compileSchema returns schema compiled, reusing an earlier compilation of
+// the same text
+func compileSchema(schema []byte) (*gojsonschema.Schema, error) {
+	compiledMu.Lock()
+	defer compiledMu.Unlock()
+
+	if compiled, ok := compiledSchemas[string(schema)]; ok {
+		return compiled, nil
+	}
+
+	compiled, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(schema))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(compiledSchemas) >= maxCompiledSchemas {
+		compiledSchemas = make(map[string]*gojsonschema.Schema)
+	}
+	compiledSchemas[string(schema)] = compiled
+	return compiled, nil
+}
+
+// schemaErrors validates document against schema and returns one
+// description per violation (empty when the document is valid)
+func schemaErrors(schema, document []byte) ([]string, error) {
+	fieldErrors, err := schemaFieldErrors(schema, document)
+	if err != nil {
+		return nil, err
+	}
+
+	descriptions := make([]string, len(fieldErrors))
+	for i, fe := range fieldErrors {
+		descriptions[i] = fe.Message
+	}
+	return descriptions, nil
+}
+
+// schemaFieldErrors is schemaErrors reporting each violation with its path
+// and type
+func schemaFieldErrors(schema, document []byte) ([]FieldError, error) {
+	compiled, err := compileSchema(schema)
+	if err != nil {
+		return nil, fmt.Errorf("validation error: %w", err)
+	}
+
+	result, err := compiled.Validate(gojsonschema.NewBytesLoader(document))
+	if err != nil {
+		return nil, fmt.Errorf("validation error: %w", err)
+	}
+
+	var root map[string]interface{}
+	fieldErrors := make([]FieldError, 0, len(result.Errors()))
+	for _, desc := range result.Errors() {
+		fe := FieldError{Path: contextPath(desc.Context()), Type: desc.Type()}
+
+		keyword := branchKeyword(desc.Type())
+		if keyword != "" && root == nil {
+			if err := json.Unmarshal(schema, &root); err != nil {
+				keyword = ""
+			}
+		}
+		if keyword != "" {
+			fe.Message = describeBranches(root, desc, keyword)
+		} else {
+			fe.Message = desc.String()
+		}
+		fieldErrors = append(fieldErrors, fe)
+	}
+	return fieldErrors, nil
+}
+
+// contextPath turns a validator context such as "(root).users.0" into a
+// path ("/users/0")
+func contextPath(ctx *gojsonschema.JsonContext) string {
+	if ctx == nil {
+		return "/"
+	}
+	path := strings.TrimPrefix(ctx.String("/"), gojsonschema.STRING_CONTEXT_ROOT)
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+func branchKeyword(errorType string) string {
+	switch errorType {
+	case "number_one_of":
+		return "oneOf"
+	case "number_any_of":
+		return "anyOf"
+	case "condition_then":
+		return "then"
+	case "condition_else":
+		return "else"
+	default:
+		return ""
+	}
+}
 
-	result, err := gojsonschema.Validate(loadedSchema, documentLoader)
+// describeBranches expands a oneOf/anyOf failure with the outcome of every
+// branch, marking the one with the fewest errors as closest, and a failed
+// if/then/else with the errors of the branch that applied. Falls back to the
+// plain description when the branches cannot be located.
+func describeBranches(root map[string]interface{}, desc gojsonschema.ResultError, keyword string) string {
+	path := ""
+	if field := desc.Field(); field != gojsonschema.STRING_ROOT_SCHEMA_PROPERTY {
+		path = "/" + strings.ReplaceAll(field, ".", "/")
+	}
+
+	schema := schemaForPath(root, path)
+	if schema == nil {
+		return desc.String()
+	}
+	var branches []interface{}
+	switch b := schema[keyword].(type) {
+	case []interface{}:
+		branches = b
+	case map[string]interface{}:
+		branches = []interface{}{b} // then/else
+	}
+	if len(branches) == 0 {
+		return desc.String()
+	}
+
+	valueBytes, err := json.Marshal(desc.Value())
 	if err != nil {
-		return fmt.Errorf("validation error: %w", err)
+		return desc.String()
+	}
+
+	outcomes := make([][]string, len(branches))
+	titles := make([]string, len(branches))
+	closest := -1
+	for i, b := range branches {
+		branch := resolveSchemaRef(root, asSchema(b))
+		if titles[i], _ = asSchema(b)["title"].(string); titles[i] == "" {
+			titles[i], _ = branch["title"].(string)
+		}
+
+		schemaBytes, err := json.Marshal(standaloneSchema(root, branch))
+		if err != nil {
+			return desc.String()
+		}
+		if outcomes[i], err = schemaErrors(schemaBytes, valueBytes); err != nil {
+			return desc.String()
+		}
+		for j := range outcomes[i] {
+			outcomes[i][j] = strings.TrimPrefix(outcomes[i][j], gojsonschema.STRING_ROOT_SCHEMA_PROPERTY+": ")
+		}
+		if len(outcomes[i]) > 0 && (closest < 0 || len(outcomes[i]) < len(outcomes[closest])) {
+			closest = i
+		}
 	}
 
-	if !result.Valid() {
-		var sb strings.Builder
-		sb.WriteString("validation failed:")
-		for i, desc := range result.Errors() {
-			sb.WriteString("\n  ")
-			sb.WriteString(fmt.Sprintf("[%d] %s", i+1, desc.String()))
+	var sb strings.Builder
+	sb.WriteString(desc.String())
+	if len(branches) == 1 {
+		sb.WriteString(fmt.Sprintf("; %s: %s", keyword, strings.Join(outcomes[0], ", ")))
+		return sb.String()
+	}
+	for i, errs := range outcomes {
+		sb.WriteString(fmt.Sprintf("; branch %d", i))
+		if titles[i] != "" {
+			sb.WriteString(fmt.Sprintf(" %q", titles[i]))
+		}
+		switch {
+		case len(errs) == 0:
+			sb.WriteString(": valid")
+		case i == closest:
+			sb.WriteString(" (closest): " + strings.Join(errs, ", "))
+		default:
+			sb.WriteString(": " + strings.Join(errs, ", "))
 		}
-		return errors.New(sb.String())
 	}
+	return sb.String()
+}
 
+func asSchema(v interface{}) map[string]interface{} {
+	schema, _ := v.(map[string]interface{})
+	return schema
+}
+
+// validateArrayItem checks a value about to be inserted into the array at
+// path against that array's "items" schema, so that an invalid element is
+// reported on its own rather than as a document-level failure. Arrays
+// without an items schema are not checked here.
+func validateArrayItem(schema *string, path string, value interface{}) error {
+	root, err := parseSchema(schema)
+	if err != nil || root == nil {
+		return nil // the full-document validation reports unusable schemas
+	}
+
+	arraySchema := schemaForPath(root, path)
+	if arraySchema == nil {
+		return nil
+	}
+	itemSchema, ok := arraySchema["items"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	schemaBytes, err := json.Marshal(standaloneSchema(root, resolveSchemaRef(root, itemSchema)))
+	if err != nil {
+		return nil
+	}
+	valueBytes, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal new item: %w", err)
+	}
+
+	descriptions, err := schemaErrors(schemaBytes, valueBytes)
+	if err != nil {
+		return nil
+	}
+	if len(descriptions) > 0 {
+		return fmt.Errorf("new item invalid: %s", strings.Join(descriptions, "; "))
+	}
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,211 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInsertReportsInvalidItem(t *testing.T) {
+	schema := `{
+		"type": "object",
+		"properties": {
+			"users": {
+				"type": "array",
+				"items": {"$ref": "#/definitions/user"}
+			}
+		},
+		"definitions": {
+			"user": {"type": "object", "required": ["email"]}
+		}
+	}`
+	source, err := NewStrSource(`{"users":[]}`, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := m.ConfigRef().At("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(users, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Insert("/users", 0, map[string]interface{}{"name": "ann"})
+	if err == nil {
+		t.Fatal("insert succeeded, want a validation error")
+	}
+	if !strings.HasPrefix(err.Error(), "new item invalid:") || !strings.Contains(err.Error(), "email") {
+		t.Errorf("error = %q, want a new item error naming email", err)
+	}
+
+	if err := m.Insert("/users", 0, map[string]interface{}{"email": "ann@example.com"}); err != nil {
+		t.Errorf("valid insert failed: %v", err)
+	}
+}
+
+func TestOneOfErrorNamesBranches(t *testing.T) {
+	schema := `{
+		"type": "object",
+		"properties": {
+			"backend": {
+				"oneOf": [
+					{"title": "file", "type": "object", "required": ["path", "mode"]},
+					{"title": "http", "type": "object", "required": ["url", "timeout"]}
+				]
+			}
+		}
+	}`
+
+	err := validate(strPtr(`{"backend":{"url":"http://x"}}`), &schema)
+	if err == nil {
+		t.Fatal("validation passed, want a oneOf failure")
+	}
+	msg := err.Error()
+	for _, want := range []string{`branch 0 "file": `, "path", `branch 1 "http" (closest)`, "timeout"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("error %q does not mention %q", msg, want)
+		}
+	}
+}
+
+func TestIfThenErrorNamesBranch(t *testing.T) {
+	schema := `{
+		"type": "object",
+		"if": {"properties": {"tls": {"const": true}}},
+		"then": {"required": ["cert"]}
+	}`
+
+	err := validate(strPtr(`{"tls":true}`), &schema)
+	if err == nil {
+		t.Fatal("validation passed, want a then failure")
+	}
+	if msg := err.Error(); !strings.Contains(msg, "then:") || !strings.Contains(msg, "cert") {
+		t.Errorf("error %q does not explain the then branch", msg)
+	}
+}
+
+func strPtr(s string) *string { return &s }
+
+func TestValidateDocument(t *testing.T) {
+	source, err := NewStrSource(`{"port":1,"users":[]}`, `{
+		"type":"object",
+		"required":["port"],
+		"properties":{
+			"port":{"type":"integer","maximum":100},
+			"users":{"type":"array","items":{"type":"object","required":["name"]}}
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.ValidateDocument([]byte(`{"port":80,"users":[{"name":"ann"}]}`)); err != nil {
+		t.Errorf("valid document: %v", err)
+	}
+
+	err = m.ValidateDocument([]byte(`{"port":"80","users":[{"name":"ann"},{}]}`))
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("err = %v, want a *ValidationError", err)
+	}
+	got := make(map[string]string)
+	for _, fe := range verr.Errors {
+		got[fe.Path] = fe.Type
+	}
+	want := map[string]string{"/port": "invalid_type", "/users/1": "required"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("errors = %+v, want %v", verr.Errors, want)
+	}
+
+	if err := m.ValidateDocument([]byte(`not json`)); err == nil || errors.As(err, &verr) {
+		t.Errorf("malformed document: err = %v, want a non-validation error", err)
+	}
+
+	code, body := serve(t, m, "POST", "/config/validate-document", `{"port":500}`)
+	data, _ := body["data"].(map[string]interface{})
+	errs, _ := data["errors"].([]interface{})
+	if code != 200 || data["valid"] != false || len(errs) != 1 {
+		t.Fatalf("POST invalid: status = %d, data = %v, want one error", code, data)
+	}
+	if fe, _ := errs[0].(map[string]interface{}); fe["path"] != "/port" || fe["type"] != "number_lte" {
+		t.Errorf("field error = %v, want number_lte at /port", fe)
+	}
+
+	code, body = serve(t, m, "POST", "/config/validate-document", `{"port":5}`)
+	data, _ = body["data"].(map[string]interface{})
+	if errs, _ := data["errors"].([]interface{}); code != 200 || data["valid"] != true || len(errs) != 0 {
+		t.Errorf("POST valid: status = %d, data = %v, want valid with no errors", code, data)
+	}
+}
+
+func TestUnvalidatedPathsSkipSchema(t *testing.T) {
+	const schema = `{"type":"object","additionalProperties":false,"properties":{
+		"port":{"type":"integer"},
+		"plugins":{"type":"object","additionalProperties":{"type":"object","additionalProperties":false,"properties":{"name":{"type":"string"}}}}}}`
+	const config = `{"port":1,"metadata":{"owner":"ops","any":[1,{"x":true}]},"plugins":{"auth":{"name":"auth","settings":{"free":"form"}}}}`
+
+	source, err := NewStrSource(config, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewManager(source); err == nil {
+		t.Fatal("free-form subtrees passed the strict schema without an exemption")
+	}
+
+	source, err = NewStrSource(config, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source, WithUnvalidatedPaths("/metadata", "/plugins/*/settings"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaceable(t, m, "metadata")
+	replaceable(t, m, "port")
+	if err := m.Replace("/metadata", map[string]interface{}{"whatever": map[string]interface{}{"deep": 1}}); err != nil {
+		t.Errorf("replace inside an exempted subtree: %v", err)
+	}
+
+	// The exempted data is stored, and the rest is still validated
+	var stored map[string]interface{}
+	if err := json.Unmarshal([]byte(*m.Source().getConfig()), &stored); err != nil {
+		t.Fatal(err)
+	}
+	if !equalJSON(stored["metadata"], map[string]interface{}{"whatever": map[string]interface{}{"deep": 1}}) {
+		t.Errorf("stored metadata = %v", stored["metadata"])
+	}
+	if !equalJSON(stored["plugins"], map[string]interface{}{"auth": map[string]interface{}{"name": "auth", "settings": map[string]interface{}{"free": "form"}}}) {
+		t.Errorf("stored plugins = %v", stored["plugins"])
+	}
+	if err := m.Replace("/port", "x"); err == nil {
+		t.Error("invalid port accepted")
+	}
+
+	// Custom validators still see exempted paths
+	if err := m.AddValidator("/metadata", func(_ string, _, new *Node) error {
+		if _, err := new.At("owner"); err != nil {
+			return errors.New("metadata needs an owner")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Replace("/metadata", map[string]interface{}{"x": 1}); err == nil {
+		t.Error("custom validator skipped for an exempted path")
+	}
+
+	if _, err := NewManager(source, WithUnvalidatedPaths("metadata")); err == nil {
+		t.Error("malformed pattern accepted")
+	}
+}
@@ -0,0 +1,291 @@
+package config
+
+import (
+	"fmt"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// checked reports whether new is a value the built-in validators in this file
+// check. They all treat missing, null and empty values alike:
+//
+//	absent (a remove has no new value): passes (Node.IsPresent is false)
+//	null:                               passes (Node.IsNull)
+//	empty, "" or []:                    checked like any other value (Node.IsEmpty)
+//	any other type than they check:     rejected
+//
+// so whether a value may be null is left to the schema.
+func checked(new *Node) bool {
+	return new.IsPresent() && !new.IsNull()
+}
+
+// stringValue returns the string a validator checks, and false for values
+// that are not checked
+func stringValue(new *Node) (string, bool, error) {
+	if !checked(new) {
+		return "", false, nil
+	}
+	s, err := new.GetString()
+	if err != nil {
+		return "", false, fmt.Errorf("expected a string, got %s", new.Type())
+	}
+	return s, true, nil
+}
+
+// MustValidatePattern returns a validator requiring string values to match
+// the regular expression pattern (Go RE2 syntax). The match is unanchored,
+// as with regexp.MatchString, so use ^ and $ to match whole values:
+//
+//	m.AddValidator("/users/*/name", MustValidatePattern(`^[a-z][a-z0-9_]*$`))
+//
+// The pattern is compiled when the validator is built, sharing the compiled
+// form with other validators of the same pattern, and like
+// regexp.MustCompile, MustValidatePattern panics if it does not compile, so
+// it is meant for patterns fixed in the code. Values that are neither
+// strings nor null are rejected.
+func MustValidatePattern(pattern string) ValidatorFunc {
+	re, err := compilePattern(pattern)
+	if err != nil {
+		panic(fmt.Sprintf("config: MustValidatePattern(%q): %s", pattern, err))
+	}
+	return matchValidator(re, pattern)
+}
+
+// ValidateGlob is MustValidatePattern for shell-style wildcards matching the
+// whole value: "*" matches any run of characters, "?" a single one and
+// everything else itself, e.g. "*.example.com".
+func ValidateGlob(glob string) ValidatorFunc {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString("(?s:.*)")
+		case '?':
+			b.WriteString("(?s:.)")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	re, err := compilePattern(b.String())
+	if err != nil {
+		panic(fmt.Sprintf("config: ValidateGlob(%q): %s", glob, err))
+	}
+	return matchValidator(re, glob)
+}
+
+// maxCompiledPatterns bounds the cache of compiled regular expressions
+const maxCompiledPatterns = 256
+
+var (
+	compiledPatternsMu sync.Mutex
+	compiledPatterns   = make(map[string]*regexp.Regexp)
+)
+
+// compilePattern returns pattern compiled, reusing an earlier compilation of
+// the same pattern. A *regexp.Regexp is safe for concurrent use, so every
+// validator and query filter of a pattern can share one.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	compiledPatternsMu.Lock()
+	defer compiledPatternsMu.Unlock()
+
+	if re, ok := compiledPatterns[pattern]; ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(compiledPatterns) >= maxCompiledPatterns {
+		compiledPatterns = make(map[string]*regexp.Regexp)
+	}
+	compiledPatterns[pattern] = re
+	return re, nil
+}
+
+func matchValidator(re *regexp.Regexp, pattern string) ValidatorFunc {
+	return func(_ string, _, new *Node) error {
+		s, ok, err := stringValue(new)
+		if err != nil || !ok {
+			return err
+		}
+		if !re.MatchString(s) {
+			return fmt.Errorf("value %q does not match '%s'", s, pattern)
+		}
+		return nil
+	}
+}
+
+// ValidateLength returns a validator bounding the length of string values,
+// counted in runes, to [min, max]; -1 leaves a bound open. Nulls pass and
+// values that are not strings are rejected.
+func ValidateLength(min, max int) ValidatorFunc {
+	return func(_ string, _, new *Node) error {
+		s, ok, err := stringValue(new)
+		if err != nil || !ok {
+			return err
+		}
+		if n := utf8.RuneCountInString(s); !withinBounds(n, min, max) {
+			return fmt.Errorf("length is %d, expected %s", n, describeBounds(min, max))
+		}
+		return nil
+	}
+}
+
+// ValidateArraySize returns a validator bounding the number of elements of
+// array values to [min, max]; -1 leaves a bound open. It checks arrays being
+// replaced and, when registered with Removable, the array as a remove would
+// leave it:
+//
+//	m.AddValidator("/servers", ValidateArraySize(1, -1), Removable)
+//
+// An insert hands validators only the new element, so the size an insert
+// leads to is not checked; bound it with the schema's maxItems. Nulls pass
+// and values that are not arrays are rejected.
+func ValidateArraySize(min, max int) ValidatorFunc {
+	return func(_ string, _, new *Node) error {
+		if !checked(new) {
+			return nil
+		}
+		arr, err := new.GetArray()
+		if err != nil {
+			return fmt.Errorf("expected an array, got %s", new.Type())
+		}
+		if n := len(arr); !withinBounds(n, min, max) {
+			return fmt.Errorf("array has %d elements, expected %s", n, describeBounds(min, max))
+		}
+		return nil
+	}
+}
+
+// withinBounds reports whether n is in [min, max], -1 meaning unbounded
+func withinBounds(n, min, max int) bool {
+	return (min < 0 || n >= min) && (max < 0 || n <= max)
+}
+
+func describeBounds(min, max int) string {
+	switch {
+	case min >= 0 && max >= 0:
+		return fmt.Sprintf("between %d and %d", min, max)
+	case min >= 0:
+		return fmt.Sprintf("at least %d", min)
+	case max >= 0:
+		return fmt.Sprintf("at most %d", max)
+	default:
+		return "any"
+	}
+}
+
+// uuidPattern matches the canonical 8-4-4-4-12 hex form of a UUID,
+// capturing the version and variant digits
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-([0-9a-fA-F])[0-9a-fA-F]{3}-([0-9a-fA-F])[0-9a-fA-F]{3}-[0-9a-fA-F]{12}$`)
+
+// ValidateEmail returns a validator requiring string values to be a bare
+// email address such as "ops@example.com", as net/mail parses it; display
+// names and angle brackets are rejected.
+func ValidateEmail() ValidatorFunc {
+	return formatValidator(func(s string) error {
+		addr, err := mail.ParseAddress(s)
+		if err != nil || addr.Name != "" || addr.Address != s {
+			return fmt.Errorf("%q is not an email address", s)
+		}
+		return nil
+	})
+}
+
+// ValidateURL returns a validator requiring string values to be absolute
+// URLs with a host. When schemes are given the URL's scheme must be one of
+// them, compared case-insensitively:
+//
+//	m.AddValidator("/webhooks/*/url", ValidateURL("https"))
+func ValidateURL(schemes ...string) ValidatorFunc {
+	return formatValidator(func(s string) error {
+		u, err := url.Parse(s)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%q is not an absolute URL", s)
+		}
+		if len(schemes) == 0 {
+			return nil
+		}
+		for _, scheme := range schemes {
+			if strings.EqualFold(u.Scheme, scheme) {
+				return nil
+			}
+		}
+		return fmt.Errorf("URL %q must use %s", s, strings.Join(schemes, " or "))
+	})
+}
+
+// ValidateIP returns a validator requiring string values to be an IP
+// address, IPv4 only when v4Only is set (IPv4-mapped IPv6 addresses are then
+// rejected too)
+func ValidateIP(v4Only bool) ValidatorFunc {
+	return formatValidator(func(s string) error {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return fmt.Errorf("%q is not an IP address", s)
+		}
+		if v4Only && !addr.Is4() {
+			return fmt.Errorf("%q is not an IPv4 address", s)
+		}
+		return nil
+	})
+}
+
+// ValidateCIDR returns a validator requiring string values to be an IP
+// prefix in CIDR notation, such as "10.0.0.0/8" or "2001:db8::/32"
+func ValidateCIDR() ValidatorFunc {
+	return formatValidator(func(s string) error {
+		if _, err := netip.ParsePrefix(s); err != nil {
+			return fmt.Errorf("%q is not a CIDR prefix", s)
+		}
+		return nil
+	})
+}
+
+// MustValidateUUID returns a validator requiring string values to be a UUID
+// in the canonical hyphenated form. A version from 1 to 8 also requires that
+// version and the RFC 9562 variant; 0 accepts any UUID, the nil UUID
+// included. MustValidateUUID panics on any other version, which can only be
+// a mistake in the calling code.
+func MustValidateUUID(version int) ValidatorFunc {
+	if version < 0 || version > 8 {
+		panic(fmt.Sprintf("config: MustValidateUUID(%d): version must be between 0 and 8", version))
+	}
+
+	return formatValidator(func(s string) error {
+		match := uuidPattern.FindStringSubmatch(s)
+		if match == nil {
+			return fmt.Errorf("%q is not a UUID", s)
+		}
+		if version == 0 {
+			return nil
+		}
+		if match[1] != strconv.Itoa(version) || !strings.Contains("89abAB", match[2]) {
+			return fmt.Errorf("%q is not a version %d UUID", s, version)
+		}
+		return nil
+	})
+}
+
+// formatValidator returns a validator running check on string values; like
+// the other built-in validators it passes absent and null values and rejects
+// values that are not strings
+func formatValidator(check func(s string) error) ValidatorFunc {
+	return func(_ string, _, new *Node) error {
+		s, ok, err := stringValue(new)
+		if err != nil || !ok {
+			return err
+		}
+		return check(s)
+	}
+}
@@ -0,0 +1,357 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMustValidatePattern(t *testing.T) {
+	email := MustValidatePattern(`^[^@\s]+@[^@\s]+\.[a-z]{2,}$`)
+	identifier := MustValidatePattern(`^[a-z][a-z0-9_]*$`)
+	unanchored := MustValidatePattern(`[0-9]`)
+
+	for _, tc := range []struct {
+		name  string
+		fn    ValidatorFunc
+		value interface{}
+		ok    bool
+	}{
+		{"email", email, "ann@example.com", true},
+		{"email without domain", email, "ann@", false},
+		{"email with space", email, "ann smith@example.com", false},
+		{"identifier", identifier, "user_1", true},
+		{"identifier starting with a digit", identifier, "1user", false},
+		{"identifier with capitals", identifier, "User", false},
+		{"unanchored", unanchored, "abc1def", true},
+		{"unanchored without digits", unanchored, "abc", false},
+		{"not a string", identifier, 42, false},
+	} {
+		err := tc.fn("/v", nil, parseNode(tc.value))
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: %v gives err = %v, want ok = %v", tc.name, tc.value, err, tc.ok)
+		}
+	}
+
+	// Removes have no value to check
+	if err := identifier("/v", parseNode("x"), nil); err != nil {
+		t.Errorf("remove: %v", err)
+	}
+
+	err := identifier("/v", nil, parseNode("Bad"))
+	if err == nil || !strings.Contains(err.Error(), `"Bad" does not match '^[a-z][a-z0-9_]*$'`) {
+		t.Errorf("error = %v, want the value and pattern named", err)
+	}
+
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(r.(string), "MustValidatePattern") {
+			t.Errorf("recovered %v, want a panic naming MustValidatePattern", r)
+		}
+	}()
+	MustValidatePattern(`[a-`)
+}
+
+func TestCompilePattern(t *testing.T) {
+	a, err := compilePattern(`^shared-[0-9]+$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := compilePattern(`^shared-[0-9]+$`); b != a {
+		t.Error("the same pattern compiled twice")
+	}
+	if _, err := compilePattern(`(`); err == nil {
+		t.Error("bad pattern compiled")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 2*maxCompiledPatterns; j++ {
+				if _, err := compilePattern(fmt.Sprintf("^p%d-%d$", i, j)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	compiledPatternsMu.Lock()
+	n := len(compiledPatterns)
+	compiledPatternsMu.Unlock()
+	if n > maxCompiledPatterns {
+		t.Errorf("%d patterns cached, want at most %d", n, maxCompiledPatterns)
+	}
+}
+
+// BenchmarkMustValidatePattern builds and runs a pattern validator per
+// operation, as a validator built inside a handler would; compare its
+// allocations with the uncompiled case to see what the cache saves
+func BenchmarkMustValidatePattern(b *testing.B) {
+	const pattern = `^[a-z][a-z0-9_]*@[a-z]+\.example\.com$`
+	value := parseNode("ann_1@ops.example.com")
+
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := MustValidatePattern(pattern)("/v", nil, value); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			re := regexp.MustCompile(pattern)
+			if err := matchValidator(re, pattern)("/v", nil, value); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestValidateGlob(t *testing.T) {
+	host := ValidateGlob("*.example.com")
+	code := ValidateGlob("v?.[1]")
+
+	for _, tc := range []struct {
+		fn    ValidatorFunc
+		value string
+		ok    bool
+	}{
+		{host, "api.example.com", true},
+		{host, "a.b.example.com", true},
+		{host, "example.com", false},
+		{host, "api.example.com.evil", false},
+		{host, "apiXexampleXcom", false},
+		{code, "v1.[1]", true},
+		{code, "v12.[1]", false},
+		{code, "v1.1", false},
+	} {
+		if err := tc.fn("/v", nil, parseNode(tc.value)); (err == nil) != tc.ok {
+			t.Errorf("%q: err = %v, want ok = %v", tc.value, err, tc.ok)
+		}
+	}
+}
+
+func TestPatternValidatorOnManager(t *testing.T) {
+	m := newTestManager(t, `{"names":["ann"]}`)
+	names, err := m.ConfigRef().At("names")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnInsert(names, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddValidator("/names/*", MustValidatePattern(`^[a-z][a-z0-9_]*$`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Insert("/names", 1, "bob_2"); err != nil {
+		t.Errorf("valid name rejected: %v", err)
+	}
+	if err := m.Insert("/names", 2, "Bob"); err == nil {
+		t.Error("invalid name accepted")
+	}
+}
+
+func TestValidateLength(t *testing.T) {
+	for _, tc := range []struct {
+		min, max int
+		value    interface{}
+		err      string
+	}{
+		{1, 5, "héllo", ""},
+		{1, 5, "héllo!", "length is 6, expected between 1 and 5"},
+		{1, 5, "", "length is 0, expected between 1 and 5"},
+		{3, -1, "abc", ""},
+		{3, -1, "ab", "length is 2, expected at least 3"},
+		{-1, 2, "abc", "length is 3, expected at most 2"},
+		{-1, -1, "anything", ""},
+		{1, 5, nil, ""},
+		{1, 5, 12, "expected a string, got"},
+	} {
+		err := ValidateLength(tc.min, tc.max)("/v", nil, parseNode(tc.value))
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("[%d, %d] %v: err = %v, want %q", tc.min, tc.max, tc.value, err, tc.err)
+		}
+	}
+
+	// Patterns let nulls through too
+	if err := MustValidatePattern(`^x$`)("/v", nil, parseNode(nil)); err != nil {
+		t.Errorf("pattern on null: %v", err)
+	}
+}
+
+func TestValidateArraySize(t *testing.T) {
+	fn := ValidateArraySize(1, 2)
+	for _, tc := range []struct {
+		value interface{}
+		err   string
+	}{
+		{[]interface{}{1}, ""},
+		{[]interface{}{1, 2, 3}, "array has 3 elements, expected between 1 and 2"},
+		{[]interface{}{}, "array has 0 elements, expected between 1 and 2"},
+		{nil, ""},
+		{"not an array", "expected an array, got string"},
+	} {
+		err := fn("/v", nil, parseNode(tc.value))
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || err.Error() != tc.err) {
+			t.Errorf("%v: err = %v, want %q", tc.value, err, tc.err)
+		}
+	}
+
+	m := newTestManager(t, `{"servers":["a","b"]}`)
+	servers, err := m.ConfigRef().At("servers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnRemove(servers, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddValidator("/servers", ValidateArraySize(1, -1), Removable); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Remove("/servers", 0); err != nil {
+		t.Fatal(err)
+	}
+	err = m.Remove("/servers", 0)
+	if err == nil || !strings.Contains(err.Error(), "expected at least 1") {
+		t.Errorf("removing the last server: err = %v, want the size bound named", err)
+	}
+}
+
+func TestValidatorsAbsentNullEmpty(t *testing.T) {
+	validators := map[string]ValidatorFunc{
+		"pattern": MustValidatePattern(`^x$`),
+		"glob":    ValidateGlob("x*"),
+		"length":  ValidateLength(1, -1),
+		"size":    ValidateArraySize(1, -1),
+		"email":   ValidateEmail(),
+		"url":     ValidateURL(),
+		"ip":      ValidateIP(false),
+		"cidr":    ValidateCIDR(),
+		"uuid":    MustValidateUUID(0),
+	}
+	root := parseNode(map[string]interface{}{"null": nil, "str": "", "arr": []interface{}{}, "num": 1})
+	at := func(path string) *Node {
+		n, _ := root.AtPath(path)
+		return n
+	}
+
+	for name, fn := range validators {
+		// Absent and null values pass
+		for _, path := range []string{"/missing", "/null"} {
+			if err := fn(path, nil, at(path)); err != nil {
+				t.Errorf("%s on %s: %v", name, path, err)
+			}
+		}
+		// Empty values are checked, and fail every validator above
+		empty := "/str"
+		if name == "size" {
+			empty = "/arr"
+		}
+		if err := fn(empty, nil, at(empty)); err == nil {
+			t.Errorf("%s on %s: passed, want it checked", name, empty)
+		}
+		// Values of another type are rejected
+		if err := fn("/num", nil, at("/num")); err == nil || !strings.Contains(err.Error(), "expected") {
+			t.Errorf("%s on a number: err = %v, want a type error", name, err)
+		}
+	}
+}
+
+func TestValidatorsNullOnManager(t *testing.T) {
+	m := newTestManager(t, `{"name":"ann"}`)
+	name, err := m.ConfigRef().At("name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.OnReplace(name, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddValidator("/name", ValidateLength(1, -1)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Replace("/name", nil); err != nil {
+		t.Errorf("replacing with null: %v", err)
+	}
+	if err := m.Replace("/name", ""); err == nil {
+		t.Error("replacing with an empty string passed, want it rejected")
+	}
+}
+
+func TestFormatValidators(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		fn    ValidatorFunc
+		value interface{}
+		err   string
+	}{
+		{"email", ValidateEmail(), "ops@example.com", ""},
+		{"email with display name", ValidateEmail(), "Ops <ops@example.com>", `"Ops <ops@example.com>" is not an email address`},
+		{"email without at", ValidateEmail(), "ops.example.com", "is not an email address"},
+		{"url", ValidateURL(), "ftp://example.com/x", ""},
+		{"relative url", ValidateURL(), "/x/y", `"/x/y" is not an absolute URL`},
+		{"url without host", ValidateURL(), "mailto:ops@example.com", "is not an absolute URL"},
+		{"url scheme", ValidateURL("https"), "HTTPS://example.com", ""},
+		{"url wrong scheme", ValidateURL("https", "wss"), "http://example.com", `URL "http://example.com" must use https or wss`},
+		{"ipv4", ValidateIP(true), "10.0.0.1", ""},
+		{"ipv6", ValidateIP(false), "2001:db8::1", ""},
+		{"ipv6 when v4 only", ValidateIP(true), "2001:db8::1", "is not an IPv4 address"},
+		{"mapped ipv4 when v4 only", ValidateIP(true), "::ffff:10.0.0.1", "is not an IPv4 address"},
+		{"bad ip", ValidateIP(false), "10.0.0.256", `"10.0.0.256" is not an IP address`},
+		{"cidr", ValidateCIDR(), "10.0.0.0/8", ""},
+		{"cidr v6", ValidateCIDR(), "2001:db8::/32", ""},
+		{"cidr without bits", ValidateCIDR(), "10.0.0.0", "is not a CIDR prefix"},
+		{"uuid", MustValidateUUID(0), "00000000-0000-0000-0000-000000000000", ""},
+		{"uuid v4", MustValidateUUID(4), "f47ac10b-58cc-4372-a567-0e02b2c3d479", ""},
+		{"uuid v4 upper case", MustValidateUUID(4), "F47AC10B-58CC-4372-A567-0E02B2C3D479", ""},
+		{"uuid wrong version", MustValidateUUID(7), "f47ac10b-58cc-4372-a567-0e02b2c3d479", "is not a version 7 UUID"},
+		{"uuid wrong variant", MustValidateUUID(4), "f47ac10b-58cc-4372-c567-0e02b2c3d479", "is not a version 4 UUID"},
+		{"uuid without hyphens", MustValidateUUID(0), "f47ac10b58cc4372a5670e02b2c3d479", "is not a UUID"},
+		{"null", ValidateEmail(), nil, ""},
+		{"not a string", ValidateCIDR(), 10, "expected a string, got"},
+	} {
+		err := tc.fn("/v", nil, parseNode(tc.value))
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%s: err = %v, want %q", tc.name, err, tc.err)
+		}
+	}
+
+	// Removes have no value to check
+	if err := ValidateIP(true)("/v", parseNode("x"), nil); err != nil {
+		t.Errorf("remove: %v", err)
+	}
+
+	for _, version := range []int{-1, 9} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil || !strings.Contains(r.(string), "MustValidateUUID") {
+					t.Errorf("MustValidateUUID(%d) recovered %v, want a panic naming MustValidateUUID", version, r)
+				}
+			}()
+			MustValidateUUID(version)
+		}()
+	}
+}
+
+func TestFormatValidatorErrorsNamePath(t *testing.T) {
+	m := newTestManager(t, `{"hooks":[{"url":"https://a.example"}]}`)
+	if err := m.OnReplacePath("/hooks/0/url", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddValidator("/hooks/*/url", ValidateURL("https")); err != nil {
+		t.Fatal(err)
+	}
+
+	err := m.Replace("/hooks/0/url", "http://a.example")
+	if err == nil || !strings.Contains(err.Error(), "/hooks/0/url") || !strings.Contains(err.Error(), "must use https") {
+		t.Errorf("err = %v, want the path and the scheme named", err)
+	}
+}
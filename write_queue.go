@@ -0,0 +1,61 @@
+package config
+
+import "sync"
+
+// writeQueue hands out turns to writers in the order they arrive. It is a
+// ticket lock: each writer takes the next ticket and waits until it is being
+// served.
+type writeQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	next    uint64 // ticket handed to the next writer
+	serving uint64 // ticket whose writer may go ahead
+}
+
+func newWriteQueue() *writeQueue {
+	q := &writeQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// wait blocks until every writer that arrived earlier has had its turn
+func (q *writeQueue) wait() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ticket := q.next
+	q.next++
+	for q.serving != ticket {
+		q.cond.Wait()
+	}
+}
+
+// done ends the current turn
+func (q *writeQueue) done() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.serving++
+	q.cond.Broadcast()
+}
+
+// lockForWrite takes the manager lock for a mutation. With WithFIFOWrites the
+// mutations take it in the order they were called, and each keeps its turn
+// until unlockForWrite: a mutation releasing the lock while a handler,
+// subscriber or validation service runs lets readers in, but no later
+// writer. Otherwise it is m.mu.Lock.
+func (m *Manager) lockForWrite() {
+	if m.writeQueue != nil {
+		m.writeQueue.wait()
+	}
+	m.mu.Lock()
+}
+
+// unlockForWrite releases the lock taken by lockForWrite and ends the
+// mutation's turn
+func (m *Manager) unlockForWrite() {
+	m.mu.Unlock()
+	if m.writeQueue != nil {
+		m.writeQueue.done()
+	}
+}
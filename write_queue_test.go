@@ -0,0 +1,192 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tickets returns how many writers have queued so far
+func (q *writeQueue) tickets() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.next
+}
+
+func TestFIFOWritesApplyInSubmissionOrder(t *testing.T) {
+	const writers = 20
+
+	config := "{"
+	for i := 0; i < writers; i++ {
+		if i > 0 {
+			config += ","
+		}
+		config += fmt.Sprintf(`"k%d":0`, i)
+	}
+	m := newTestManager(t, config+"}", WithFIFOWrites(true))
+	for i := 0; i < writers; i++ {
+		if err := m.OnReplacePath(fmt.Sprintf("/k%d", i), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Hold the writers back with a reader, queueing them one by one
+	m.mu.RLock()
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := m.Replace(fmt.Sprintf("/k%d", i), 1); err != nil {
+				t.Error(err)
+			}
+		}(i)
+
+		deadline := time.Now().Add(5 * time.Second)
+		for m.writeQueue.tickets() != uint64(i+1) {
+			if time.Now().After(deadline) {
+				m.mu.RUnlock()
+				t.Fatalf("writer %d did not queue", i)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	m.mu.RUnlock()
+	wg.Wait()
+
+	var got, want []string
+	for i, ev := range m.History().GetAll() {
+		got = append(got, ev.Path)
+		want = append(want, fmt.Sprintf("/k%d", i))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("history order = %v, want %v", got, want)
+	}
+}
+
+func TestFIFOWritesUnderLoad(t *testing.T) {
+	const writers, writes, readers = 8, 100, 8
+
+	m := newTestManager(t, `{"n":0}`, WithFIFOWrites(true))
+	replaceable(t, m, "n")
+	before := m.Version()
+
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				m.Config()
+			}
+		}()
+	}
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				if err := m.Replace("/n", i*writes+j+1); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if m.Version() != before+writers*writes {
+		t.Errorf("version = %d, want %d", m.Version(), before+writers*writes)
+	}
+	if m.writeQueue.tickets() != writers*writes {
+		t.Errorf("%d writes queued, want %d", m.writeQueue.tickets(), writers*writes)
+	}
+}
+
+func TestFIFOWriterKeepsTurnDuringHandlers(t *testing.T) {
+	source, err := NewStrSource(`{"a":-1}`, `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source, WithFIFOWrites(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first write's critical handler holds the write back until every
+	// other writer is queued, so they all wait on its turn
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var seen []int
+	node, err := m.liveNode("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.OnReplaceWithOptions(node, func(n *Node) error {
+		value, _ := n.GetInt()
+		if value == 0 {
+			<-release
+		}
+		mu.Lock()
+		seen = append(seen, value)
+		mu.Unlock()
+		return nil
+	}, HandlerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const writers = 50
+	errs := make([]error, writers)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = m.Replace("/a", i)
+		}(i)
+		waitForTickets(t, m, uint64(i+1))
+	}
+	close(release)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("write %d: %v", i, err)
+		}
+	}
+	for i, value := range seen {
+		if value != i {
+			t.Fatalf("handlers ran in order %v, want submission order", seen)
+		}
+	}
+
+	events := m.History().GetAll()
+	if len(events) != writers {
+		t.Fatalf("%d events recorded, want %d", len(events), writers)
+	}
+	for i, ev := range events {
+		if !sameJSON(ev.NewValue, i) {
+			t.Fatalf("event %d (version %d) recorded %v, want %d", i, ev.Version, ev.NewValue, i)
+		}
+	}
+}
+
+// waitForTickets waits until n writers have taken a ticket from m's queue
+func waitForTickets(t *testing.T, m *Manager, n uint64) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		next := m.writeQueue.tickets()
+		if next >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d writers queued", next, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/iancoleman/orderedmap"
+	"gopkg.in/yaml.v3"
+)
+
+// YAMLCodec stores configs as YAML. Key order is kept in both directions and,
+// as with JSON, integers are decoded as int64 and other numbers as float64.
+type YAMLCodec struct{}
+
+func (YAMLCodec) Decode(data []byte) (interface{}, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse yaml: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("config is empty")
+	}
+
+	result, err := fromYAMLNode(doc.Content[0], 0)
+	if err != nil {
+		return nil, err
+	}
+
+	switch result.(type) {
+	case *orderedmap.OrderedMap, []interface{}:
+		return result, nil
+	default:
+		return nil, fmt.Errorf("config root must be an object or array, got %T", result)
+	}
+}
+
+func (YAMLCodec) Encode(config interface{}) ([]byte, error) {
+	node, err := toYAMLNode(config)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(node)
+}
+
+func fromYAMLNode(n *yaml.Node, depth int) (interface{}, error) {
+	if depth > maxParseDepth {
+		return nil, fmt.Errorf("value is nested deeper than %d levels", maxParseDepth)
+	}
+
+	switch n.Kind {
+	case yaml.MappingNode:
+		om := orderedmap.New()
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			var key string
+			if err := n.Content[i].Decode(&key); err != nil {
+				return nil, fmt.Errorf("line %d: keys must be strings", n.Content[i].Line)
+			}
+			value, err := fromYAMLNode(n.Content[i+1], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			om.Set(key, value)
+		}
+		return om, nil
+
+	case yaml.SequenceNode:
+		arr := make([]interface{}, 0, len(n.Content))
+		for _, item := range n.Content {
+			value, err := fromYAMLNode(item, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, value)
+		}
+		return arr, nil
+
+	case yaml.AliasNode:
+		return fromYAMLNode(n.Alias, depth+1)
+
+	case yaml.ScalarNode:
+		var v interface{}
+		if err := n.Decode(&v); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n.Line, err)
+		}
+		switch x := v.(type) {
+		case int:
+			return int64(x), nil
+		case int64:
+			return x, nil
+		case uint64:
+			if x <= math.MaxInt64 {
+				return int64(x), nil
+			}
+			return float64(x), nil
+		case nil, bool, float64, string:
+			return x, nil
+		default:
+			// Timestamps and the like are kept in their textual form
+			return n.Value, nil
+		}
+
+	default:
+		return nil, fmt.Errorf("line %d: unsupported yaml node", n.Line)
+	}
+}
+
+func toYAMLNode(v interface{}) (*yaml.Node, error) {
+	switch x := v.(type) {
+	case *orderedmap.OrderedMap:
+		n := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, key := range x.Keys() {
+			value, _ := x.Get(key)
+			if err := appendYAMLPair(n, key, value); err != nil {
+				return nil, err
+			}
+		}
+		return n, nil
+
+	case orderedmap.OrderedMap:
+		return toYAMLNode(&x)
+
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for key := range x {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		n := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, key := range keys {
+			if err := appendYAMLPair(n, key, x[key]); err != nil {
+				return nil, err
+			}
+		}
+		return n, nil
+
+	case []interface{}:
+		n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range x {
+			child, err := toYAMLNode(item)
+			if err != nil {
+				return nil, err
+			}
+			n.Content = append(n.Content, child)
+		}
+		return n, nil
+
+	default:
+		n := &yaml.Node{}
+		if err := n.Encode(integralNumber(v)); err != nil {
+			return nil, err
+		}
+		return n, nil
+	}
+}
+
+func appendYAMLPair(n *yaml.Node, key string, value interface{}) error {
+	child, err := toYAMLNode(value)
+	if err != nil {
+		return err
+	}
+	n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, child)
+	return nil
+}
+
+// integralNumber turns a float64 holding a whole number into an int64 so it
+// is written without a fraction by formats that tell the two apart
+func integralNumber(v interface{}) interface{} {
+	if f, ok := v.(float64); ok && f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return int64(f)
+	}
+	return v
+}
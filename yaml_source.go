@@ -0,0 +1,30 @@
+package config
+
+import (
+	"fmt"
+)
+
+// YAMLSource is a FileSource for a YAML file. The config is exposed, and
+// validated, as the equivalent JSON; changes are written back as YAML, with
+// key order and scalar types kept but comments lost. Watch works as for
+// FileSource.
+type YAMLSource struct {
+	FileSource
+}
+
+func NewYAMLSource(configPath string, schema string) (*YAMLSource, error) {
+	if configPath == "" {
+		return nil, fmt.Errorf("config path cannot be empty")
+	}
+
+	ys := &YAMLSource{}
+	ys.configPath = configPath
+	ys.store = &FileStore{Path: configPath}
+	ys.codec = YAMLCodec{}
+	ys.schema = schema
+
+	if err := ys.load(); err != nil {
+		return nil, err
+	}
+	return ys, nil
+}
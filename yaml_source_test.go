@@ -0,0 +1,84 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestYAMLSourceRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	doc := "# service settings\nname: api\nport: 8080\nratio: 0.5\ndebug: false\nversion: \"1.0\"\nservers:\n  - host: b\n    weight: 2\n  - host: a\n    weight: 1\n"
+	if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := NewYAMLSource(path, `{"type":"object","properties":{"port":{"type":"integer"},"version":{"type":"string"}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewManager(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Scalars keep their types: "1.0" stays a string, 8080 an integer
+	if v, err := m.ConfigRef().GetString("version"); err != nil || v != "1.0" {
+		t.Errorf("version = %q, %v, want the string 1.0", v, err)
+	}
+	if v, err := m.ConfigRef().GetInt("port"); err != nil || v != 8080 {
+		t.Errorf("port = %d, %v, want 8080", v, err)
+	}
+
+	replaceable(t, m, "port")
+	if err := m.Replace("/port", 9090); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := string(data)
+	if !strings.Contains(saved, "port: 9090") || !strings.Contains(saved, `version: "1.0"`) {
+		t.Errorf("saved YAML lost the change or a type:\n%s", saved)
+	}
+
+	// Key order survives the write
+	order := []string{"name:", "port:", "ratio:", "debug:", "version:", "servers:", "host: b", "host: a"}
+	last := -1
+	for _, key := range order {
+		i := strings.Index(saved, key)
+		if i < last {
+			t.Errorf("%q out of order in:\n%s", key, saved)
+		}
+		last = i
+	}
+
+	reloaded, err := NewYAMLSource(path, `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalJSON(parseNode(reloaded.getConfigObject()).toInterface(), m.ConfigRef().toInterface()) {
+		t.Errorf("reloaded %v, want %v", reloaded.getConfigObject(), m.ConfigRef().toInterface())
+	}
+}
+
+func TestYAMLSourceRejectsInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewYAMLSource("", `{}`); err == nil {
+		t.Error("empty path accepted")
+	}
+	if _, err := NewYAMLSource(filepath.Join(dir, "missing.yaml"), `{}`); err == nil {
+		t.Error("missing file accepted")
+	}
+
+	path := filepath.Join(dir, "bad.yaml")
+	if err := os.WriteFile(path, []byte("a: [1, 2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewYAMLSource(path, `{}`); err == nil {
+		t.Error("malformed YAML accepted")
+	}
+}